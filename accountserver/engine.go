@@ -19,12 +19,15 @@ import (
 	"container/list"
 	"crypto/md5"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/troubling/hummingbird/common/conf"
 	"github.com/troubling/hummingbird/common/fs"
 )
 
@@ -72,11 +75,13 @@ type policyStats struct {
 
 // ContainerListingRecord is the struct used for serializing objects in json and xml account listings.
 type ContainerListingRecord struct {
-	XMLName      xml.Name `xml:"container" json:"-"`
-	Name         string   `xml:"name" json:"name"`
-	Bytes        int64    `xml:"bytes" json:"bytes"`
-	Count        int64    `xml:"count" json:"count"`
-	LastModified string   `xml:"last_modified" json:"last_modified"`
+	XMLName            xml.Name `xml:"container" json:"-"`
+	Name               string   `xml:"name" json:"name"`
+	Bytes              int64    `xml:"bytes" json:"bytes"`
+	Count              int64    `xml:"count" json:"count"`
+	LastModified       string   `xml:"last_modified" json:"last_modified"`
+	StoragePolicy      string   `xml:"storage_policy,omitempty" json:"storage_policy,omitempty"`
+	StoragePolicyIndex int      `xml:"-" json:"-"`
 }
 
 // SubdirListingRecord is the struct used for serializing subdirs in json and xml account listings.
@@ -86,6 +91,26 @@ type SubdirListingRecord struct {
 	Name    string   `xml:"name" json:"subdir"`
 }
 
+// ListingName returns the container's name.
+func (r *ContainerListingRecord) ListingName() string {
+	return r.Name
+}
+
+// CSVRow returns the container's fields in the order of common.ContainerListingCSVHeader.
+func (r *ContainerListingRecord) CSVRow() []string {
+	return []string{r.Name, strconv.FormatInt(r.Count, 10), strconv.FormatInt(r.Bytes, 10), r.LastModified, r.StoragePolicy}
+}
+
+// ListingName returns the subdir's name.
+func (r *SubdirListingRecord) ListingName() string {
+	return r.Name
+}
+
+// CSVRow returns the subdir's name, with the container fields left empty.
+func (r *SubdirListingRecord) CSVRow() []string {
+	return []string{r.Name, "", "", "", ""}
+}
+
 // ContainerRecord represents the object's data in-databaee, it is used by replication.
 type ContainerRecord struct {
 	Rowid              int64  `json:"ROWID"`
@@ -155,6 +180,20 @@ type ReplicableAccount interface {
 	RingHash() string
 }
 
+// AuditableAccount is an account whose database can be checked for corruption.
+type AuditableAccount interface {
+	ReplicableAccount
+	// Audit checks the database's integrity and that its hash matches its container records.
+	Audit() error
+}
+
+// CompactableAccount is an account whose database can be rewritten to reclaim free space.
+type CompactableAccount interface {
+	ReplicableAccount
+	// Compact rewrites the database if it's at least minSize bytes and at least minFree of it is free, returning its size before and after.
+	Compact(minSize int64, minFree float64) (int64, int64, error)
+}
+
 // AccountEngine is the interface of an object that creates and returns accounts.
 type AccountEngine interface {
 	// Get returns an Account, given a vars mapping.
@@ -173,7 +212,54 @@ type AccountEngine interface {
 	Invalidate(id string)
 }
 
-// My attempts at making this lruEngine reusable have not been successful, so for now it's sqlite-specific and not exported.
+// ImportingEngine is an AccountEngine that doesn't keep its accounts in database files, so databases replicated in
+// whole from other servers have to be loaded into it.
+type ImportingEngine interface {
+	AccountEngine
+	// Import replaces the account with the given hash with a copy of db.
+	Import(device, hash, partition string, db ReplicableAccount) error
+}
+
+// AccountEngineConstructor is a function that, given the server's config, device root, and hash path prefix and
+// suffix, returns an AccountEngine.
+type AccountEngineConstructor func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (AccountEngine, error)
+
+type accountEngineFactoryEntry struct {
+	name        string
+	constructor AccountEngineConstructor
+}
+
+var accountEngineFactories = []accountEngineFactoryEntry{}
+
+// RegisterAccountEngine lets you tell hummingbird about a new account engine.
+func RegisterAccountEngine(name string, newEngine AccountEngineConstructor) {
+	for i, e := range accountEngineFactories {
+		if e.name == name {
+			accountEngineFactories[i].constructor = newEngine
+			return
+		}
+	}
+	accountEngineFactories = append(accountEngineFactories, accountEngineFactoryEntry{name, newEngine})
+}
+
+// FindAccountEngine returns the registered account engine with the given name.
+func FindAccountEngine(name string) (AccountEngineConstructor, error) {
+	for _, e := range accountEngineFactories {
+		if e.name == name {
+			return e.constructor, nil
+		}
+	}
+	return nil, errors.New("Not found")
+}
+
+func init() {
+	RegisterAccountEngine("sqlite", func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (AccountEngine, error) {
+		return newLRUEngine(deviceRoot, hashPathPrefix, hashPathSuffix, 32), nil
+	})
+}
+
+// lruEngine is the "sqlite" account engine.  It keeps the most recently used account databases open, and isn't
+// reusable by other backends since it opens the databases itself.
 type lruEngine struct {
 	deviceRoot     string
 	hashPathPrefix string
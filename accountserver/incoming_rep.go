@@ -150,6 +150,9 @@ func (server *AccountServer) AccountReplicateHandler(writer http.ResponseWriter,
 func (server *AccountServer) replicateCompleteRsync(request *http.Request, vars map[string]string, tmpFileName string) int {
 	accountFile := filepath.Join(server.driveRoot, vars["device"], "accounts", vars["partition"], vars["hash"][29:32], vars["hash"], vars["hash"]+".db")
 	tmpAccountFile := filepath.Join(server.driveRoot, vars["device"], "tmp", tmpFileName)
+	if ie, ok := server.accountEngine.(ImportingEngine); ok {
+		return server.importCompleteRsync(request, ie, vars, tmpAccountFile)
+	}
 	if !fs.Exists(tmpAccountFile) || fs.Exists(accountFile) {
 		return http.StatusNotFound
 	}
@@ -182,6 +185,35 @@ func (server *AccountServer) replicateCompleteRsync(request *http.Request, vars
 	return http.StatusNoContent
 }
 
+// importCompleteRsync loads a replicated database into an engine that doesn't keep its accounts in database files,
+// then removes the database.
+func (server *AccountServer) importCompleteRsync(request *http.Request, ie ImportingEngine, vars map[string]string, tmpAccountFile string) int {
+	if db, err := ie.GetByHash(vars["device"], vars["hash"], vars["partition"]); err == nil {
+		ie.Return(db)
+		return http.StatusNotFound
+	}
+	if !fs.Exists(tmpAccountFile) {
+		return http.StatusNotFound
+	}
+	tmpDb, err := sqliteOpenAccount(tmpAccountFile)
+	if err != nil {
+		return http.StatusNotFound
+	}
+	defer os.Remove(tmpAccountFile)
+	defer tmpDb.Close()
+	if err := tmpDb.NewID(); err != nil {
+		srv.GetLogger(request).Error("Error blessing new account db.",
+			zap.String("tmpAccountFile", tmpAccountFile), zap.Error(err))
+		return http.StatusInternalServerError
+	}
+	if err := ie.Import(vars["device"], vars["hash"], vars["partition"], tmpDb); err != nil {
+		srv.GetLogger(request).Error("Error importing new account db.",
+			zap.String("tmpAccountFile", tmpAccountFile), zap.Error(err))
+		return http.StatusInternalServerError
+	}
+	return http.StatusNoContent
+}
+
 func (server *AccountServer) replicateMergeItems(request *http.Request, vars map[string]string, records []*ContainerRecord, remoteID string) int {
 	db, err := server.accountEngine.GetByHash(vars["device"], vars["hash"], vars["partition"])
 	if err != nil {
@@ -0,0 +1,724 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package accountserver
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+)
+
+func init() {
+	RegisterAccountEngine("memory", func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (AccountEngine, error) {
+		return newMemoryEngine(hashPathPrefix, hashPathSuffix), nil
+	})
+}
+
+// memoryEngine is the "memory" account engine, which keeps its accounts in memory instead of in sqlite databases.
+// It's meant for tests, which would otherwise spend most of their time waiting on fsyncs.  Nothing it holds survives
+// a restart, and the daemons that walk the database files on disk can't see its accounts.
+type memoryEngine struct {
+	hashPathPrefix string
+	hashPathSuffix string
+	accounts       map[string]*memoryAccount
+	m              sync.Mutex
+}
+
+var _ ImportingEngine = &memoryEngine{}
+
+func newMemoryEngine(hashPathPrefix, hashPathSuffix string) *memoryEngine {
+	return &memoryEngine{
+		hashPathPrefix: hashPathPrefix,
+		hashPathSuffix: hashPathSuffix,
+		accounts:       make(map[string]*memoryAccount),
+	}
+}
+
+func (e *memoryEngine) ringHash(vars map[string]string) string {
+	h := md5.New()
+	fmt.Fprintf(h, "%s/%s%s", e.hashPathPrefix, vars["account"], e.hashPathSuffix)
+	return fmt.Sprintf("%032x", h.Sum(nil))
+}
+
+func (e *memoryEngine) get(device, hash string) (*memoryAccount, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	a := e.accounts[device+"/"+hash]
+	if a == nil {
+		return nil, ErrorNoSuchAccount
+	}
+	return a, nil
+}
+
+// Get returns an account given the incoming vars.
+func (e *memoryEngine) Get(vars map[string]string) (Account, error) {
+	return e.get(vars["device"], e.ringHash(vars))
+}
+
+// Create creates a new account, or updates the existing one the way a PUT would.
+func (e *memoryEngine) Create(vars map[string]string, putTimestamp string, metadata map[string][]string) (bool, Account, error) {
+	hash := e.ringHash(vars)
+	key := vars["device"] + "/" + hash
+	e.m.Lock()
+	if a := e.accounts[key]; a != nil {
+		e.m.Unlock()
+		created, err := a.createExisting(putTimestamp, metadata)
+		if err != nil {
+			return false, nil, err
+		}
+		return created, a, nil
+	}
+	defer e.m.Unlock()
+	if metadata == nil {
+		metadata = map[string][]string{}
+	}
+	serializedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return false, nil, err
+	}
+	a := newMemoryAccount(e, key, hash)
+	a.info = AccountInfo{
+		Account:         vars["account"],
+		CreatedAt:       common.GetTimestamp(),
+		PutTimestamp:    putTimestamp,
+		DeleteTimestamp: "0",
+		StatusChangedAt: putTimestamp,
+		Hash:            "00000000000000000000000000000000",
+		ID:              common.UUID(),
+		RawMetadata:     string(serializedMetadata),
+		MaxRow:          -1,
+	}
+	e.accounts[key] = a
+	return true, a, nil
+}
+
+// Return does nothing, since there's no connection to an account to close or cache.
+func (e *memoryEngine) Return(a Account) {
+}
+
+// GetByHash returns an account given its device and ring hash.  The partition isn't needed to find it.
+func (e *memoryEngine) GetByHash(device, hash, partition string) (ReplicableAccount, error) {
+	return e.get(device, hash)
+}
+
+// Invalidate does nothing, since there are no cached connections to drop.
+func (e *memoryEngine) Invalidate(id string) {
+}
+
+// Import replaces the account with the given hash with a copy of db, keeping its ROWIDs so sync points still apply.
+func (e *memoryEngine) Import(device, hash, partition string, db ReplicableAccount) error {
+	info, err := db.GetInfo()
+	if err != nil {
+		return err
+	}
+	syncs, err := db.SyncTable()
+	if err != nil {
+		return err
+	}
+	a := newMemoryAccount(e, device+"/"+hash, hash)
+	a.info = *info
+	a.info.Hash = "00000000000000000000000000000000"
+	a.info.ContainerCount = 0
+	a.info.ObjectCount = 0
+	a.info.BytesUsed = 0
+	for point := int64(-1); ; {
+		records, err := db.ItemsSince(point, 10000)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			a.insert(record)
+		}
+		point = records[len(records)-1].Rowid
+	}
+	for _, record := range syncs {
+		if record.RemoteID != info.ID {
+			a.incomingSync[record.RemoteID] = record.SyncPoint
+		}
+	}
+	e.m.Lock()
+	e.accounts[a.key] = a
+	e.m.Unlock()
+	return nil
+}
+
+// Close drops every account, since there's nowhere to keep them.
+func (e *memoryEngine) Close() {
+	e.m.Lock()
+	e.accounts = make(map[string]*memoryAccount)
+	e.m.Unlock()
+}
+
+// memoryAccount is an account held by the memory engine.  Its info holds what the sqlite backend keeps in the
+// account_stat table, and its container records are kept the way they'd be in the container table, tombstones included.
+type memoryAccount struct {
+	engine       *memoryEngine
+	key          string
+	ringHash     string
+	info         AccountInfo
+	containers   map[string]*ContainerRecord
+	policyStats  map[int]*PolicyStat
+	incomingSync map[string]int64
+	m            sync.Mutex
+}
+
+var _ ReplicableAccount = &memoryAccount{}
+
+func newMemoryAccount(e *memoryEngine, key, hash string) *memoryAccount {
+	return &memoryAccount{
+		engine:       e,
+		key:          key,
+		ringHash:     hash,
+		containers:   make(map[string]*ContainerRecord),
+		policyStats:  make(map[int]*PolicyStat),
+		incomingSync: make(map[string]int64),
+	}
+}
+
+func recordHashValue(r *ContainerRecord) string {
+	return fmt.Sprintf("%s-%s-%d-%d", r.PutTimestamp, r.DeleteTimestamp, r.ObjectCount, r.BytesUsed)
+}
+
+// insert adds a copy of a record, which should already have its ROWID, keeping the stats and hash up to date.
+func (a *memoryAccount) insert(record *ContainerRecord) {
+	r := *record
+	a.containers[r.Name] = &r
+	stat := a.policyStats[r.StoragePolicyIndex]
+	if stat == nil {
+		stat = &PolicyStat{StoragePolicyIndex: r.StoragePolicyIndex}
+		a.policyStats[r.StoragePolicyIndex] = stat
+	}
+	stat.ContainerCount += int64(1 - r.Deleted)
+	stat.ObjectCount += r.ObjectCount
+	stat.BytesUsed += r.BytesUsed
+	a.info.ContainerCount += int64(1 - r.Deleted)
+	a.info.ObjectCount += r.ObjectCount
+	a.info.BytesUsed += r.BytesUsed
+	a.info.Hash = chexor(a.info.Hash, r.Name, recordHashValue(&r))
+	if r.Rowid > a.info.MaxRow {
+		a.info.MaxRow = r.Rowid
+	}
+}
+
+// remove deletes a record, keeping the stats and hash up to date.
+func (a *memoryAccount) remove(r *ContainerRecord) {
+	delete(a.containers, r.Name)
+	if stat := a.policyStats[r.StoragePolicyIndex]; stat != nil {
+		stat.ContainerCount -= int64(1 - r.Deleted)
+		stat.ObjectCount -= r.ObjectCount
+		stat.BytesUsed -= r.BytesUsed
+	}
+	a.info.ContainerCount -= int64(1 - r.Deleted)
+	a.info.ObjectCount -= r.ObjectCount
+	a.info.BytesUsed -= r.BytesUsed
+	a.info.Hash = chexor(a.info.Hash, r.Name, recordHashValue(r))
+}
+
+// sortedRecords returns the records with a ROWID greater than start, in ROWID order.
+func (a *memoryAccount) sortedRecords(start int64) []*ContainerRecord {
+	records := []*ContainerRecord{}
+	for _, r := range a.containers {
+		if r.Rowid > start {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Rowid < records[j].Rowid })
+	return records
+}
+
+func (a *memoryAccount) metadata() (map[string][]string, error) {
+	metadata := map[string][]string{}
+	if a.info.RawMetadata != "" {
+		if err := json.Unmarshal([]byte(a.info.RawMetadata), &metadata); err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// GetInfo returns the account's information as an AccountInfo struct.
+func (a *memoryAccount) GetInfo() (*AccountInfo, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	info := a.info
+	info.updated = time.Now()
+	metadata, err := a.metadata()
+	if err != nil {
+		return nil, err
+	}
+	info.Metadata = metadata
+	return &info, nil
+}
+
+// PolicyStats returns the account's per-policy container, object, and byte counts.
+func (a *memoryAccount) PolicyStats() ([]*PolicyStat, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	var list []*PolicyStat
+	for _, stat := range a.policyStats {
+		s := *stat
+		list = append(list, &s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StoragePolicyIndex < list[j].StoragePolicyIndex })
+	return list, nil
+}
+
+// IsDeleted returns true if the account is deleted - if its delete timestamp is later than its put timestamp.
+func (a *memoryAccount) IsDeleted() (bool, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.info.DeleteTimestamp > a.info.PutTimestamp, nil
+}
+
+// Remove drops the account from the engine.
+func (a *memoryAccount) Remove() error {
+	a.engine.m.Lock()
+	if a.engine.accounts[a.key] == a {
+		delete(a.engine.accounts, a.key)
+	}
+	a.engine.m.Unlock()
+	return nil
+}
+
+// Delete sets the account's deleted timestamp and tombstones any metadata older than that timestamp.
+// This may or may not make the account "deleted".
+func (a *memoryAccount) Delete(timestamp string) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.info.DeleteTimestamp >= timestamp {
+		return common.ErrConflict
+	}
+	metadata, err := a.metadata()
+	if err != nil {
+		return err
+	}
+	for key, value := range metadata {
+		if value[1] < timestamp {
+			metadata[key] = []string{"", timestamp}
+		}
+	}
+	serializedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	a.info.DeleteTimestamp = timestamp
+	a.info.RawMetadata = string(serializedMetadata)
+	return nil
+}
+
+// MergeItems merges ContainerRecords into the account, combining the timestamps of any record it already has for
+// the container.  If a remote id is provided (incoming replication), the incoming sync table is updated.
+func (a *memoryAccount) MergeItems(records []*ContainerRecord, remoteID string) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	var maxRowid int64 = -1
+	for _, record := range records {
+		if record.Rowid > maxRowid {
+			maxRowid = record.Rowid
+		}
+		r := *record
+		if er, exists := a.containers[r.Name]; exists {
+			a.remove(er)
+			if er.PutTimestamp > r.PutTimestamp {
+				r.PutTimestamp = er.PutTimestamp
+			}
+			if er.DeleteTimestamp > r.DeleteTimestamp {
+				r.DeleteTimestamp = er.DeleteTimestamp
+			}
+			if r.DeleteTimestamp > r.PutTimestamp {
+				r.Deleted = 1
+			} else {
+				r.Deleted = 0
+			}
+		}
+		r.Rowid = a.info.MaxRow + 1
+		if r.Rowid < 1 {
+			r.Rowid = 1
+		}
+		a.insert(&r)
+	}
+	if remoteID != "" && maxRowid > -1 {
+		a.incomingSync[remoteID] = maxRowid
+	}
+	return nil
+}
+
+// ListContainers implements container listings.  It walks the listing in name order the same way the sqlite backend
+// pages through its queries, so the two list identically.
+func (a *memoryAccount) ListContainers(limit int, marker string, endMarker string, prefix string, delimiter string,
+	reverse bool) ([]interface{}, error) {
+	a.m.Lock()
+	records := []*ContainerRecord{}
+	for _, r := range a.containers {
+		if r.Deleted == 0 {
+			rc := *r
+			records = append(records, &rc)
+		}
+	}
+	a.m.Unlock()
+	if reverse {
+		marker, endMarker = endMarker, marker
+		sort.Slice(records, func(i, j int) bool { return records[i].Name > records[j].Name })
+	} else {
+		sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	}
+	results := []interface{}{}
+	point := ""
+	for _, r := range records {
+		if len(results) >= limit {
+			break
+		}
+		if (prefix != "" && (r.Name < prefix || r.Name > prefix+"\xFF")) ||
+			(marker != "" && r.Name <= marker) || (endMarker != "" && r.Name >= endMarker) ||
+			(point != "" && ((reverse && r.Name >= point) || (!reverse && r.Name <= point))) {
+			continue
+		}
+		if delimiter != "" {
+			end := indexAfter(r.Name, delimiter, len(prefix))
+			if end >= 0 && len(r.Name) > end+1 {
+				dirName := r.Name[:end] + delimiter
+				if reverse {
+					point = r.Name[:end+len(delimiter)]
+				} else {
+					point = dirName + "\xFF"
+				}
+				if dirName != marker {
+					results = append(results, &SubdirListingRecord{Name2: dirName, Name: dirName})
+				}
+				continue
+			}
+		}
+		record := &ContainerListingRecord{Name: r.Name, Count: r.ObjectCount, Bytes: r.BytesUsed, StoragePolicyIndex: r.StoragePolicyIndex}
+		if f, err := strconv.ParseFloat(r.PutTimestamp, 64); err != nil {
+			return nil, err
+		} else {
+			whole, nans := math.Modf(f)
+			record.LastModified = time.Unix(int64(whole), int64(nans*1.0e9)).In(common.GMT).Format("2006-01-02T15:04:05.000000")
+		}
+		results = append(results, record)
+	}
+	return results, nil
+}
+
+// NewID sets the account's ID to a new, random string, recording the old one as synced through the current max row.
+func (a *memoryAccount) NewID() error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.incomingSync[a.info.ID] = a.info.MaxRow
+	a.info.ID = common.UUID()
+	return nil
+}
+
+// ItemsSince returns (count) container records with a rowid greater than (start).
+func (a *memoryAccount) ItemsSince(start int64, count int) ([]*ContainerRecord, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	records := a.sortedRecords(start)
+	if len(records) > count {
+		records = records[:count]
+	}
+	for i, r := range records {
+		rc := *r
+		records[i] = &rc
+	}
+	return records, nil
+}
+
+// GetMetadata returns the current account metadata as a simple map[string]string, i.e. it leaves out tombstones and timestamps.
+func (a *memoryAccount) GetMetadata() (map[string]string, error) {
+	info, err := a.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string)
+	for key, value := range info.Metadata {
+		if value[0] != "" {
+			metadata[key] = value[0]
+		}
+	}
+	return metadata, nil
+}
+
+// UpdateMetadata merges the current account metadata with new incoming metadata.
+func (a *memoryAccount) UpdateMetadata(newMetadata map[string][]string) error {
+	if len(newMetadata) == 0 {
+		return nil
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	existingMetadata, err := a.metadata()
+	if err != nil {
+		return err
+	}
+	metastr, err := mergeMetas(existingMetadata, newMetadata, a.info.DeleteTimestamp)
+	if err != nil {
+		return err
+	}
+	a.info.RawMetadata = metastr
+	return nil
+}
+
+// MergeSyncTable updates the account's incoming sync table records.
+func (a *memoryAccount) MergeSyncTable(records []*SyncRecord) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, record := range records {
+		a.incomingSync[record.RemoteID] = record.SyncPoint
+	}
+	return nil
+}
+
+// CleanupTombstones removes any expired tombstoned containers or metadata.
+func (a *memoryAccount) CleanupTombstones(reclaimAge int64) error {
+	now := float64(time.Now().UnixNano()) / 1000000000.0
+	reclaimTimestamp := common.CanonicalTimestamp(now - float64(reclaimAge))
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, r := range a.containers {
+		if r.Deleted == 1 && r.DeleteTimestamp < reclaimTimestamp {
+			a.remove(r)
+		}
+	}
+	metadata, err := a.metadata()
+	if err != nil {
+		return err
+	}
+	for k, v := range metadata {
+		if v[0] == "" {
+			if ts, err := common.GetEpochFromTimestamp(v[1]); err != nil || ts < reclaimTimestamp {
+				delete(metadata, k)
+			}
+		}
+	}
+	mb, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	a.info.RawMetadata = string(mb)
+	return nil
+}
+
+// SyncTable returns the account's current incoming sync table, and also includes the current account's id and max row as an entry.
+func (a *memoryAccount) SyncTable() ([]*SyncRecord, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	records := []*SyncRecord{{SyncPoint: a.info.MaxRow, RemoteID: a.info.ID}}
+	for remoteID, point := range a.incomingSync {
+		if remoteID != a.info.ID {
+			records = append(records, &SyncRecord{SyncPoint: point, RemoteID: remoteID})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].SyncPoint != records[j].SyncPoint {
+			return records[i].SyncPoint < records[j].SyncPoint
+		}
+		return records[i].RemoteID < records[j].RemoteID
+	})
+	return records, nil
+}
+
+// SyncRemoteData compares a remote account's info to the local info and updates any necessary replication bookkeeping, returning the current account's info.
+func (a *memoryAccount) SyncRemoteData(maxRow int64, hash, id, createdAt, putTimestamp, deleteTimestamp, metadata string) (*AccountInfo, error) {
+	a.m.Lock()
+	var rm map[string][]string
+	if err := json.Unmarshal([]byte(metadata), &rm); err != nil {
+		a.m.Unlock()
+		return nil, err
+	}
+	lm, err := a.metadata()
+	if err != nil {
+		a.m.Unlock()
+		return nil, err
+	}
+	if deleteTimestamp > a.info.DeleteTimestamp {
+		a.info.DeleteTimestamp = deleteTimestamp
+	}
+	metastr, err := mergeMetas(lm, rm, a.info.DeleteTimestamp)
+	if err != nil {
+		a.m.Unlock()
+		return nil, err
+	}
+	a.info.RawMetadata = metastr
+	if createdAt < a.info.CreatedAt {
+		a.info.CreatedAt = createdAt
+	}
+	if putTimestamp > a.info.PutTimestamp {
+		a.info.PutTimestamp = putTimestamp
+	}
+	localPoint, ok := a.incomingSync[id]
+	if !ok {
+		localPoint = -1
+	}
+	if a.info.Hash == hash && maxRow > localPoint {
+		localPoint = maxRow
+		a.incomingSync[id] = localPoint
+	}
+	a.m.Unlock()
+	info, err := a.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	info.Point = localPoint
+	return info, nil
+}
+
+// OpenDatabaseFile writes the account out to a temporary sqlite database, since that's what replication uploads,
+// and opens it for reading.  The cleanup function removes it.
+func (a *memoryAccount) OpenDatabaseFile() (*os.File, func(), error) {
+	dir, err := ioutil.TempDir("", "memoryaccount")
+	if err != nil {
+		return nil, nil, err
+	}
+	dbFile := filepath.Join(dir, a.ringHash, a.ringHash+".db")
+	if err := a.export(dbFile); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	fp, err := os.Open(dbFile)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("Error opening %s: %v", dbFile, err)
+	}
+	return fp, func() {
+		fp.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// export creates a sqlite database with the account's contents, keeping its ROWIDs.
+func (a *memoryAccount) export(dbFile string) error {
+	a.m.Lock()
+	info := a.info
+	records := a.sortedRecords(-1)
+	syncs := make(map[string]int64, len(a.incomingSync))
+	for remoteID, point := range a.incomingSync {
+		syncs[remoteID] = point
+	}
+	a.m.Unlock()
+	if err := sqliteCreateAccount(dbFile, info.Account, info.PutTimestamp, nil); err != nil {
+		return err
+	}
+	ra, err := sqliteOpenAccount(dbFile)
+	if err != nil {
+		return err
+	}
+	db := ra.(*sqliteAccount)
+	defer db.Close()
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE account_stat SET created_at = ?, delete_timestamp = ?, status_changed_at = ?, id = ?, metadata = ?",
+		info.CreatedAt, info.DeleteTimestamp, info.StatusChangedAt, info.ID, info.RawMetadata); err != nil {
+		return err
+	}
+	ast, err := tx.Prepare(`INSERT INTO container (ROWID, name, put_timestamp, delete_timestamp, object_count, bytes_used, deleted, storage_policy_index)
+							VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer ast.Close()
+	for _, r := range records {
+		if _, err := ast.Exec(r.Rowid, r.Name, r.PutTimestamp, r.DeleteTimestamp, r.ObjectCount, r.BytesUsed, r.Deleted, r.StoragePolicyIndex); err != nil {
+			return err
+		}
+	}
+	// Rows removed since the max row was handed out still count, so the next ROWID matches what the account would use.
+	if _, err := tx.Exec(`UPDATE sqlite_sequence SET seq = ? WHERE name = 'container';
+						  INSERT INTO sqlite_sequence (name, seq) SELECT 'container', ? WHERE changes() == 0 AND ? > -1;`,
+		info.MaxRow, info.MaxRow, info.MaxRow); err != nil {
+		return err
+	}
+	for remoteID, point := range syncs {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO incoming_sync (remote_id, sync_point) VALUES (?, ?)", remoteID, point); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Ping does nothing, since there's no database file that could go away.
+func (a *memoryAccount) Ping() error {
+	return nil
+}
+
+// ID returns the account's ring hash as a unique identifier for it.
+func (a *memoryAccount) ID() string {
+	return a.ringHash
+}
+
+// RingHash returns the account's ring hash as a string.
+func (a *memoryAccount) RingHash() string {
+	return a.ringHash
+}
+
+// PutContainer adds a container to the account.
+func (a *memoryAccount) PutContainer(name string, putTimestamp string, deleteTimestamp string, objectCount int64, bytesUsed int64, storagePolicyIndex int) error {
+	deleted := 0
+	if deleteTimestamp > putTimestamp {
+		deleted = 1
+	}
+	return a.MergeItems([]*ContainerRecord{{
+		Name:               name,
+		PutTimestamp:       putTimestamp,
+		DeleteTimestamp:    deleteTimestamp,
+		ObjectCount:        objectCount,
+		BytesUsed:          bytesUsed,
+		Deleted:            deleted,
+		StoragePolicyIndex: storagePolicyIndex,
+	}}, "")
+}
+
+// Close does nothing, since the account's data lives on in the engine.
+func (a *memoryAccount) Close() error {
+	return nil
+}
+
+// createExisting updates an existing account for a PUT, returning true if it had been deleted and is now recreated.
+func (a *memoryAccount) createExisting(putTimestamp string, newMetadata map[string][]string) (bool, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	existingMetadata, err := a.metadata()
+	if err != nil {
+		return false, err
+	}
+	metastr, err := mergeMetas(existingMetadata, newMetadata, a.info.DeleteTimestamp)
+	if err != nil {
+		return false, err
+	}
+	recreated := a.info.DeleteTimestamp > a.info.PutTimestamp && putTimestamp > a.info.DeleteTimestamp
+	a.info.PutTimestamp = putTimestamp
+	a.info.RawMetadata = metastr
+	return recreated, nil
+}
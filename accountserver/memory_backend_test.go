@@ -0,0 +1,307 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package accountserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/test"
+	"go.uber.org/zap"
+)
+
+func createMemoryAccount(t *testing.T, e *memoryEngine, account string) *memoryAccount {
+	_, a, err := e.Create(map[string]string{"device": "sda", "account": account}, common.GetTimestamp(), nil)
+	require.Nil(t, err)
+	return a.(*memoryAccount)
+}
+
+func TestAccountEngineRegistry(t *testing.T) {
+	for _, name := range []string{"sqlite", "memory"} {
+		constructor, err := FindAccountEngine(name)
+		require.Nil(t, err)
+		engine, err := constructor(conf.Config{}, "/srv/node", "changeme", "changeme")
+		require.Nil(t, err)
+		require.NotNil(t, engine)
+	}
+	constructor, err := FindAccountEngine("hopefullynotfound")
+	require.Nil(t, constructor)
+	require.NotNil(t, err)
+}
+
+func TestMemoryListsLikeSqlite(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase(common.GetTimestamp())
+	require.Nil(t, err)
+	defer cleanup()
+	a := createMemoryAccount(t, newMemoryEngine("changeme", "changeme"), "a")
+	names := []string{"a", "a-", "a-b", "a-b-c", "a-c", "b", "b-a", "c-d-e", "d"}
+	for _, account := range []Account{db, a} {
+		for i, name := range names {
+			require.Nil(t, account.PutContainer(name, common.CanonicalTimestamp(float64(1000000000+i)), "0", int64(i), int64(i*10), i%2))
+		}
+		require.Nil(t, account.PutContainer("d", "0", common.CanonicalTimestamp(2000000000), 0, 0, 0))
+	}
+	expectedInfo, err := db.GetInfo()
+	require.Nil(t, err)
+	info, err := a.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, expectedInfo.Hash, info.Hash)
+	require.Equal(t, expectedInfo.MaxRow, info.MaxRow)
+	require.Equal(t, expectedInfo.ContainerCount, info.ContainerCount)
+	require.Equal(t, expectedInfo.ObjectCount, info.ObjectCount)
+	require.Equal(t, expectedInfo.BytesUsed, info.BytesUsed)
+	expectedStats, err := db.PolicyStats()
+	require.Nil(t, err)
+	stats, err := a.PolicyStats()
+	require.Nil(t, err)
+	require.Equal(t, expectedStats, stats)
+	for _, args := range []struct {
+		marker, endMarker, prefix, delimiter string
+		reverse                              bool
+	}{
+		{},
+		{marker: "a-b", endMarker: "c"},
+		{prefix: "a-"},
+		{delimiter: "-"},
+		{delimiter: "-", reverse: true},
+		{prefix: "a-", delimiter: "-"},
+		{marker: "a-", delimiter: "-"},
+	} {
+		expected, err := db.ListContainers(100, args.marker, args.endMarker, args.prefix, args.delimiter, args.reverse)
+		require.Nil(t, err)
+		listed, err := a.ListContainers(100, args.marker, args.endMarker, args.prefix, args.delimiter, args.reverse)
+		require.Nil(t, err)
+		require.Equal(t, expected, listed, fmt.Sprintf("%+v", args))
+	}
+}
+
+func TestMemoryAccountDelete(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	a := createMemoryAccount(t, e, "a")
+	require.Nil(t, a.UpdateMetadata(map[string][]string{"X-Account-Meta-Test": {"value", common.CanonicalTimestamp(1)}}))
+	timestamp := common.GetTimestamp()
+	require.Nil(t, a.Delete(timestamp))
+	require.Equal(t, common.ErrConflict, a.Delete(timestamp))
+	deleted, err := a.IsDeleted()
+	require.Nil(t, err)
+	require.True(t, deleted)
+	metadata, err := a.GetMetadata()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(metadata))
+	created, _, err := e.Create(map[string]string{"device": "sda", "account": "a"}, common.GetTimestamp(), nil)
+	require.Nil(t, err)
+	require.True(t, created)
+	deleted, err = a.IsDeleted()
+	require.Nil(t, err)
+	require.False(t, deleted)
+}
+
+func TestMemoryReplication(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	a1 := createMemoryAccount(t, e, "a1")
+	a2 := createMemoryAccount(t, e, "a2")
+	require.Nil(t, mergeItemsByName(a1, []string{"a", "b", "c"}))
+	require.Nil(t, a1.PutContainer("b", "0", common.GetTimestamp(), 0, 0, 0))
+
+	records, err := a1.ItemsSince(-1, 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	require.True(t, records[0].Rowid < records[1].Rowid)
+	records, err = a1.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	require.Equal(t, "b", records[2].Name)
+	require.Equal(t, 1, records[2].Deleted)
+
+	info1, err := a1.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, int64(2), info1.ContainerCount)
+	require.Nil(t, a2.MergeItems(records, info1.ID))
+	info2, err := a2.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info1.Hash, info2.Hash)
+	require.Equal(t, int64(2), info2.ContainerCount)
+
+	syncs, err := a2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(syncs))
+	info, err := a2.SyncRemoteData(info1.MaxRow, info1.Hash, info1.ID, info1.CreatedAt, info1.PutTimestamp, info1.DeleteTimestamp, info1.RawMetadata)
+	require.Nil(t, err)
+	require.Equal(t, info1.MaxRow, info.Point)
+
+	require.Nil(t, a2.NewID())
+	syncs, err = a2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 3, len(syncs))
+	require.Nil(t, a2.MergeSyncTable([]*SyncRecord{{RemoteID: "other", SyncPoint: 7}}))
+	syncs, err = a2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 4, len(syncs))
+
+	require.Nil(t, a1.CleanupTombstones(-1))
+	records, err = a1.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+}
+
+func TestMemoryExportImport(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	a := createMemoryAccount(t, e, "a")
+	require.Nil(t, a.UpdateMetadata(map[string][]string{"X-Account-Meta-Test": {"value", common.GetTimestamp()}}))
+	require.Nil(t, mergeItemsByName(a, []string{"a", "b", "c"}))
+	require.Nil(t, a.PutContainer("a", common.GetTimestamp(), "0", 5, 50, 1))
+	require.Nil(t, a.MergeSyncTable([]*SyncRecord{{RemoteID: "other", SyncPoint: 7}}))
+	info, err := a.GetInfo()
+	require.Nil(t, err)
+
+	fp, release, err := a.OpenDatabaseFile()
+	require.Nil(t, err)
+	defer release()
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dbFile := filepath.Join(dir, "db", "db.db")
+	require.Nil(t, os.MkdirAll(filepath.Dir(dbFile), 0777))
+	out, err := os.Create(dbFile)
+	require.Nil(t, err)
+	_, err = io.Copy(out, fp)
+	require.Nil(t, err)
+	out.Close()
+	db, err := sqliteOpenAccount(dbFile)
+	require.Nil(t, err)
+	defer db.Close()
+
+	exported, err := db.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info.ID, exported.ID)
+	require.Equal(t, info.Hash, exported.Hash)
+	require.Equal(t, info.MaxRow, exported.MaxRow)
+	require.Equal(t, info.ContainerCount, exported.ContainerCount)
+	require.Equal(t, info.ObjectCount, exported.ObjectCount)
+	require.Equal(t, info.Metadata, exported.Metadata)
+	expectedRecords, err := a.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	records, err := db.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, expectedRecords, records)
+	expectedStats, err := a.PolicyStats()
+	require.Nil(t, err)
+	stats, err := db.PolicyStats()
+	require.Nil(t, err)
+	require.Equal(t, expectedStats, stats)
+	syncs, err := db.SyncTable()
+	require.Nil(t, err)
+	expectedSyncs, err := a.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, len(expectedSyncs), len(syncs))
+
+	e2 := newMemoryEngine("changeme", "changeme")
+	require.Nil(t, e2.Import("sda", a.RingHash(), "1", db))
+	imported, err := e2.GetByHash("sda", a.RingHash(), "1")
+	require.Nil(t, err)
+	importedInfo, err := imported.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info.Hash, importedInfo.Hash)
+	require.Equal(t, info.MaxRow, importedInfo.MaxRow)
+	require.Equal(t, info.ContainerCount, importedInfo.ContainerCount)
+	records, err = imported.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, expectedRecords, records)
+	syncs, err = imported.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, expectedSyncs, syncs)
+}
+
+func makeMemoryTestServer() (http.Handler, func(), error) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Mkdir(filepath.Join(dir, "device"), 0777); err != nil {
+		return nil, nil, err
+	}
+	server := &AccountServer{
+		driveRoot:        dir,
+		hashPathPrefix:   "changeme",
+		hashPathSuffix:   "changeme",
+		logLevel:         zap.NewAtomicLevelAt(zap.InfoLevel),
+		logger:           zap.NewNop(),
+		checkMounts:      false,
+		accountEngine:    newMemoryEngine("changeme", "changeme"),
+		diskInUse:        common.NewKeyedLimit(2, 2),
+		autoCreatePrefix: ".",
+		policyList: conf.PolicyList{
+			0: {Index: 0, Name: "gold", Default: true},
+		},
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+	return server.GetHandler(*new(conf.Config), fmt.Sprintf("test_accountserver_%d", atomic.AddUint64(&makeTestServerCounter, 1))), cleanup, nil
+}
+
+func TestMemoryServerReplicateCompleteRsync(t *testing.T) {
+	handler, cleanup, err := makeMemoryTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	db, _, dbCleanup, err := createTestDatabase(common.GetTimestamp())
+	require.Nil(t, err)
+	defer dbCleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a", "b", "c"}))
+	tmpFilename := common.UUID()
+	fp, release, err := db.OpenDatabaseFile()
+	require.Nil(t, err)
+	defer release()
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/tmp/"+tmpFilename, fp)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	accountHash := newMemoryEngine("changeme", "changeme").ringHash(map[string]string{"account": "a"})
+	msg, err := json.Marshal([]interface{}{"complete_rsync", tmpFilename})
+	require.Nil(t, err)
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("REPLICATE", "/device/1/"+accountHash, bytes.NewBuffer(msg))
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, http.StatusNoContent, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, "3", rsp.Header().Get("X-Account-Container-Count"))
+
+	// The account exists now, so completing the same rsync again is refused.
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("REPLICATE", "/device/1/"+accountHash, bytes.NewBuffer(msg))
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+}
@@ -391,12 +391,12 @@ func (rd *replicationDevice) replicateDatabase(dbFile string) error {
 	return nil
 }
 
-func (rd *replicationDevice) findAccountDbs(devicePath string, results chan string) {
+func findAccountDbs(devicePath string, results chan string, cancel chan struct{}, logger srv.LowLevelLogger) {
 	defer close(results)
 	accountsDir := filepath.Join(devicePath, "accounts")
 	partitions, err := filepath.Glob(filepath.Join(accountsDir, "[0-9]*"))
 	if err != nil {
-		rd.r.logger.Error("Error getting partitions.",
+		logger.Error("Error getting partitions.",
 			zap.String("accountsDir", accountsDir),
 			zap.Error(err))
 		return
@@ -404,7 +404,7 @@ func (rd *replicationDevice) findAccountDbs(devicePath string, results chan stri
 	for _, part := range partitions {
 		suffixes, err := filepath.Glob(filepath.Join(part, "[a-f0-9][a-f0-9][a-f0-9]"))
 		if err != nil {
-			rd.r.logger.Error("Error getting suffixes.",
+			logger.Error("Error getting suffixes.",
 				zap.String("part", part),
 				zap.Error(err))
 			return
@@ -412,7 +412,7 @@ func (rd *replicationDevice) findAccountDbs(devicePath string, results chan stri
 		for _, suff := range suffixes {
 			hashes, err := filepath.Glob(filepath.Join(suff, "????????????????????????????????"))
 			if err != nil {
-				rd.r.logger.Error("Error getting hashes",
+				logger.Error("Error getting hashes",
 					zap.String("suff", suff),
 					zap.Error(err))
 				return
@@ -422,7 +422,7 @@ func (rd *replicationDevice) findAccountDbs(devicePath string, results chan stri
 				if fs.Exists(dbFile) {
 					select {
 					case results <- dbFile:
-					case <-rd.cancel:
+					case <-cancel:
 						return
 					}
 				}
@@ -431,6 +431,10 @@ func (rd *replicationDevice) findAccountDbs(devicePath string, results chan stri
 	}
 }
 
+func (rd *replicationDevice) findAccountDbs(devicePath string, results chan string) {
+	findAccountDbs(devicePath, results, rd.cancel, rd.r.logger)
+}
+
 func (rd *replicationDevice) replicate() {
 	rd.r.logger.Info("Beginning replication for device.",
 		zap.String("device", rd.dev.Device))
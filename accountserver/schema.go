@@ -153,6 +153,15 @@ const (
 		PRAGMA temp_store = MEMORY;
 		PRAGMA journal_mode = WAL;
 		PRAGMA busy_timeout = 25000;`
+
+	// compactScript rebuilds the database without its free pages.  VACUUM's scratch copy goes to disk rather than
+	// memory, since the databases worth compacting tend to be big ones, and the checkpoint moves the rebuilt pages
+	// out of the wal so the database file actually shrinks.
+	compactScript = `
+		PRAGMA temp_store = FILE;
+		VACUUM;
+		PRAGMA temp_store = MEMORY;
+		PRAGMA wal_checkpoint(TRUNCATE);`
 )
 
 func schemaMigrate(db *sql.DB) (bool, error) {
@@ -21,6 +21,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof" // install pprof http handlers
 	"path/filepath"
@@ -194,23 +195,42 @@ func (server *AccountServer) AccountGetHandler(writer http.ResponseWriter, reque
 	endMarker := request.Form.Get("end_marker")
 	prefix := request.Form.Get("prefix")
 	reverse := common.LooksTrue(request.Form.Get("reverse"))
-	containers, err := db.ListContainers(int(limit), marker, endMarker, prefix, delimiter, reverse)
+	format := common.ListingFormat(request.Form.Get("format"), request.Header.Get("Accept"))
+	if format == "" {
+		srv.StandardResponse(writer, http.StatusNotAcceptable)
+		return
+	}
+	list := func(limit int, marker string) ([]interface{}, error) {
+		containers, err := db.ListContainers(limit, marker, endMarker, prefix, delimiter, reverse)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range containers {
+			if record, ok := obj.(*ContainerListingRecord); ok {
+				if policy := server.policyList[record.StoragePolicyIndex]; policy != nil {
+					record.StoragePolicy = policy.Name
+				} else {
+					record.StoragePolicy = strconv.Itoa(record.StoragePolicyIndex)
+				}
+			}
+		}
+		return containers, nil
+	}
+	if common.StreamedListingFormat(format) {
+		if status, err := common.StreamListing(writer, format, common.ContainerListingCSVHeader, int(limit), marker, list); err != nil {
+			srv.GetLogger(request).Error("Unable to list containers.", zap.Error(err))
+			if status == 0 {
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+	containers, err := list(int(limit), marker)
 	if err != nil {
 		srv.GetLogger(request).Error("Unable to list containers.", zap.Error(err))
 		srv.StandardResponse(writer, http.StatusInternalServerError)
 		return
 	}
-	format := request.Form.Get("format")
-	if format == "" { /* TODO: real accept parsing */
-		accept := request.Header.Get("Accept")
-		if strings.Contains(accept, "application/json") {
-			format = "json"
-		} else if strings.Contains(accept, "application/xml") || strings.Contains(accept, "text/xml") {
-			format = "xml"
-		} else {
-			format = "text"
-		}
-	}
 	if format == "json" {
 		output, err := json.Marshal(containers)
 		if err != nil {
@@ -259,6 +279,10 @@ func (server *AccountServer) AccountGetHandler(writer http.ResponseWriter, reque
 // AccountPutHandler handles PUT requests for an account.
 func (server *AccountServer) AccountPutHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := srv.GetVars(request)
+	if request.Header.Get("X-Backend-Record-Type") == "container" {
+		server.containersPut(writer, request, vars)
+		return
+	}
 	timestamp, err := common.StandardizeTimestamp(request.Header.Get("X-Timestamp"))
 	if err != nil {
 		srv.StandardResponse(writer, http.StatusBadRequest)
@@ -413,6 +437,57 @@ func (server *AccountServer) ContainerPutHandler(writer http.ResponseWriter, req
 	srv.StandardResponse(writer, http.StatusCreated)
 }
 
+// containersPut adds the json list of container records in the request body to the account.  It is used by
+// container updaters to batch the stats of many containers in the same account.
+func (server *AccountServer) containersPut(writer http.ResponseWriter, request *http.Request, vars map[string]string) {
+	var records []*ContainerRecord
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &records); err != nil || len(records) == 0 {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	oldest := ""
+	for _, record := range records {
+		if record.PutTimestamp, err = common.StandardizeTimestamp(record.PutTimestamp); err != nil || record.Name == "" {
+			srv.StandardResponse(writer, http.StatusBadRequest)
+			return
+		}
+		if oldest == "" || record.PutTimestamp < oldest {
+			oldest = record.PutTimestamp
+		}
+	}
+	db, err := server.accountEngine.Get(vars)
+	if err == ErrorNoSuchAccount {
+		if strings.HasPrefix(vars["account"], server.autoCreatePrefix) {
+			if _, db, err = server.accountEngine.Create(vars, oldest, map[string][]string{}); err != nil {
+				srv.GetLogger(request).Error("Unable to auto-create account.", zap.Error(err))
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+				return
+			}
+		} else {
+			srv.StandardResponse(writer, http.StatusNotFound)
+			return
+		}
+	} else if err != nil {
+		srv.GetLogger(request).Error("Unable to get account.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	defer server.accountEngine.Return(db)
+	for _, record := range records {
+		if err := db.PutContainer(record.Name, record.PutTimestamp, record.DeleteTimestamp, record.ObjectCount, record.BytesUsed, record.StoragePolicyIndex); err != nil {
+			srv.GetLogger(request).Error("Error adding container to account.", zap.Error(err))
+			srv.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+	}
+	srv.StandardResponse(writer, http.StatusAccepted)
+}
+
 // HealthcheckHandler implements a basic health check, that just returns "OK".
 func (server *AccountServer) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Length", "2")
@@ -554,7 +629,12 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 	if server.logger, err = srv.SetupLogger("account-server", &server.logLevel, flags); err != nil {
 		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
 	}
-	server.accountEngine = newLRUEngine(server.driveRoot, server.hashPathPrefix, server.hashPathSuffix, 32)
+	engineName := serverconf.GetDefault("app:account-server", "engine", "sqlite")
+	if newEngine, err := FindAccountEngine(engineName); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find account engine type %s: %v", engineName, err)
+	} else if server.accountEngine, err = newEngine(serverconf, server.driveRoot, server.hashPathPrefix, server.hashPathSuffix); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error instantiating account engine type %s: %v", engineName, err)
+	}
 	if serverconf.HasSection("tracing") {
 		server.tracer, server.traceCloser, err = tracing.Init("accountserver", server.logger, serverconf.GetSection("tracing"))
 		if err != nil {
@@ -16,11 +16,13 @@
 package accountserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 
@@ -52,6 +54,10 @@ func makeTestServer() (http.Handler, func(), error) {
 		accountEngine:    newLRUEngine(dir, "changeme", "changeme", 32),
 		diskInUse:        common.NewKeyedLimit(2, 2),
 		autoCreatePrefix: ".",
+		policyList: conf.PolicyList{
+			0: {Index: 0, Name: "gold", Default: true},
+			1: {Index: 1, Name: "silver"},
+		},
 	}
 	cleanup := func() {
 		os.RemoveAll(dir)
@@ -258,6 +264,162 @@ func TestAccountPutContainersGet(t *testing.T) {
 	require.Equal(t, "application/xml; charset=utf-8", rsp.Header().Get("Content-Type"))
 }
 
+func TestAccountPutContainerBatch(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	put := func(path, body string) int {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.Header.Set("X-Backend-Record-Type", "container")
+		handler.ServeHTTP(rsp, req)
+		return rsp.Status
+	}
+	batch := `[{"name": "c1", "put_timestamp": "100000000.00002", "delete_timestamp": "", "object_count": 2, "bytes_used": 20},
+		{"name": "c2", "put_timestamp": "100000000.00003", "delete_timestamp": "", "object_count": 3, "bytes_used": 30, "storage_policy_index": 1}]`
+	require.Equal(t, 404, put("/device/1/a", batch))
+	require.Equal(t, 400, put("/device/1/a", `[]`))
+	require.Equal(t, 400, put("/device/1/a", `[{"name": "c1", "put_timestamp": "never"}]`))
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+	require.Equal(t, 202, put("/device/1/a", batch))
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+	require.Equal(t, "2", rsp.Header().Get("X-Account-Container-Count"))
+	require.Equal(t, "5", rsp.Header().Get("X-Account-Object-Count"))
+	require.Equal(t, "50", rsp.Header().Get("X-Account-Bytes-Used"))
+	require.Equal(t, "3", rsp.Header().Get("X-Account-Storage-Policy-Silver-Object-Count"))
+
+	// Accounts with the auto-create prefix are created by the batch.
+	require.Equal(t, 202, put("/device/1/.shards_a", batch))
+}
+
+func TestAccountPolicyUsage(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	for i, policy := range []string{"0", "1", "1"} {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", fmt.Sprintf("/device/1/a/c%d", i), nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Put-Timestamp", common.GetTimestamp())
+		req.Header.Set("X-Object-Count", "2")
+		req.Header.Set("X-Bytes-Used", "10")
+		req.Header.Set("X-Backend-Storage-Policy-Index", policy)
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+	require.Equal(t, "1", rsp.Header().Get("X-Account-Storage-Policy-Gold-Container-Count"))
+	require.Equal(t, "2", rsp.Header().Get("X-Account-Storage-Policy-Gold-Object-Count"))
+	require.Equal(t, "10", rsp.Header().Get("X-Account-Storage-Policy-Gold-Bytes-Used"))
+	require.Equal(t, "2", rsp.Header().Get("X-Account-Storage-Policy-Silver-Container-Count"))
+	require.Equal(t, "4", rsp.Header().Get("X-Account-Storage-Policy-Silver-Object-Count"))
+	require.Equal(t, "20", rsp.Header().Get("X-Account-Storage-Policy-Silver-Bytes-Used"))
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a?format=json", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	var listing []map[string]interface{}
+	require.Nil(t, json.Unmarshal(rsp.Body.Bytes(), &listing))
+	require.Equal(t, 3, len(listing))
+	require.Equal(t, "gold", listing[0]["storage_policy"])
+	require.Equal(t, "silver", listing[1]["storage_policy"])
+	require.Equal(t, "silver", listing[2]["storage_policy"])
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a?format=xml", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Contains(t, rsp.Body.String(), "<name>c0</name><bytes>10</bytes><count>2</count>")
+	require.Contains(t, rsp.Body.String(), "<storage_policy>gold</storage_policy>")
+}
+
+func TestAccountGetStreamedFormats(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	for _, container := range []string{"c1", "c2"} {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", "/device/1/a/"+container, nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Put-Timestamp", common.GetTimestamp())
+		req.Header.Set("X-Object-Count", "2")
+		req.Header.Set("X-Bytes-Used", "10")
+		req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "application/x-ndjson; charset=utf-8", rsp.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSuffix(rsp.Body.String(), "\n"), "\n")
+	require.Equal(t, 2, len(lines))
+	var record map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+	require.Equal(t, "c2", record["name"])
+	require.Equal(t, "gold", record["storage_policy"])
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a?format=csv&marker=c1", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "text/csv; charset=utf-8", rsp.Header().Get("Content-Type"))
+	lines = strings.Split(strings.TrimSuffix(rsp.Body.String(), "\n"), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Equal(t, "name,count,bytes,last_modified,storage_policy", lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "c2,2,10,"))
+	require.True(t, strings.HasSuffix(lines[1], ",gold"))
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "text/html")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 406, rsp.Status)
+}
+
 func TestContainerGetTextEmpty(t *testing.T) {
 	handler, cleanup, err := makeTestServer()
 	require.Nil(t, err)
@@ -36,6 +36,7 @@ import (
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/dbdaemon"
 	"github.com/troubling/hummingbird/common/fs"
 	"github.com/troubling/hummingbird/common/pickle"
 )
@@ -102,6 +103,8 @@ type sqliteAccount struct {
 }
 
 var _ Account = &sqliteAccount{}
+var _ AuditableAccount = &sqliteAccount{}
+var _ CompactableAccount = &sqliteAccount{}
 
 func (db *sqliteAccount) connect() error {
 	if db.DB != nil {
@@ -427,7 +430,7 @@ func (db *sqliteAccount) ListContainers(limit int, marker string, endMarker stri
 	}
 	var point, pointDirection, queryTail, queryStart string
 
-	queryStart = "SELECT name, object_count, bytes_used, put_timestamp FROM container WHERE "
+	queryStart = "SELECT name, object_count, bytes_used, put_timestamp, storage_policy_index FROM container WHERE "
 	if reverse {
 		marker, endMarker = endMarker, marker
 		queryTail = "ORDER BY name DESC LIMIT ?"
@@ -478,7 +481,7 @@ func (db *sqliteAccount) ListContainers(limit int, marker string, endMarker stri
 		for rows.Next() && len(results) < limit {
 			gotResults = true
 			record := &ContainerListingRecord{}
-			if err := rows.Scan(&record.Name, &record.Count, &record.Bytes, &record.LastModified); err != nil {
+			if err := rows.Scan(&record.Name, &record.Count, &record.Bytes, &record.LastModified, &record.StoragePolicyIndex); err != nil {
 				if common.IsCorruptDBError(err) {
 					return nil, fmt.Errorf("Failed to ListContainers Scan: %v; %v", err, common.QuarantineDir(path.Dir(db.accountFile), 4, "accounts"))
 				}
@@ -604,7 +607,8 @@ func (db *sqliteAccount) GetMetadata() (map[string]string, error) {
 	return metadata, nil
 }
 
-func (db *sqliteAccount) mergeMetas(a map[string][]string, b map[string][]string, deleteTimestamp string) (string, error) {
+// mergeMetas merges two sets of metadata, keeping the newer value of each key and tombstoning any older than deleteTimestamp.
+func mergeMetas(a map[string][]string, b map[string][]string, deleteTimestamp string) (string, error) {
 	newMeta := map[string][]string{}
 	for k, v := range a {
 		newMeta[k] = v
@@ -665,7 +669,7 @@ func (db *sqliteAccount) UpdateMetadata(newMetadata map[string][]string) error {
 	} else if err := json.Unmarshal([]byte(metadataValue), &existingMetadata); err != nil {
 		return err
 	}
-	metastr, err := db.mergeMetas(existingMetadata, newMetadata, deleteTimestamp)
+	metastr, err := mergeMetas(existingMetadata, newMetadata, deleteTimestamp)
 	if err != nil {
 		return err
 	}
@@ -843,7 +847,7 @@ func (db *sqliteAccount) SyncRemoteData(maxRow int64, hash, id, createdAt, putTi
 	if deleteTimestamp > localDeleteTimestamp {
 		localDeleteTimestamp = deleteTimestamp
 	}
-	metastr, err := db.mergeMetas(lm, rm, localDeleteTimestamp)
+	metastr, err := mergeMetas(lm, rm, localDeleteTimestamp)
 	if _, err = tx.Exec(`UPDATE account_stat SET created_at=MIN(?, created_at), put_timestamp=MAX(?, put_timestamp),
 	  					 delete_timestamp=MAX(?, delete_timestamp), metadata=?`,
 		createdAt, putTimestamp, deleteTimestamp, metastr); err != nil {
@@ -1103,7 +1107,7 @@ func sqliteCreateExistingAccount(db Account, putTimestamp string, newMetadata ma
 	} else if err := json.Unmarshal([]byte(cMetadata), &existingMetadata); err != nil {
 		return false, err
 	}
-	metastr, err := cdb.mergeMetas(existingMetadata, newMetadata, cDeleteTimestamp)
+	metastr, err := mergeMetas(existingMetadata, newMetadata, cDeleteTimestamp)
 	if err != nil {
 		return false, err
 	}
@@ -1218,3 +1222,89 @@ func sqliteRename(fromFile, toFile string) error {
 	}
 	return nil
 }
+
+// Audit runs sqlite's quick_check on the database and recomputes its hash from the container records.  A hash that
+// changes while being recomputed isn't treated as a failure, since the account may just be busy.
+func (db *sqliteAccount) Audit() error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return dbdaemon.AuditError(fmt.Sprintf("quick_check failed: %s", result))
+	}
+	var before, after string
+	if err := db.QueryRow("SELECT hash FROM account_stat").Scan(&before); err != nil {
+		return err
+	}
+	// The record's timestamp is built the same way as in the container_insert trigger.
+	rows, err := db.Query(`SELECT name, put_timestamp || '-' || delete_timestamp || '-' || object_count || '-' || bytes_used
+						   FROM container`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	hash := "00000000000000000000000000000000"
+	for rows.Next() {
+		var name, timestamp string
+		if err := rows.Scan(&name, &timestamp); err != nil {
+			return err
+		}
+		hash = chexor(hash, name, timestamp)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT hash FROM account_stat").Scan(&after); err != nil {
+		return err
+	}
+	if before == after && hash != before {
+		return dbdaemon.AuditError(fmt.Sprintf("hash %s doesn't match container records %s", before, hash))
+	}
+	return nil
+}
+
+// freeSpace returns the size of the database and how much of it is free pages that compaction would reclaim.
+func (db *sqliteAccount) freeSpace() (int64, int64, error) {
+	if err := db.connect(); err != nil {
+		return 0, 0, err
+	}
+	var size, free int64
+	if err := db.QueryRow(`SELECT page_size * page_count, page_size * freelist_count
+						   FROM pragma_page_size, pragma_page_count, pragma_freelist_count`).Scan(&size, &free); err != nil {
+		if common.IsCorruptDBError(err) {
+			return 0, 0, fmt.Errorf("Failed to get free space: %v; %v", err, common.QuarantineDir(path.Dir(db.accountFile), 4, "accounts"))
+		}
+		return 0, 0, err
+	}
+	return size, free, nil
+}
+
+// Compact rewrites the database without its free pages if it's at least minSize bytes and at least minFree of it is
+// free, returning its size before and after.  It vacuums in place for the same reasons as the container backend.
+func (db *sqliteAccount) Compact(minSize int64, minFree float64) (int64, int64, error) {
+	if err := db.flush(); err != nil {
+		return 0, 0, err
+	}
+	before, free, err := db.freeSpace()
+	if err != nil {
+		return 0, 0, err
+	}
+	if before < minSize || float64(free) < minFree*float64(before) {
+		return before, before, nil
+	}
+	if _, err := db.Exec(compactScript); err != nil {
+		if common.IsCorruptDBError(err) {
+			return 0, 0, fmt.Errorf("Failed to Compact: %v; %v", err, common.QuarantineDir(path.Dir(db.accountFile), 4, "accounts"))
+		}
+		return 0, 0, fmt.Errorf("Error compacting database %s: %v", db.accountFile, err)
+	}
+	after, _, err := db.freeSpace()
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
@@ -19,11 +19,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/dbdaemon"
 	"github.com/troubling/hummingbird/common/fs"
 )
 
@@ -470,3 +472,47 @@ func TestSqliteRename(t *testing.T) {
 		require.Equal(t, data, []byte("a data"))
 	}
 }
+
+func TestAccountAudit(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a", "b", "c"}))
+	require.Nil(t, db.Audit())
+	_, err = db.Exec("UPDATE account_stat SET hash = '00000000000000000000000000000001'")
+	require.Nil(t, err)
+	_, ok := db.Audit().(dbdaemon.AuditError)
+	require.True(t, ok)
+}
+
+func TestCompact(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	var names []string
+	for i := 0; i < 1000; i++ {
+		names = append(names, strings.Repeat("c", 200)+strconv.Itoa(i))
+	}
+	require.Nil(t, mergeItemsByName(db, names))
+	_, err = db.Exec("DELETE FROM container WHERE name != ?", names[0])
+	require.Nil(t, err)
+
+	// Databases that are too small, or don't have enough free space, are left alone.
+	before, after, err := db.Compact(0, 0.99)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+	before, after, err = db.Compact(before+1, 0)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+
+	before, after, err = db.Compact(0, 0.5)
+	require.Nil(t, err)
+	require.True(t, after < before/2)
+	_, free, err := db.freeSpace()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), free)
+	listing, err := db.ListContainers(10000, "", "", "", "", false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(listing))
+	require.Equal(t, names[0], listing[0].(*ContainerListingRecord).Name)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/troubling/hummingbird/common/ring"
 	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
 )
 
 // RequestClient is similar to github.com/troubling/nectar.Client, but its calls accept a context and it is scoped to a specific API request.
@@ -40,6 +41,10 @@ type RequestClient interface {
 // ProxyClient is the factory for RequestClients, and manages any persistent/shared client resources.
 type ProxyClient interface {
 	NewRequestClient(mc ring.MemcacheRing, lc map[string]*ContainerInfo, logger srv.LowLevelLogger) RequestClient
+	// SetMetricsScope sets where metrics such as backend error limiting are reported.
+	SetMetricsScope(scope tally.Scope)
+	// ErrorLimits returns the error limiting state of backend devices that have recently errored.
+	ErrorLimits() []DeviceErrorLimit
 	Close() error
 }
 
@@ -53,4 +58,6 @@ type ContainerInfo struct {
 	Metadata           map[string]string
 	SysMetadata        map[string]string
 	StoragePolicyIndex int
+	ShardingState      string
+	IndexedMetadata    string
 }
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/uber-go/tally"
+)
+
+const defaultErrorSuppressionLimit = 10
+const defaultErrorSuppressionInterval = 60 * time.Second
+
+// DeviceErrorLimit describes the error state of a single backend device as
+// tracked by the proxy.
+type DeviceErrorLimit struct {
+	Device          string    `json:"device"`
+	Errors          int       `json:"errors"`
+	LastError       time.Time `json:"last_error"`
+	Suppressed      bool      `json:"suppressed"`
+	SuppressedUntil time.Time `json:"suppressed_until,omitempty"`
+}
+
+type deviceErrors struct {
+	count    int
+	last     time.Time
+	suppress time.Time
+}
+
+// errorLimiter counts errors per backend device, similar to swift's
+// error_suppression_limit and error_suppression_interval. Once a device has
+// seen more than limit errors with no more than interval between them, it is
+// suppressed for interval and the ring filters will route around it.
+type errorLimiter struct {
+	lock             sync.Mutex
+	devices          map[string]*deviceErrors
+	limit            int
+	interval         time.Duration
+	now              func() time.Time
+	errorsMetric     tally.Counter
+	suppressedMetric tally.Counter
+	skippedMetric    tally.Counter
+	recoveredMetric  tally.Counter
+}
+
+func newErrorLimiter(limit int, interval time.Duration) *errorLimiter {
+	el := &errorLimiter{
+		devices:  map[string]*deviceErrors{},
+		limit:    limit,
+		interval: interval,
+		now:      time.Now,
+	}
+	el.setMetricsScope(tally.NoopScope)
+	return el
+}
+
+func (el *errorLimiter) setMetricsScope(scope tally.Scope) {
+	el.errorsMetric = scope.Counter("proxy_node_errors")
+	el.suppressedMetric = scope.Counter("proxy_node_error_limited")
+	el.skippedMetric = scope.Counter("proxy_node_error_limited_skips")
+	el.recoveredMetric = scope.Counter("proxy_node_error_limit_recovered")
+}
+
+func deviceKey(dev *ring.Device) string {
+	return fmt.Sprintf("%s:%d/%s", dev.Ip, dev.Port, dev.Device)
+}
+
+// errorOccurred records a single error against the device.
+func (el *errorLimiter) errorOccurred(dev *ring.Device) {
+	el.addErrors(dev, 1)
+}
+
+// errorLimit suppresses the device immediately, as for a 507 Insufficient
+// Storage response.
+func (el *errorLimiter) errorLimit(dev *ring.Device) {
+	el.addErrors(dev, el.limit+1)
+}
+
+func (el *errorLimiter) addErrors(dev *ring.Device, n int) {
+	if el == nil || dev == nil {
+		return
+	}
+	el.errorsMetric.Inc(1)
+	now := el.now()
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	key := deviceKey(dev)
+	de := el.devices[key]
+	if de == nil || now.Sub(de.last) > el.interval {
+		de = &deviceErrors{}
+		el.devices[key] = de
+	}
+	de.count += n
+	de.last = now
+	if de.count > el.limit && !now.Before(de.suppress) {
+		de.suppress = now.Add(el.interval)
+		el.suppressedMetric.Inc(1)
+	}
+}
+
+// response records an error for the device if the response (or error)
+// indicates the device is misbehaving.
+func (el *errorLimiter) response(dev *ring.Device, resp *http.Response, err error) {
+	if el == nil {
+		return
+	}
+	if err != nil {
+		el.errorOccurred(dev)
+	} else if resp.StatusCode == http.StatusInsufficientStorage {
+		el.errorLimit(dev)
+	} else if resp.StatusCode >= 500 {
+		el.errorOccurred(dev)
+	}
+}
+
+// suppressed returns whether requests to the device should be avoided.
+func (el *errorLimiter) suppressed(dev *ring.Device) bool {
+	if el == nil || dev == nil {
+		return false
+	}
+	now := el.now()
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	key := deviceKey(dev)
+	de := el.devices[key]
+	if de == nil {
+		return false
+	}
+	if now.Sub(de.last) > el.interval && !now.Before(de.suppress) {
+		if !de.suppress.IsZero() {
+			el.recoveredMetric.Inc(1)
+		}
+		delete(el.devices, key)
+		return false
+	}
+	return now.Before(de.suppress)
+}
+
+// skip is used by the ring filters to note they have routed around a device.
+func (el *errorLimiter) skip(dev *ring.Device) bool {
+	if el.suppressed(dev) {
+		el.skippedMetric.Inc(1)
+		return true
+	}
+	return false
+}
+
+// state returns the devices currently having errors tracked, sorted by
+// device key.
+func (el *errorLimiter) state() []DeviceErrorLimit {
+	if el == nil {
+		return nil
+	}
+	now := el.now()
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	state := make([]DeviceErrorLimit, 0, len(el.devices))
+	for key, de := range el.devices {
+		if now.Sub(de.last) > el.interval && !now.Before(de.suppress) {
+			continue
+		}
+		d := DeviceErrorLimit{Device: key, Errors: de.count, LastError: de.last}
+		if now.Before(de.suppress) {
+			d.Suppressed = true
+			d.SuppressedUntil = de.suppress
+		}
+		state = append(state, d)
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].Device < state[j].Device })
+	return state
+}
+
+// errorLimitedMoreNodes yields handoff devices that are not suppressed,
+// followed by any suppressed devices as a last resort.
+type errorLimitedMoreNodes struct {
+	lock     sync.Mutex
+	more     ring.MoreNodes
+	limiter  *errorLimiter
+	deferred []*ring.Device
+	seen     map[*ring.Device]bool
+}
+
+func (m *errorLimitedMoreNodes) Next() *ring.Device {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for m.more != nil {
+		dev := m.more.Next()
+		if dev == nil || m.seen[dev] {
+			// A repeated device means the iterator will only ever return
+			// devices we've already deferred.
+			m.more = nil
+			break
+		}
+		if !m.limiter.skip(dev) {
+			return dev
+		}
+		m.seen[dev] = true
+		m.deferred = append(m.deferred, dev)
+	}
+	if len(m.deferred) > 0 {
+		var dev *ring.Device
+		dev, m.deferred = m.deferred[0], m.deferred[1:]
+		return dev
+	}
+	return nil
+}
+
+func newErrorLimitedMoreNodes(more ring.MoreNodes, limiter *errorLimiter, deferred []*ring.Device) *errorLimitedMoreNodes {
+	seen := make(map[*ring.Device]bool, len(deferred))
+	for _, dev := range deferred {
+		seen[dev] = true
+	}
+	return &errorLimitedMoreNodes{more: more, limiter: limiter, deferred: deferred, seen: seen}
+}
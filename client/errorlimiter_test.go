@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+)
+
+func TestErrorLimiterSuppression(t *testing.T) {
+	el := newErrorLimiter(3, time.Minute)
+	now := time.Unix(1000, 0)
+	el.now = func() time.Time { return now }
+	dev := &ring.Device{Ip: "127.0.0.1", Port: 6010, Device: "sda"}
+	for i := 0; i < 3; i++ {
+		el.response(dev, nil, errors.New("timeout"))
+	}
+	require.False(t, el.suppressed(dev))
+	el.response(dev, &http.Response{StatusCode: 404}, nil)
+	require.False(t, el.suppressed(dev))
+	el.response(dev, &http.Response{StatusCode: 503}, nil)
+	require.True(t, el.suppressed(dev))
+	state := el.state()
+	require.Equal(t, 1, len(state))
+	require.Equal(t, "127.0.0.1:6010/sda", state[0].Device)
+	require.Equal(t, 4, state[0].Errors)
+	require.True(t, state[0].Suppressed)
+
+	now = now.Add(time.Minute + time.Second)
+	require.False(t, el.suppressed(dev))
+	require.Equal(t, 0, len(el.state()))
+}
+
+func TestErrorLimiterInsufficientStorage(t *testing.T) {
+	el := newErrorLimiter(10, time.Minute)
+	dev := &ring.Device{Ip: "127.0.0.1", Port: 6010, Device: "sda"}
+	el.response(dev, &http.Response{StatusCode: 507}, nil)
+	require.True(t, el.suppressed(dev))
+}
+
+func TestErrorLimiterErrorsExpire(t *testing.T) {
+	el := newErrorLimiter(1, time.Minute)
+	now := time.Unix(1000, 0)
+	el.now = func() time.Time { return now }
+	dev := &ring.Device{Ip: "127.0.0.1", Port: 6010, Device: "sda"}
+	el.errorOccurred(dev)
+	now = now.Add(2 * time.Minute)
+	el.errorOccurred(dev)
+	require.False(t, el.suppressed(dev))
+}
+
+func TestErrorLimitedReadNodes(t *testing.T) {
+	r := &fakeRing{
+		FakeRing: &test.FakeRing{
+			MockMoreNodes: &ring.Device{Id: 3, Device: "sdd"},
+		},
+		nodes: []*ring.Device{
+			{Id: 0, Device: "sda"},
+			{Id: 1, Device: "sdb"},
+			{Id: 2, Device: "sdc"},
+		},
+	}
+	a := newClientRingFilter(r, "", "", "", 0)
+	a.limiter = newErrorLimiter(10, time.Minute)
+	a.limiter.errorLimit(r.nodes[1])
+	devs, more := a.getReadNodes(1)
+	require.Equal(t, 2, len(devs))
+	for _, dev := range devs {
+		require.NotEqual(t, "sdb", dev.Device)
+	}
+	require.Equal(t, "sdd", more.Next().Device)
+	require.Equal(t, "sdd", more.Next().Device)
+
+	a.limiter.errorLimit(r.MockMoreNodes)
+	devs, more = a.getReadNodes(1)
+	require.Equal(t, 2, len(devs))
+	require.Equal(t, "sdb", more.Next().Device)
+	require.Equal(t, "sdd", more.Next().Device)
+	require.Equal(t, (*ring.Device)(nil), more.Next())
+}
+
+func TestErrorLimitedWriteNodes(t *testing.T) {
+	r := &fakeRing{
+		FakeRing: &test.FakeRing{
+			MockMoreNodes: &ring.Device{Id: 3, Device: "sdd"},
+		},
+		nodes: []*ring.Device{
+			{Id: 0, Device: "sda"},
+			{Id: 1, Device: "sdb"},
+			{Id: 2, Device: "sdc"},
+		},
+	}
+	a := newClientRingFilter(r, "", "", "", 0)
+	a.limiter = newErrorLimiter(10, time.Minute)
+	a.limiter.errorLimit(r.nodes[0])
+	devs, _ := a.getWriteNodes(1)
+	require.Equal(t, 3, len(devs))
+	require.Equal(t, 1, devs[0].Id)
+	require.Equal(t, 2, devs[1].Id)
+	require.Equal(t, 3, devs[2].Id)
+}
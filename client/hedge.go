@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+const defaultHedgeDelay = time.Second
+const hedgeSampleSize = 1000
+const hedgeMinSamples = 100
+const hedgeRecalcEvery = 100
+
+// hedger decides how long firstResponse waits on a request before sending a
+// duplicate to the next node. With a percentile set, the delay tracks that
+// percentile of recent response times, falling back to the fixed delay until
+// enough responses have been seen.
+type hedger struct {
+	lock        sync.Mutex
+	delay       time.Duration
+	percentile  float64
+	samples     []time.Duration
+	next        int
+	observed    int
+	current     time.Duration
+	hedgeMetric tally.Counter
+	winMetric   tally.Counter
+}
+
+func newHedger(delay time.Duration, percentile float64) *hedger {
+	h := &hedger{delay: delay, percentile: percentile, current: delay}
+	h.setMetricsScope(tally.NoopScope, 0)
+	return h
+}
+
+func (h *hedger) setMetricsScope(scope tally.Scope, policy int) {
+	h.hedgeMetric = scope.Counter(fmt.Sprintf("%d_object_get_hedges", policy))
+	h.winMetric = scope.Counter(fmt.Sprintf("%d_object_get_hedge_wins", policy))
+}
+
+// getDelay returns how long to wait on outstanding requests before hedging.
+func (h *hedger) getDelay() time.Duration {
+	if h == nil {
+		return defaultHedgeDelay
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.current
+}
+
+// observe records how long a request that wasn't itself a hedge took to respond, whether or not it won.
+func (h *hedger) observe(d time.Duration) {
+	if h == nil || h.percentile <= 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.samples) < hedgeSampleSize {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSampleSize
+	}
+	h.observed++
+	if len(h.samples) >= hedgeMinSamples && h.observed%hedgeRecalcEvery == 0 {
+		sorted := make([]time.Duration, len(h.samples))
+		copy(sorted, h.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		i := int(float64(len(sorted)) * h.percentile / 100)
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		h.current = sorted[i]
+	}
+}
+
+func (h *hedger) hedged() {
+	if h != nil {
+		h.hedgeMetric.Inc(1)
+	}
+}
+
+func (h *hedger) won() {
+	if h != nil {
+		h.winMetric.Inc(1)
+	}
+}
+
+// cancelOnClose releases a request's context once its response body is done
+// with.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+	"go.uber.org/zap"
+)
+
+func TestHedgerPercentile(t *testing.T) {
+	h := newHedger(time.Second, 50)
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, time.Second, h.getDelay())
+	h.observe(hedgeMinSamples * time.Millisecond)
+	require.Equal(t, 51*time.Millisecond, h.getDelay())
+
+	h = newHedger(time.Second, 0)
+	for i := 1; i <= hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, time.Second, h.getDelay())
+}
+
+func serverDevice(t *testing.T, ts *httptest.Server, region int) *ring.Device {
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.Nil(t, err)
+	p, err := strconv.Atoi(port)
+	require.Nil(t, err)
+	return &ring.Device{Scheme: "http", Ip: host, Port: p, Device: "sda", Region: region}
+}
+
+func TestHedgedResponse(t *testing.T) {
+	slowCancelled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(slowCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	r := &fakeRing{
+		FakeRing: &test.FakeRing{MockMoreNodes: serverDevice(t, fast, 2)},
+		nodes:    []*ring.Device{serverDevice(t, slow, 1), serverDevice(t, fast, 2)},
+	}
+	c := &proxyClient{client: &http.Client{}, Logger: zap.NewNop()}
+	h := newHedger(10*time.Millisecond, 0)
+	scope := common.NewTestScope()
+	h.setMetricsScope(scope, 0)
+	resp := c.hedgedResponse(newClientRingFilter(r, "r1=100", "", "", 0), 0, h, func(dev *ring.Device) (*http.Request, error) {
+		return http.NewRequest("GET", fmt.Sprintf("%s://%s:%d/", dev.Scheme, dev.Ip, dev.Port), nil)
+	})
+	require.Equal(t, 200, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, "fast", string(body))
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request was not cancelled")
+	}
+	require.Equal(t, int64(1), scope.Counter("0_object_get_hedges").(*common.TestCounter).Value())
+	require.Equal(t, int64(1), scope.Counter("0_object_get_hedge_wins").(*common.TestCounter).Value())
+}
+
+func TestHedgedResponseSamplesLosers(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	r := &fakeRing{
+		FakeRing: &test.FakeRing{MockMoreNodes: serverDevice(t, fast, 2)},
+		nodes:    []*ring.Device{serverDevice(t, slow, 1), serverDevice(t, fast, 2)},
+	}
+	c := &proxyClient{client: &http.Client{}, Logger: zap.NewNop()}
+	h := newHedger(10*time.Millisecond, 50)
+	resp := c.hedgedResponse(newClientRingFilter(r, "r1=100", "", "", 0), 0, h, func(dev *ring.Device) (*http.Request, error) {
+		return http.NewRequest("GET", fmt.Sprintf("%s://%s:%d/", dev.Scheme, dev.Ip, dev.Port), nil)
+	})
+	require.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+	// Only the slow primary is sampled, for at least as long as it took the hedge to win, rather than the hedge's own
+	// response time.
+	require.Equal(t, 1, len(h.samples))
+	require.True(t, h.samples[0] >= 60*time.Millisecond)
+}
@@ -77,6 +77,7 @@ type clientRingFilter struct {
 	waffRegion  int
 	waffCount   int
 	deviceLimit int
+	limiter     *errorLimiter
 }
 
 func (a *clientRingFilter) ring() ring.Ring {
@@ -100,7 +101,26 @@ func (a *clientRingFilter) getReadNodes(partition uint64) ([]*ring.Device, ring.
 	}
 	rand.Shuffle(len(devs), func(i, j int) { devs[i], devs[j] = devs[j], devs[i] })
 	sort.SliceStable(devs, func(i, j int) bool { return d2a[devs[i]] < d2a[devs[j]] })
-	return devs, a.Ring.GetMoreNodes(partition)
+	if a.limiter == nil {
+		return devs, a.Ring.GetMoreNodes(partition)
+	}
+	devs, suppressed := a.filterSuppressed(devs)
+	return devs, newErrorLimitedMoreNodes(a.Ring.GetMoreNodes(partition), a.limiter, suppressed)
+}
+
+// filterSuppressed splits out any error limited devices so they can be
+// tried after the handoffs.
+func (a *clientRingFilter) filterSuppressed(devs []*ring.Device) ([]*ring.Device, []*ring.Device) {
+	var suppressed []*ring.Device
+	available := make([]*ring.Device, 0, len(devs))
+	for _, dev := range devs {
+		if a.limiter.skip(dev) {
+			suppressed = append(suppressed, dev)
+		} else {
+			available = append(available, dev)
+		}
+	}
+	return available, suppressed
 }
 
 func (a *clientRingFilter) getWriteNodes(partition uint64) ([]*ring.Device, ring.MoreNodes) {
@@ -116,6 +136,11 @@ func (a *clientRingFilter) getWriteNodes(partition uint64) ([]*ring.Device, ring
 		waffCount:  a.waffCount,
 		limit:      a.deviceLimit,
 	}
+	if a.limiter != nil {
+		var suppressed []*ring.Device
+		more.devs, suppressed = a.filterSuppressed(devs)
+		more.more = newErrorLimitedMoreNodes(more.more, a.limiter, suppressed)
+	}
 	if a.deviceLimit < len(devs) {
 		ndevs = make([]*ring.Device, a.deviceLimit)
 	} else {
@@ -8,6 +8,7 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/troubling/hummingbird/common"
@@ -60,6 +61,7 @@ type standardObjectClient struct {
 	policy      int
 	objectRing  ringFilter
 	deviceLimit int
+	hedge       *hedger
 	Logger      srv.LowLevelLogger
 }
 
@@ -95,9 +97,19 @@ func (p *putReader) Read(b []byte) (int, error) {
 	}
 }
 
+// containerUpdatePath returns the account and container whose database should be updated for an object write,
+// which is the shard container in X-Backend-Container-Path if the object's container has been sharded.
+func containerUpdatePath(account, container string, headers http.Header) (string, string) {
+	if parts := strings.SplitN(headers.Get("X-Backend-Container-Path"), "/", 2); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		return parts[0], parts[1]
+	}
+	return account, container
+}
+
 func (oc *standardObjectClient) putObject(ctx context.Context, account, container, obj string, headers http.Header, src io.Reader) *http.Response {
 	objectPartition := oc.objectRing.GetPartition(account, container, obj)
-	containerPartition := oc.pdc.ContainerRing.GetPartition(account, container, "")
+	updateAccount, updateContainer := containerUpdatePath(account, container, headers)
+	containerPartition := oc.pdc.ContainerRing.GetPartition(updateAccount, updateContainer, "")
 	containerDevices := oc.pdc.ContainerRing.GetNodes(containerPartition)
 	ready := make(chan io.WriteCloser)
 	cancel := make(chan struct{})
@@ -135,7 +147,7 @@ func (oc *standardObjectClient) putObject(ctx context.Context, account, containe
 				if req, err := devToRequest(index, dev); err != nil {
 					oc.Logger.Error("unable create PUT request", zap.Error(err))
 					resp = nectarutil.ResponseStub(http.StatusInternalServerError, err.Error())
-				} else if r, err := oc.pdc.client.Do(req); err != nil {
+				} else if r, err := oc.pdc.do(dev, req); err != nil {
 					oc.Logger.Error("unable to PUT object", zap.Error(err))
 					resp = nectarutil.ResponseStub(http.StatusInternalServerError, err.Error())
 				} else {
@@ -197,6 +209,12 @@ func (oc *standardObjectClient) putObject(ctx context.Context, account, containe
 		if !written && len(writers) >= quorum && len(writers)+responseCount == objectReplicaCount {
 			written = true
 			if _, err := common.CopyQuorum(src, quorum, writers...); err != nil {
+				// Fail the uploads, rather than ending them early, so the object servers don't commit a partial object.
+				for _, w := range cWriters {
+					if pw, ok := w.(*io.PipeWriter); ok {
+						pw.CloseWithError(err)
+					}
+				}
 				return nectarutil.ResponseStub(http.StatusServiceUnavailable, "The service is currently unavailable.")
 			}
 			for _, w := range cWriters {
@@ -208,7 +226,8 @@ func (oc *standardObjectClient) putObject(ctx context.Context, account, containe
 
 func (oc *standardObjectClient) postObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
 	partition := oc.objectRing.GetPartition(account, container, obj)
-	containerPartition := oc.pdc.ContainerRing.GetPartition(account, container, "")
+	updateAccount, updateContainer := containerUpdatePath(account, container, headers)
+	containerPartition := oc.pdc.ContainerRing.GetPartition(updateAccount, updateContainer, "")
 	containerDevices := oc.pdc.ContainerRing.GetNodes(containerPartition)
 	devs, _ := oc.objectRing.getWriteNodes(partition)
 	objectReplicaCount := len(devs)
@@ -233,7 +252,7 @@ func (oc *standardObjectClient) postObject(ctx context.Context, account, contain
 
 func (oc *standardObjectClient) getObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
 	partition := oc.objectRing.GetPartition(account, container, obj)
-	return oc.pdc.firstResponse(oc.objectRing, partition, func(dev *ring.Device) (*http.Request, error) {
+	return oc.pdc.hedgedResponse(oc.objectRing, partition, oc.hedge, func(dev *ring.Device) (*http.Request, error) {
 		url := fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s/%s", dev.Scheme, dev.Ip, dev.Port, dev.Device, partition,
 			common.Urlencode(account), common.Urlencode(container), common.Urlencode(obj))
 		req, err := http.NewRequest("GET", url, nil)
@@ -287,7 +306,8 @@ func (oc *standardObjectClient) headObject(ctx context.Context, account, contain
 
 func (oc *standardObjectClient) deleteObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
 	partition := oc.objectRing.GetPartition(account, container, obj)
-	containerPartition := oc.pdc.ContainerRing.GetPartition(account, container, "")
+	updateAccount, updateContainer := containerUpdatePath(account, container, headers)
+	containerPartition := oc.pdc.ContainerRing.GetPartition(updateAccount, updateContainer, "")
 	containerDevices := oc.pdc.ContainerRing.GetNodes(containerPartition)
 	devs, _ := oc.objectRing.getWriteNodes(partition)
 	objectReplicaCount := len(devs)
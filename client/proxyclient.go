@@ -19,6 +19,7 @@ import (
 	"github.com/troubling/hummingbird/common/srv"
 	"github.com/troubling/hummingbird/common/tracing"
 	"github.com/troubling/nectar/nectarutil"
+	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 	"golang.org/x/net/http2"
 )
@@ -52,6 +53,7 @@ type proxyClient struct {
 	Logger            srv.LowLevelLogger
 	ClientTraceCloser io.Closer
 	userAgent         string
+	errorLimiter      *errorLimiter
 }
 
 var _ ProxyClient = &proxyClient{}
@@ -89,6 +91,9 @@ func NewProxyClient(policyList conf.PolicyList, cnf srv.ConfigLoader, logger srv
 		client:     httpClient,
 		Logger:     logger,
 		userAgent:  "Proxy",
+		errorLimiter: newErrorLimiter(
+			int(serverconf.GetInt("app:proxy-server", "error_suppression_limit", defaultErrorSuppressionLimit)),
+			time.Duration(serverconf.GetFloat("app:proxy-server", "error_suppression_interval", defaultErrorSuppressionInterval.Seconds())*float64(time.Second))),
 	}
 	if serverconf.HasSection("tracing") {
 		clientTracer, clientTraceCloser, err := tracing.Init("proxydirect-client", logger, serverconf.GetSection("tracing"))
@@ -118,13 +123,19 @@ func NewProxyClient(policyList conf.PolicyList, cnf srv.ConfigLoader, logger srv
 	if err != nil {
 		return nil, err
 	}
-	c.ContainerRing = newClientRingFilter(containerRing, readAffinity, "", "", 0)
+	containerRingFilter := newClientRingFilter(containerRing, readAffinity, "", "", 0)
+	containerRingFilter.limiter = c.errorLimiter
+	c.ContainerRing = containerRingFilter
 	accountRing, err := cnf.GetRing("account", hashPathPrefix, hashPathSuffix, 0)
 	if err != nil {
 		return nil, err
 	}
-	c.AccountRing = newClientRingFilter(accountRing, readAffinity, "", "", 0)
+	accountRingFilter := newClientRingFilter(accountRing, readAffinity, "", "", 0)
+	accountRingFilter.limiter = c.errorLimiter
+	c.AccountRing = accountRingFilter
 	c.objectClients = make(map[int]proxyObjectClient)
+	hedgeDelay := time.Duration(serverconf.GetFloat("app:proxy-server", "hedge_delay", defaultHedgeDelay.Seconds()) * float64(time.Second))
+	hedgePercentile := serverconf.GetFloat("app:proxy-server", "hedge_percentile", 0)
 	for _, policy := range c.policyList {
 		// TODO: the intention is to (if it becomes necessary) have a policy type to object client
 		// constructor mapping here, similar to how object engines are loaded by policy type.
@@ -152,10 +163,21 @@ func NewProxyClient(policyList conf.PolicyList, cnf srv.ConfigLoader, logger srv
 				deviceLimit = 3
 			}
 		}
+		policyHedgeDelay := hedgeDelay
+		if v, err := strconv.ParseFloat(policy.Config["hedge_delay"], 64); err == nil {
+			policyHedgeDelay = time.Duration(v * float64(time.Second))
+		}
+		policyHedgePercentile := hedgePercentile
+		if v, err := strconv.ParseFloat(policy.Config["hedge_percentile"], 64); err == nil {
+			policyHedgePercentile = v
+		}
+		objectRingFilter := newClientRingFilter(ring, policyReadAffinity, policyWriteAffinity, policyWriteAffinityCount, deviceLimit)
+		objectRingFilter.limiter = c.errorLimiter
 		client := &standardObjectClient{
 			pdc:        c,
 			policy:     policy.Index,
-			objectRing: newClientRingFilter(ring, policyReadAffinity, policyWriteAffinity, policyWriteAffinityCount, deviceLimit),
+			objectRing: objectRingFilter,
+			hedge:      newHedger(policyHedgeDelay, policyHedgePercentile),
 			Logger:     logger,
 		}
 		c.objectClients[policy.Index] = client
@@ -167,6 +189,29 @@ func (c *proxyClient) SetUserAgent(v string) {
 	c.userAgent = v
 }
 
+func (c *proxyClient) SetMetricsScope(scope tally.Scope) {
+	c.errorLimiter.setMetricsScope(scope)
+	for policy, oc := range c.objectClients {
+		if soc, ok := oc.(*standardObjectClient); ok {
+			soc.hedge.setMetricsScope(scope, policy)
+		}
+	}
+}
+
+func (c *proxyClient) ErrorLimits() []DeviceErrorLimit {
+	return c.errorLimiter.state()
+}
+
+// do sends the request to the device, noting any errors against it for error
+// limiting.
+func (c *proxyClient) do(dev *ring.Device, req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if req.Context().Err() == nil {
+		c.errorLimiter.response(dev, resp, err)
+	}
+	return resp, err
+}
+
 // quorumResponse returns with a response representative of a quorum of nodes.
 //
 // This is analogous to swift's best_response function.
@@ -183,7 +228,7 @@ func (c *proxyClient) quorumResponse(r ringFilter, partition uint64, devToReques
 				if req, err := devToRequest(index, dev); err != nil {
 					c.Logger.Error("unable to create request", zap.Error(err))
 					resp = nectarutil.ResponseStub(http.StatusInternalServerError, err.Error())
-				} else if r, err := c.client.Do(req); err != nil {
+				} else if r, err := c.do(dev, req); err != nil {
 					c.Logger.Error("unable to get response", zap.Error(err))
 					resp = nectarutil.ResponseStub(http.StatusInternalServerError, err.Error())
 				} else {
@@ -232,21 +277,59 @@ func (c *proxyClient) quorumResponse(r ringFilter, partition uint64, devToReques
 	return nectarutil.ResponseStub(http.StatusServiceUnavailable, "Unknown State")
 }
 
-func (c *proxyClient) firstResponse(r ringFilter, partition uint64, devToRequest func(*ring.Device) (*http.Request, error)) (resp *http.Response) {
-	receivedResponses := make(chan *http.Response)
+func (c *proxyClient) firstResponse(r ringFilter, partition uint64, devToRequest func(*ring.Device) (*http.Request, error)) *http.Response {
+	return c.hedgedResponse(r, partition, nil, devToRequest)
+}
+
+type hedgedResult struct {
+	resp  *http.Response
+	index int
+}
+
+// hedgedResponse returns the first good response from the read nodes. If a
+// request is slow to respond, a duplicate is sent to the next node after the
+// hedger's delay; whichever responds first wins and the others are cancelled.
+func (c *proxyClient) hedgedResponse(r ringFilter, partition uint64, h *hedger, devToRequest func(*ring.Device) (*http.Request, error)) (resp *http.Response) {
+	receivedResponses := make(chan hedgedResult)
 	alreadyFoundGoodResponse := make(chan struct{})
 	defer close(alreadyFoundGoodResponse)
 	devs, more := r.getReadNodes(partition)
 	internalErrors := 0
 	notFounds := 0
 	backendHeaders := map[string]string{}
-	interpretResponse := func(resp *http.Response) *http.Response {
+	var cancels []context.CancelFunc
+	var starts []time.Time
+	var hedged, responded []bool
+	winner := -1
+	defer func() {
+		for i, cancel := range cancels {
+			if i != winner {
+				// A request that hasn't responded by now took at least this long, and leaving it out of the
+				// samples would bias the hedge delay low.
+				if !hedged[i] && !responded[i] {
+					h.observe(time.Since(starts[i]))
+				}
+				cancel()
+			}
+		}
+	}()
+	interpretResponse := func(result hedgedResult) *http.Response {
+		resp := result.resp
+		responded[result.index] = true
+		if resp != nil && !hedged[result.index] {
+			h.observe(time.Since(starts[result.index]))
+		}
 		if resp != nil && (resp.StatusCode/100 == 2 || resp.StatusCode == http.StatusPreconditionFailed ||
 			resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
 			resp.Header.Set("Accept-Ranges", "bytes")
 			if etag := resp.Header.Get("Etag"); etag != "" {
 				resp.Header.Set("Etag", strings.Trim(etag, "\""))
 			}
+			winner = result.index
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancels[winner]}
+			if hedged[winner] {
+				h.won()
+			}
 			return resp
 		}
 		if resp != nil {
@@ -268,6 +351,7 @@ func (c *proxyClient) firstResponse(r ringFilter, partition uint64, devToRequest
 	}
 	maxRequests := int(r.ReplicaCount()) * 2
 	requestsPending := 0
+	timedOut := false
 	for requestCount := 0; requestCount < maxRequests; requestCount++ {
 		var dev *ring.Device
 		if requestCount < len(devs) {
@@ -284,42 +368,56 @@ func (c *proxyClient) firstResponse(r ringFilter, partition uint64, devToRequest
 			internalErrors++
 			continue
 		}
+		ctx, cancel := context.WithCancel(req.Context())
+		req = req.WithContext(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		starts = append(starts, time.Now())
+		hedged = append(hedged, timedOut)
+		responded = append(responded, false)
+		if timedOut {
+			h.hedged()
+		}
 
 		requestsPending++
-		go func(r *http.Request) {
-			response, err := c.client.Do(r)
+		go func(dev *ring.Device, r *http.Request, index int) {
+			response, err := c.do(dev, r)
 			if err != nil {
-				c.Logger.Error("firstResponse response", zap.Error(err))
+				if r.Context().Err() == nil {
+					c.Logger.Error("firstResponse response", zap.Error(err))
+				}
 				if response != nil {
 					response.Body.Close()
 				}
 				response = nil
 			}
 			select {
-			case receivedResponses <- response:
+			case receivedResponses <- hedgedResult{resp: response, index: index}:
 			case <-alreadyFoundGoodResponse:
 				if response != nil {
 					response.Body.Close()
 				}
 			}
-		}(req)
+		}(dev, req, index)
 
+		timedOut = false
 		select {
-		case resp = <-receivedResponses:
+		case result := <-receivedResponses:
 			requestsPending--
-			resp = interpretResponse(resp)
+			resp = interpretResponse(result)
 			if resp != nil {
 				return resp
 			}
-		case <-time.After(time.Second):
+		case <-time.After(h.getDelay()):
+			timedOut = true
 		}
 	}
 	giveUp := time.After(firstResponseFinalTimeout)
 	for requestsPending > 0 {
 		select {
-		case resp = <-receivedResponses:
+		case result := <-receivedResponses:
 			requestsPending--
-			resp = interpretResponse(resp)
+			resp = interpretResponse(result)
 			if resp != nil {
 				return resp
 			}
@@ -539,7 +637,7 @@ func (c *requestClient) PostContainer(ctx context.Context, account string, conta
 func (c *requestClient) GetContainerRaw(ctx context.Context, account string, container string, options map[string]string, headers http.Header) *http.Response {
 	partition := c.pdc.ContainerRing.GetPartition(account, container, "")
 	query := nectarutil.Mkquery(options)
-	return c.pdc.firstResponse(c.pdc.ContainerRing, partition, func(dev *ring.Device) (*http.Request, error) {
+	resp := c.pdc.firstResponse(c.pdc.ContainerRing, partition, func(dev *ring.Device) (*http.Request, error) {
 		url := fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s%s", dev.Scheme, dev.Ip, dev.Port, dev.Device, partition,
 			common.Urlencode(account), common.Urlencode(container), query)
 		req, err := http.NewRequest("GET", url, nil)
@@ -553,6 +651,11 @@ func (c *requestClient) GetContainerRaw(ctx context.Context, account string, con
 		}
 		return req, nil
 	})
+	if resp.StatusCode/100 == 2 && resp.Header.Get("X-Backend-Sharding-State") == common.ShardingStateSharded &&
+		headers.Get("X-Backend-Record-Type") != "shard" {
+		return c.shardedListing(ctx, account, container, options, headers, resp)
+	}
+	return resp
 }
 
 func (c *requestClient) GetContainerInfo(ctx context.Context, account string, container string) (*ContainerInfo, error) {
@@ -623,6 +726,8 @@ func (c *requestClient) SetContainerInfo(ctx context.Context, account string, co
 	if ci.StoragePolicyIndex, err = strconv.Atoi(resp.Header.Get("X-Backend-Storage-Policy-Index")); err != nil {
 		return nil, fmt.Errorf("Error retrieving X-Backend-Storage-Policy-Index for container %s/%s : %s", account, container, resp.Header.Get("X-Backend-Storage-Policy-Index"))
 	}
+	ci.ShardingState = resp.Header.Get("X-Backend-Sharding-State")
+	ci.IndexedMetadata = resp.Header.Get("X-Container-Indexed-Metadata")
 	for k := range resp.Header {
 		if strings.HasPrefix(k, "X-Container-Meta-") {
 			ci.Metadata[k[17:]] = resp.Header.Get(k)
@@ -690,11 +795,11 @@ func (c *requestClient) DeleteContainer(ctx context.Context, account string, con
 }
 
 func (c *requestClient) PutObject(ctx context.Context, account string, container string, obj string, headers http.Header, src io.Reader) *http.Response {
-	return c.getObjectClient(ctx, account, container, c.mc, c.lc).putObject(ctx, account, container, obj, headers, src)
+	return c.getObjectClient(ctx, account, container, c.mc, c.lc).putObject(ctx, account, container, obj, c.shardUpdateHeaders(ctx, account, container, obj, headers), src)
 }
 
 func (c *requestClient) PostObject(ctx context.Context, account string, container string, obj string, headers http.Header) *http.Response {
-	return c.getObjectClient(ctx, account, container, c.mc, c.lc).postObject(ctx, account, container, obj, headers)
+	return c.getObjectClient(ctx, account, container, c.mc, c.lc).postObject(ctx, account, container, obj, c.shardUpdateHeaders(ctx, account, container, obj, headers))
 }
 
 func (c *requestClient) GetObject(ctx context.Context, account string, container string, obj string, headers http.Header) *http.Response {
@@ -706,7 +811,7 @@ func (c *requestClient) HeadObject(ctx context.Context, account string, containe
 }
 
 func (c *requestClient) DeleteObject(ctx context.Context, account string, container string, obj string, headers http.Header) *http.Response {
-	return c.getObjectClient(ctx, account, container, c.mc, c.lc).deleteObject(ctx, account, container, obj, headers)
+	return c.getObjectClient(ctx, account, container, c.mc, c.lc).deleteObject(ctx, account, container, obj, c.shardUpdateHeaders(ctx, account, container, obj, headers))
 }
 
 func (c *requestClient) ObjectRingFor(ctx context.Context, account string, container string) (ring.Ring, *http.Response) {
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/nectar/nectarutil"
+	"go.uber.org/zap"
+)
+
+const shardRangesCacheTime = 60
+
+// shardListingRecord is an object or subdir entry from a shard container's json listing.
+type shardListingRecord struct {
+	XMLName      xml.Name `xml:"object"`
+	Name         string   `xml:"name"`
+	LastModified string   `xml:"last_modified"`
+	Bytes        int64    `xml:"bytes"`
+	ContentType  string   `xml:"content_type"`
+	Hash         string   `xml:"hash"`
+	raw          json.RawMessage
+}
+
+type shardListingSubdir struct {
+	XMLName xml.Name `xml:"subdir"`
+	Name2   string   `xml:"name,attr"`
+	Name    string   `xml:"name"`
+}
+
+func (r *shardListingRecord) UnmarshalJSON(b []byte) error {
+	var rec struct {
+		Name         string `json:"name"`
+		Subdir       string `json:"subdir"`
+		LastModified string `json:"last_modified"`
+		Bytes        int64  `json:"bytes"`
+		ContentType  string `json:"content_type"`
+		Hash         string `json:"hash"`
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+	r.Name, r.LastModified, r.Bytes, r.ContentType, r.Hash = rec.Name, rec.LastModified, rec.Bytes, rec.ContentType, rec.Hash
+	if rec.Subdir != "" {
+		r.Name = rec.Subdir
+		r.XMLName.Local = "subdir"
+	}
+	r.raw = append(json.RawMessage{}, b...)
+	return nil
+}
+
+func (r *shardListingRecord) MarshalJSON() ([]byte, error) {
+	return r.raw, nil
+}
+
+// ListingName returns the record's object or subdir name.
+func (r *shardListingRecord) ListingName() string {
+	return r.Name
+}
+
+// CSVRow returns the record's fields in the order of common.ObjectListingCSVHeader.
+func (r *shardListingRecord) CSVRow() []string {
+	if r.XMLName.Local == "subdir" {
+		return []string{r.Name, "", "", "", ""}
+	}
+	return []string{r.Name, strconv.FormatInt(r.Bytes, 10), r.Hash, r.ContentType, r.LastModified}
+}
+
+func (r *shardListingRecord) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if r.XMLName.Local == "subdir" {
+		return e.Encode(&shardListingSubdir{Name2: r.Name, Name: r.Name})
+	}
+	type plain shardListingRecord
+	return e.Encode((*plain)(r))
+}
+
+// getShardRanges returns the shard ranges of a sharded container, which are cached for a short time.
+func (c *requestClient) getShardRanges(ctx context.Context, account, container string) ([]*common.ShardRange, error) {
+	key := fmt.Sprintf("shard-ranges/%s/%s", account, container)
+	var ranges []*common.ShardRange
+	if c.mc != nil {
+		if err := c.mc.GetStructured(ctx, key, &ranges); err == nil {
+			return ranges, nil
+		}
+	}
+	resp := c.GetContainerRaw(ctx, account, container, map[string]string{"format": "json"}, http.Header{"X-Backend-Record-Type": {"shard"}})
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%d error retrieving shard ranges for container %s/%s", resp.StatusCode, account, container)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ranges); err != nil {
+		return nil, err
+	}
+	common.SortShardRanges(ranges)
+	if c.mc != nil {
+		c.mc.Set(ctx, key, ranges, shardRangesCacheTime)
+	}
+	return ranges, nil
+}
+
+// shardUpdateHeaders returns a copy of the headers for an object write, directing the container update to the
+// shard container responsible for the object if the container has been sharded.
+func (c *requestClient) shardUpdateHeaders(ctx context.Context, account, container, obj string, headers http.Header) http.Header {
+	h := make(http.Header, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h.Del("X-Backend-Container-Path")
+	if ci, err := c.GetContainerInfo(ctx, account, container); err != nil || ci.ShardingState != common.ShardingStateSharded {
+		return h
+	}
+	// If we can't get the shard ranges, the root container will redirect the update instead.
+	ranges, err := c.getShardRanges(ctx, account, container)
+	if err != nil {
+		c.Logger.Error("Unable to get shard ranges", zap.String("account", account), zap.String("container", container), zap.Error(err))
+		return h
+	}
+	if sr := common.FindShardRange(ranges, obj); sr != nil {
+		h.Set("X-Backend-Container-Path", sr.Account+"/"+sr.Container)
+	}
+	return h
+}
+
+func shardRangeSkipped(sr *common.ShardRange, marker, prefix string, reverse bool) bool {
+	if reverse {
+		return (marker != "" && sr.Lower != "" && sr.Lower >= marker) ||
+			(prefix != "" && sr.Lower != "" && sr.Lower >= prefix+"\xff")
+	}
+	return (marker != "" && sr.Upper != "" && sr.Upper <= marker) ||
+		(prefix != "" && sr.Upper != "" && sr.Upper < prefix)
+}
+
+func shardRangePast(sr *common.ShardRange, endMarker string, reverse bool) bool {
+	if endMarker == "" {
+		return false
+	}
+	if reverse {
+		return sr.Upper != "" && sr.Upper <= endMarker
+	}
+	return sr.Lower != "" && sr.Lower >= endMarker
+}
+
+// shardedListing builds a sharded container's listing from the listings of its shard containers.
+func (c *requestClient) shardedListing(ctx context.Context, account, container string, options map[string]string, headers http.Header, rootResp *http.Response) *http.Response {
+	io.Copy(ioutil.Discard, rootResp.Body)
+	rootResp.Body.Close()
+	ranges, err := c.getShardRanges(ctx, account, container)
+	if err != nil {
+		c.Logger.Error("Unable to get shard ranges", zap.String("account", account), zap.String("container", container), zap.Error(err))
+		return nectarutil.ResponseStub(http.StatusServiceUnavailable, "The service is currently unavailable.")
+	}
+	limit := 10000
+	if l, err := strconv.Atoi(options["limit"]); err == nil && l >= 0 && l < limit {
+		limit = l
+	}
+	reverse := common.LooksTrue(options["reverse"])
+	if reverse {
+		for i, j := 0, len(ranges)-1; i < j; i, j = i+1, j-1 {
+			ranges[i], ranges[j] = ranges[j], ranges[i]
+		}
+	}
+	marker := options["marker"]
+	prefix := options["prefix"]
+	if path, ok := options["path"]; ok {
+		prefix = path
+	}
+	records := []*shardListingRecord{}
+	for _, sr := range ranges {
+		if len(records) >= limit || shardRangePast(sr, options["end_marker"], reverse) {
+			break
+		}
+		if shardRangeSkipped(sr, marker, prefix, reverse) {
+			continue
+		}
+		shardOptions := map[string]string{}
+		for k, v := range options {
+			shardOptions[k] = v
+		}
+		shardOptions["format"] = "json"
+		shardOptions["limit"] = strconv.Itoa(limit - len(records))
+		shardOptions["marker"] = marker
+		resp := c.GetContainerRaw(ctx, sr.Account, sr.Container, shardOptions, headers)
+		if resp.StatusCode/100 != 2 {
+			// A missing shard would silently drop its objects from the listing, so fail instead.
+			resp.Body.Close()
+			c.Logger.Error("Error listing shard container", zap.String("account", sr.Account), zap.String("container", sr.Container),
+				zap.Int("status", resp.StatusCode))
+			return nectarutil.ResponseStub(http.StatusServiceUnavailable, "The service is currently unavailable.")
+		}
+		var shardRecords []*shardListingRecord
+		err := json.NewDecoder(resp.Body).Decode(&shardRecords)
+		resp.Body.Close()
+		if err != nil {
+			c.Logger.Error("Error decoding shard listing", zap.String("account", sr.Account), zap.String("container", sr.Container), zap.Error(err))
+			return nectarutil.ResponseStub(http.StatusServiceUnavailable, "The service is currently unavailable.")
+		}
+		for _, r := range shardRecords {
+			// A subdir may span shards.
+			if len(records) > 0 && records[len(records)-1].Name == r.Name {
+				continue
+			}
+			records = append(records, r)
+		}
+		if len(records) > 0 {
+			marker = records[len(records)-1].Name
+		}
+	}
+	return renderShardedListing(container, options["format"], headers, rootResp.Header, records)
+}
+
+func renderShardedListing(container, format string, requestHeaders, header http.Header, records []*shardListingRecord) *http.Response {
+	format = common.ListingFormat(format, requestHeaders.Get("Accept"))
+	status := http.StatusOK
+	var body []byte
+	switch format {
+	case common.ListingFormatJSON:
+		body, _ = json.Marshal(records)
+	case common.ListingFormatXML:
+		type Container struct {
+			XMLName xml.Name `xml:"container"`
+			Name    string   `xml:"name,attr"`
+			Objects []*shardListingRecord
+		}
+		output, _ := xml.Marshal(&Container{Name: container, Objects: records})
+		body = append([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"), output...)
+	case common.ListingFormatNDJSON, common.ListingFormatCSV:
+		rs := make([]interface{}, len(records))
+		for i, r := range records {
+			rs[i] = r
+		}
+		buf := &bytes.Buffer{}
+		common.WriteListingRecords(buf, format, common.ObjectListingCSVHeader, rs)
+		body = buf.Bytes()
+		if len(body) == 0 {
+			status = http.StatusNoContent
+		}
+	case common.ListingFormatText:
+		for _, r := range records {
+			body = append(body, r.Name+"\n"...)
+		}
+		if len(body) == 0 {
+			status = http.StatusNoContent
+		}
+	default:
+		return nectarutil.ResponseStub(http.StatusNotAcceptable, "")
+	}
+	header.Set("Content-Type", common.ListingContentType(format))
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp := nectarutil.ResponseStub(status, string(body))
+	resp.Header = header
+	return resp
+}
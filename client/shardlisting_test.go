@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+	"go.uber.org/zap"
+)
+
+func shardedContainerServer(t *testing.T) *httptest.Server {
+	shards := map[string][]string{
+		"c-0": {"a", "b", "c"},
+		"c-1": {"d", "e"},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		if parts[4] == "c" {
+			w.Header().Set("X-Backend-Sharding-State", common.ShardingStateSharded)
+			w.Header().Set("X-Container-Object-Count", "5")
+			if r.Header.Get("X-Backend-Record-Type") == "shard" {
+				json.NewEncoder(w).Encode([]*common.ShardRange{
+					{Account: ".shards_a", Container: "c-1", Lower: "c", State: common.ShardRangeActive},
+					{Account: ".shards_a", Container: "c-0", Upper: "c", State: common.ShardRangeActive},
+				})
+			}
+			return
+		}
+		if parts[4] == "d" {
+			w.Header().Set("X-Backend-Sharding-State", common.ShardingStateSharded)
+			if r.Header.Get("X-Backend-Record-Type") == "shard" {
+				json.NewEncoder(w).Encode([]*common.ShardRange{
+					{Account: ".shards_a", Container: "c-0", Upper: "c", State: common.ShardRangeActive},
+					{Account: ".shards_a", Container: "d-1", Lower: "c", State: common.ShardRangeActive},
+				})
+			}
+			return
+		}
+		names, ok := shards[parts[4]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		require.Nil(t, err)
+		records := []map[string]string{}
+		for _, name := range names {
+			if name > r.URL.Query().Get("marker") && len(records) < limit {
+				records = append(records, map[string]string{"name": name})
+			}
+		}
+		json.NewEncoder(w).Encode(records)
+	}))
+}
+
+func TestShardedListing(t *testing.T) {
+	ts := shardedContainerServer(t)
+	defer ts.Close()
+	r := &fakeRing{FakeRing: &test.FakeRing{MockMoreNodes: serverDevice(t, ts, 1)}, nodes: []*ring.Device{serverDevice(t, ts, 1)}}
+	pc := &proxyClient{client: &http.Client{}, Logger: zap.NewNop(), ContainerRing: newClientRingFilter(r, "", "", "", 0)}
+	c := pc.NewRequestClient(nil, nil, zap.NewNop()).(*requestClient)
+
+	resp := c.GetContainerRaw(context.Background(), "a", "c", map[string]string{}, http.Header{})
+	require.Equal(t, 200, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "a\nb\nc\nd\ne\n", string(body))
+	require.Equal(t, "5", resp.Header.Get("X-Container-Object-Count"))
+
+	resp = c.GetContainerRaw(context.Background(), "a", "c", map[string]string{"marker": "b", "limit": "2", "format": "json"}, http.Header{})
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	var records []map[string]string
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&records))
+	require.Equal(t, 2, len(records))
+	require.Equal(t, "c", records[0]["name"])
+	require.Equal(t, "d", records[1]["name"])
+
+	resp = c.GetContainerRaw(context.Background(), "a", "c", map[string]string{"marker": "e"}, http.Header{})
+	require.Equal(t, 204, resp.StatusCode)
+
+	// A shard that can't be found fails the listing rather than leaving out its objects.
+	resp = c.GetContainerRaw(context.Background(), "a", "d", map[string]string{}, http.Header{})
+	require.Equal(t, 503, resp.StatusCode)
+}
+
+func TestShardUpdateHeaders(t *testing.T) {
+	ts := shardedContainerServer(t)
+	defer ts.Close()
+	r := &fakeRing{FakeRing: &test.FakeRing{}, nodes: []*ring.Device{serverDevice(t, ts, 1)}}
+	pc := &proxyClient{client: &http.Client{}, Logger: zap.NewNop(), ContainerRing: newClientRingFilter(r, "", "", "", 0)}
+	c := pc.NewRequestClient(nil, map[string]*ContainerInfo{}, zap.NewNop()).(*requestClient)
+	c.lc["container/a/c"] = &ContainerInfo{ShardingState: common.ShardingStateSharded}
+	c.lc["container/a/d"] = &ContainerInfo{}
+
+	headers := c.shardUpdateHeaders(context.Background(), "a", "c", "d", http.Header{"X-Timestamp": {"1"}})
+	require.Equal(t, ".shards_a/c-1", headers.Get("X-Backend-Container-Path"))
+	require.Equal(t, "1", headers.Get("X-Timestamp"))
+	headers = c.shardUpdateHeaders(context.Background(), "a", "d", "d", http.Header{"X-Backend-Container-Path": {"x/y"}})
+	require.Equal(t, "", headers.Get("X-Backend-Container-Path"))
+	account, container := containerUpdatePath("a", "c", http.Header{"X-Backend-Container-Path": {".shards_a/c-1"}})
+	require.Equal(t, ".shards_a", account)
+	require.Equal(t, "c-1", container)
+}
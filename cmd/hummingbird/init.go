@@ -263,6 +263,16 @@ func initCommand(args []string) error {
 			print(`bind_port = %d`, repport)
 		}
 		print(``)
+		print(`[account-auditor]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultAccountAuditorPort+index*10)
+		}
+		print(``)
+		print(`[account-compactor]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultAccountCompactorPort+index*10)
+		}
+		print(``)
 		print(`#[tracing]`)
 		print(`#disabled = false`)
 		print(`#sampler_type = const`)
@@ -316,6 +326,41 @@ func initCommand(args []string) error {
 			print(`bind_port = %d`, repport)
 		}
 		print(``)
+		print(`[container-sharder]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerSharderPort+index*10)
+		}
+		print(``)
+		print(`[container-sync]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerSyncPort+index*10)
+		}
+		print(``)
+		print(`[container-reconciler]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerReconcilerPort+index*10)
+		}
+		print(``)
+		print(`[container-auditor]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerAuditorPort+index*10)
+		}
+		print(``)
+		print(`[container-updater]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerUpdaterPort+index*10)
+		}
+		print(``)
+		print(`[container-compactor]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerCompactorPort+index*10)
+		}
+		print(``)
+		print(`[container-inventory]`)
+		if index > 0 {
+			print(`bind_port = %d`, common.DefaultContainerInventoryPort+index*10)
+		}
+		print(``)
 		print(`#[tracing]`)
 		print(`#disabled = false`)
 		print(`#sampler_type = const`)
@@ -468,11 +513,21 @@ func initCommand(args []string) error {
 	for index := start; index <= stop; index++ {
 		printService("account", index)
 		printService("account-replicator", index)
+		printService("account-auditor", index)
+		printService("account-compactor", index)
 		printService("container", index)
 		printService("container-replicator", index)
+		printService("container-sharder", index)
+		printService("container-sync", index)
+		printService("container-auditor", index)
+		printService("container-updater", index)
+		printService("container-compactor", index)
+		printService("container-inventory", index)
 		printService("object", index)
 		printService("object-replicator", index)
 	}
+	// The reconciler works through the whole cluster's queue, so one is enough.
+	printService("container-reconciler", start)
 	printService("andrewd", 0)
 
 	if subcmd == "haio" {
@@ -483,26 +538,59 @@ func initCommand(args []string) error {
 		print(`    sudo systemctl \$@ hummingbird-proxy &`)
 		print(`    sudo systemctl \$@ hummingbird-account1 &`)
 		print(`    sudo systemctl \$@ hummingbird-account-replicator1 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-auditor1 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-compactor1 &`)
 		print(`    sudo systemctl \$@ hummingbird-container1 &`)
 		print(`    sudo systemctl \$@ hummingbird-container-replicator1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sharder1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sync1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-auditor1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-updater1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-compactor1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-inventory1 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-reconciler1 &`)
 		print(`    sudo systemctl \$@ hummingbird-object1 &`)
 		print(`    sudo systemctl \$@ hummingbird-object-replicator1 &`)
 		print(`    sudo systemctl \$@ hummingbird-account2 &`)
 		print(`    sudo systemctl \$@ hummingbird-account-replicator2 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-auditor2 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-compactor2 &`)
 		print(`    sudo systemctl \$@ hummingbird-container2 &`)
 		print(`    sudo systemctl \$@ hummingbird-container-replicator2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sharder2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sync2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-auditor2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-updater2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-compactor2 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-inventory2 &`)
 		print(`    sudo systemctl \$@ hummingbird-object2 &`)
 		print(`    sudo systemctl \$@ hummingbird-object-replicator2 &`)
 		print(`    sudo systemctl \$@ hummingbird-account3 &`)
 		print(`    sudo systemctl \$@ hummingbird-account-replicator3 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-auditor3 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-compactor3 &`)
 		print(`    sudo systemctl \$@ hummingbird-container3 &`)
 		print(`    sudo systemctl \$@ hummingbird-container-replicator3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sharder3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sync3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-auditor3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-updater3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-compactor3 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-inventory3 &`)
 		print(`    sudo systemctl \$@ hummingbird-object3 &`)
 		print(`    sudo systemctl \$@ hummingbird-object-replicator3 &`)
 		print(`    sudo systemctl \$@ hummingbird-account4 &`)
 		print(`    sudo systemctl \$@ hummingbird-account-replicator4 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-auditor4 &`)
+		print(`    sudo systemctl \$@ hummingbird-account-compactor4 &`)
 		print(`    sudo systemctl \$@ hummingbird-container4 &`)
 		print(`    sudo systemctl \$@ hummingbird-container-replicator4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sharder4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-sync4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-auditor4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-updater4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-compactor4 &`)
+		print(`    sudo systemctl \$@ hummingbird-container-inventory4 &`)
 		print(`    sudo systemctl \$@ hummingbird-object4 &`)
 		print(`    sudo systemctl \$@ hummingbird-object-replicator4 &`)
 		print(`    sudo systemctl \$@ hummingbird-andrewd &`)
@@ -596,26 +684,59 @@ func initCommand(args []string) error {
 		print(`    sudo systemctl stop hummingbird-proxy &`)
 		print(`    sudo systemctl stop hummingbird-account1 &`)
 		print(`    sudo systemctl stop hummingbird-account-replicator1 &`)
+		print(`    sudo systemctl stop hummingbird-account-auditor1 &`)
+		print(`    sudo systemctl stop hummingbird-account-compactor1 &`)
 		print(`    sudo systemctl stop hummingbird-container1 &`)
 		print(`    sudo systemctl stop hummingbird-container-replicator1 &`)
+		print(`    sudo systemctl stop hummingbird-container-sharder1 &`)
+		print(`    sudo systemctl stop hummingbird-container-sync1 &`)
+		print(`    sudo systemctl stop hummingbird-container-auditor1 &`)
+		print(`    sudo systemctl stop hummingbird-container-updater1 &`)
+		print(`    sudo systemctl stop hummingbird-container-compactor1 &`)
+		print(`    sudo systemctl stop hummingbird-container-inventory1 &`)
+		print(`    sudo systemctl stop hummingbird-container-reconciler1 &`)
 		print(`    sudo systemctl stop hummingbird-object1 &`)
 		print(`    sudo systemctl stop hummingbird-object-replicator1 &`)
 		print(`    sudo systemctl stop hummingbird-account2 &`)
 		print(`    sudo systemctl stop hummingbird-account-replicator2 &`)
+		print(`    sudo systemctl stop hummingbird-account-auditor2 &`)
+		print(`    sudo systemctl stop hummingbird-account-compactor2 &`)
 		print(`    sudo systemctl stop hummingbird-container2 &`)
 		print(`    sudo systemctl stop hummingbird-container-replicator2 &`)
+		print(`    sudo systemctl stop hummingbird-container-sharder2 &`)
+		print(`    sudo systemctl stop hummingbird-container-sync2 &`)
+		print(`    sudo systemctl stop hummingbird-container-auditor2 &`)
+		print(`    sudo systemctl stop hummingbird-container-updater2 &`)
+		print(`    sudo systemctl stop hummingbird-container-compactor2 &`)
+		print(`    sudo systemctl stop hummingbird-container-inventory2 &`)
 		print(`    sudo systemctl stop hummingbird-object2 &`)
 		print(`    sudo systemctl stop hummingbird-object-replicator2 &`)
 		print(`    sudo systemctl stop hummingbird-account3 &`)
 		print(`    sudo systemctl stop hummingbird-account-replicator3 &`)
+		print(`    sudo systemctl stop hummingbird-account-auditor3 &`)
+		print(`    sudo systemctl stop hummingbird-account-compactor3 &`)
 		print(`    sudo systemctl stop hummingbird-container3 &`)
 		print(`    sudo systemctl stop hummingbird-container-replicator3 &`)
+		print(`    sudo systemctl stop hummingbird-container-sharder3 &`)
+		print(`    sudo systemctl stop hummingbird-container-sync3 &`)
+		print(`    sudo systemctl stop hummingbird-container-auditor3 &`)
+		print(`    sudo systemctl stop hummingbird-container-updater3 &`)
+		print(`    sudo systemctl stop hummingbird-container-compactor3 &`)
+		print(`    sudo systemctl stop hummingbird-container-inventory3 &`)
 		print(`    sudo systemctl stop hummingbird-object3 &`)
 		print(`    sudo systemctl stop hummingbird-object-replicator3 &`)
 		print(`    sudo systemctl stop hummingbird-account4 &`)
 		print(`    sudo systemctl stop hummingbird-account-replicator4 &`)
+		print(`    sudo systemctl stop hummingbird-account-auditor4 &`)
+		print(`    sudo systemctl stop hummingbird-account-compactor4 &`)
 		print(`    sudo systemctl stop hummingbird-container4 &`)
 		print(`    sudo systemctl stop hummingbird-container-replicator4 &`)
+		print(`    sudo systemctl stop hummingbird-container-sharder4 &`)
+		print(`    sudo systemctl stop hummingbird-container-sync4 &`)
+		print(`    sudo systemctl stop hummingbird-container-auditor4 &`)
+		print(`    sudo systemctl stop hummingbird-container-updater4 &`)
+		print(`    sudo systemctl stop hummingbird-container-compactor4 &`)
+		print(`    sudo systemctl stop hummingbird-container-inventory4 &`)
 		print(`    sudo systemctl stop hummingbird-object4 &`)
 		print(`    sudo systemctl stop hummingbird-object-replicator4 &`)
 		print(`    sudo systemctl stop hummingbird-andrewd &`)
@@ -199,7 +199,7 @@ func processControlCommand(serverCommand func(name string, args ...string) error
 	}
 
 	switch flag.Arg(1) {
-	case "proxy", "object", "object-replicator", "container", "container-replicator", "account", "account-replicator", "andrewd":
+	case "proxy", "object", "object-replicator", "container", "container-replicator", "container-sharder", "container-sync", "container-reconciler", "container-auditor", "container-updater", "container-compactor", "container-inventory", "account", "account-replicator", "account-auditor", "account-compactor", "andrewd":
 		if err := serverCommand(flag.Arg(1), flag.Args()[2:]...); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -216,8 +216,9 @@ func processControlCommand(serverCommand func(name string, args ...string) error
 	case "all":
 		exc := 0
 		for _, server := range []string{"proxy", "object", "object-replicator",
-			"container", "container-replicator", "account",
-			"account-replicator"} {
+			"container", "container-replicator", "container-sharder", "container-sync", "container-reconciler",
+			"container-auditor", "container-updater", "container-compactor", "container-inventory", "account", "account-replicator",
+			"account-auditor", "account-compactor"} {
 			if err := serverCommand(server); err != nil {
 				fmt.Fprintln(os.Stderr, server, ":", err)
 				exc = 1
@@ -289,6 +290,83 @@ func main() {
 		containerReplicatorFlags.PrintDefaults()
 	}
 
+	containerSharderFlags := flag.NewFlagSet("container sharder", flag.ExitOnError)
+	containerSharderFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerSharderFlags.String("l", "stdout", "Log location")
+	containerSharderFlags.String("e", "stderr", "Error log location")
+	containerSharderFlags.Bool("once", false, "Run one pass of the sharder")
+	containerSharderFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-sharder [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container sharder")
+		containerSharderFlags.PrintDefaults()
+	}
+
+	containerSyncFlags := flag.NewFlagSet("container sync", flag.ExitOnError)
+	containerSyncFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerSyncFlags.String("l", "stdout", "Log location")
+	containerSyncFlags.String("e", "stderr", "Error log location")
+	containerSyncFlags.Bool("once", false, "Run one pass of container sync")
+	containerSyncFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-sync [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container sync")
+		containerSyncFlags.PrintDefaults()
+	}
+
+	containerReconcilerFlags := flag.NewFlagSet("container reconciler", flag.ExitOnError)
+	containerReconcilerFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerReconcilerFlags.String("l", "stdout", "Log location")
+	containerReconcilerFlags.String("e", "stderr", "Error log location")
+	containerReconcilerFlags.Bool("once", false, "Run one pass of the reconciler")
+	containerReconcilerFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-reconciler [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container reconciler")
+		containerReconcilerFlags.PrintDefaults()
+	}
+
+	containerAuditorFlags := flag.NewFlagSet("container auditor", flag.ExitOnError)
+	containerAuditorFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerAuditorFlags.String("l", "stdout", "Log location")
+	containerAuditorFlags.String("e", "stderr", "Error log location")
+	containerAuditorFlags.Bool("once", false, "Run one pass of the auditor")
+	containerAuditorFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-auditor [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container auditor")
+		containerAuditorFlags.PrintDefaults()
+	}
+
+	containerUpdaterFlags := flag.NewFlagSet("container updater", flag.ExitOnError)
+	containerUpdaterFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerUpdaterFlags.String("l", "stdout", "Log location")
+	containerUpdaterFlags.String("e", "stderr", "Error log location")
+	containerUpdaterFlags.Bool("once", false, "Run one pass of the updater")
+	containerUpdaterFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-updater [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container updater")
+		containerUpdaterFlags.PrintDefaults()
+	}
+
+	containerCompactorFlags := flag.NewFlagSet("container compactor", flag.ExitOnError)
+	containerCompactorFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerCompactorFlags.String("l", "stdout", "Log location")
+	containerCompactorFlags.String("e", "stderr", "Error log location")
+	containerCompactorFlags.Bool("once", false, "Run one pass of the compactor")
+	containerCompactorFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-compactor [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container compactor")
+		containerCompactorFlags.PrintDefaults()
+	}
+
+	containerInventoryFlags := flag.NewFlagSet("container inventory", flag.ExitOnError)
+	containerInventoryFlags.String("c", findConfig("container"), "Config file/directory to use")
+	containerInventoryFlags.String("l", "stdout", "Log location")
+	containerInventoryFlags.String("e", "stderr", "Error log location")
+	containerInventoryFlags.Bool("once", false, "Run one pass of container inventory")
+	containerInventoryFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird container-inventory [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run container inventory")
+		containerInventoryFlags.PrintDefaults()
+	}
+
 	accountFlags := flag.NewFlagSet("account server", flag.ExitOnError)
 	accountFlags.String("c", findConfig("account"), "Config file/directory to use")
 	accountFlags.String("l", "stdout", "Log location")
@@ -310,6 +388,28 @@ func main() {
 		accountReplicatorFlags.PrintDefaults()
 	}
 
+	accountAuditorFlags := flag.NewFlagSet("account auditor", flag.ExitOnError)
+	accountAuditorFlags.String("c", findConfig("account"), "Config file/directory to use")
+	accountAuditorFlags.String("l", "stdout", "Log location")
+	accountAuditorFlags.String("e", "stderr", "Error log location")
+	accountAuditorFlags.Bool("once", false, "Run one pass of the auditor")
+	accountAuditorFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird account-auditor [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run account auditor")
+		accountAuditorFlags.PrintDefaults()
+	}
+
+	accountCompactorFlags := flag.NewFlagSet("account compactor", flag.ExitOnError)
+	accountCompactorFlags.String("c", findConfig("account"), "Config file/directory to use")
+	accountCompactorFlags.String("l", "stdout", "Log location")
+	accountCompactorFlags.String("e", "stderr", "Error log location")
+	accountCompactorFlags.Bool("once", false, "Run one pass of the compactor")
+	accountCompactorFlags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "hummingbird account-compactor [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run account compactor")
+		accountCompactorFlags.PrintDefaults()
+	}
+
 	ringBuilderFlags := flag.NewFlagSet("ring builder", flag.ExitOnError)
 	ringBuilderFlags.Bool("debug", false, "Run in debug mode")
 	ringBuilderFlags.Bool("json", false, "Ouput in JSON format")
@@ -427,6 +527,9 @@ func main() {
 		fmt.Fprintln(os.Stderr, "hummingbird restoredevice [ip] [device-name]")
 		fmt.Fprintln(os.Stderr, "  Reconstruct a device from its peers")
 		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "hummingbird db-compact [account | container] [ARGS]")
+		fmt.Fprintln(os.Stderr, "  Run one pass of the account or container compactor")
+		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "hummingbird bench CONFIG")
 		fmt.Fprintln(os.Stderr, "  Run bench tool")
 		fmt.Fprintln(os.Stderr)
@@ -489,12 +592,53 @@ func main() {
 	case "container-replicator":
 		containerReplicatorFlags.Parse(flag.Args()[1:])
 		srv.RunServers(containerserver.NewReplicator, containerReplicatorFlags)
+	case "container-sharder":
+		containerSharderFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewSharder, containerSharderFlags)
+	case "container-sync":
+		containerSyncFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewContainerSync, containerSyncFlags)
+	case "container-reconciler":
+		containerReconcilerFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewReconciler, containerReconcilerFlags)
+	case "container-auditor":
+		containerAuditorFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewAuditor, containerAuditorFlags)
+	case "container-updater":
+		containerUpdaterFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewUpdater, containerUpdaterFlags)
+	case "container-compactor":
+		containerCompactorFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewCompactor, containerCompactorFlags)
+	case "container-inventory":
+		containerInventoryFlags.Parse(flag.Args()[1:])
+		srv.RunServers(containerserver.NewInventory, containerInventoryFlags)
 	case "account":
 		accountFlags.Parse(flag.Args()[1:])
 		srv.RunServers(accountserver.NewServer, accountFlags)
 	case "account-replicator":
 		accountReplicatorFlags.Parse(flag.Args()[1:])
 		srv.RunServers(accountserver.NewReplicator, accountReplicatorFlags)
+	case "account-auditor":
+		accountAuditorFlags.Parse(flag.Args()[1:])
+		srv.RunServers(accountserver.NewAuditor, accountAuditorFlags)
+	case "account-compactor":
+		accountCompactorFlags.Parse(flag.Args()[1:])
+		srv.RunServers(accountserver.NewCompactor, accountCompactorFlags)
+	case "db-compact":
+		// A single pass of the account or container compactor, for running by hand or from cron.
+		switch flag.Arg(1) {
+		case "account":
+			accountCompactorFlags.Parse(flag.Args()[2:])
+			accountCompactorFlags.Set("once", "true")
+			srv.RunServers(accountserver.NewCompactor, accountCompactorFlags)
+		case "container":
+			containerCompactorFlags.Parse(flag.Args()[2:])
+			containerCompactorFlags.Set("once", "true")
+			srv.RunServers(containerserver.NewCompactor, containerCompactorFlags)
+		default:
+			flag.Usage()
+		}
 	case "object":
 		objectFlags.Parse(flag.Args()[1:])
 		srv.RunServers(objectserver.NewServer, objectFlags)
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ChecksumHeaderPrefix is the prefix of the headers (and object metadata keys)
+// that carry content checksums beyond the MD5 ETag, as lowercase hex.
+const ChecksumHeaderPrefix = "X-Object-Checksum-"
+
+const (
+	ChecksumSHA256 = ChecksumHeaderPrefix + "Sha256"
+	ChecksumCRC32C = ChecksumHeaderPrefix + "Crc32c"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	ChecksumSHA256: sha256.New,
+	ChecksumCRC32C: func() hash.Hash { return crc32.New(crc32cTable) },
+}
+
+// ChecksumNames returns the header names of the supported checksums.
+func ChecksumNames() []string {
+	names := make([]string, 0, len(checksumAlgorithms))
+	for name := range checksumAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsChecksumHeader returns whether the header or metadata key is a content checksum.
+func IsChecksumHeader(key string) bool {
+	return strings.HasPrefix(key, ChecksumHeaderPrefix)
+}
+
+// ChecksumHeaders returns the checksum entries of the object metadata as
+// headers, suitable for verifying the object's contents with NewChecksummer.
+func ChecksumHeaders(metadata map[string]string) http.Header {
+	header := http.Header{}
+	for key, value := range metadata {
+		if IsChecksumHeader(key) {
+			header.Set(key, value)
+		}
+	}
+	return header
+}
+
+// Checksummer computes the content checksums requested for an object and
+// verifies them against any values the client supplied.
+type Checksummer struct {
+	hashes   map[string]hash.Hash
+	expected map[string]string
+}
+
+// NewChecksummer returns a Checksummer for the checksum headers in header, plus
+// any algorithms (such as "sha256") in always. It returns an error if a header
+// names an unknown algorithm or has a malformed value.
+func NewChecksummer(header http.Header, always ...string) (*Checksummer, error) {
+	c := &Checksummer{hashes: map[string]hash.Hash{}, expected: map[string]string{}}
+	for key := range header {
+		if !IsChecksumHeader(key) {
+			continue
+		}
+		newHash, ok := checksumAlgorithms[key]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported checksum %s", key)
+		}
+		value := strings.ToLower(strings.TrimSpace(header.Get(key)))
+		h := newHash()
+		if b, err := hex.DecodeString(value); err != nil || len(b) != h.Size() {
+			return nil, fmt.Errorf("Invalid %s value %q", key, header.Get(key))
+		}
+		c.hashes[key] = h
+		c.expected[key] = value
+	}
+	for _, name := range always {
+		key := ChecksumHeaderPrefix + strings.Title(strings.ToLower(name))
+		if _, ok := c.hashes[key]; ok {
+			continue
+		}
+		newHash, ok := checksumAlgorithms[key]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported checksum %s", key)
+		}
+		c.hashes[key] = newHash()
+	}
+	return c, nil
+}
+
+// Writer returns a writer that feeds all the checksums being computed.
+func (c *Checksummer) Writer() io.Writer {
+	if len(c.hashes) == 0 {
+		return ioutil.Discard
+	}
+	writers := make([]io.Writer, 0, len(c.hashes))
+	for _, h := range c.hashes {
+		writers = append(writers, h)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Sums returns the computed checksums, keyed by header name.
+func (c *Checksummer) Sums() map[string]string {
+	sums := make(map[string]string, len(c.hashes))
+	for key, h := range c.hashes {
+		sums[key] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// Verify returns an error if any computed checksum doesn't match the value
+// the client supplied.
+func (c *Checksummer) Verify() error {
+	for key, sum := range c.Sums() {
+		if expected, ok := c.expected[key]; ok && expected != sum {
+			return fmt.Errorf("%s mismatch: expected %s, computed %s", key, expected, sum)
+		}
+	}
+	return nil
+}
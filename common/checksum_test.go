@@ -0,0 +1,54 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksummer(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Object-Checksum-CRC32C", "E3069283")
+	c, err := NewChecksummer(header, "sha256")
+	require.Nil(t, err)
+	io.Copy(c.Writer(), strings.NewReader("123456789"))
+	require.Nil(t, c.Verify())
+	sums := c.Sums()
+	require.Equal(t, 2, len(sums))
+	require.Equal(t, "e3069283", sums[ChecksumCRC32C])
+	require.Equal(t, "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", sums[ChecksumSHA256])
+
+	header.Set("X-Object-Checksum-Crc32c", "00000000")
+	c, err = NewChecksummer(header)
+	require.Nil(t, err)
+	io.Copy(c.Writer(), strings.NewReader("123456789"))
+	require.NotNil(t, c.Verify())
+}
+
+func TestChecksummerInvalid(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Object-Checksum-Md4", "00")
+	_, err := NewChecksummer(header)
+	require.NotNil(t, err)
+
+	header = http.Header{}
+	header.Set("X-Object-Checksum-Sha256", "1234")
+	_, err = NewChecksummer(header)
+	require.NotNil(t, err)
+
+	_, err = NewChecksummer(nil, "md4")
+	require.NotNil(t, err)
+
+	c, err := NewChecksummer(nil)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(c.Sums()))
+}
+
+func TestChecksumHeaders(t *testing.T) {
+	header := ChecksumHeaders(map[string]string{"ETag": "x", ChecksumSHA256: "abc"})
+	require.Equal(t, 1, len(header))
+	require.Equal(t, "abc", header.Get(ChecksumSHA256))
+}
@@ -281,3 +281,23 @@ func TestReadResellerOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncToURL(t *testing.T) {
+	realms := SyncRealmList{"US": SyncRealm{Name: "US", Key1: "realmkey", Clusters: map[string]string{"DFW1": "http://127.0.0.1:8080/v1/"}}}
+	realm, url, ok := realms.SyncToURL("//US/DFW1/AUTH_a/c")
+	require.True(t, ok)
+	require.Equal(t, "realmkey", realm.Key1)
+	require.Equal(t, "http://127.0.0.1:8080/v1/AUTH_a/c", url)
+	_, _, ok = realms.SyncToURL("//US/ORD1/AUTH_a/c")
+	require.False(t, ok)
+	_, _, ok = realms.SyncToURL("//EU/DFW1/AUTH_a/c")
+	require.False(t, ok)
+	require.False(t, realms.ValidateSyncTo("http://127.0.0.1:8080/v1/AUTH_a/c"))
+	require.False(t, realms.ValidateSyncTo("//US/DFW1/AUTH_a/"))
+}
+
+func TestSyncSignature(t *testing.T) {
+	// generated with swift's container_sync_realms.get_sig
+	require.Equal(t, "059853680672d95eb652f36c66251ec4b8f15266",
+		SyncSignature("put", "/v1/AUTH_a/c/o", "1500000000.00000", "nonce", "realmkey", "userkey"))
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Policy struct {
@@ -60,6 +61,46 @@ func (p Policy) GetDbSubDirs() (int, error) {
 	return subdirs, nil
 }
 
+// GetDemoteAge returns how long objects stay on the fast_devices of a hybrid
+// policy before being demoted, from demote_after in seconds.
+func (p Policy) GetDemoteAge() (time.Duration, error) {
+	demoteAfter := int64(3600)
+	if p.Config["demote_after"] != "" {
+		var err error
+		if demoteAfter, err = strconv.ParseInt(p.Config["demote_after"], 10, 64); err != nil {
+			return 0, fmt.Errorf("Could not parse demote_after value %q: %s", p.Config["demote_after"], err)
+		}
+	}
+	return time.Duration(demoteAfter) * time.Second, nil
+}
+
+// GetDemoteSize returns the content size at which objects on the fast_devices
+// of a hybrid policy are demoted regardless of age; 0 disables it.
+func (p Policy) GetDemoteSize() (int64, error) {
+	demoteSize := int64(0)
+	if p.Config["demote_size"] != "" {
+		var err error
+		if demoteSize, err = strconv.ParseInt(p.Config["demote_size"], 10, 64); err != nil {
+			return 0, fmt.Errorf("Could not parse demote_size value %q: %s", p.Config["demote_size"], err)
+		}
+	}
+	return demoteSize, nil
+}
+
+// GetShardHedgeDelay returns how long an EC read waits on its data shards
+// before also requesting a parity shard, from shard_hedge_delay in
+// milliseconds; a negative value disables hedging.
+func (p Policy) GetShardHedgeDelay() (time.Duration, error) {
+	hedgeDelay := int64(25)
+	if p.Config["shard_hedge_delay"] != "" {
+		var err error
+		if hedgeDelay, err = strconv.ParseInt(p.Config["shard_hedge_delay"], 10, 64); err != nil {
+			return 0, fmt.Errorf("Could not parse shard_hedge_delay value %q: %s", p.Config["shard_hedge_delay"], err)
+		}
+	}
+	return time.Duration(hedgeDelay) * time.Millisecond, nil
+}
+
 type PolicyList map[int]*Policy
 
 func (p PolicyList) Default() int {
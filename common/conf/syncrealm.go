@@ -15,7 +15,13 @@
 
 package conf
 
-import "strings"
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
 
 type SyncRealm struct {
 	Name     string
@@ -27,27 +33,44 @@ type SyncRealm struct {
 type SyncRealmList map[string]SyncRealm
 
 func (l SyncRealmList) ValidateSyncTo(syncHeader string) bool {
+	_, _, ok := l.SyncToURL(syncHeader)
+	return ok
+}
+
+// SyncToURL returns the realm and the url of the remote container for an
+// X-Container-Sync-To header of the form //realm/cluster/account/container.
+func (l SyncRealmList) SyncToURL(syncHeader string) (SyncRealm, string, bool) {
 	if !strings.HasPrefix(syncHeader, "//") {
-		return false
+		return SyncRealm{}, "", false
 	}
 	parts := strings.Split(syncHeader[2:], "/")
 	if len(parts) < 4 {
-		return false
+		return SyncRealm{}, "", false
 	}
 	realm := parts[0]
 	cluster := parts[1]
 	account := parts[2]
 	container := parts[3]
 	if account == "" || container == "" {
-		return false
+		return SyncRealm{}, "", false
 	}
 	if l[realm].Key1 == "" {
-		return false
+		return SyncRealm{}, "", false
 	}
-	if l[realm].Clusters[cluster] == "" {
-		return false
+	endpoint := l[realm].Clusters[cluster]
+	if endpoint == "" {
+		return SyncRealm{}, "", false
 	}
-	return true
+	return l[realm], strings.TrimRight(endpoint, "/") + "/" + account + "/" + container, true
+}
+
+// SyncSignature returns the signature sent in the X-Container-Sync-Auth header
+// of a container sync request, keyed by both the realm key and the
+// destination container's X-Container-Sync-Key.
+func SyncSignature(method, path, timestamp, nonce, realmKey, userKey string) string {
+	mac := hmac.New(sha1.New, []byte(realmKey))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", strings.ToUpper(method), path, timestamp, nonce, userKey)
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 var syncRealmConfigLocations = []string{"/etc/hummingbird/container-sync-realms.conf", "/etc/swift/container-sync-realms.conf"}
@@ -55,15 +55,16 @@ var DEFAULT_CONSTRAINTS = map[string]interface{}{
 }
 
 var OwnerHeaders = map[string]bool{
-	"x-container-read":                true,
-	"x-container-write":               true,
-	"x-container-sync-key":            true,
-	"x-container-sync-to":             true,
-	"x-account-meta-temp-url-key":     true,
-	"x-account-meta-temp-url-key-2":   true,
-	"x-container-meta-temp-url-key":   true,
-	"x-container-meta-temp-url-key-2": true,
-	"x-account-access-control":        true,
+	"x-container-read":                  true,
+	"x-container-write":                 true,
+	"x-container-sync-key":              true,
+	"x-container-sync-to":               true,
+	"x-container-inventory-destination": true,
+	"x-account-meta-temp-url-key":       true,
+	"x-account-meta-temp-url-key-2":     true,
+	"x-container-meta-temp-url-key":     true,
+	"x-container-meta-temp-url-key-2":   true,
+	"x-account-access-control":          true,
 }
 
 var ErrBadRequest = errors.New("bad request")
@@ -0,0 +1,111 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package dbdaemon
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// AuditError is returned by a Verify when a database fails its audit, as opposed to being unable to run one.
+type AuditError string
+
+func (e AuditError) Error() string {
+	return string(e)
+}
+
+// Verify opens and audits a single database file.  It returns an AuditError, or an error common.IsCorruptDBError
+// recognizes, if the database should be quarantined.
+type Verify func(dbFile string) error
+
+// auditor is the Job that verifies each database, quarantining those that fail.
+type auditor struct {
+	// kind is "account" or "container".
+	kind         string
+	verify       Verify
+	logger       srv.LowLevelLogger
+	auditsPassed tally.Counter
+	auditsFailed tally.Counter
+	auditErrors  tally.Counter
+	passed       int64
+	failed       int64
+}
+
+func (a *auditor) SetMetricsScope(scope tally.Scope) {
+	a.auditsPassed = scope.Counter(a.kind + "_audits_passed")
+	a.auditsFailed = scope.Counter(a.kind + "_audits_failed")
+	a.auditErrors = scope.Counter(a.kind + "_audit_errors")
+}
+
+func (a *auditor) StartPass() {
+	a.passed = 0
+	a.failed = 0
+}
+
+func (a *auditor) Do(dbFile string) bool {
+	err := a.verify(dbFile)
+	if _, ok := err.(AuditError); ok || (err != nil && common.IsCorruptDBError(err)) {
+		a.auditsFailed.Inc(1)
+		a.failed++
+		a.logger.Error(strings.Title(a.kind)+" database failed audit.", zap.String("dbFile", dbFile), zap.Error(err))
+		// The database may have quarantined itself on finding it was corrupt.
+		if fs.Exists(dbFile) {
+			a.logger.Info("Quarantining "+a.kind+" database.", zap.String("dbFile", dbFile),
+				zap.Error(common.QuarantineDir(filepath.Dir(dbFile), 4, a.kind+"s")))
+		}
+	} else if err != nil {
+		a.auditErrors.Inc(1)
+		a.logger.Error("Error auditing "+a.kind+" database.", zap.String("dbFile", dbFile), zap.Error(err))
+	} else {
+		a.auditsPassed.Inc(1)
+		a.passed++
+	}
+	return true
+}
+
+func (a *auditor) Progress(since time.Time) ([]zap.Field, map[string]interface{}) {
+	fields := []zap.Field{zap.Int64("passed", a.passed), zap.Int64("failed", a.failed)}
+	recon := map[string]interface{}{
+		a.kind + "_audits_passed": a.passed,
+		a.kind + "_audits_failed": a.failed,
+		a.kind + "_audits_since":  float64(since.UnixNano()) / float64(time.Second),
+	}
+	a.passed = 0
+	a.failed = 0
+	return fields, recon
+}
+
+// NewAuditor uses the config settings and command-line flags to configure and return an auditor daemon for the
+// kind of database, "account" or "container", that runs verify on each one findDbs finds.
+func NewAuditor(serverconf conf.Config, flags *flag.FlagSet, kind string, findDbs FindDbs, defaultPort int, verify Verify) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	ipPort, d, err := newDaemon(serverconf, flags, kind, "auditor", findDbs, defaultPort, 1800, 200)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	a := &auditor{kind: kind, verify: verify, logger: d.Logger}
+	a.SetMetricsScope(tally.NoopScope)
+	d.Job = a
+	return ipPort, d, d.Logger, nil
+}
@@ -0,0 +1,66 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package dbdaemon
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func TestAuditor(t *testing.T) {
+	a := &auditor{kind: "container", logger: zap.NewNop()}
+	a.SetMetricsScope(tally.NoopScope)
+	d, cleanup := newTestDaemon(t, a)
+	defer cleanup()
+	d.Name = "container-auditor"
+	good := makeDb(t, d.DeviceRoot, "sda", "aaa111", 1)
+	bad := makeDb(t, d.DeviceRoot, "sda", "bbb222", 1)
+	unreadable := makeDb(t, d.DeviceRoot, "sda", "ccc333", 1)
+	var verified []string
+	a.verify = func(dbFile string) error {
+		verified = append(verified, dbFile)
+		switch dbFile {
+		case bad:
+			return AuditError("bad hash")
+		case unreadable:
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+
+	d.Run()
+	require.Equal(t, 3, len(verified))
+	require.True(t, fs.Exists(good))
+	require.False(t, fs.Exists(bad))
+	// Only databases that fail their audit are quarantined, not ones that couldn't be audited.
+	require.True(t, fs.Exists(unreadable))
+	quarantined, err := filepath.Glob(filepath.Join(d.DeviceRoot, "sda", "quarantined", "containers", "*"))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(quarantined))
+	require.Equal(t, "bbb222", filepath.Base(quarantined[0])[:6])
+
+	recon := readRecon(t, d.ReconCachePath, "container")
+	require.Equal(t, float64(1), recon["container_audits_passed"])
+	require.Equal(t, float64(1), recon["container_audits_failed"])
+	require.NotNil(t, recon["container_audits_since"])
+	require.NotNil(t, recon["container_auditor_pass_completed"])
+}
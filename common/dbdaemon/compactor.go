@@ -0,0 +1,118 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package dbdaemon
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// Compact opens a single database file and compacts it if it's at least minSize bytes and at least minFree of it is
+// free space, returning its size before and after.
+type Compact func(dbFile string, minSize int64, minFree float64) (int64, int64, error)
+
+// compactor is the Job that reclaims the free space left in databases by deleted rows and cleaned up tombstones.
+type compactor struct {
+	// kind is "account" or "container".
+	kind              string
+	compact           Compact
+	logger            srv.LowLevelLogger
+	minSize           int64
+	minFree           float64
+	compactions       tally.Counter
+	compactionErrors  tally.Counter
+	bytesBefore       tally.Counter
+	bytesAfter        tally.Counter
+	checked           int64
+	compacted         int64
+	reclaimed         int64
+	reportedCompacted int64
+	reportedReclaimed int64
+}
+
+func (c *compactor) SetMetricsScope(scope tally.Scope) {
+	c.compactions = scope.Counter(c.kind + "_compactions")
+	c.compactionErrors = scope.Counter(c.kind + "_compaction_errors")
+	c.bytesBefore = scope.Counter(c.kind + "_compaction_bytes_before")
+	c.bytesAfter = scope.Counter(c.kind + "_compaction_bytes_after")
+}
+
+func (c *compactor) StartPass() {
+	c.checked = 0
+	c.compacted = 0
+	c.reclaimed = 0
+	c.reportedCompacted = 0
+	c.reportedReclaimed = 0
+}
+
+func (c *compactor) Do(dbFile string) bool {
+	// Databases too small to compact are skipped without opening them.
+	if info, err := os.Stat(dbFile); err != nil || info.Size() < c.minSize {
+		return false
+	}
+	before, after, err := c.compact(dbFile, c.minSize, c.minFree)
+	if err != nil {
+		c.compactionErrors.Inc(1)
+		c.logger.Error("Error compacting "+c.kind+" database.", zap.String("dbFile", dbFile), zap.Error(err))
+	} else if after < before {
+		c.compactions.Inc(1)
+		c.bytesBefore.Inc(before)
+		c.bytesAfter.Inc(after)
+		c.compacted++
+		c.reclaimed += before - after
+		c.logger.Debug("Compacted "+c.kind+" database.", zap.String("dbFile", dbFile),
+			zap.Int64("before", before), zap.Int64("after", after))
+	}
+	c.checked++
+	return true
+}
+
+func (c *compactor) Progress(since time.Time) ([]zap.Field, map[string]interface{}) {
+	fields := []zap.Field{zap.Int64("checked", c.checked), zap.Int64("compacted", c.compacted), zap.Int64("reclaimed", c.reclaimed)}
+	recon := map[string]interface{}{
+		c.kind + "_compactions":                c.compacted - c.reportedCompacted,
+		c.kind + "_compaction_bytes_reclaimed": c.reclaimed - c.reportedReclaimed,
+		c.kind + "_compactions_since":          float64(since.UnixNano()) / float64(time.Second),
+	}
+	c.reportedCompacted = c.compacted
+	c.reportedReclaimed = c.reclaimed
+	return fields, recon
+}
+
+// NewCompactor uses the config settings and command-line flags to configure and return a compactor daemon for the
+// kind of database, "account" or "container", that runs compact on each one findDbs finds.
+func NewCompactor(serverconf conf.Config, flags *flag.FlagSet, kind string, findDbs FindDbs, defaultPort int, compact Compact) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	ipPort, d, err := newDaemon(serverconf, flags, kind, "compactor", findDbs, defaultPort, 86400, 50)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c := &compactor{
+		kind:    kind,
+		compact: compact,
+		logger:  d.Logger,
+		minSize: serverconf.GetInt(d.Name, "min_size", 16*1024*1024),
+		minFree: serverconf.GetFloat(d.Name, "min_free", 0.25),
+	}
+	c.SetMetricsScope(tally.NoopScope)
+	d.Job = c
+	return ipPort, d, d.Logger, nil
+}
@@ -0,0 +1,69 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package dbdaemon
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func TestCompactor(t *testing.T) {
+	c := &compactor{kind: "account", logger: zap.NewNop(), minSize: 100, minFree: 0.5}
+	c.SetMetricsScope(tally.NoopScope)
+	d, cleanup := newTestDaemon(t, c)
+	defer cleanup()
+	d.Name = "account-compactor"
+	d.ReconType = "account"
+	small := makeDb(t, d.DeviceRoot, "sda", "aaa111", 10)
+	full := makeDb(t, d.DeviceRoot, "sda", "bbb222", 100)
+	sparse := makeDb(t, d.DeviceRoot, "sda", "ccc333", 1000)
+	broken := makeDb(t, d.DeviceRoot, "sda", "ddd444", 1000)
+	var compacted []string
+	c.compact = func(dbFile string, minSize int64, minFree float64) (int64, int64, error) {
+		require.Equal(t, int64(100), minSize)
+		require.Equal(t, 0.5, minFree)
+		compacted = append(compacted, dbFile)
+		info, err := os.Stat(dbFile)
+		require.Nil(t, err)
+		switch dbFile {
+		case sparse:
+			return info.Size(), info.Size() / 4, nil
+		case broken:
+			return 0, 0, errors.New("disk I/O error")
+		}
+		return info.Size(), info.Size(), nil
+	}
+
+	d.Run()
+	// Databases smaller than min_size are never opened and don't count against the rate limit.
+	require.NotContains(t, compacted, small)
+	require.Contains(t, compacted, full)
+	require.Equal(t, 3, len(compacted))
+	require.Equal(t, int64(3), d.processed)
+	require.Equal(t, int64(3), c.checked)
+	require.Equal(t, int64(1), c.compacted)
+	require.Equal(t, int64(750), c.reclaimed)
+
+	recon := readRecon(t, d.ReconCachePath, "account")
+	require.Equal(t, float64(1), recon["account_compactions"])
+	require.Equal(t, float64(750), recon["account_compaction_bytes_reclaimed"])
+	require.NotNil(t, recon["account_compactor_pass_completed"])
+}
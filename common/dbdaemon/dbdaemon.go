@@ -0,0 +1,243 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package dbdaemon runs the background passes over account and container
+// databases, like auditing and compaction.  A Daemon walks every database on
+// the server's devices at a limited rate, handing each to its Job, and takes
+// care of progress reports, recon, and serving metrics; the Job only has to
+// deal with one database at a time.  The account and container servers only
+// supply how to find, open, and check their own databases.
+package dbdaemon
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+// Job is the work a Daemon does on each database.
+type Job interface {
+	// SetMetricsScope creates the job's metrics in scope.
+	SetMetricsScope(scope tally.Scope)
+	// StartPass resets the job's counts at the start of a pass.
+	StartPass()
+	// Do processes a single database file.  It returns false if the database was skipped without opening it, in
+	// which case it doesn't count against the rate limit.
+	Do(dbFile string) bool
+	// Progress returns log fields and recon entries describing what the job has done, resetting any counts that
+	// are reported since the last call.  The recon entries can use since, the time of the last report.
+	Progress(since time.Time) ([]zap.Field, map[string]interface{})
+}
+
+// FindDbs sends the path of every database on the device to results, closing it when done or when cancel is closed.
+type FindDbs func(devicePath string, results chan string, cancel chan struct{}, logger srv.LowLevelLogger)
+
+// Daemon runs its Job over every database on the server's devices.
+type Daemon struct {
+	Job Job
+	// Name is the daemon's config section and log name, like "container-auditor".
+	Name string
+	// ReconType is the recon cache the daemon reports to, "account" or "container".
+	ReconType      string
+	FindDbs        FindDbs
+	CheckMounts    bool
+	DeviceRoot     string
+	ReconCachePath string
+	Logger         srv.LowLevelLogger
+	LogLevel       zap.AtomicLevel
+	Interval       time.Duration
+	LogTime        time.Duration
+	DbsPerSecond   int64
+	metricsCloser  io.Closer
+	passStart      time.Time
+	lastReport     time.Time
+	processed      int64
+}
+
+// report logs the job's progress and dumps it to the recon cache.
+func (d *Daemon) report() {
+	fields, recon := d.Job.Progress(d.lastReport)
+	d.Logger.Info(d.Name+" progress.", append(fields, zap.Float64("seconds", time.Since(d.lastReport).Seconds()))...)
+	middleware.DumpReconCache(d.ReconCachePath, d.ReconType, recon)
+	d.lastReport = time.Now()
+}
+
+func (d *Daemon) runDevice(devicePath string) {
+	defer srv.LogPanics(d.Logger, "PANIC WHILE RUNNING "+strings.ToUpper(d.Name)+" ON DEVICE")
+	if mount, err := fs.IsMount(devicePath); d.CheckMounts && (err != nil || !mount) {
+		d.Logger.Error("Device not mounted.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	results := make(chan string, 100)
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go d.FindDbs(devicePath, results, cancel, d.Logger)
+	for dbFile := range results {
+		if !d.Job.Do(dbFile) {
+			continue
+		}
+		d.processed++
+		if d.DbsPerSecond > 0 {
+			if shouldHave := int64(time.Since(d.passStart)/time.Second) * d.DbsPerSecond; d.processed > shouldHave {
+				time.Sleep(time.Second * time.Duration((d.processed-shouldHave)/d.DbsPerSecond))
+			}
+		}
+		if time.Since(d.lastReport) > d.LogTime {
+			d.report()
+		}
+	}
+}
+
+// Run runs a pass of the job over every device once.
+func (d *Daemon) Run() {
+	d.passStart = time.Now()
+	d.lastReport = d.passStart
+	d.processed = 0
+	d.Job.StartPass()
+	devices, err := fs.ReadDirNames(d.DeviceRoot)
+	if err != nil {
+		d.Logger.Error("Unable to list devices.", zap.String("deviceRoot", d.DeviceRoot), zap.Error(err))
+		return
+	}
+	for _, dev := range devices {
+		d.runDevice(filepath.Join(d.DeviceRoot, dev))
+	}
+	d.report()
+	elapsed := time.Since(d.passStart).Seconds()
+	middleware.DumpReconCache(d.ReconCachePath, d.ReconType, map[string]interface{}{
+		strings.Replace(d.Name, "-", "_", -1) + "_pass_completed": elapsed,
+	})
+	d.Logger.Info(d.Name+" pass complete.", zap.Int64("databases", d.processed), zap.Float64("seconds", elapsed))
+}
+
+// RunForever runs the job in a forever-loop.
+func (d *Daemon) RunForever() {
+	for {
+		start := time.Now()
+		d.Run()
+		if elapsed := time.Since(start); elapsed < d.Interval {
+			time.Sleep(d.Interval - elapsed)
+		}
+	}
+}
+
+func (d *Daemon) Type() string {
+	return d.Name
+}
+
+func (d *Daemon) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			d.Run()
+		}()
+		return ch
+	}
+	go d.RunForever()
+	return nil
+}
+
+func (d *Daemon) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, d.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	d.Job.SetMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		d.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", d.LogLevel)
+	router.Put("/loglevel", d.LogLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(d.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (d *Daemon) Finalize() {
+	if d.metricsCloser != nil {
+		d.metricsCloser.Close()
+	}
+}
+
+func (d *Daemon) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (d *Daemon) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(d.Logger, next)
+}
+
+// newDaemon configures a Daemon from the kind-job config section, like "container-auditor", with its rate limit read
+// from the section's accounts_per_second or containers_per_second setting.  The caller still has to set its Job.
+func newDaemon(serverconf conf.Config, flags *flag.FlagSet, kind, job string, findDbs FindDbs, defaultPort int,
+	defaultInterval float64, defaultRate int64) (*srv.IpPort, *Daemon, error) {
+	name := kind + "-" + job
+	if !serverconf.HasSection(name) {
+		return nil, nil, fmt.Errorf("Unable to find %s config section", name)
+	}
+	logLevelString := serverconf.GetDefault(name, "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	logger, err := srv.SetupLogger(name, &logLevel, flags)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	ip := serverconf.GetDefault(name, "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt(name, "bind_port", int64(defaultPort)))
+	d := &Daemon{
+		Name:           name,
+		ReconType:      kind,
+		FindDbs:        findDbs,
+		CheckMounts:    serverconf.GetBool(name, "mount_check", true),
+		DeviceRoot:     serverconf.GetDefault(name, "devices", "/srv/node"),
+		ReconCachePath: serverconf.GetDefault(name, "recon_cache_path", "/var/cache/swift"),
+		Logger:         logger,
+		LogLevel:       logLevel,
+		Interval:       time.Duration(serverconf.GetFloat(name, "interval", defaultInterval) * float64(time.Second)),
+		LogTime:        time.Duration(serverconf.GetInt(name, "log_time", 3600)) * time.Second,
+		DbsPerSecond:   serverconf.GetInt(name, kind+"s_per_second", defaultRate),
+	}
+	return &srv.IpPort{Ip: ip, Port: port}, d, nil
+}
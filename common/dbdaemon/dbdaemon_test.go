@@ -0,0 +1,127 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package dbdaemon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// makeDb creates a fake database file laid out like the servers' databases, at
+// devices/dev/containers/part/suffix/hash/hash.db, with size bytes of content.
+func makeDb(t *testing.T, devices, dev, hash string, size int) string {
+	dir := filepath.Join(devices, dev, "containers", "0", hash[len(hash)-3:], hash)
+	require.Nil(t, os.MkdirAll(dir, 0755))
+	dbFile := filepath.Join(dir, hash+".db")
+	require.Nil(t, ioutil.WriteFile(dbFile, make([]byte, size), 0644))
+	return dbFile
+}
+
+func findTestDbs(devicePath string, results chan string, cancel chan struct{}, logger srv.LowLevelLogger) {
+	defer close(results)
+	dbFiles, _ := filepath.Glob(filepath.Join(devicePath, "containers", "*", "*", "*", "*.db"))
+	for _, dbFile := range dbFiles {
+		select {
+		case results <- dbFile:
+		case <-cancel:
+			return
+		}
+	}
+}
+
+func readRecon(t *testing.T, reconDir, reconType string) map[string]interface{} {
+	data, err := ioutil.ReadFile(filepath.Join(reconDir, reconType+".recon"))
+	require.Nil(t, err)
+	recon := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(data, &recon))
+	return recon
+}
+
+type testJob struct {
+	seen   []string
+	passes int
+}
+
+func (j *testJob) SetMetricsScope(scope tally.Scope) {}
+
+func (j *testJob) StartPass() {
+	j.passes++
+	j.seen = nil
+}
+
+func (j *testJob) Do(dbFile string) bool {
+	j.seen = append(j.seen, filepath.Base(dbFile))
+	return true
+}
+
+func (j *testJob) Progress(since time.Time) ([]zap.Field, map[string]interface{}) {
+	return nil, map[string]interface{}{"test_seen": len(j.seen)}
+}
+
+func newTestDaemon(t *testing.T, job Job) (*Daemon, func()) {
+	devices, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	reconDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	d := &Daemon{Job: job, Name: "container-test", ReconType: "container", FindDbs: findTestDbs,
+		DeviceRoot: devices, ReconCachePath: reconDir, Logger: zap.NewNop(), LogTime: time.Hour}
+	return d, func() {
+		os.RemoveAll(devices)
+		os.RemoveAll(reconDir)
+	}
+}
+
+func TestDaemonRun(t *testing.T) {
+	j := &testJob{}
+	d, cleanup := newTestDaemon(t, j)
+	defer cleanup()
+	makeDb(t, d.DeviceRoot, "sda", "aaa111", 1)
+	makeDb(t, d.DeviceRoot, "sda", "bbb222", 1)
+	makeDb(t, d.DeviceRoot, "sdb", "ccc333", 1)
+
+	d.Run()
+	sort.Strings(j.seen)
+	require.Equal(t, []string{"aaa111.db", "bbb222.db", "ccc333.db"}, j.seen)
+	require.Equal(t, int64(3), d.processed)
+	recon := readRecon(t, d.ReconCachePath, "container")
+	require.Equal(t, float64(3), recon["test_seen"])
+	require.NotNil(t, recon["container_test_pass_completed"])
+
+	d.Run()
+	require.Equal(t, 2, j.passes)
+	require.Equal(t, 3, len(j.seen))
+}
+
+func TestDaemonRunChecksMounts(t *testing.T) {
+	j := &testJob{}
+	d, cleanup := newTestDaemon(t, j)
+	defer cleanup()
+	makeDb(t, d.DeviceRoot, "sda", "aaa111", 1)
+	// A temp dir is never a mount point.
+	d.CheckMounts = true
+	d.Run()
+	require.Equal(t, 0, len(j.seen))
+}
@@ -0,0 +1,227 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package common
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The formats account and container listings can be returned in.
+const (
+	ListingFormatText   = "text"
+	ListingFormatJSON   = "json"
+	ListingFormatXML    = "xml"
+	ListingFormatNDJSON = "ndjson"
+	ListingFormatCSV    = "csv"
+)
+
+// ListingPageSize is how many records at a time streamed listings are read from the database and written out.
+const ListingPageSize = 1000
+
+// ObjectListingCSVHeader is the header row of csv container listings.
+var ObjectListingCSVHeader = []string{"name", "bytes", "hash", "content_type", "last_modified"}
+
+// ContainerListingCSVHeader is the header row of csv account listings.
+var ContainerListingCSVHeader = []string{"name", "count", "bytes", "last_modified", "storage_policy"}
+
+// listingMediaTypes are the media types of the listing formats, in the order they're preferred when an Accept
+// header's wildcards match more than one.
+var listingMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{"text/plain", ListingFormatText},
+	{"application/json", ListingFormatJSON},
+	{"application/xml", ListingFormatXML},
+	{"text/xml", ListingFormatXML},
+	{"application/x-ndjson", ListingFormatNDJSON},
+	{"text/csv", ListingFormatCSV},
+}
+
+var listingContentTypes = map[string]string{
+	ListingFormatText:   "text/plain; charset=utf-8",
+	ListingFormatJSON:   "application/json; charset=utf-8",
+	ListingFormatXML:    "application/xml; charset=utf-8",
+	ListingFormatNDJSON: "application/x-ndjson; charset=utf-8",
+	ListingFormatCSV:    "text/csv; charset=utf-8",
+}
+
+// ListingRecord is an account or container listing entry that can be written in the streamed listing formats.
+type ListingRecord interface {
+	// ListingName returns the record's name, which is the marker for the listing page after it.
+	ListingName() string
+	// CSVRow returns the record's fields for csv listings.
+	CSVRow() []string
+}
+
+// mediaRangeMatch returns how specifically an Accept header's media range matches a media type: 2 for an exact
+// match, 1 for a type/* match, 0 for */*, and -1 if it doesn't match.
+func mediaRangeMatch(mediaRange, mediaType string) int {
+	if mediaRange == mediaType {
+		return 2
+	} else if mediaRange == "*/*" {
+		return 0
+	} else if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1]) {
+		return 1
+	}
+	return -1
+}
+
+// ListingFormat returns the format a listing should be returned in, given the request's format query parameter and
+// Accept header.  An unrecognized format parameter gets a text listing, as does a missing Accept header.  If the Accept
+// header doesn't accept any listing format, it returns an empty string.
+func ListingFormat(format, accept string) string {
+	if format != "" {
+		format = strings.ToLower(format)
+		if _, ok := listingContentTypes[format]; ok {
+			return format
+		}
+		return ListingFormatText
+	}
+	if strings.TrimSpace(accept) == "" {
+		return ListingFormatText
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1.0}
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					r.q = q
+				} else {
+					r.q = 0
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	// Each format gets the quality of the most specific range that matches it.  The highest quality wins, then the
+	// most specific match, then whichever the client listed first.
+	best, bestQ, bestSpecificity, bestPosition := "", 0.0, -1, len(ranges)
+	for _, lmt := range listingMediaTypes {
+		q, specificity, position := 0.0, -1, len(ranges)
+		for i, r := range ranges {
+			if s := mediaRangeMatch(r.mediaType, lmt.mediaType); s > specificity {
+				q, specificity, position = r.q, s, i
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && (specificity > bestSpecificity || (specificity == bestSpecificity && position < bestPosition))) {
+			best, bestQ, bestSpecificity, bestPosition = lmt.format, q, specificity, position
+		}
+	}
+	return best
+}
+
+// ListingContentType returns the Content-Type of a listing in the given format.
+func ListingContentType(format string) string {
+	if contentType, ok := listingContentTypes[format]; ok {
+		return contentType
+	}
+	return listingContentTypes[ListingFormatText]
+}
+
+// StreamedListingFormat returns true if listings in the format are written as they're read instead of all at once.
+func StreamedListingFormat(format string) bool {
+	return format == ListingFormatNDJSON || format == ListingFormatCSV
+}
+
+// WriteListingRecords writes listing records as ndjson or csv.  The csv header row is written first unless csvHeader is nil.
+func WriteListingRecords(w io.Writer, format string, csvHeader []string, records []interface{}) error {
+	if format == ListingFormatNDJSON {
+		for _, record := range records {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	if csvHeader != nil {
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if lr, ok := record.(ListingRecord); ok {
+			if err := cw.Write(lr.CSVRow()); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// StreamListing writes a listing in a streamed format, reading it a page at a time with list, which is given how
+// many records to return and the marker to start after.  It returns the status written, which is 0 if the listing
+// failed before anything was written.
+func StreamListing(writer http.ResponseWriter, format string, csvHeader []string, limit int, marker string,
+	list func(limit int, marker string) ([]interface{}, error)) (int, error) {
+	pageSize := ListingPageSize
+	if limit < pageSize {
+		pageSize = limit
+	}
+	records, err := list(pageSize, marker)
+	if err != nil {
+		return 0, err
+	}
+	writer.Header().Set("Content-Type", ListingContentType(format))
+	writer.Header().Del("Content-Length")
+	if len(records) == 0 && format == ListingFormatNDJSON {
+		writer.WriteHeader(http.StatusNoContent)
+		return http.StatusNoContent, nil
+	}
+	writer.WriteHeader(http.StatusOK)
+	for written := 0; ; {
+		if err := WriteListingRecords(writer, format, csvHeader, records); err != nil {
+			return http.StatusOK, err
+		}
+		csvHeader = nil
+		if f, ok := writer.(http.Flusher); ok {
+			f.Flush()
+		}
+		written += len(records)
+		if len(records) < pageSize || written >= limit {
+			return http.StatusOK, nil
+		}
+		if lr, ok := records[len(records)-1].(ListingRecord); ok {
+			marker = lr.ListingName()
+		}
+		if limit-written < pageSize {
+			pageSize = limit - written
+		}
+		if records, err = list(pageSize, marker); err != nil {
+			return http.StatusOK, err
+		}
+	}
+}
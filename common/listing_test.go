@@ -0,0 +1,132 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testListingRecord struct {
+	Name string `json:"name"`
+}
+
+func (r *testListingRecord) ListingName() string { return r.Name }
+
+func (r *testListingRecord) CSVRow() []string { return []string{r.Name} }
+
+func TestListingFormat(t *testing.T) {
+	require.Equal(t, ListingFormatJSON, ListingFormat("JSON", "text/csv"))
+	require.Equal(t, ListingFormatText, ListingFormat("yaml", ""))
+	require.Equal(t, ListingFormatText, ListingFormat("", ""))
+	require.Equal(t, ListingFormatText, ListingFormat("", "*/*"))
+	require.Equal(t, ListingFormatJSON, ListingFormat("", "application/json"))
+	require.Equal(t, ListingFormatJSON, ListingFormat("", "application/*"))
+	require.Equal(t, ListingFormatNDJSON, ListingFormat("", "application/x-ndjson"))
+	require.Equal(t, ListingFormatCSV, ListingFormat("", "text/csv"))
+	require.Equal(t, ListingFormatXML, ListingFormat("", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"))
+	require.Equal(t, ListingFormatCSV, ListingFormat("", "application/json;q=0.5, text/csv"))
+	require.Equal(t, ListingFormatJSON, ListingFormat("", "*/*, text/plain;q=0"))
+	require.Equal(t, "", ListingFormat("", "text/html"))
+	require.Equal(t, "", ListingFormat("", "text/plain;q=0"))
+}
+
+func TestListingContentType(t *testing.T) {
+	require.Equal(t, "application/x-ndjson; charset=utf-8", ListingContentType(ListingFormatNDJSON))
+	require.Equal(t, "text/csv; charset=utf-8", ListingContentType(ListingFormatCSV))
+	require.Equal(t, "text/plain; charset=utf-8", ListingContentType("yaml"))
+}
+
+func TestWriteListingRecords(t *testing.T) {
+	records := []interface{}{&testListingRecord{"a"}, &testListingRecord{"b,c"}}
+	buf := &bytes.Buffer{}
+	require.Nil(t, WriteListingRecords(buf, ListingFormatNDJSON, nil, records))
+	require.Equal(t, "{\"name\":\"a\"}\n{\"name\":\"b,c\"}\n", buf.String())
+	buf.Reset()
+	require.Nil(t, WriteListingRecords(buf, ListingFormatCSV, []string{"name"}, records))
+	require.Equal(t, "name\na\n\"b,c\"\n", buf.String())
+}
+
+func testListing(count int, calls *int) func(limit int, marker string) ([]interface{}, error) {
+	return func(limit int, marker string) ([]interface{}, error) {
+		*calls++
+		var records []interface{}
+		for i := 0; i < count && len(records) < limit; i++ {
+			if name := fmt.Sprintf("%05d", i); name > marker {
+				records = append(records, &testListingRecord{name})
+			}
+		}
+		return records, nil
+	}
+}
+
+func TestStreamListingPages(t *testing.T) {
+	calls := 0
+	w := httptest.NewRecorder()
+	status, err := StreamListing(w, ListingFormatCSV, []string{"name"}, 10000, "", testListing(2500, &calls))
+	require.Nil(t, err)
+	require.Equal(t, 200, status)
+	require.Equal(t, 3, calls)
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	require.Equal(t, 2501, len(lines))
+	require.Equal(t, "name", lines[0])
+	require.Equal(t, "00000", lines[1])
+	require.Equal(t, "02499", lines[2500])
+	require.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestStreamListingLimit(t *testing.T) {
+	calls := 0
+	w := httptest.NewRecorder()
+	status, err := StreamListing(w, ListingFormatNDJSON, nil, 1500, "00010", testListing(5000, &calls))
+	require.Nil(t, err)
+	require.Equal(t, 200, status)
+	require.Equal(t, 2, calls)
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	require.Equal(t, 1500, len(lines))
+	require.Equal(t, `{"name":"00011"}`, lines[0])
+	require.Equal(t, `{"name":"01510"}`, lines[1499])
+}
+
+func TestStreamListingEmpty(t *testing.T) {
+	calls := 0
+	w := httptest.NewRecorder()
+	status, err := StreamListing(w, ListingFormatNDJSON, nil, 10000, "", testListing(0, &calls))
+	require.Nil(t, err)
+	require.Equal(t, 204, status)
+	require.Equal(t, 204, w.Code)
+
+	w = httptest.NewRecorder()
+	status, err = StreamListing(w, ListingFormatCSV, []string{"name"}, 10000, "", testListing(0, &calls))
+	require.Nil(t, err)
+	require.Equal(t, 200, status)
+	require.Equal(t, "name\n", w.Body.String())
+}
+
+func TestStreamListingError(t *testing.T) {
+	w := httptest.NewRecorder()
+	status, err := StreamListing(w, ListingFormatCSV, nil, 10000, "", func(limit int, marker string) ([]interface{}, error) {
+		return nil, fmt.Errorf("broken")
+	})
+	require.NotNil(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, "", w.Header().Get("Content-Type"))
+}
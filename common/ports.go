@@ -5,8 +5,17 @@ const (
 	DefaultAndrewdPort             = 6003
 	DefaultAccountServerPort       = 6002
 	DefaultAccountReplicatorPort   = DefaultAccountServerPort + 500
+	DefaultAccountAuditorPort      = DefaultAccountServerPort + 600
+	DefaultAccountCompactorPort    = DefaultAccountServerPort + 700
 	DefaultContainerServerPort     = 6001
 	DefaultContainerReplicatorPort = DefaultContainerServerPort + 500
+	DefaultContainerSharderPort    = DefaultContainerServerPort + 600
+	DefaultContainerSyncPort       = DefaultContainerServerPort + 700
+	DefaultContainerReconcilerPort = DefaultContainerServerPort + 800
+	DefaultContainerAuditorPort    = DefaultContainerServerPort + 900
+	DefaultContainerUpdaterPort    = DefaultContainerServerPort + 1000
+	DefaultContainerCompactorPort  = DefaultContainerServerPort + 1100
+	DefaultContainerInventoryPort  = DefaultContainerServerPort + 1200
 	DefaultObjectServerPort        = 6000
 	DefaultObjectReplicatorPort    = DefaultObjectServerPort + 500
 )
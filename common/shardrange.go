@@ -0,0 +1,78 @@
+package common
+
+import "sort"
+
+// ShardAccountPrefix is prepended to an account's name to get the hidden
+// account that holds the shard containers for that account's containers.
+const ShardAccountPrefix = ".shards_"
+
+const (
+	// ShardRangeCreated is the state of a shard range that has been chosen but
+	// whose shard container isn't serving listings or updates yet.
+	ShardRangeCreated = "created"
+	// ShardRangeActive is the state of a shard range whose shard container
+	// holds the objects in its range.
+	ShardRangeActive = "active"
+)
+
+const (
+	// ShardingStateUnsharded is a container with no shard ranges.
+	ShardingStateUnsharded = "unsharded"
+	// ShardingStateSharding is a container whose shard ranges aren't all active yet.
+	ShardingStateSharding = "sharding"
+	// ShardingStateSharded is a container whose objects are held by its shard containers.
+	ShardingStateSharded = "sharded"
+)
+
+// ShardRange describes the range of object names held by a shard container.
+// Lower is exclusive and Upper is inclusive; an empty bound is unbounded.
+type ShardRange struct {
+	Account       string `json:"account"`
+	Container     string `json:"container"`
+	Lower         string `json:"lower"`
+	Upper         string `json:"upper"`
+	ObjectCount   int64  `json:"object_count"`
+	BytesUsed     int64  `json:"bytes_used"`
+	State         string `json:"state"`
+	Timestamp     string `json:"timestamp"`
+	MetaTimestamp string `json:"meta_timestamp"`
+	Deleted       int    `json:"deleted"`
+}
+
+// ShardAccount returns the name of the hidden account holding the shards of account's containers.
+func ShardAccount(account string) string {
+	return ShardAccountPrefix + account
+}
+
+// Includes returns true if the object name falls within the shard range.
+func (sr *ShardRange) Includes(name string) bool {
+	return (sr.Lower == "" || name > sr.Lower) && (sr.Upper == "" || name <= sr.Upper)
+}
+
+// ShardingState returns the sharding state of a container with the given shard ranges.
+func ShardingState(ranges []*ShardRange) string {
+	if len(ranges) == 0 {
+		return ShardingStateUnsharded
+	}
+	for _, sr := range ranges {
+		if sr.State != ShardRangeActive {
+			return ShardingStateSharding
+		}
+	}
+	return ShardingStateSharded
+}
+
+// FindShardRange returns the shard range that includes the object name, or nil.
+func FindShardRange(ranges []*ShardRange, name string) *ShardRange {
+	for _, sr := range ranges {
+		if sr.Deleted == 0 && sr.Includes(name) {
+			return sr
+		}
+	}
+	return nil
+}
+
+// SortShardRanges orders shard ranges by their lower bounds.
+func SortShardRanges(ranges []*ShardRange) {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Lower < ranges[j].Lower })
+}
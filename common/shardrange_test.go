@@ -0,0 +1,41 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardRangeIncludes(t *testing.T) {
+	sr := &ShardRange{Lower: "b", Upper: "d"}
+	require.False(t, sr.Includes("a"))
+	require.False(t, sr.Includes("b"))
+	require.True(t, sr.Includes("c"))
+	require.True(t, sr.Includes("d"))
+	require.False(t, sr.Includes("da"))
+	sr = &ShardRange{Upper: "d"}
+	require.True(t, sr.Includes(""))
+	require.True(t, sr.Includes("a"))
+	sr = &ShardRange{Lower: "d"}
+	require.False(t, sr.Includes("d"))
+	require.True(t, sr.Includes("zzz"))
+}
+
+func TestShardingState(t *testing.T) {
+	require.Equal(t, ShardingStateUnsharded, ShardingState(nil))
+	ranges := []*ShardRange{{Upper: "m", State: ShardRangeActive}, {Lower: "m", State: ShardRangeCreated}}
+	require.Equal(t, ShardingStateSharding, ShardingState(ranges))
+	ranges[1].State = ShardRangeActive
+	require.Equal(t, ShardingStateSharded, ShardingState(ranges))
+}
+
+func TestFindShardRange(t *testing.T) {
+	ranges := []*ShardRange{{Lower: "m", Container: "c-2"}, {Upper: "m", Container: "c-1"}}
+	SortShardRanges(ranges)
+	require.Equal(t, "c-1", ranges[0].Container)
+	require.Equal(t, "c-1", FindShardRange(ranges, "a").Container)
+	require.Equal(t, "c-1", FindShardRange(ranges, "m").Container)
+	require.Equal(t, "c-2", FindShardRange(ranges, "ma").Container)
+	ranges[1].Deleted = 1
+	require.Nil(t, FindShardRange(ranges, "ma"))
+}
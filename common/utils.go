@@ -26,6 +26,7 @@ import (
 	"math/rand"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -462,6 +463,28 @@ func ParseContentTypeForSlo(contentType string, listedSize int64) (string, int64
 	return contentType, listedSize, nil
 }
 
+// symlinkPathParam is the content type parameter symlinks' target paths are sent to containers in.
+const symlinkPathParam = ";symlink_path="
+
+// ContentTypeWithSymlink adds a symlink's target path to its content type, for listing in its container.
+func ContentTypeWithSymlink(contentType, symlinkPath string) string {
+	return contentType + symlinkPathParam + Urlencode(symlinkPath)
+}
+
+// ParseContentTypeForSymlink splits a listed content type into the object's content type and, if it's a symlink, its
+// target path.
+func ParseContentTypeForSymlink(contentType string) (string, string) {
+	i := strings.LastIndex(contentType, symlinkPathParam)
+	if i == -1 {
+		return contentType, ""
+	}
+	symlinkPath, err := url.PathUnescape(contentType[i+len(symlinkPathParam):])
+	if err != nil {
+		return contentType, ""
+	}
+	return contentType[:i], symlinkPath
+}
+
 func SliceFromCSV(csv string) []string {
 	s := []string{}
 	for _, val := range strings.Split(csv, ",") {
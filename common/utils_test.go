@@ -310,6 +310,16 @@ func TestParseContentTypeForSlo(t *testing.T) {
 	require.NotNil(t, err)
 }
 
+func TestParseContentTypeForSymlink(t *testing.T) {
+	ct, symlinkPath := ParseContentTypeForSymlink("text/html")
+	require.Equal(t, "text/html", ct)
+	require.Equal(t, "", symlinkPath)
+
+	ct, symlinkPath = ParseContentTypeForSymlink(ContentTypeWithSymlink("application/symlink", "/v1/a/c/o;x y"))
+	require.Equal(t, "application/symlink", ct)
+	require.Equal(t, "/v1/a/c/o;x y", symlinkPath)
+}
+
 func TestSliceFromCSV(t *testing.T) {
 	var tests = []struct {
 		s        string   // input
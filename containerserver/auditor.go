@@ -0,0 +1,64 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"crypto/md5"
+	"flag"
+	"fmt"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/dbdaemon"
+	"github.com/troubling/hummingbird/common/srv"
+)
+
+// verifyContainerDb returns the auditor's check of a single container database: its integrity, and that it hashes to where it's stored.
+func verifyContainerDb(hashPathPrefix, hashPathSuffix string) dbdaemon.Verify {
+	return func(dbFile string) error {
+		c, err := sqliteOpenContainer(dbFile)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		ac, ok := c.(AuditableContainer)
+		if !ok {
+			return nil
+		}
+		if err := ac.Audit(); err != nil {
+			return err
+		}
+		info, err := ac.GetInfo()
+		if err != nil {
+			return err
+		}
+		h := md5.New()
+		fmt.Fprintf(h, "%s/%s/%s%s", hashPathPrefix, info.Account, info.Container, hashPathSuffix)
+		if hash := fmt.Sprintf("%032x", h.Sum(nil)); hash != ac.RingHash() {
+			return dbdaemon.AuditError(fmt.Sprintf("%s/%s hashes to %s", info.Account, info.Container, hash))
+		}
+		return nil
+	}
+}
+
+// NewAuditor uses the config settings and command-line flags to configure and return a container auditor daemon.
+func NewAuditor(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	return dbdaemon.NewAuditor(serverconf, flags, "container", findContainerDbs, common.DefaultContainerAuditorPort, verifyContainerDb(hashPathPrefix, hashPathSuffix))
+}
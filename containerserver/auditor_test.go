@@ -0,0 +1,58 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/dbdaemon"
+)
+
+func TestVerifyContainerDb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	engine := newLRUEngine(dir, "changeme", "changeme", 32)
+	defer engine.Close()
+	// A database created under a different hash path prefix is misnamed for this cluster.
+	misnamedEngine := newLRUEngine(dir, "other", "changeme", 32)
+	defer misnamedEngine.Close()
+	dbFiles := map[string]string{}
+	for _, name := range []string{"good", "badhash", "misnamed"} {
+		vars := map[string]string{"device": "sda", "partition": "0", "account": "a", "container": name}
+		e := engine
+		if name == "misnamed" {
+			e = misnamedEngine
+		}
+		_, c, err := e.Create(vars, "100000000.00000", nil, 0, 0)
+		require.Nil(t, err)
+		require.Nil(t, mergeItemsByName(c, []string{"o1", "o2"}))
+		if name == "badhash" {
+			_, err = c.(*sqliteContainer).Exec("UPDATE container_info SET hash = '00000000000000000000000000000001'")
+			require.Nil(t, err)
+		}
+		e.Return(c)
+		dbFiles[name] = e.containerLocation(vars)
+	}
+
+	verify := verifyContainerDb("changeme", "changeme")
+	require.Nil(t, verify(dbFiles["good"]))
+	require.IsType(t, dbdaemon.AuditError(""), verify(dbFiles["badhash"]))
+	require.IsType(t, dbdaemon.AuditError(""), verify(dbFiles["misnamed"]))
+}
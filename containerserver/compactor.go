@@ -0,0 +1,44 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"flag"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/dbdaemon"
+	"github.com/troubling/hummingbird/common/srv"
+)
+
+// compactContainerDb is the compactor's dbdaemon.Compact for a single container database.
+func compactContainerDb(dbFile string, minSize int64, minFree float64) (int64, int64, error) {
+	db, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	cc, ok := db.(CompactableContainer)
+	if !ok {
+		return 0, 0, nil
+	}
+	return cc.Compact(minSize, minFree)
+}
+
+// NewCompactor uses the config settings and command-line flags to configure and return a container compactor daemon.
+func NewCompactor(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	return dbdaemon.NewCompactor(serverconf, flags, "container", findContainerDbs, common.DefaultContainerCompactorPort, compactContainerDb)
+}
@@ -0,0 +1,514 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Container sync copies the objects of containers with an X-Container-Sync-To
+// header to the remote container it names, which may be in another cluster.
+// The container server keeps a symlink under each device's sync_containers
+// directory for every such container database, so the daemon only has to walk
+// those.
+//
+// Each primary replica of a container syncs the rows whose object names hash
+// to its position in the ring, advancing x_container_sync_point1 as it goes.
+// It then double checks the rows the other replicas were responsible for,
+// advancing x_container_sync_point2, so objects still get synced if a node is
+// down for a while.  Requests to the remote cluster are signed with the
+// realm's key and the container's X-Container-Sync-Key, and carry the
+// object's original timestamp.
+
+package containerserver
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+// syncHeaders are the object headers copied to the remote object.
+var syncHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Disposition", "Etag",
+	"X-Delete-At", "X-Object-Manifest", "X-Static-Large-Object"}
+
+// ContainerSync is the container sync daemon object.
+type ContainerSync struct {
+	checkMounts   bool
+	deviceRoot    string
+	serverPort    int
+	Ring          ring.Ring
+	realms        conf.SyncRealmList
+	local         client.RequestClient
+	remote        common.HTTPClient
+	logger        srv.LowLevelLogger
+	logLevel      zap.AtomicLevel
+	containerTime time.Duration
+	batchSize     int
+	interval      time.Duration
+	metricsCloser io.Closer
+	syncs         tally.Counter
+	puts          tally.Counter
+	deletes       tally.Counter
+	skips         tally.Counter
+	failures      tally.Counter
+}
+
+func (s *ContainerSync) setMetricsScope(scope tally.Scope) {
+	s.syncs = scope.Counter("container_sync_syncs")
+	s.puts = scope.Counter("container_sync_puts")
+	s.deletes = scope.Counter("container_sync_deletes")
+	s.skips = scope.Counter("container_sync_skips")
+	s.failures = scope.Counter("container_sync_failures")
+}
+
+// syncTarget is where a container's objects are synced to.
+type syncTarget struct {
+	realm   conf.SyncRealm
+	url     string
+	path    string
+	userKey string
+}
+
+// signedRequest returns a request to the remote object, signed for the remote proxy's container sync middleware.
+func (t *syncTarget) signedRequest(method, obj, timestamp string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, t.url+"/"+common.Urlencode(obj), body)
+	if err != nil {
+		return nil, err
+	}
+	nonce := common.UUID()
+	sig := conf.SyncSignature(method, t.path+"/"+obj, timestamp, nonce, t.realm.Key1, t.userKey)
+	req.Header.Set("X-Container-Sync-Auth", fmt.Sprintf("%s %s %s", t.realm.Name, nonce, sig))
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("User-Agent", fmt.Sprintf("container-sync %d", os.Getpid()))
+	return req, nil
+}
+
+func timestampBefore(a, b string) bool {
+	af, aerr := strconv.ParseFloat(a, 64)
+	bf, berr := strconv.ParseFloat(b, 64)
+	return aerr == nil && berr == nil && af < bf
+}
+
+// syncRow sends a single object row to the remote container, returning true if it has been synced.
+func (s *ContainerSync) syncRow(info *ContainerInfo, target *syncTarget, row *ObjectRecord) bool {
+	if row.Deleted == 1 {
+		req, err := target.signedRequest("DELETE", row.Name, row.CreatedAt, nil)
+		if err != nil {
+			s.logger.Error("Error creating sync DELETE.", zap.Error(err))
+			return false
+		}
+		resp, err := s.remote.Do(req)
+		if err != nil {
+			s.logger.Error("Error syncing DELETE.", zap.String("url", req.URL.String()), zap.Error(err))
+			return false
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusConflict {
+			s.logger.Error("Bad status syncing DELETE.", zap.String("url", req.URL.String()), zap.Int("status", resp.StatusCode))
+			return false
+		}
+		s.deletes.Inc(1)
+		return true
+	}
+	resp := s.local.GetObject(context.Background(), info.Account, info.Container, row.Name, http.Header{"X-Newest": {"true"}})
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// The object has since been deleted, which a later row will sync.
+		s.skips.Inc(1)
+		return true
+	} else if resp.StatusCode/100 != 2 {
+		s.logger.Error("Bad status getting object to sync.", zap.String("account", info.Account),
+			zap.String("container", info.Container), zap.String("obj", row.Name), zap.Int("status", resp.StatusCode))
+		return false
+	}
+	timestamp := resp.Header.Get("X-Backend-Timestamp")
+	if timestamp == "" {
+		timestamp = resp.Header.Get("X-Timestamp")
+	}
+	if timestampBefore(timestamp, row.CreatedAt) {
+		// The object servers don't have this version yet; try again next pass.
+		return false
+	}
+	req, err := target.signedRequest("PUT", row.Name, timestamp, resp.Body)
+	if err != nil {
+		s.logger.Error("Error creating sync PUT.", zap.Error(err))
+		return false
+	}
+	for _, key := range syncHeaders {
+		if v := resp.Header.Get(key); v != "" {
+			req.Header.Set(key, v)
+		}
+	}
+	for key := range resp.Header {
+		if strings.HasPrefix(key, "X-Object-Meta-") {
+			req.Header.Set(key, resp.Header.Get(key))
+		}
+	}
+	req.ContentLength = resp.ContentLength
+	putResp, err := s.remote.Do(req)
+	if err != nil {
+		s.logger.Error("Error syncing PUT.", zap.String("url", req.URL.String()), zap.Error(err))
+		return false
+	}
+	io.Copy(ioutil.Discard, putResp.Body)
+	putResp.Body.Close()
+	// A conflict means the remote already has this version or a newer one.
+	if putResp.StatusCode/100 != 2 && putResp.StatusCode != http.StatusConflict {
+		s.logger.Error("Bad status syncing PUT.", zap.String("url", req.URL.String()), zap.Int("status", putResp.StatusCode))
+		return false
+	}
+	s.puts.Inc(1)
+	return true
+}
+
+func syncPoint(point string) int64 {
+	if p, err := strconv.ParseInt(point, 10, 64); err == nil {
+		return p
+	}
+	return -1
+}
+
+// responsible returns true if the replica at ordinal is the one that should sync the object.
+func responsible(name string, ordinal, replicas int) bool {
+	return int(crc32.ChecksumIEEE([]byte(name))%uint32(replicas)) == ordinal
+}
+
+// syncDatabase runs a pass of container sync over a single container database.
+func (s *ContainerSync) syncDatabase(dev *ring.Device, dbFile string) error {
+	parts := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(dbFile))))
+	part, err := strconv.ParseUint(parts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Bad partition: %s", parts)
+	}
+	nodes := s.Ring.GetNodes(part)
+	ordinal := -1
+	for i, node := range nodes {
+		if node.Id == dev.Id {
+			ordinal = i
+		}
+	}
+	if ordinal < 0 {
+		// Handoffs leave syncing to the primaries.
+		return nil
+	}
+	rc, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	c, ok := rc.(SyncableContainer)
+	if !ok {
+		return nil
+	}
+	if deleted, err := c.IsDeleted(); err != nil || deleted {
+		return err
+	}
+	info, err := c.GetInfo()
+	if err != nil {
+		return err
+	}
+	metadata, err := c.GetMetadata()
+	if err != nil {
+		return err
+	}
+	if metadata["X-Container-Sync-To"] == "" || metadata["X-Container-Sync-Key"] == "" {
+		return nil
+	}
+	realm, syncURL, ok := s.realms.SyncToURL(metadata["X-Container-Sync-To"])
+	if !ok {
+		return fmt.Errorf("Invalid X-Container-Sync-To %q for %s/%s", metadata["X-Container-Sync-To"], info.Account, info.Container)
+	}
+	u, err := url.Parse(syncURL)
+	if err != nil {
+		return err
+	}
+	target := &syncTarget{realm: realm, url: syncURL, path: u.Path, userKey: metadata["X-Container-Sync-Key"]}
+	point1, point2 := syncPoint(info.XContainerSyncPoint1), syncPoint(info.XContainerSyncPoint2)
+	stopAt := time.Now().Add(s.containerTime)
+	// Double check the rows the other replicas were responsible for, up to where this replica has synced its own.
+	// Rows are read, and the sync points saved, a batch at a time.
+	for point2 < point1 {
+		rows, err := c.ItemsSince(point2, s.batchSize)
+		if err != nil {
+			return err
+		}
+		last, stopped := point2, len(rows) < s.batchSize
+		for _, row := range rows {
+			if row.Rowid > point1 || !time.Now().Before(stopAt) {
+				stopped = true
+				break
+			}
+			if !responsible(row.Name, ordinal, len(nodes)) && !s.syncRow(info, target, row) {
+				s.failures.Inc(1)
+				stopped = true
+				break
+			}
+			point2 = row.Rowid
+		}
+		if point2 != last {
+			if err := c.SetSyncPoints(point1, point2); err != nil {
+				return err
+			}
+		}
+		if stopped {
+			break
+		}
+	}
+	// Sync this replica's share of the new rows.
+	for time.Now().Before(stopAt) {
+		rows, err := c.ItemsSince(point1, s.batchSize)
+		if err != nil {
+			return err
+		}
+		last, failed := point1, false
+		for _, row := range rows {
+			if !time.Now().Before(stopAt) {
+				break
+			}
+			if responsible(row.Name, ordinal, len(nodes)) && !s.syncRow(info, target, row) {
+				s.failures.Inc(1)
+				failed = true
+				break
+			}
+			point1 = row.Rowid
+		}
+		if point1 != last {
+			if err := c.SetSyncPoints(point1, point2); err != nil {
+				return err
+			}
+		}
+		if failed {
+			return nil
+		}
+		if len(rows) < s.batchSize {
+			break
+		}
+	}
+	s.syncs.Inc(1)
+	return nil
+}
+
+// findSyncDbs returns the container databases with sync symlinks on the device.  Links to databases that
+// no longer exist are removed.
+func (s *ContainerSync) findSyncDbs(devicePath string) []string {
+	links, err := filepath.Glob(filepath.Join(devicePath, "sync_containers", "[0-9]*", "[a-f0-9][a-f0-9][a-f0-9]", "*", "*.db"))
+	if err != nil {
+		s.logger.Error("Error finding sync containers.", zap.String("devicePath", devicePath), zap.Error(err))
+		return nil
+	}
+	dbFiles := make([]string, 0, len(links))
+	for _, link := range links {
+		dbFile, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			os.Remove(link)
+			continue
+		}
+		dbFiles = append(dbFiles, dbFile)
+	}
+	return dbFiles
+}
+
+func (s *ContainerSync) syncDevice(dev *ring.Device) {
+	devicePath := filepath.Join(s.deviceRoot, dev.Device)
+	if stat, err := os.Stat(devicePath); err != nil || !stat.IsDir() {
+		s.logger.Error("Device doesn't exist.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	if mount, err := fs.IsMount(devicePath); s.checkMounts && (err != nil || !mount) {
+		s.logger.Error("Device not mounted.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	for _, dbFile := range s.findSyncDbs(devicePath) {
+		if err := s.syncDatabase(dev, dbFile); err != nil {
+			s.failures.Inc(1)
+			s.logger.Error("Error syncing container.", zap.String("dbFile", dbFile), zap.Error(err))
+		}
+	}
+}
+
+// Run runs a pass of container sync once.
+func (s *ContainerSync) Run() {
+	devices, err := s.Ring.LocalDevices(s.serverPort)
+	if err != nil {
+		s.logger.Error("Error getting local devices from ring.", zap.Error(err))
+		return
+	}
+	start := time.Now()
+	for _, dev := range devices {
+		s.syncDevice(dev)
+	}
+	s.logger.Info("Container sync pass complete.", zap.Float64("seconds", time.Since(start).Seconds()))
+}
+
+// RunForever runs container sync in a forever-loop.
+func (s *ContainerSync) RunForever() {
+	for {
+		start := time.Now()
+		s.Run()
+		if elapsed := time.Since(start); elapsed < s.interval {
+			time.Sleep(s.interval - elapsed)
+		}
+	}
+}
+
+func (s *ContainerSync) Type() string {
+	return "container-sync"
+}
+
+func (s *ContainerSync) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			s.Run()
+		}()
+		return ch
+	}
+	go s.RunForever()
+	return nil
+}
+
+func (s *ContainerSync) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, s.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	s.setMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		s.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", s.logLevel)
+	router.Put("/loglevel", s.logLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(s.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (s *ContainerSync) Finalize() {
+	if s.metricsCloser != nil {
+		s.metricsCloser.Close()
+	}
+}
+
+func (s *ContainerSync) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (s *ContainerSync) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(s.logger, next)
+}
+
+// NewContainerSync uses the config settings and command-line flags to configure and return a container sync daemon struct.
+func NewContainerSync(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	var ipPort *srv.IpPort
+	var err error
+	var logger srv.LowLevelLogger
+	if !serverconf.HasSection("container-sync") {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container-sync config section")
+	}
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	ring, err := cnf.GetRing("container", hashPathPrefix, hashPathSuffix, 0)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading container ring: %s", err)
+	}
+	realms, err := cnf.GetSyncRealms()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading sync realms: %s", err)
+	}
+	policies, err := cnf.GetPolicies()
+	if err != nil {
+		return ipPort, nil, nil, err
+	}
+	logLevelString := serverconf.GetDefault("container-sync", "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	if logger, err = srv.SetupLogger("container-sync", &logLevel, flags); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	certFile := serverconf.GetDefault("container-sync", "cert_file", "")
+	keyFile := serverconf.GetDefault("container-sync", "key_file", "")
+	pdc, err := client.NewProxyClient(policies, cnf, logger, certFile, keyFile, "", "", "", serverconf)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Could not make client: %v", err)
+	}
+	ip := serverconf.GetDefault("container-sync", "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt("container-sync", "bind_port", common.DefaultContainerSyncPort))
+	connTimeout := time.Duration(serverconf.GetFloat("container-sync", "conn_timeout", 5.0) * float64(time.Second))
+	nodeTimeout := time.Duration(serverconf.GetFloat("container-sync", "request_timeout", 300.0) * float64(time.Second))
+	s := &ContainerSync{
+		checkMounts:   serverconf.GetBool("container-sync", "mount_check", true),
+		deviceRoot:    serverconf.GetDefault("container-sync", "devices", "/srv/node"),
+		serverPort:    int(serverconf.GetInt("container-replicator", "bind_port", common.DefaultContainerReplicatorPort)),
+		Ring:          ring,
+		realms:        realms,
+		local:         pdc.NewRequestClient(nil, nil, logger),
+		logger:        logger,
+		logLevel:      logLevel,
+		containerTime: time.Duration(serverconf.GetFloat("container-sync", "container_time", 60) * float64(time.Second)),
+		batchSize:     int(serverconf.GetInt("container-sync", "batch_size", 100)),
+		interval:      time.Duration(serverconf.GetFloat("container-sync", "interval", 300) * float64(time.Second)),
+		remote: &http.Client{
+			Timeout: nodeTimeout,
+			Transport: &http.Transport{
+				Dial:                (&net.Dialer{Timeout: connTimeout}).Dial,
+				MaxIdleConnsPerHost: 100,
+				MaxIdleConns:        0,
+			},
+		},
+	}
+	s.local.SetUserAgent(fmt.Sprintf("container-sync %d", os.Getpid()))
+	s.setMetricsScope(tally.NoopScope)
+	ipPort = &srv.IpPort{Ip: ip, Port: port}
+	return ipPort, s, logger, nil
+}
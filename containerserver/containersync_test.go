@@ -0,0 +1,181 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type syncLocalClient struct {
+	client.RequestClient
+}
+
+func (c *syncLocalClient) GetObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
+	if obj == "gone" {
+		return &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"X-Backend-Timestamp": {"10000000.00001"},
+			"Content-Type":        {"text/plain"},
+			"X-Object-Meta-Color": {"blue"},
+		},
+		ContentLength: int64(len(obj)),
+		Body:          ioutil.NopCloser(strings.NewReader(obj)),
+	}
+}
+
+type syncRemoteClient struct {
+	requests []*http.Request
+	status   int
+}
+
+func (c *syncRemoteClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	if req.Body != nil {
+		ioutil.ReadAll(req.Body)
+	}
+	return &http.Response{StatusCode: c.status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestContainerSyncDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	devs := []*ring.Device{{Id: 0, Device: "sda"}, {Id: 1, Device: "sdb"}, {Id: 2, Device: "sdc"}}
+	remote := &syncRemoteClient{status: http.StatusCreated}
+	s := &ContainerSync{
+		deviceRoot:    dir,
+		Ring:          &test.FakeRing{MockDevices: devs},
+		realms:        conf.SyncRealmList{"realm": {Name: "realm", Key1: "realmkey", Clusters: map[string]string{"c1": "http://remote/v1/"}}},
+		local:         &syncLocalClient{},
+		remote:        remote,
+		logger:        zap.NewNop(),
+		containerTime: time.Minute,
+		batchSize:     2,
+	}
+	s.setMetricsScope(tally.NoopScope)
+
+	engine := newLRUEngine(dir, "changeme", "changeme", 32)
+	defer engine.Close()
+	vars := map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c"}
+	_, c, err := engine.Create(vars, "100000000.00000", map[string][]string{
+		"X-Container-Sync-To":  {"//realm/c1/b/c2", "100000000.00000"},
+		"X-Container-Sync-Key": {"userkey", "100000000.00000"},
+	}, 0, 0)
+	require.Nil(t, err)
+	names := []string{"a", "b", "c", "d", "e", "f", "gone"}
+	require.Nil(t, mergeItemsByName(c, names))
+	require.Nil(t, c.(ReplicableContainer).MergeItems([]*ObjectRecord{{Name: "h", CreatedAt: "10000000.00002", Deleted: 1}}, ""))
+	require.Nil(t, c.(*sqliteContainer).CheckSyncLink())
+	engine.Return(c)
+	dbFiles := s.findSyncDbs(filepath.Join(dir, "sda"))
+	require.Equal(t, 1, len(dbFiles))
+
+	// The first pass only syncs this replica's share of the rows.
+	require.Nil(t, s.syncDatabase(devs[0], dbFiles[0]))
+	expected := 0
+	for _, name := range append(names, "h") {
+		if responsible(name, 0, 3) && name != "gone" {
+			expected++
+		}
+	}
+	require.Equal(t, expected, len(remote.requests))
+	for _, req := range remote.requests {
+		require.True(t, strings.HasPrefix(req.URL.String(), "http://remote/v1/b/c2/"))
+		require.True(t, strings.HasPrefix(req.Header.Get("X-Container-Sync-Auth"), "realm "))
+		if req.Method == "PUT" {
+			require.Equal(t, "10000000.00001", req.Header.Get("X-Timestamp"))
+			require.Equal(t, "blue", req.Header.Get("X-Object-Meta-Color"))
+		}
+	}
+
+	// The second pass double checks everything else.
+	remote.requests = nil
+	require.Nil(t, s.syncDatabase(devs[0], dbFiles[0]))
+	require.Equal(t, len(names)-expected, len(remote.requests))
+	syncPoints := func() (string, string) {
+		c, err := sqliteOpenContainer(dbFiles[0])
+		require.Nil(t, err)
+		defer c.Close()
+		info, err := c.GetInfo()
+		require.Nil(t, err)
+		return info.XContainerSyncPoint1, info.XContainerSyncPoint2
+	}
+	point1, point2 := syncPoints()
+	require.NotEqual(t, "-1", point1)
+	require.Equal(t, point1, point2)
+
+	// Handoffs don't sync.
+	remote.requests = nil
+	require.Nil(t, s.syncDatabase(&ring.Device{Id: 3, Device: "sdd"}, dbFiles[0]))
+	require.Equal(t, 0, len(remote.requests))
+
+	// A failure partway through a batch still saves the rows synced before it.
+	c, err = engine.Get(vars)
+	require.Nil(t, err)
+	var other, mine string
+	for _, name := range []string{"i", "j", "k", "l", "m", "n", "o", "p"} {
+		if responsible(name, 0, 3) && mine == "" {
+			mine = name
+		} else if !responsible(name, 0, 3) && other == "" {
+			other = name
+		}
+	}
+	// Merged separately so the row the sync fails on comes after the one it skips.
+	require.Nil(t, mergeItemsByName(c, []string{other}))
+	require.Nil(t, mergeItemsByName(c, []string{mine}))
+	rows, err := c.(ReplicableContainer).ItemsSince(syncPoint(point1), 100)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rows))
+	engine.Return(c)
+	remote.status = http.StatusInternalServerError
+	require.Nil(t, s.syncDatabase(devs[0], dbFiles[0]))
+	point1, _ = syncPoints()
+	require.Equal(t, strconv.FormatInt(rows[0].Rowid, 10), point1)
+}
+
+func TestContainerSyncRowFailure(t *testing.T) {
+	remote := &syncRemoteClient{status: http.StatusUnauthorized}
+	s := &ContainerSync{local: &syncLocalClient{}, remote: remote, logger: zap.NewNop()}
+	s.setMetricsScope(tally.NoopScope)
+	target := &syncTarget{realm: conf.SyncRealm{Name: "realm", Key1: "realmkey"}, url: "http://remote/v1/b/c2", path: "/v1/b/c2", userKey: "userkey"}
+	info := &ContainerInfo{Account: "a", Container: "c"}
+	require.False(t, s.syncRow(info, target, &ObjectRecord{Name: "o", CreatedAt: "10000000.00001"}))
+	// The local object is older than the row.
+	remote.status = http.StatusCreated
+	require.False(t, s.syncRow(info, target, &ObjectRecord{Name: "o", CreatedAt: "10000000.00002"}))
+	require.True(t, s.syncRow(info, target, &ObjectRecord{Name: "o", CreatedAt: "10000000.00001"}))
+	remote.status = http.StatusNotFound
+	require.True(t, s.syncRow(info, target, &ObjectRecord{Name: "o", CreatedAt: "10000000.00003", Deleted: 1}))
+}
@@ -19,12 +19,16 @@ import (
 	"container/list"
 	"crypto/md5"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
 	"github.com/troubling/hummingbird/common/fs"
 )
 
@@ -35,6 +39,10 @@ var (
 	ErrorInvalidMetadata = fmt.Errorf("Invalid metadata value")
 	// ErrorPolicyConflict is returned when an operation conflicts with the container's existing policy.
 	ErrorPolicyConflict = fmt.Errorf("Policy conflicts with existing value")
+	// ErrorNoSuchSnapshot is returned when a requested snapshot doesn't exist or has expired.
+	ErrorNoSuchSnapshot = fmt.Errorf("No such snapshot.")
+	// ErrorSnapshotOrder is returned when diffing a snapshot against a newer one.
+	ErrorSnapshotOrder = fmt.Errorf("Snapshots must be diffed oldest first")
 )
 
 // ContainerInfo represents the container_info database record - basic information about the container.
@@ -55,6 +63,7 @@ type ContainerInfo struct {
 	ID                      string              `json:"id"`
 	XContainerSyncPoint1    string              `json:"-"`
 	XContainerSyncPoint2    string              `json:"-"`
+	ReconcilerSyncPoint     int64               `json:"-"`
 	StoragePolicyIndex      int                 `json:"storage_policy_index"`
 	RawMetadata             string              `json:"metadata"`
 	Metadata                map[string][]string `json:"-"`
@@ -74,6 +83,7 @@ type ObjectListingRecord struct {
 	Size         int64    `xml:"bytes" json:"bytes"`
 	ContentType  string   `xml:"content_type" json:"content_type"`
 	ETag         string   `xml:"hash" json:"hash"`
+	SymlinkPath  string   `xml:"symlink_path,omitempty" json:"symlink_path,omitempty"`
 }
 
 // SubdirListingRecord is the struct used for serializing subdirs in json and xml container listings.
@@ -83,17 +93,65 @@ type SubdirListingRecord struct {
 	Name    string   `xml:"name" json:"subdir"`
 }
 
+// ListingName returns the object's name.
+func (r *ObjectListingRecord) ListingName() string {
+	return r.Name
+}
+
+// CSVRow returns the object's fields in the order of common.ObjectListingCSVHeader.
+func (r *ObjectListingRecord) CSVRow() []string {
+	return []string{r.Name, strconv.FormatInt(r.Size, 10), r.ETag, r.ContentType, r.LastModified}
+}
+
+// ListingName returns the subdir's name.
+func (r *SubdirListingRecord) ListingName() string {
+	return r.Name
+}
+
+// CSVRow returns the subdir's name, with the object fields left empty.
+func (r *SubdirListingRecord) CSVRow() []string {
+	return []string{r.Name, "", "", "", ""}
+}
+
 // ObjectRecord represents the object's data in-databaee, it is used by replication.
 type ObjectRecord struct {
-	Rowid              int64   `json:"ROWID"`
-	Name               string  `json:"name"`
-	CreatedAt          string  `json:"created_at"`
-	Size               int64   `json:"size"`
-	ContentType        string  `json:"content_type"`
-	ETag               string  `json:"etag"`
-	Deleted            int     `json:"deleted"`
-	StoragePolicyIndex int     `json:"storage_policy_index"`
-	Expires            *string `json:"expires"`
+	Rowid              int64             `json:"ROWID"`
+	Name               string            `json:"name"`
+	CreatedAt          string            `json:"created_at"`
+	Size               int64             `json:"size"`
+	ContentType        string            `json:"content_type"`
+	ETag               string            `json:"etag"`
+	Deleted            int               `json:"deleted"`
+	StoragePolicyIndex int               `json:"storage_policy_index"`
+	Expires            *string           `json:"expires"`
+	Meta               map[string]string `json:"meta,omitempty"`
+}
+
+// ListingFilter narrows a container listing to the objects matching all of its set fields.
+type ListingFilter struct {
+	// ContentType matches objects with exactly this content type, or any subtype if it ends in "/".
+	ContentType string
+	// ModifiedSince and ModifiedBefore bound the objects' timestamps.
+	ModifiedSince  string
+	ModifiedBefore string
+	// SizeGT and SizeLT bound the objects' sizes.
+	SizeGT *int64
+	SizeLT *int64
+	// Meta matches objects with these values for indexed metadata keys.
+	Meta map[string]string
+}
+
+// ContainerSnapshot is a point-in-time view of a container's listing.
+type ContainerSnapshot struct {
+	Name    string `json:"name"`
+	MaxRow  int64  `json:"max_row"`
+	Expires string `json:"expires"`
+}
+
+// SnapshotChange is an object that was added, modified, or deleted between two snapshots.
+type SnapshotChange struct {
+	ObjectListingRecord
+	Change string `json:"change"`
 }
 
 // SyncRecord represents a row in the incoming_sync table.  It is used by replication.
@@ -155,6 +213,77 @@ type ReplicableContainer interface {
 	Reported(putTimestamp, deleteTimestamp string, objectCount, bytesUsed int64) error
 }
 
+// ShardableContainer is a container that can be split into shard containers.
+type ShardableContainer interface {
+	ReplicableContainer
+	// ShardRanges returns the container's shard ranges, ordered by lower bound.
+	ShardRanges(includeDeleted bool) ([]*common.ShardRange, error)
+	// MergeShardRanges merges shard range records into the container.
+	MergeShardRanges(ranges []*common.ShardRange) error
+	// ShardPoints returns the names to use as upper bounds when splitting the container into shards of rowsPerShard objects.
+	ShardPoints(rowsPerShard int64) ([]string, error)
+	// ItemsInRange returns up to count object records with names greater than marker and no greater than upper.
+	ItemsInRange(marker, upper string, count int) ([]*ObjectRecord, error)
+	// RemoveItems deletes object records that have been moved to a shard container.
+	RemoveItems(records []*ObjectRecord) error
+}
+
+// SyncableContainer is a container whose objects can be copied to a remote container with container sync.
+type SyncableContainer interface {
+	ReplicableContainer
+	// SetSyncPoints records the ROWIDs container sync has gotten through.
+	SetSyncPoints(point1, point2 int64) error
+}
+
+// ReconcilableContainer is a container whose objects may have been written to the wrong storage policy.
+type ReconcilableContainer interface {
+	ReplicableContainer
+	// SetStoragePolicy changes the container's storage policy, as when replicas created with different policies converge.
+	SetStoragePolicy(policyIndex int, statusChangedAt string) error
+	// MisplacedItems returns count object records with a ROWID greater than start and a storage policy other than the container's.
+	MisplacedItems(start int64, count int) ([]*ObjectRecord, error)
+	// SetReconcilerSyncPoint records the ROWID through which misplaced objects have been queued for the reconciler.
+	SetReconcilerSyncPoint(point int64) error
+}
+
+// AuditableContainer is a container whose database can be checked for corruption.
+type AuditableContainer interface {
+	ReplicableContainer
+	// Audit checks the database's integrity and that its hash matches its object records.
+	Audit() error
+}
+
+// IndexedContainer is a container that indexes selected object metadata, so listings can be filtered on it.
+type IndexedContainer interface {
+	Container
+	// PutObjectMeta adds a new object to the container along with its indexed metadata.
+	PutObjectMeta(name string, timestamp string, size int64, contentType string, etag string, storagePolicyIndex int, expires string, meta map[string]string) error
+	// ListObjectsFiltered is ListObjects for only the objects matching filter.
+	ListObjectsFiltered(limit int, marker string, endMarker string, prefix string, delimiter string, path *string, reverse bool, storagePolicyIndex int, filter *ListingFilter) ([]interface{}, error)
+}
+
+// SnapshotContainer is a container that can keep point-in-time views of its listing.
+type SnapshotContainer interface {
+	Container
+	// CreateSnapshot records the container's current listing as the named snapshot, which is kept until expires.
+	CreateSnapshot(name string, expires string) error
+	// DeleteSnapshot removes a snapshot, allowing the rows it pinned to be reclaimed.
+	DeleteSnapshot(name string) error
+	// Snapshots returns the container's unexpired snapshots, oldest first.
+	Snapshots() ([]*ContainerSnapshot, error)
+	// ListObjectsSnapshot is ListObjects as of the named snapshot.
+	ListObjectsSnapshot(snapshot string, limit int, marker string, endMarker string, prefix string, delimiter string, path *string, reverse bool, storagePolicyIndex int) ([]interface{}, error)
+	// DiffSnapshots returns up to limit objects named after marker that changed between the from and to snapshots.
+	DiffSnapshots(from string, to string, limit int, marker string, storagePolicyIndex int) ([]*SnapshotChange, error)
+}
+
+// CompactableContainer is a container whose database can be rewritten to reclaim free space.
+type CompactableContainer interface {
+	ReplicableContainer
+	// Compact rewrites the database if it's at least minSize bytes and at least minFree of it is free, returning its size before and after.
+	Compact(minSize int64, minFree float64) (int64, int64, error)
+}
+
 // ContainerEngine is the interface of an object that creates and returns containers.
 type ContainerEngine interface {
 	// GET returns a container, given a vars mapping.
@@ -176,7 +305,54 @@ type ContainerEngine interface {
 	OpenCount() (count int)
 }
 
-// My attempts at making this lruEngine reusable have not been successful, so for now it's sqlite-specific and not exported.
+// ImportingEngine is a ContainerEngine that doesn't keep its containers in database files, so databases replicated
+// in whole from other servers have to be loaded into it.
+type ImportingEngine interface {
+	ContainerEngine
+	// Import replaces the container with the given hash with a copy of db.
+	Import(device, hash, partition string, db ReplicableContainer) error
+}
+
+// ContainerEngineConstructor is a function that, given the server's config, device root, and hash path prefix and
+// suffix, returns a ContainerEngine.
+type ContainerEngineConstructor func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (ContainerEngine, error)
+
+type containerEngineFactoryEntry struct {
+	name        string
+	constructor ContainerEngineConstructor
+}
+
+var containerEngineFactories = []containerEngineFactoryEntry{}
+
+// RegisterContainerEngine lets you tell hummingbird about a new container engine.
+func RegisterContainerEngine(name string, newEngine ContainerEngineConstructor) {
+	for i, e := range containerEngineFactories {
+		if e.name == name {
+			containerEngineFactories[i].constructor = newEngine
+			return
+		}
+	}
+	containerEngineFactories = append(containerEngineFactories, containerEngineFactoryEntry{name, newEngine})
+}
+
+// FindContainerEngine returns the registered container engine with the given name.
+func FindContainerEngine(name string) (ContainerEngineConstructor, error) {
+	for _, e := range containerEngineFactories {
+		if e.name == name {
+			return e.constructor, nil
+		}
+	}
+	return nil, errors.New("Not found")
+}
+
+func init() {
+	RegisterContainerEngine("sqlite", func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (ContainerEngine, error) {
+		return newLRUEngine(deviceRoot, hashPathPrefix, hashPathSuffix, 32), nil
+	})
+}
+
+// lruEngine is the "sqlite" container engine.  It keeps the most recently used container databases open, and isn't
+// reusable by other backends since it opens the databases itself.
 type lruEngine struct {
 	deviceRoot     string
 	hashPathPrefix string
@@ -193,6 +193,15 @@ func (server *ContainerServer) replicateRsyncThenMerge(request *http.Request, va
 			zap.String("containerFile", containerFile), zap.Error(err))
 		return http.StatusInternalServerError
 	}
+	if ie, ok := server.containerEngine.(ImportingEngine); ok {
+		if err := importDatabase(ie, vars, tmpDb, tmpContainerFile); err != nil {
+			srv.GetLogger(request).Error("Error importing new container db",
+				zap.String("tmpContainerFile", tmpContainerFile), zap.Error(err))
+			return http.StatusInternalServerError
+		}
+		server.containerEngine.Invalidate(localDb)
+		return http.StatusNoContent
+	}
 	if err := os.MkdirAll(filepath.Dir(containerFile), 0777); err != nil {
 		srv.GetLogger(request).Error("Error blessing new container db",
 			zap.String("containerFile", containerFile), zap.Error(err))
@@ -207,10 +216,26 @@ func (server *ContainerServer) replicateRsyncThenMerge(request *http.Request, va
 	return http.StatusNoContent
 }
 
+// importDatabase loads a replicated database into an engine that doesn't keep its containers in database files, then
+// removes the database.
+func importDatabase(ie ImportingEngine, vars map[string]string, tmpDb ReplicableContainer, tmpContainerFile string) error {
+	err := ie.Import(vars["device"], vars["hash"], vars["partition"], tmpDb)
+	tmpDb.Close()
+	os.Remove(tmpContainerFile)
+	return err
+}
+
 func (server *ContainerServer) replicateCompleteRsync(request *http.Request, vars map[string]string, tmpFileName string) int {
 	containerFile := filepath.Join(server.driveRoot, vars["device"], "containers", vars["partition"], vars["hash"][29:32], vars["hash"], vars["hash"]+".db")
 	tmpContainerFile := filepath.Join(server.driveRoot, vars["device"], "tmp", tmpFileName)
-	if !fs.Exists(tmpContainerFile) || fs.Exists(containerFile) {
+	ie, importing := server.containerEngine.(ImportingEngine)
+	if importing {
+		if db, err := ie.GetByHash(vars["device"], vars["hash"], vars["partition"]); err == nil {
+			ie.Return(db)
+			return http.StatusNotFound
+		}
+	}
+	if !fs.Exists(tmpContainerFile) || (!importing && fs.Exists(containerFile)) {
 		return http.StatusNotFound
 	}
 	tmpDb, err := sqliteOpenContainer(tmpContainerFile)
@@ -223,6 +248,14 @@ func (server *ContainerServer) replicateCompleteRsync(request *http.Request, var
 			zap.String("containerFile", containerFile), zap.Error(err))
 		return http.StatusInternalServerError
 	}
+	if importing {
+		if err := importDatabase(ie, vars, tmpDb, tmpContainerFile); err != nil {
+			srv.GetLogger(request).Error("Error importing new container db",
+				zap.String("tmpContainerFile", tmpContainerFile), zap.Error(err))
+			return http.StatusInternalServerError
+		}
+		return http.StatusNoContent
+	}
 	if err := os.MkdirAll(filepath.Dir(containerFile), 0777); err != nil {
 		srv.GetLogger(request).Error("Error blessing new container db",
 			zap.String("containerFile", containerFile), zap.Error(err))
@@ -0,0 +1,115 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+)
+
+// indexedMetadataKey is the container metadata listing the object metadata keys to index, separated by commas.
+const indexedMetadataKey = "X-Container-Indexed-Metadata"
+
+// indexedKeys returns the set of object metadata keys the container indexes, lowercased.
+func indexedKeys(metadata map[string]string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(metadata[indexedMetadataKey], ",") {
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// indexedMeta picks the values of the indexed keys out of an object's X-Object-Meta- headers.
+func indexedMeta(keys map[string]bool, headers http.Header) map[string]string {
+	var meta map[string]string
+	for header := range headers {
+		if !strings.HasPrefix(header, "X-Object-Meta-") {
+			continue
+		}
+		if key := strings.ToLower(strings.TrimPrefix(header, "X-Object-Meta-")); keys[key] {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[key] = headers.Get(header)
+		}
+	}
+	return meta
+}
+
+// listingTimestamp parses the time given to a modified_since or modified_before filter.
+func listingTimestamp(value string) (string, error) {
+	t, err := common.ParseDate(value)
+	if err != nil {
+		return "", err
+	}
+	return common.CanonicalTimestampFromTime(t), nil
+}
+
+// parseListingFilter builds the filter for a container listing from its query parameters, returning nil if the
+// listing isn't filtered.
+func parseListingFilter(form url.Values, keys map[string]bool) (*ListingFilter, error) {
+	filter := &ListingFilter{}
+	filtered := false
+	for param, values := range form {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[0]
+		var err error
+		switch {
+		case param == "content_type":
+			filter.ContentType = value
+		case param == "modified_since":
+			filter.ModifiedSince, err = listingTimestamp(value)
+		case param == "modified_before":
+			filter.ModifiedBefore, err = listingTimestamp(value)
+		case param == "size_gt":
+			var size int64
+			size, err = strconv.ParseInt(value, 10, 64)
+			filter.SizeGT = &size
+		case param == "size_lt":
+			var size int64
+			size, err = strconv.ParseInt(value, 10, 64)
+			filter.SizeLT = &size
+		case strings.HasPrefix(param, "meta."):
+			key := strings.ToLower(strings.TrimPrefix(param, "meta."))
+			if !keys[key] {
+				return nil, fmt.Errorf("Object metadata %q is not indexed", key)
+			}
+			if filter.Meta == nil {
+				filter.Meta = make(map[string]string)
+			}
+			filter.Meta[key] = value
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %q", param, value)
+		}
+		filtered = true
+	}
+	if !filtered {
+		return nil, nil
+	}
+	return filter, nil
+}
@@ -0,0 +1,638 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Container inventory writes a daily or weekly report of every object in a
+// container, as csv or ndjson, into a destination container in the same
+// account.  Containers are configured with the X-Container-Inventory-*
+// headers, which s3api also sets through the bucket ?inventory subresource.
+//
+// The first primary replica of a container writes its reports, building them
+// from proxy listings so sharded containers are reported whole.  Each report
+// is named for the day its period started, so a report that already exists
+// means the container is done until the next period.  Reports bigger than
+// segment_size are uploaded in segments with a static large object manifest.
+
+package containerserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+// The container headers that configure inventory reports.
+const (
+	// InventoryDestinationHeader is the container, optionally followed by a /prefix, reports are written to.
+	InventoryDestinationHeader = "X-Container-Inventory-Destination"
+	// InventoryFormatHeader is the report format, csv or ndjson.
+	InventoryFormatHeader = "X-Container-Inventory-Format"
+	// InventoryFrequencyHeader is how often reports are written, daily or weekly.
+	InventoryFrequencyHeader = "X-Container-Inventory-Frequency"
+	// InventoryFieldsHeader is a comma separated list of the fields reported after each object's name.
+	InventoryFieldsHeader = "X-Container-Inventory-Fields"
+	// InventoryPrefixHeader limits reports to the objects whose names start with it.
+	InventoryPrefixHeader = "X-Container-Inventory-Prefix"
+)
+
+// InventoryFields are the fields inventory reports can include after the object name.
+var InventoryFields = []string{"bytes", "hash", "content_type", "last_modified", "storage_policy", "metadata"}
+
+var defaultInventoryFields = []string{"bytes", "hash", "last_modified", "storage_policy"}
+
+// inventoryConfig is a container's inventory settings.
+type inventoryConfig struct {
+	destination string
+	prefix      string
+	format      string
+	frequency   string
+	fields      []string
+	filter      string
+}
+
+func parseInventoryFields(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return defaultInventoryFields, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		known := false
+		for _, f := range InventoryFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown inventory field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// parseInventoryConfig returns the inventory settings in a container's metadata, or nil if it has none.
+func parseInventoryConfig(metadata map[string]string) (*inventoryConfig, error) {
+	destination := strings.Trim(metadata[InventoryDestinationHeader], "/")
+	if destination == "" {
+		return nil, nil
+	}
+	cfg := &inventoryConfig{destination: destination, filter: metadata[InventoryPrefixHeader]}
+	if parts := strings.SplitN(destination, "/", 2); len(parts) == 2 {
+		cfg.destination, cfg.prefix = parts[0], strings.TrimSuffix(parts[1], "/")+"/"
+	}
+	switch cfg.format = strings.ToLower(metadata[InventoryFormatHeader]); cfg.format {
+	case "":
+		cfg.format = common.ListingFormatCSV
+	case common.ListingFormatCSV, common.ListingFormatNDJSON:
+	default:
+		return nil, fmt.Errorf("Unknown inventory format %q", cfg.format)
+	}
+	switch cfg.frequency = strings.ToLower(metadata[InventoryFrequencyHeader]); cfg.frequency {
+	case "":
+		cfg.frequency = "daily"
+	case "daily", "weekly":
+	default:
+		return nil, fmt.Errorf("Unknown inventory frequency %q", cfg.frequency)
+	}
+	var err error
+	if cfg.fields, err = parseInventoryFields(metadata[InventoryFieldsHeader]); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validateInventoryHeaders returns an error if a request sets any inventory header to something invalid.
+func validateInventoryHeaders(header http.Header) error {
+	metadata := map[string]string{InventoryDestinationHeader: "c"}
+	for _, key := range []string{InventoryFormatHeader, InventoryFrequencyHeader, InventoryFieldsHeader} {
+		metadata[key] = header.Get(key)
+	}
+	_, err := parseInventoryConfig(metadata)
+	return err
+}
+
+// periodStart returns the first day of the reporting period containing now.  Weekly periods start on Sundays.
+func (cfg *inventoryConfig) periodStart(now time.Time) string {
+	now = now.UTC()
+	if cfg.frequency == "weekly" {
+		now = now.AddDate(0, 0, -int(now.Weekday()))
+	}
+	return now.Format("2006-01-02")
+}
+
+// reportPrefix is the start of the names of every report for the container.
+func (cfg *inventoryConfig) reportPrefix(container string) string {
+	return cfg.prefix + container + "/"
+}
+
+// reportName is the name of the container's report for the period starting on date.
+func (cfg *inventoryConfig) reportName(container, date string) string {
+	return cfg.reportPrefix(container) + date + "/inventory." + cfg.format
+}
+
+// header returns the report's csv header row.
+func (cfg *inventoryConfig) header() []string {
+	return append([]string{"name"}, cfg.fields...)
+}
+
+// Inventory is the container inventory daemon object.
+type Inventory struct {
+	checkMounts   bool
+	deviceRoot    string
+	serverPort    int
+	Ring          ring.Ring
+	policies      conf.PolicyList
+	local         client.RequestClient
+	logger        srv.LowLevelLogger
+	logLevel      zap.AtomicLevel
+	interval      time.Duration
+	segmentSize   int
+	now           func() time.Time
+	metricsCloser io.Closer
+	reports       tally.Counter
+	segments      tally.Counter
+	objects       tally.Counter
+	failures      tally.Counter
+}
+
+func (inv *Inventory) setMetricsScope(scope tally.Scope) {
+	inv.reports = scope.Counter("container_inventory_reports")
+	inv.segments = scope.Counter("container_inventory_segments")
+	inv.objects = scope.Counter("container_inventory_objects")
+	inv.failures = scope.Counter("container_inventory_failures")
+}
+
+// inventoryReport builds one report, uploading it a segment at a time once it outgrows the segment size.
+type inventoryReport struct {
+	inv       *Inventory
+	cfg       *inventoryConfig
+	account   string
+	name      string
+	timestamp string
+	buf       bytes.Buffer
+	csv       *csv.Writer
+	segments  []inventorySegment
+	bytes     int64
+}
+
+// inventorySegment is an entry in a segmented report's static large object manifest, as stored on the object servers.
+type inventorySegment struct {
+	Name         string `json:"name"`
+	Hash         string `json:"hash"`
+	Bytes        int64  `json:"bytes"`
+	ContentType  string `json:"content_type"`
+	LastModified string `json:"last_modified"`
+}
+
+func (r *inventoryReport) contentType() string {
+	return common.ListingContentType(r.cfg.format)
+}
+
+// put uploads data as an object in the destination container, returning its etag.
+func (r *inventoryReport) put(obj string, data []byte, headers http.Header) (string, error) {
+	etag := fmt.Sprintf("%x", md5.Sum(data))
+	headers.Set("Content-Length", strconv.Itoa(len(data)))
+	headers.Set("Etag", etag)
+	headers.Set("X-Timestamp", r.timestamp)
+	if headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", r.contentType())
+	}
+	resp := r.inv.local.PutObject(context.Background(), r.account, r.cfg.destination, obj, headers, bytes.NewReader(data))
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("PUT of %s/%s/%s returned %d", r.account, r.cfg.destination, obj, resp.StatusCode)
+	}
+	return etag, nil
+}
+
+// flushSegment uploads what's been buffered as the report's next segment.
+func (r *inventoryReport) flushSegment() error {
+	obj := fmt.Sprintf("%s.segments/%06d", r.name, len(r.segments)+1)
+	etag, err := r.put(obj, r.buf.Bytes(), http.Header{})
+	if err != nil {
+		return err
+	}
+	r.segments = append(r.segments, inventorySegment{
+		Name:         "/" + r.cfg.destination + "/" + obj,
+		Hash:         etag,
+		Bytes:        int64(r.buf.Len()),
+		ContentType:  r.contentType(),
+		LastModified: time.Now().UTC().Format("2006-01-02T15:04:05.00000"),
+	})
+	r.bytes += int64(r.buf.Len())
+	r.buf.Reset()
+	r.inv.segments.Inc(1)
+	return nil
+}
+
+// add writes a row to the report, uploading a segment if the buffer has grown big enough.
+func (r *inventoryReport) add(row map[string]interface{}) error {
+	if r.cfg.format == common.ListingFormatNDJSON {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		r.buf.Write(append(line, '\n'))
+	} else {
+		fields := make([]string, 0, len(r.cfg.fields)+1)
+		for _, field := range r.cfg.header() {
+			switch v := row[field].(type) {
+			case string:
+				fields = append(fields, v)
+			case int64:
+				fields = append(fields, strconv.FormatInt(v, 10))
+			default:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, string(b))
+			}
+		}
+		if err := r.csv.Write(fields); err != nil {
+			return err
+		}
+		r.csv.Flush()
+	}
+	if r.buf.Len() >= r.inv.segmentSize {
+		return r.flushSegment()
+	}
+	return nil
+}
+
+// finish uploads the report, as a single object if it never needed segmenting or as a manifest of its segments.
+func (r *inventoryReport) finish() error {
+	if len(r.segments) == 0 {
+		_, err := r.put(r.name, r.buf.Bytes(), http.Header{})
+		return err
+	}
+	if r.buf.Len() > 0 {
+		if err := r.flushSegment(); err != nil {
+			return err
+		}
+	}
+	manifest, err := json.Marshal(r.segments)
+	if err != nil {
+		return err
+	}
+	sloEtag := md5.New()
+	for _, segment := range r.segments {
+		io.WriteString(sloEtag, segment.Hash)
+	}
+	_, err = r.put(r.name, manifest, http.Header{
+		"Content-Type":              {fmt.Sprintf("%s;swift_bytes=%d", r.contentType(), r.bytes)},
+		"X-Static-Large-Object":     {"True"},
+		"X-Object-Sysmeta-Slo-Etag": {fmt.Sprintf("%x", sloEtag.Sum(nil))},
+		"X-Object-Sysmeta-Slo-Size": {strconv.FormatInt(r.bytes, 10)},
+	})
+	return err
+}
+
+// objectMetadata returns an object's user metadata, without the X-Object-Meta- prefixes.
+func (inv *Inventory) objectMetadata(account, container, obj string) (map[string]string, error) {
+	resp := inv.local.HeadObject(context.Background(), account, container, obj, http.Header{})
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("HEAD of %s/%s/%s returned %d", account, container, obj, resp.StatusCode)
+	}
+	metadata := map[string]string{}
+	for key := range resp.Header {
+		if strings.HasPrefix(key, "X-Object-Meta-") {
+			metadata[key[len("X-Object-Meta-"):]] = resp.Header.Get(key)
+		}
+	}
+	return metadata, nil
+}
+
+// writeReport writes the container's report for the current period, unless it's already been written.
+func (inv *Inventory) writeReport(info *ContainerInfo, cfg *inventoryConfig) error {
+	ctx := context.Background()
+	name := cfg.reportName(info.Container, cfg.periodStart(inv.now()))
+	resp := inv.local.HeadObject(ctx, info.Account, cfg.destination, name, http.Header{})
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	} else if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("HEAD of report %s/%s/%s returned %d", info.Account, cfg.destination, name, resp.StatusCode)
+	}
+	resp = inv.local.HeadContainer(ctx, info.Account, cfg.destination, http.Header{})
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Inventory destination %s/%s returned %d", info.Account, cfg.destination, resp.StatusCode)
+	}
+	policyName := ""
+	if policy := inv.policies[info.StoragePolicyIndex]; policy != nil {
+		policyName = policy.Name
+	}
+	report := &inventoryReport{
+		inv:       inv,
+		cfg:       cfg,
+		account:   info.Account,
+		name:      name,
+		timestamp: common.GetTimestamp(),
+	}
+	if cfg.format == common.ListingFormatCSV {
+		report.csv = csv.NewWriter(&report.buf)
+		report.csv.Write(cfg.header())
+		report.csv.Flush()
+	}
+	options := map[string]string{"format": "json", "limit": "10000", "prefix": cfg.filter}
+	for {
+		resp := inv.local.GetContainerRaw(ctx, info.Account, info.Container, options, http.Header{})
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		} else if resp.StatusCode == http.StatusNoContent {
+			break
+		} else if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("Listing of %s/%s returned %d", info.Account, info.Container, resp.StatusCode)
+		}
+		var records []ObjectListingRecord
+		if err := json.Unmarshal(body, &records); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+	records:
+		for _, record := range records {
+			// Reports written to the container they're reporting on leave themselves out.
+			if cfg.destination == info.Container && strings.HasPrefix(record.Name, cfg.reportPrefix(info.Container)) {
+				continue
+			}
+			row := map[string]interface{}{"name": record.Name}
+			for _, field := range cfg.fields {
+				switch field {
+				case "bytes":
+					row[field] = record.Size
+				case "hash":
+					row[field] = record.ETag
+				case "content_type":
+					row[field] = record.ContentType
+				case "last_modified":
+					row[field] = record.LastModified
+				case "storage_policy":
+					row[field] = policyName
+				case "metadata":
+					metadata, err := inv.objectMetadata(info.Account, info.Container, record.Name)
+					if err != nil {
+						return err
+					} else if metadata == nil {
+						// Deleted since it was listed.
+						continue records
+					}
+					row[field] = metadata
+				}
+			}
+			if err := report.add(row); err != nil {
+				return err
+			}
+			inv.objects.Inc(1)
+		}
+		options["marker"] = records[len(records)-1].Name
+	}
+	if err := report.finish(); err != nil {
+		return err
+	}
+	inv.reports.Inc(1)
+	inv.logger.Debug("Wrote inventory report.", zap.String("account", info.Account), zap.String("container", info.Container),
+		zap.String("report", cfg.destination+"/"+name))
+	return nil
+}
+
+// inventoryDatabase writes the report for a single container database, if it's configured for reports and this is its
+// first primary.
+func (inv *Inventory) inventoryDatabase(dev *ring.Device, dbFile string) error {
+	parts := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(dbFile))))
+	part, err := strconv.ParseUint(parts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Bad partition: %s", parts)
+	}
+	if nodes := inv.Ring.GetNodes(part); len(nodes) == 0 || nodes[0].Id != dev.Id {
+		return nil
+	}
+	c, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if deleted, err := c.IsDeleted(); err != nil || deleted {
+		return err
+	}
+	metadata, err := c.GetMetadata()
+	if err != nil {
+		return err
+	}
+	cfg, err := parseInventoryConfig(metadata)
+	if err != nil || cfg == nil {
+		return err
+	}
+	info, err := c.GetInfo()
+	if err != nil {
+		return err
+	}
+	return inv.writeReport(info, cfg)
+}
+
+func (inv *Inventory) inventoryDevice(dev *ring.Device) {
+	defer srv.LogPanics(inv.logger, "PANIC WHILE WRITING INVENTORY REPORTS")
+	devicePath := filepath.Join(inv.deviceRoot, dev.Device)
+	if stat, err := os.Stat(devicePath); err != nil || !stat.IsDir() {
+		inv.logger.Error("Device doesn't exist.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	if mount, err := fs.IsMount(devicePath); inv.checkMounts && (err != nil || !mount) {
+		inv.logger.Error("Device not mounted.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	results := make(chan string, 100)
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go findContainerDbs(devicePath, results, cancel, inv.logger)
+	for dbFile := range results {
+		if err := inv.inventoryDatabase(dev, dbFile); err != nil {
+			inv.failures.Inc(1)
+			inv.logger.Error("Error writing inventory report.", zap.String("dbFile", dbFile), zap.Error(err))
+		}
+	}
+}
+
+// Run runs a pass of container inventory once.
+func (inv *Inventory) Run() {
+	devices, err := inv.Ring.LocalDevices(inv.serverPort)
+	if err != nil {
+		inv.logger.Error("Error getting local devices from ring.", zap.Error(err))
+		return
+	}
+	start := time.Now()
+	for _, dev := range devices {
+		inv.inventoryDevice(dev)
+	}
+	inv.logger.Info("Container inventory pass complete.", zap.Float64("seconds", time.Since(start).Seconds()))
+}
+
+// RunForever runs container inventory in a forever-loop.
+func (inv *Inventory) RunForever() {
+	for {
+		start := time.Now()
+		inv.Run()
+		if elapsed := time.Since(start); elapsed < inv.interval {
+			time.Sleep(inv.interval - elapsed)
+		}
+	}
+}
+
+func (inv *Inventory) Type() string {
+	return "container-inventory"
+}
+
+func (inv *Inventory) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			inv.Run()
+		}()
+		return ch
+	}
+	go inv.RunForever()
+	return nil
+}
+
+func (inv *Inventory) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, inv.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	inv.setMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		inv.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", inv.logLevel)
+	router.Put("/loglevel", inv.logLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(inv.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (inv *Inventory) Finalize() {
+	if inv.metricsCloser != nil {
+		inv.metricsCloser.Close()
+	}
+}
+
+func (inv *Inventory) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (inv *Inventory) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(inv.logger, next)
+}
+
+// NewInventory uses the config settings and command-line flags to configure and return a container inventory daemon struct.
+func NewInventory(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	var ipPort *srv.IpPort
+	var err error
+	var logger srv.LowLevelLogger
+	if !serverconf.HasSection("container-inventory") {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container-inventory config section")
+	}
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	ring, err := cnf.GetRing("container", hashPathPrefix, hashPathSuffix, 0)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading container ring: %s", err)
+	}
+	policies, err := cnf.GetPolicies()
+	if err != nil {
+		return ipPort, nil, nil, err
+	}
+	logLevelString := serverconf.GetDefault("container-inventory", "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	if logger, err = srv.SetupLogger("container-inventory", &logLevel, flags); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	certFile := serverconf.GetDefault("container-inventory", "cert_file", "")
+	keyFile := serverconf.GetDefault("container-inventory", "key_file", "")
+	pdc, err := client.NewProxyClient(policies, cnf, logger, certFile, keyFile, "", "", "", serverconf)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Could not make client: %v", err)
+	}
+	ip := serverconf.GetDefault("container-inventory", "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt("container-inventory", "bind_port", common.DefaultContainerInventoryPort))
+	inv := &Inventory{
+		checkMounts: serverconf.GetBool("container-inventory", "mount_check", true),
+		deviceRoot:  serverconf.GetDefault("container-inventory", "devices", "/srv/node"),
+		serverPort:  int(serverconf.GetInt("container-replicator", "bind_port", common.DefaultContainerReplicatorPort)),
+		Ring:        ring,
+		policies:    policies,
+		local:       pdc.NewRequestClient(nil, nil, logger),
+		logger:      logger,
+		logLevel:    logLevel,
+		interval:    time.Duration(serverconf.GetFloat("container-inventory", "interval", 3600) * float64(time.Second)),
+		segmentSize: int(serverconf.GetInt("container-inventory", "segment_size", 100*1024*1024)),
+		now:         time.Now,
+	}
+	inv.local.SetUserAgent(fmt.Sprintf("container-inventory %d", os.Getpid()))
+	inv.setMetricsScope(tally.NoopScope)
+	ipPort = &srv.IpPort{Ip: ip, Port: port}
+	return ipPort, inv, logger, nil
+}
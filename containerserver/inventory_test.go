@@ -0,0 +1,184 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type inventoryLocalClient struct {
+	client.RequestClient
+	objects []ObjectListingRecord
+	puts    map[string]string
+	headers map[string]http.Header
+}
+
+func inventoryResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func (c *inventoryLocalClient) HeadContainer(ctx context.Context, account, container string, headers http.Header) *http.Response {
+	return inventoryResponse(http.StatusNoContent, "")
+}
+
+func (c *inventoryLocalClient) HeadObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
+	if _, ok := c.puts[container+"/"+obj]; ok {
+		return inventoryResponse(http.StatusOK, "")
+	} else if strings.Contains(obj, "/inventory.") {
+		return inventoryResponse(http.StatusNotFound, "")
+	}
+	resp := inventoryResponse(http.StatusOK, "")
+	resp.Header.Set("X-Object-Meta-Color", "blue")
+	return resp
+}
+
+func (c *inventoryLocalClient) GetContainerRaw(ctx context.Context, account, container string, options map[string]string, headers http.Header) *http.Response {
+	var page []ObjectListingRecord
+	for _, obj := range c.objects {
+		if obj.Name > options["marker"] && strings.HasPrefix(obj.Name, options["prefix"]) && len(page) < 2 {
+			page = append(page, obj)
+		}
+	}
+	body, _ := json.Marshal(page)
+	return inventoryResponse(http.StatusOK, string(body))
+}
+
+func (c *inventoryLocalClient) PutObject(ctx context.Context, account, container, obj string, headers http.Header, src io.Reader) *http.Response {
+	body, _ := ioutil.ReadAll(src)
+	c.puts[container+"/"+obj] = string(body)
+	c.headers[container+"/"+obj] = headers
+	return inventoryResponse(http.StatusCreated, "")
+}
+
+func newTestInventory(local client.RequestClient, segmentSize int) *Inventory {
+	inv := &Inventory{
+		local:       local,
+		logger:      zap.NewNop(),
+		segmentSize: segmentSize,
+		policies:    conf.PolicyList{0: {Index: 0, Name: "gold"}},
+		now:         func() time.Time { return time.Date(2018, 7, 12, 10, 0, 0, 0, time.UTC) },
+	}
+	inv.setMetricsScope(tally.NoopScope)
+	return inv
+}
+
+func TestParseInventoryConfig(t *testing.T) {
+	cfg, err := parseInventoryConfig(map[string]string{})
+	require.Nil(t, err)
+	require.Nil(t, cfg)
+
+	cfg, err = parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports/daily/"})
+	require.Nil(t, err)
+	require.Equal(t, "reports", cfg.destination)
+	require.Equal(t, "daily/", cfg.prefix)
+	require.Equal(t, "csv", cfg.format)
+	require.Equal(t, "daily", cfg.frequency)
+	require.Equal(t, []string{"bytes", "hash", "last_modified", "storage_policy"}, cfg.fields)
+	require.Equal(t, "daily/c/2018-07-12/inventory.csv", cfg.reportName("c", cfg.periodStart(time.Date(2018, 7, 12, 23, 0, 0, 0, time.UTC))))
+
+	cfg, err = parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports", InventoryFrequencyHeader: "Weekly",
+		InventoryFormatHeader: "ndjson", InventoryFieldsHeader: "bytes, metadata"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"bytes", "metadata"}, cfg.fields)
+	// 2018-07-12 was a Thursday.
+	require.Equal(t, "c/2018-07-08/inventory.ndjson", cfg.reportName("c", cfg.periodStart(time.Date(2018, 7, 12, 10, 0, 0, 0, time.UTC))))
+
+	_, err = parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports", InventoryFormatHeader: "orc"})
+	require.NotNil(t, err)
+	_, err = parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports", InventoryFrequencyHeader: "hourly"})
+	require.NotNil(t, err)
+	require.NotNil(t, validateInventoryHeaders(http.Header{InventoryFieldsHeader: {"bytes,owner"}}))
+	require.Nil(t, validateInventoryHeaders(http.Header{}))
+}
+
+func TestInventoryReport(t *testing.T) {
+	local := &inventoryLocalClient{
+		objects: []ObjectListingRecord{
+			{Name: "a", Size: 1, ETag: "etaga", LastModified: "2018-07-11T00:00:00.000000"},
+			{Name: "b,c", Size: 2, ETag: "etagb", LastModified: "2018-07-11T00:00:00.000000"},
+			{Name: "d", Size: 3, ETag: "etagd", LastModified: "2018-07-11T00:00:00.000000"},
+		},
+		puts:    map[string]string{},
+		headers: map[string]http.Header{},
+	}
+	inv := newTestInventory(local, 1024)
+	cfg, err := parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports"})
+	require.Nil(t, err)
+	info := &ContainerInfo{Account: "a", Container: "c"}
+	require.Nil(t, inv.writeReport(info, cfg))
+	require.Equal(t, 1, len(local.puts))
+	require.Equal(t, "name,bytes,hash,last_modified,storage_policy\n"+
+		"a,1,etaga,2018-07-11T00:00:00.000000,gold\n"+
+		"\"b,c\",2,etagb,2018-07-11T00:00:00.000000,gold\n"+
+		"d,3,etagd,2018-07-11T00:00:00.000000,gold\n", local.puts["reports/c/2018-07-12/inventory.csv"])
+
+	// The report already exists, so there's nothing more to do this period.
+	require.Nil(t, inv.writeReport(info, cfg))
+	require.Equal(t, 1, len(local.puts))
+}
+
+func TestInventoryReportSegments(t *testing.T) {
+	local := &inventoryLocalClient{puts: map[string]string{}, headers: map[string]http.Header{}}
+	for i := 0; i < 5; i++ {
+		local.objects = append(local.objects, ObjectListingRecord{Name: fmt.Sprintf("o%d", i), Size: int64(i)})
+	}
+	inv := newTestInventory(local, 100)
+	cfg, err := parseInventoryConfig(map[string]string{InventoryDestinationHeader: "reports/inv", InventoryFormatHeader: "ndjson",
+		InventoryFieldsHeader: "bytes,metadata"})
+	require.Nil(t, err)
+	require.Nil(t, inv.writeReport(&ContainerInfo{Account: "a", Container: "c"}, cfg))
+	name := "reports/inv/c/2018-07-12/inventory.ndjson"
+	require.Equal(t, "True", local.headers[name].Get("X-Static-Large-Object"))
+	var segments []inventorySegment
+	require.Nil(t, json.Unmarshal([]byte(local.puts[name]), &segments))
+	require.Equal(t, 3, len(segments))
+	var whole string
+	for i, segment := range segments {
+		require.Equal(t, fmt.Sprintf("/%s.segments/%06d", name, i+1), segment.Name)
+		whole += local.puts[segment.Name[1:]]
+	}
+	lines := strings.Split(strings.TrimSuffix(whole, "\n"), "\n")
+	require.Equal(t, 5, len(lines))
+	require.Equal(t, `{"bytes":0,"metadata":{"Color":"blue"},"name":"o0"}`, lines[0])
+	require.Equal(t, fmt.Sprintf("%d", len(whole)), local.headers[name].Get("X-Object-Sysmeta-Slo-Size"))
+}
+
+func TestInventoryReportSkipsItself(t *testing.T) {
+	local := &inventoryLocalClient{
+		objects: []ObjectListingRecord{{Name: "a"}, {Name: "inv/c/2018-07-11/inventory.csv"}, {Name: "z"}},
+		puts:    map[string]string{},
+		headers: map[string]http.Header{},
+	}
+	inv := newTestInventory(local, 1024)
+	cfg, err := parseInventoryConfig(map[string]string{InventoryDestinationHeader: "c/inv", InventoryFieldsHeader: "bytes"})
+	require.Nil(t, err)
+	require.Nil(t, inv.writeReport(&ContainerInfo{Account: "a", Container: "c"}, cfg))
+	require.Equal(t, "name,bytes\na,0\nz,0\n", local.puts["c/inv/c/2018-07-12/inventory.csv"])
+}
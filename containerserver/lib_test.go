@@ -73,16 +73,17 @@ func makeTestServer() (http.Handler, func(), error) {
 		return nil, nil, err
 	}
 	server := &ContainerServer{
-		driveRoot:        dir,
-		hashPathPrefix:   "changeme",
-		hashPathSuffix:   "changeme",
-		logLevel:         zap.NewAtomicLevelAt(zap.InfoLevel),
-		logger:           zap.NewNop(),
-		checkMounts:      false,
-		updateClient:     http.DefaultClient,
-		containerEngine:  newLRUEngine(dir, "changeme", "changeme", 32),
-		diskInUse:        common.NewKeyedLimit(2, 2),
-		autoCreatePrefix: ".",
+		driveRoot:           dir,
+		hashPathPrefix:      "changeme",
+		hashPathSuffix:      "changeme",
+		logLevel:            zap.NewAtomicLevelAt(zap.InfoLevel),
+		logger:              zap.NewNop(),
+		checkMounts:         false,
+		updateClient:        http.DefaultClient,
+		containerEngine:     newLRUEngine(dir, "changeme", "changeme", 32),
+		diskInUse:           common.NewKeyedLimit(2, 2),
+		autoCreatePrefix:    ".",
+		snapshotExpireAfter: 3600,
 	}
 	cleanup := func() {
 		os.RemoveAll(dir)
@@ -0,0 +1,906 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+)
+
+func init() {
+	RegisterContainerEngine("memory", func(serverconf conf.Config, deviceRoot, hashPathPrefix, hashPathSuffix string) (ContainerEngine, error) {
+		return newMemoryEngine(hashPathPrefix, hashPathSuffix), nil
+	})
+}
+
+// memoryEngine is the "memory" container engine, which keeps its containers in memory instead of in sqlite databases.
+// It's meant for tests, which would otherwise spend most of their time waiting on fsyncs.  Nothing it holds survives
+// a restart, and the daemons that walk the database files on disk can't see its containers.
+type memoryEngine struct {
+	hashPathPrefix string
+	hashPathSuffix string
+	containers     map[string]*memoryContainer
+	open           int
+	m              sync.Mutex
+}
+
+var _ ImportingEngine = &memoryEngine{}
+
+func newMemoryEngine(hashPathPrefix, hashPathSuffix string) *memoryEngine {
+	return &memoryEngine{
+		hashPathPrefix: hashPathPrefix,
+		hashPathSuffix: hashPathSuffix,
+		containers:     make(map[string]*memoryContainer),
+	}
+}
+
+func (e *memoryEngine) ringHash(vars map[string]string) string {
+	h := md5.New()
+	fmt.Fprintf(h, "%s/%s/%s%s", e.hashPathPrefix, vars["account"], vars["container"], e.hashPathSuffix)
+	return fmt.Sprintf("%032x", h.Sum(nil))
+}
+
+func (e *memoryEngine) get(device, hash string) (*memoryContainer, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	c := e.containers[device+"/"+hash]
+	if c == nil {
+		return nil, ErrorNoSuchContainer
+	}
+	e.open++
+	return c, nil
+}
+
+// Get returns a container given the incoming vars.
+func (e *memoryEngine) Get(vars map[string]string) (Container, error) {
+	return e.get(vars["device"], e.ringHash(vars))
+}
+
+// Create creates a new container, or updates the existing one the way a PUT would.
+func (e *memoryEngine) Create(vars map[string]string, putTimestamp string, metadata map[string][]string, policyIndex, defaultPolicyIndex int) (bool, Container, error) {
+	hash := e.ringHash(vars)
+	key := vars["device"] + "/" + hash
+	e.m.Lock()
+	if c := e.containers[key]; c != nil {
+		e.open++
+		e.m.Unlock()
+		created, err := c.createExisting(putTimestamp, metadata, policyIndex, defaultPolicyIndex)
+		if err != nil {
+			e.Return(c)
+			return created, nil, err
+		}
+		return created, c, nil
+	}
+	defer e.m.Unlock()
+	if metadata == nil {
+		metadata = map[string][]string{}
+	}
+	serializedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return true, nil, err
+	}
+	if policyIndex < 0 {
+		policyIndex = defaultPolicyIndex
+	}
+	c := newMemoryContainer(e, key, hash)
+	c.info = ContainerInfo{
+		Account:                 vars["account"],
+		Container:               vars["container"],
+		CreatedAt:               common.GetTimestamp(),
+		PutTimestamp:            putTimestamp,
+		DeleteTimestamp:         "0",
+		StatusChangedAt:         putTimestamp,
+		ReportedPutTimestamp:    "0",
+		ReportedDeleteTimestamp: "0",
+		Hash:                    "00000000000000000000000000000000",
+		ID:                      common.UUID(),
+		XContainerSyncPoint1:    "-1",
+		XContainerSyncPoint2:    "-1",
+		ReconcilerSyncPoint:     -1,
+		StoragePolicyIndex:      policyIndex,
+		RawMetadata:             string(serializedMetadata),
+		MaxRow:                  -1,
+	}
+	c.policyStats[policyIndex] = &memoryPolicyStat{}
+	e.containers[key] = c
+	e.open++
+	return true, c, nil
+}
+
+// Return returns a container to the engine.
+func (e *memoryEngine) Return(c Container) {
+	e.m.Lock()
+	e.open--
+	e.m.Unlock()
+}
+
+// GetByHash returns a container given its device and ring hash.  The partition isn't needed to find it.
+func (e *memoryEngine) GetByHash(device, hash, partition string) (ReplicableContainer, error) {
+	return e.get(device, hash)
+}
+
+// Invalidate releases a container that won't be returned, since there's no cached connection to it to drop.
+func (e *memoryEngine) Invalidate(c Container) {
+	e.Return(c)
+}
+
+// Import replaces the container with the given hash with a copy of db, keeping its ROWIDs so sync points still apply.
+func (e *memoryEngine) Import(device, hash, partition string, db ReplicableContainer) error {
+	info, err := db.GetInfo()
+	if err != nil {
+		return err
+	}
+	syncs, err := db.SyncTable()
+	if err != nil {
+		return err
+	}
+	c := newMemoryContainer(e, device+"/"+hash, hash)
+	c.info = *info
+	c.info.Hash = "00000000000000000000000000000000"
+	c.policyStats[info.StoragePolicyIndex] = &memoryPolicyStat{}
+	for point := int64(-1); ; {
+		records, err := db.ItemsSince(point, 10000)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			c.insert(record)
+		}
+		point = records[len(records)-1].Rowid
+	}
+	for _, record := range syncs {
+		if record.RemoteID != info.ID {
+			c.incomingSync[record.RemoteID] = record.SyncPoint
+		}
+	}
+	e.m.Lock()
+	e.containers[c.key] = c
+	e.m.Unlock()
+	return nil
+}
+
+// OpenCount returns the number of containers that have been gotten but not returned.
+func (e *memoryEngine) OpenCount() int {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.open
+}
+
+// Close drops every container, since there's nowhere to keep them.
+func (e *memoryEngine) Close() {
+	e.m.Lock()
+	e.containers = make(map[string]*memoryContainer)
+	e.open = 0
+	e.m.Unlock()
+}
+
+type memoryObjectKey struct {
+	policy int
+	name   string
+}
+
+type memoryPolicyStat struct {
+	objectCount int64
+	bytesUsed   int64
+}
+
+// memoryContainer is a container held by the memory engine.  Its info holds what the sqlite backend keeps in the
+// container_info table, and its object records are kept the way they'd be in the object table, tombstones included.
+type memoryContainer struct {
+	engine       *memoryEngine
+	key          string
+	ringHash     string
+	info         ContainerInfo
+	objects      map[memoryObjectKey]*ObjectRecord
+	policyStats  map[int]*memoryPolicyStat
+	incomingSync map[string]int64
+	m            sync.Mutex
+}
+
+var _ SyncableContainer = &memoryContainer{}
+var _ ReconcilableContainer = &memoryContainer{}
+var _ IndexedContainer = &memoryContainer{}
+
+func newMemoryContainer(e *memoryEngine, key, hash string) *memoryContainer {
+	return &memoryContainer{
+		engine:       e,
+		key:          key,
+		ringHash:     hash,
+		objects:      make(map[memoryObjectKey]*ObjectRecord),
+		policyStats:  make(map[int]*memoryPolicyStat),
+		incomingSync: make(map[string]int64),
+	}
+}
+
+func copyRecord(record *ObjectRecord) *ObjectRecord {
+	r := *record
+	if record.Meta != nil {
+		r.Meta = make(map[string]string, len(record.Meta))
+		for k, v := range record.Meta {
+			r.Meta[k] = v
+		}
+	}
+	return &r
+}
+
+// insert adds a copy of a record, which should already have its ROWID, keeping the stats and hash up to date.
+func (c *memoryContainer) insert(record *ObjectRecord) {
+	r := copyRecord(record)
+	c.objects[memoryObjectKey{r.StoragePolicyIndex, r.Name}] = r
+	stat := c.policyStats[r.StoragePolicyIndex]
+	if stat == nil {
+		stat = &memoryPolicyStat{}
+		c.policyStats[r.StoragePolicyIndex] = stat
+	}
+	stat.objectCount += int64(1 - r.Deleted)
+	stat.bytesUsed += r.Size
+	c.info.Hash = chexor(c.info.Hash, r.Name, r.CreatedAt)
+	if r.Rowid > c.info.MaxRow {
+		c.info.MaxRow = r.Rowid
+	}
+}
+
+// remove deletes a record, keeping the stats and hash up to date.
+func (c *memoryContainer) remove(r *ObjectRecord) {
+	delete(c.objects, memoryObjectKey{r.StoragePolicyIndex, r.Name})
+	if stat := c.policyStats[r.StoragePolicyIndex]; stat != nil {
+		stat.objectCount -= int64(1 - r.Deleted)
+		stat.bytesUsed -= r.Size
+	}
+	c.info.Hash = chexor(c.info.Hash, r.Name, r.CreatedAt)
+}
+
+// sortedRecords returns the records with a ROWID greater than start that match, in ROWID order.
+func (c *memoryContainer) sortedRecords(start int64, match func(*ObjectRecord) bool) []*ObjectRecord {
+	records := []*ObjectRecord{}
+	for _, r := range c.objects {
+		if r.Rowid > start && (match == nil || match(r)) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Rowid < records[j].Rowid })
+	return records
+}
+
+func (c *memoryContainer) metadata() (map[string][]string, error) {
+	metadata := map[string][]string{}
+	if c.info.RawMetadata != "" {
+		if err := json.Unmarshal([]byte(c.info.RawMetadata), &metadata); err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// GetInfo returns the container's information as a ContainerInfo struct, after removing any expired objects.
+func (c *memoryContainer) GetInfo() (*ContainerInfo, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	now := time.Now().Unix()
+	for _, r := range c.objects {
+		if r.Expires == nil {
+			continue
+		}
+		if expires, err := strconv.ParseInt(*r.Expires, 10, 64); err == nil && expires < now {
+			c.remove(r)
+		}
+	}
+	info := c.info
+	info.updated = time.Now()
+	if stat := c.policyStats[info.StoragePolicyIndex]; stat != nil {
+		info.ObjectCount = stat.objectCount
+		info.BytesUsed = stat.bytesUsed
+	}
+	metadata, err := c.metadata()
+	if err != nil {
+		return nil, err
+	}
+	info.Metadata = metadata
+	return &info, nil
+}
+
+// IsDeleted returns true if the container is deleted - if its delete timestamp is later than its put timestamp.
+func (c *memoryContainer) IsDeleted() (bool, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.info.DeleteTimestamp > c.info.PutTimestamp, nil
+}
+
+// Remove drops the container from the engine.
+func (c *memoryContainer) Remove() error {
+	c.engine.m.Lock()
+	if c.engine.containers[c.key] == c {
+		delete(c.engine.containers, c.key)
+	}
+	c.engine.m.Unlock()
+	return nil
+}
+
+// Delete sets the container's deleted timestamp and tombstones any metadata older than that timestamp.
+func (c *memoryContainer) Delete(timestamp string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	metadata, err := c.metadata()
+	if err != nil {
+		return err
+	}
+	for key, value := range metadata {
+		if value[1] < timestamp {
+			metadata[key] = []string{"", timestamp}
+		}
+	}
+	serializedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	c.info.DeleteTimestamp = timestamp
+	c.info.RawMetadata = string(serializedMetadata)
+	return nil
+}
+
+// MergeItems merges ObjectRecords into the container, keeping the newest record for each object.  If a remote id is
+// provided (incoming replication), the incoming sync table is updated.
+func (c *memoryContainer) MergeItems(records []*ObjectRecord, remoteID string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	var maxRowid int64 = -1
+	var order []memoryObjectKey
+	toAdd := make(map[memoryObjectKey]*ObjectRecord)
+	for _, record := range records {
+		if record.Rowid > maxRowid {
+			maxRowid = record.Rowid
+		}
+		key := memoryObjectKey{record.StoragePolicyIndex, record.Name}
+		if alreadyIn, ok := toAdd[key]; ok && record.CreatedAt <= alreadyIn.CreatedAt {
+			continue
+		} else if !ok {
+			if current, ok := c.objects[key]; ok && current.CreatedAt >= record.CreatedAt {
+				continue
+			}
+			order = append(order, key)
+		}
+		toAdd[key] = record
+	}
+	for _, key := range order {
+		if current, ok := c.objects[key]; ok {
+			c.remove(current)
+		}
+		r := copyRecord(toAdd[key])
+		r.Rowid = c.info.MaxRow + 1
+		if r.Rowid < 1 {
+			r.Rowid = 1
+		}
+		c.insert(r)
+	}
+	if remoteID != "" && maxRowid > -1 {
+		c.incomingSync[remoteID] = maxRowid
+	}
+	return nil
+}
+
+// filterMatches returns true if the record is one a listing filter selects.
+func filterMatches(filter *ListingFilter, r *ObjectRecord) bool {
+	if filter == nil {
+		return true
+	}
+	if strings.HasSuffix(filter.ContentType, "/") {
+		if r.ContentType < filter.ContentType || r.ContentType > filter.ContentType+"\xFF" {
+			return false
+		}
+	} else if filter.ContentType != "" && r.ContentType != filter.ContentType &&
+		(r.ContentType < filter.ContentType+";" || r.ContentType > filter.ContentType+";\xFF") {
+		return false
+	}
+	if (filter.ModifiedSince != "" && r.CreatedAt <= filter.ModifiedSince) ||
+		(filter.ModifiedBefore != "" && r.CreatedAt >= filter.ModifiedBefore) {
+		return false
+	}
+	if (filter.SizeGT != nil && r.Size <= *filter.SizeGT) || (filter.SizeLT != nil && r.Size >= *filter.SizeLT) {
+		return false
+	}
+	for key, value := range filter.Meta {
+		if v, ok := r.Meta[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ListObjects implements object listings.  Path is a string pointer because behavior is different for empty and missing path query parameters.
+func (c *memoryContainer) ListObjects(limit int, marker string, endMarker string, prefix string, delimiter string,
+	pth *string, reverse bool, storagePolicyIndex int) ([]interface{}, error) {
+	return c.ListObjectsFiltered(limit, marker, endMarker, prefix, delimiter, pth, reverse, storagePolicyIndex, nil)
+}
+
+// ListObjectsFiltered implements object listings of only the objects matching filter.  It walks the listing in name
+// order the same way the sqlite backend pages through its queries, so the two list identically.
+func (c *memoryContainer) ListObjectsFiltered(limit int, marker string, endMarker string, prefix string, delimiter string,
+	pth *string, reverse bool, storagePolicyIndex int, filter *ListingFilter) ([]interface{}, error) {
+	c.m.Lock()
+	records := c.sortedRecords(-1, func(r *ObjectRecord) bool {
+		return r.Deleted == 0 && r.StoragePolicyIndex == storagePolicyIndex && filterMatches(filter, r)
+	})
+	c.m.Unlock()
+	if pth != nil {
+		if *pth != "" {
+			p := strings.TrimRight(*pth, "/") + "/"
+			pth = &p
+		}
+		delimiter = "/"
+		prefix = *pth
+	}
+	if reverse {
+		marker, endMarker = endMarker, marker
+		sort.Slice(records, func(i, j int) bool { return records[i].Name > records[j].Name })
+	} else {
+		sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	}
+	results := []interface{}{}
+	point := ""
+	for _, r := range records {
+		if len(results) >= limit {
+			break
+		}
+		if (prefix != "" && (r.Name < prefix || r.Name > prefix+"\xFF")) ||
+			(marker != "" && r.Name <= marker) || (endMarker != "" && r.Name >= endMarker) ||
+			(point != "" && ((reverse && r.Name >= point) || (!reverse && r.Name <= point))) {
+			continue
+		}
+		if delimiter != "" {
+			if pth != nil && r.Name == *pth {
+				continue
+			}
+			end := indexAfter(r.Name, delimiter, len(prefix))
+			if end >= 0 && (pth == nil || len(r.Name) > end+1) {
+				dirName := r.Name[:end] + delimiter
+				if reverse {
+					point = r.Name[:end+len(delimiter)]
+				} else {
+					point = dirName + "\xFF"
+				}
+				if pth == nil && dirName != marker {
+					results = append(results, &SubdirListingRecord{Name2: dirName, Name: dirName})
+				}
+				continue
+			}
+		}
+		record := &ObjectListingRecord{Name: r.Name, LastModified: r.CreatedAt, Size: r.Size, ContentType: r.ContentType, ETag: r.ETag}
+		if err := updateRecord(record); err != nil {
+			return nil, err
+		}
+		results = append(results, record)
+	}
+	return results, nil
+}
+
+// NewID sets the container's ID to a new, random string, recording the old one as synced through the current max row.
+func (c *memoryContainer) NewID() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.incomingSync[c.info.ID] = c.info.MaxRow
+	c.info.ID = common.UUID()
+	return nil
+}
+
+// ItemsSince returns (count) object records with a rowid greater than (start).
+func (c *memoryContainer) ItemsSince(start int64, count int) ([]*ObjectRecord, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	records := c.sortedRecords(start, nil)
+	if len(records) > count {
+		records = records[:count]
+	}
+	for i, r := range records {
+		records[i] = copyRecord(r)
+	}
+	return records, nil
+}
+
+// GetMetadata returns the current container metadata as a simple map[string]string, i.e. it leaves out tombstones and timestamps.
+func (c *memoryContainer) GetMetadata() (map[string]string, error) {
+	info, err := c.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string)
+	for key, value := range info.Metadata {
+		if value[0] != "" {
+			metadata[key] = value[0]
+		}
+	}
+	return metadata, nil
+}
+
+// UpdateMetadata merges the current container metadata with new incoming metadata.
+func (c *memoryContainer) UpdateMetadata(newMetadata map[string][]string, timestamp string) error {
+	if len(newMetadata) == 0 {
+		return nil
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	existingMetadata, err := c.metadata()
+	if err != nil {
+		return err
+	}
+	metastr, err := mergeMetas(existingMetadata, newMetadata, c.info.DeleteTimestamp)
+	if err != nil {
+		return err
+	}
+	c.info.RawMetadata = metastr
+	if timestamp > c.info.PutTimestamp {
+		c.info.PutTimestamp = timestamp
+	}
+	return nil
+}
+
+// MergeSyncTable updates the container's incoming sync table records.
+func (c *memoryContainer) MergeSyncTable(records []*SyncRecord) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, record := range records {
+		c.incomingSync[record.RemoteID] = record.SyncPoint
+	}
+	return nil
+}
+
+// CleanupTombstones removes any expired tombstoned objects or metadata.
+func (c *memoryContainer) CleanupTombstones(reclaimAge int64) error {
+	now := float64(time.Now().UnixNano()) / 1000000000.0
+	reclaimTimestamp := common.CanonicalTimestamp(now - float64(reclaimAge))
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, r := range c.objects {
+		if r.Deleted == 1 && r.CreatedAt < reclaimTimestamp {
+			c.remove(r)
+		}
+	}
+	metadata, err := c.metadata()
+	if err != nil {
+		return err
+	}
+	for k, v := range metadata {
+		if v[0] == "" {
+			if ts, err := common.GetEpochFromTimestamp(v[1]); err != nil || ts < reclaimTimestamp {
+				delete(metadata, k)
+			}
+		}
+	}
+	mb, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	c.info.RawMetadata = string(mb)
+	return nil
+}
+
+// SyncTable returns the container's current incoming sync table, and also includes the current container's id and max row as an entry.
+func (c *memoryContainer) SyncTable() ([]*SyncRecord, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	records := []*SyncRecord{{SyncPoint: c.info.MaxRow, RemoteID: c.info.ID}}
+	for remoteID, point := range c.incomingSync {
+		if remoteID != c.info.ID {
+			records = append(records, &SyncRecord{SyncPoint: point, RemoteID: remoteID})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].SyncPoint != records[j].SyncPoint {
+			return records[i].SyncPoint < records[j].SyncPoint
+		}
+		return records[i].RemoteID < records[j].RemoteID
+	})
+	return records, nil
+}
+
+// SyncRemoteData compares a remote container's info to the local info and updates any necessary replication bookkeeping, returning the current container's info.
+func (c *memoryContainer) SyncRemoteData(maxRow int64, hash, id, createdAt, putTimestamp, deleteTimestamp, metadata string) (*ContainerInfo, error) {
+	c.m.Lock()
+	var rm map[string][]string
+	if err := json.Unmarshal([]byte(metadata), &rm); err != nil {
+		c.m.Unlock()
+		return nil, err
+	}
+	lm, err := c.metadata()
+	if err != nil {
+		c.m.Unlock()
+		return nil, err
+	}
+	if deleteTimestamp > c.info.DeleteTimestamp {
+		c.info.DeleteTimestamp = deleteTimestamp
+	}
+	metastr, err := mergeMetas(lm, rm, c.info.DeleteTimestamp)
+	if err != nil {
+		c.m.Unlock()
+		return nil, err
+	}
+	c.info.RawMetadata = metastr
+	if createdAt < c.info.CreatedAt {
+		c.info.CreatedAt = createdAt
+	}
+	if putTimestamp > c.info.PutTimestamp {
+		c.info.PutTimestamp = putTimestamp
+	}
+	localPoint, ok := c.incomingSync[id]
+	if !ok {
+		localPoint = -1
+	}
+	if c.info.Hash == hash && maxRow > localPoint {
+		localPoint = maxRow
+		c.incomingSync[id] = localPoint
+	}
+	c.m.Unlock()
+	info, err := c.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	info.Point = localPoint
+	return info, nil
+}
+
+// CheckSyncLink does nothing, since container sync finds containers by symlinks to their database files.
+func (c *memoryContainer) CheckSyncLink() error {
+	return nil
+}
+
+// OpenDatabaseFile writes the container out to a temporary sqlite database, since that's what replication uploads,
+// and opens it for reading.  The cleanup function removes it.
+func (c *memoryContainer) OpenDatabaseFile() (*os.File, func(), error) {
+	dir, err := ioutil.TempDir("", "memorycontainer")
+	if err != nil {
+		return nil, nil, err
+	}
+	dbFile := filepath.Join(dir, c.ringHash, c.ringHash+".db")
+	if err := c.export(dbFile); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	fp, err := os.Open(dbFile)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("Error opening %s: %v", dbFile, err)
+	}
+	return fp, func() {
+		fp.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// export creates a sqlite database with the container's contents, keeping its ROWIDs.
+func (c *memoryContainer) export(dbFile string) error {
+	c.m.Lock()
+	info := c.info
+	records := c.sortedRecords(-1, nil)
+	syncs := make(map[string]int64, len(c.incomingSync))
+	for remoteID, point := range c.incomingSync {
+		syncs[remoteID] = point
+	}
+	c.m.Unlock()
+	if err := sqliteCreateContainer(dbFile, info.Account, info.Container, info.PutTimestamp, nil, info.StoragePolicyIndex); err != nil {
+		return err
+	}
+	rc, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return err
+	}
+	db := rc.(*sqliteContainer)
+	defer db.Close()
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE container_info SET created_at = ?, delete_timestamp = ?, status_changed_at = ?, id = ?,
+						  metadata = ?, reported_put_timestamp = ?, reported_delete_timestamp = ?, reported_object_count = ?,
+						  reported_bytes_used = ?, x_container_sync_point1 = ?, x_container_sync_point2 = ?,
+						  reconciler_sync_point = ?`,
+		info.CreatedAt, info.DeleteTimestamp, info.StatusChangedAt, info.ID, info.RawMetadata, info.ReportedPutTimestamp,
+		info.ReportedDeleteTimestamp, info.ReportedObjectCount, info.ReportedBytesUsed, info.XContainerSyncPoint1,
+		info.XContainerSyncPoint2, info.ReconcilerSyncPoint); err != nil {
+		return err
+	}
+	ast, err := tx.Prepare(`INSERT INTO object (ROWID, name, created_at, size, content_type, etag, deleted, storage_policy_index, expires, meta)
+							VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer ast.Close()
+	mst, err := tx.Prepare("INSERT INTO object_meta (name, storage_policy_index, key, value) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer mst.Close()
+	for _, r := range records {
+		if _, err := ast.Exec(r.Rowid, r.Name, r.CreatedAt, r.Size, r.ContentType, r.ETag, r.Deleted, r.StoragePolicyIndex, r.Expires, metaColumn{&r.Meta}); err != nil {
+			return err
+		}
+		for key, value := range r.Meta {
+			if _, err := mst.Exec(r.Name, r.StoragePolicyIndex, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	// Rows removed since the max row was handed out still count, so the next ROWID matches what the container would use.
+	if _, err := tx.Exec(`UPDATE sqlite_sequence SET seq = ? WHERE name = 'object';
+						  INSERT INTO sqlite_sequence (name, seq) SELECT 'object', ? WHERE changes() == 0 AND ? > -1;`,
+		info.MaxRow, info.MaxRow, info.MaxRow); err != nil {
+		return err
+	}
+	for remoteID, point := range syncs {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO incoming_sync (remote_id, sync_point) VALUES (?, ?)", remoteID, point); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ID returns the container's ring hash as a unique identifier for it.
+func (c *memoryContainer) ID() string {
+	return c.ringHash
+}
+
+// RingHash returns the container's ring hash as a string.
+func (c *memoryContainer) RingHash() string {
+	return c.ringHash
+}
+
+func (c *memoryContainer) addObject(name string, timestamp string, size int64, contentType string, etag string, deleted int, storagePolicyIndex int, expires string, meta map[string]string) error {
+	rec := &ObjectRecord{
+		Name:               name,
+		CreatedAt:          timestamp,
+		Size:               size,
+		ContentType:        contentType,
+		ETag:               etag,
+		Deleted:            deleted,
+		StoragePolicyIndex: storagePolicyIndex,
+		Expires:            &expires,
+		Meta:               meta,
+	}
+	if expires == "" {
+		rec.Expires = nil
+	}
+	return c.MergeItems([]*ObjectRecord{rec}, "")
+}
+
+// PutObject adds an object to the container.
+func (c *memoryContainer) PutObject(name string, timestamp string, size int64, contentType string, etag string, storagePolicyIndex int, expires string) error {
+	return c.addObject(name, timestamp, size, contentType, etag, 0, storagePolicyIndex, expires, nil)
+}
+
+// PutObjectMeta adds an object and its indexed metadata to the container.
+func (c *memoryContainer) PutObjectMeta(name string, timestamp string, size int64, contentType string, etag string, storagePolicyIndex int, expires string, meta map[string]string) error {
+	return c.addObject(name, timestamp, size, contentType, etag, 0, storagePolicyIndex, expires, meta)
+}
+
+// DeleteObject removes an object from the container.
+func (c *memoryContainer) DeleteObject(name string, timestamp string, storagePolicyIndex int) error {
+	return c.addObject(name, timestamp, 0, "", "", 1, storagePolicyIndex, "", nil)
+}
+
+// Close does nothing, since the container's data lives on in the engine.
+func (c *memoryContainer) Close() error {
+	return nil
+}
+
+// createExisting updates an existing container for a PUT, returning true if it had been deleted and is now recreated.
+func (c *memoryContainer) createExisting(putTimestamp string, newMetadata map[string][]string, policyIndex, defaultPolicyIndex int) (bool, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.info.DeleteTimestamp <= c.info.PutTimestamp { // not deleted
+		if policyIndex < 0 {
+			policyIndex = c.info.StoragePolicyIndex
+		} else if c.info.StoragePolicyIndex != policyIndex {
+			return false, ErrorPolicyConflict
+		}
+	} else { // deleted
+		if policyIndex < 0 {
+			policyIndex = defaultPolicyIndex
+		}
+	}
+	existingMetadata, err := c.metadata()
+	if err != nil {
+		return false, err
+	}
+	metastr, err := mergeMetas(existingMetadata, newMetadata, c.info.DeleteTimestamp)
+	if err != nil {
+		return false, err
+	}
+	recreated := c.info.DeleteTimestamp > c.info.PutTimestamp && putTimestamp > c.info.DeleteTimestamp
+	c.info.PutTimestamp = putTimestamp
+	c.info.StoragePolicyIndex = policyIndex
+	c.info.RawMetadata = metastr
+	if c.policyStats[policyIndex] == nil {
+		c.policyStats[policyIndex] = &memoryPolicyStat{}
+	}
+	if recreated {
+		// A recreated container may have a new storage policy, which should win over any replicas that missed it.
+		c.info.StatusChangedAt = putTimestamp
+	}
+	return recreated, nil
+}
+
+// Reported records the information as having been reported to an account database.
+func (c *memoryContainer) Reported(putTimestamp, deleteTimestamp string, objectCount, bytesUsed int64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.info.ReportedPutTimestamp = putTimestamp
+	c.info.ReportedDeleteTimestamp = deleteTimestamp
+	c.info.ReportedObjectCount = objectCount
+	c.info.ReportedBytesUsed = bytesUsed
+	return nil
+}
+
+// SetSyncPoints records the ROWIDs container sync has gotten through.
+func (c *memoryContainer) SetSyncPoints(point1, point2 int64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.info.XContainerSyncPoint1 = strconv.FormatInt(point1, 10)
+	c.info.XContainerSyncPoint2 = strconv.FormatInt(point2, 10)
+	return nil
+}
+
+// SetStoragePolicy changes the container's storage policy.  Objects already recorded under the old policy are left
+// for the reconciler to move.
+func (c *memoryContainer) SetStoragePolicy(policyIndex int, statusChangedAt string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.info.StoragePolicyIndex = policyIndex
+	c.info.StatusChangedAt = statusChangedAt
+	if c.policyStats[policyIndex] == nil {
+		c.policyStats[policyIndex] = &memoryPolicyStat{}
+	}
+	return nil
+}
+
+// MisplacedItems returns (count) object records with a rowid greater than (start) that are recorded under a storage
+// policy other than the container's.
+func (c *memoryContainer) MisplacedItems(start int64, count int) ([]*ObjectRecord, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	records := c.sortedRecords(start, func(r *ObjectRecord) bool { return r.StoragePolicyIndex != c.info.StoragePolicyIndex })
+	if len(records) > count {
+		records = records[:count]
+	}
+	for i, r := range records {
+		records[i] = copyRecord(r)
+	}
+	return records, nil
+}
+
+// SetReconcilerSyncPoint records the ROWID through which misplaced objects have been queued for the reconciler.
+func (c *memoryContainer) SetReconcilerSyncPoint(point int64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.info.ReconcilerSyncPoint = point
+	return nil
+}
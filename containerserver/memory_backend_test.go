@@ -0,0 +1,332 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/test"
+	"go.uber.org/zap"
+)
+
+func createMemoryContainer(t *testing.T, e *memoryEngine, container string) *memoryContainer {
+	_, c, err := e.Create(map[string]string{"device": "sda", "account": "a", "container": container}, common.GetTimestamp(), nil, 0, 0)
+	require.Nil(t, err)
+	return c.(*memoryContainer)
+}
+
+func TestContainerEngineRegistry(t *testing.T) {
+	for _, name := range []string{"sqlite", "memory"} {
+		constructor, err := FindContainerEngine(name)
+		require.Nil(t, err)
+		engine, err := constructor(conf.Config{}, "/srv/node", "changeme", "changeme")
+		require.Nil(t, err)
+		require.NotNil(t, engine)
+	}
+	constructor, err := FindContainerEngine("hopefullynotfound")
+	require.Nil(t, constructor)
+	require.NotNil(t, err)
+}
+
+func TestMemoryListsLikeSqlite(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase(common.GetTimestamp())
+	require.Nil(t, err)
+	defer cleanup()
+	c := createMemoryContainer(t, newMemoryEngine("changeme", "changeme"), "c")
+	names := []string{"a", "a/", "a/b", "a/b/c", "a/c", "b", "b/a", "c/d/e", "d"}
+	otherTimestamp := common.GetTimestamp()
+	for _, container := range []Container{db, c} {
+		for i, name := range names {
+			require.Nil(t, container.PutObject(name, common.CanonicalTimestamp(float64(1000000000+i)), int64(i), "text/plain", "etag", 0, ""))
+		}
+		require.Nil(t, container.DeleteObject("d", common.CanonicalTimestamp(2000000000), 0))
+		require.Nil(t, container.PutObject("other", otherTimestamp, 5, "text/plain", "etag", 1, ""))
+	}
+	expectedInfo, err := db.GetInfo()
+	require.Nil(t, err)
+	info, err := c.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, expectedInfo.Hash, info.Hash)
+	require.Equal(t, expectedInfo.ObjectCount, info.ObjectCount)
+	require.Equal(t, expectedInfo.BytesUsed, info.BytesUsed)
+	empty, slash, dir := "", "/", "a"
+	for _, args := range []struct {
+		marker, endMarker, prefix, delimiter string
+		path                                 *string
+		reverse                              bool
+	}{
+		{},
+		{marker: "a/b", endMarker: "c"},
+		{prefix: "a/"},
+		{delimiter: "/"},
+		{delimiter: "/", reverse: true},
+		{prefix: "a/", delimiter: "/"},
+		{marker: "a/", delimiter: "/"},
+		{path: &empty},
+		{path: &slash},
+		{path: &dir},
+		{path: &dir, reverse: true},
+	} {
+		expected, err := db.ListObjects(100, args.marker, args.endMarker, args.prefix, args.delimiter, args.path, args.reverse, 0)
+		require.Nil(t, err)
+		listed, err := c.ListObjects(100, args.marker, args.endMarker, args.prefix, args.delimiter, args.path, args.reverse, 0)
+		require.Nil(t, err)
+		require.Equal(t, expected, listed, fmt.Sprintf("%+v", args))
+	}
+}
+
+func TestMemoryFilteredListing(t *testing.T) {
+	c := createMemoryContainer(t, newMemoryEngine("changeme", "changeme"), "c")
+	require.Nil(t, c.PutObjectMeta("a", common.CanonicalTimestamp(1000000000), 1, "image/png", "etag", 0, "", map[string]string{"color": "red"}))
+	require.Nil(t, c.PutObjectMeta("b", common.CanonicalTimestamp(1000000001), 10, "image/jpeg", "etag", 0, "", map[string]string{"color": "blue"}))
+	require.Nil(t, c.PutObject("c", common.CanonicalTimestamp(1000000002), 100, "text/plain;charset=utf-8", "etag", 0, ""))
+	listNames := func(filter *ListingFilter) []string {
+		listed, err := c.ListObjectsFiltered(100, "", "", "", "", nil, false, 0, filter)
+		require.Nil(t, err)
+		names := []string{}
+		for _, record := range listed {
+			names = append(names, record.(*ObjectListingRecord).Name)
+		}
+		return names
+	}
+	size := int64(5)
+	require.Equal(t, []string{"a", "b"}, listNames(&ListingFilter{ContentType: "image/"}))
+	require.Equal(t, []string{"c"}, listNames(&ListingFilter{ContentType: "text/plain"}))
+	require.Equal(t, []string{"b", "c"}, listNames(&ListingFilter{SizeGT: &size}))
+	require.Equal(t, []string{"a"}, listNames(&ListingFilter{SizeLT: &size}))
+	require.Equal(t, []string{"c"}, listNames(&ListingFilter{ModifiedSince: common.CanonicalTimestamp(1000000001)}))
+	require.Equal(t, []string{"b"}, listNames(&ListingFilter{Meta: map[string]string{"color": "blue"}}))
+}
+
+func TestMemoryReplication(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	c1 := createMemoryContainer(t, e, "c1")
+	c2 := createMemoryContainer(t, e, "c2")
+	require.Nil(t, mergeItemsByName(c1, []string{"a", "b", "c"}))
+	require.Nil(t, c1.DeleteObject("b", common.GetTimestamp(), 0))
+
+	records, err := c1.ItemsSince(-1, 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	require.True(t, records[0].Rowid < records[1].Rowid)
+	records, err = c1.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	require.Equal(t, "b", records[2].Name)
+	require.Equal(t, 1, records[2].Deleted)
+
+	info1, err := c1.GetInfo()
+	require.Nil(t, err)
+	require.Nil(t, c2.MergeItems(records, info1.ID))
+	info2, err := c2.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info1.Hash, info2.Hash)
+	require.Equal(t, int64(2), info2.ObjectCount)
+
+	syncs, err := c2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(syncs))
+	info, err := c2.SyncRemoteData(info1.MaxRow, info1.Hash, info1.ID, info1.CreatedAt, info1.PutTimestamp, info1.DeleteTimestamp, info1.RawMetadata)
+	require.Nil(t, err)
+	require.Equal(t, info1.MaxRow, info.Point)
+
+	require.Nil(t, c2.NewID())
+	syncs, err = c2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 3, len(syncs))
+	require.Nil(t, c2.MergeSyncTable([]*SyncRecord{{RemoteID: "other", SyncPoint: 7}}))
+	syncs, err = c2.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, 4, len(syncs))
+
+	require.Nil(t, c1.CleanupTombstones(-1))
+	records, err = c1.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+}
+
+func TestMemoryExportImport(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	c := createMemoryContainer(t, e, "c")
+	require.Nil(t, c.UpdateMetadata(map[string][]string{"X-Container-Meta-Test": {"value", common.GetTimestamp()}}, common.GetTimestamp()))
+	require.Nil(t, mergeItemsByName(c, []string{"a", "b", "c"}))
+	require.Nil(t, c.PutObjectMeta("a", common.GetTimestamp(), 1, "text/plain", "etag", 0, "", map[string]string{"color": "red"}))
+	require.Nil(t, c.MergeSyncTable([]*SyncRecord{{RemoteID: "other", SyncPoint: 7}}))
+	info, err := c.GetInfo()
+	require.Nil(t, err)
+
+	fp, release, err := c.OpenDatabaseFile()
+	require.Nil(t, err)
+	defer release()
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dbFile := filepath.Join(dir, "db", "db.db")
+	require.Nil(t, os.MkdirAll(filepath.Dir(dbFile), 0777))
+	out, err := os.Create(dbFile)
+	require.Nil(t, err)
+	_, err = io.Copy(out, fp)
+	require.Nil(t, err)
+	out.Close()
+	db, err := sqliteOpenContainer(dbFile)
+	require.Nil(t, err)
+	defer db.Close()
+
+	exported, err := db.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info.ID, exported.ID)
+	require.Equal(t, info.Hash, exported.Hash)
+	require.Equal(t, info.MaxRow, exported.MaxRow)
+	require.Equal(t, info.ObjectCount, exported.ObjectCount)
+	require.Equal(t, info.Metadata, exported.Metadata)
+	expectedRecords, err := c.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	records, err := db.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, expectedRecords, records)
+	syncs, err := db.SyncTable()
+	require.Nil(t, err)
+	expectedSyncs, err := c.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, len(expectedSyncs), len(syncs))
+
+	e2 := newMemoryEngine("changeme", "changeme")
+	require.Nil(t, e2.Import("sda", c.RingHash(), "1", db))
+	imported, err := e2.GetByHash("sda", c.RingHash(), "1")
+	require.Nil(t, err)
+	defer e2.Return(imported)
+	importedInfo, err := imported.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info.Hash, importedInfo.Hash)
+	require.Equal(t, info.MaxRow, importedInfo.MaxRow)
+	records, err = imported.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Equal(t, expectedRecords, records)
+	syncs, err = imported.SyncTable()
+	require.Nil(t, err)
+	require.Equal(t, expectedSyncs, syncs)
+}
+
+func TestMemoryEngineOpenCount(t *testing.T) {
+	e := newMemoryEngine("changeme", "changeme")
+	vars := map[string]string{"device": "sda", "account": "a", "container": "c"}
+	_, err := e.Get(vars)
+	require.Equal(t, ErrorNoSuchContainer, err)
+	created, c, err := e.Create(vars, common.GetTimestamp(), nil, -1, 2)
+	require.Nil(t, err)
+	require.True(t, created)
+	require.Equal(t, 1, e.OpenCount())
+	e.Return(c)
+	c, err = e.Get(vars)
+	require.Nil(t, err)
+	require.Equal(t, 1, e.OpenCount())
+	e.Return(c)
+	require.Equal(t, 0, e.OpenCount())
+	_, _, err = e.Create(vars, common.GetTimestamp(), nil, 1, 0)
+	require.Equal(t, ErrorPolicyConflict, err)
+	require.Equal(t, 0, e.OpenCount())
+}
+
+func makeMemoryTestServer() (http.Handler, func(), error) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Mkdir(filepath.Join(dir, "device"), 0777); err != nil {
+		return nil, nil, err
+	}
+	server := &ContainerServer{
+		driveRoot:       dir,
+		hashPathPrefix:  "changeme",
+		hashPathSuffix:  "changeme",
+		logLevel:        zap.NewAtomicLevelAt(zap.InfoLevel),
+		logger:          zap.NewNop(),
+		checkMounts:     false,
+		updateClient:    http.DefaultClient,
+		containerEngine: newMemoryEngine("changeme", "changeme"),
+		diskInUse:       common.NewKeyedLimit(2, 2),
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+	return server.GetHandler(*new(conf.Config), fmt.Sprintf("test_container_%d", atomic.AddUint64(&testServerCount, 1))), cleanup, nil
+}
+
+func TestMemoryServerReplicateRsyncThenMerge(t *testing.T) {
+	handler, cleanup, err := makeMemoryTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.CanonicalTimestamp(100))
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+	for _, name := range []string{"a", "b", "c"} {
+		req, err := http.NewRequest("PUT", "/device/1/a/c/"+name, nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", common.GetTimestamp())
+		req.Header.Set("X-Content-Type", "application/octet-stream")
+		req.Header.Set("X-Size", "2")
+		req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	db, _, dbCleanup, err := createTestDatabase(common.GetTimestamp())
+	require.Nil(t, err)
+	defer dbCleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"d"}))
+	tmpFilename := common.UUID()
+	fp, release, err := db.OpenDatabaseFile()
+	require.Nil(t, err)
+	defer release()
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/tmp/"+tmpFilename, fp)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	containerHash := newMemoryEngine("changeme", "changeme").ringHash(map[string]string{"account": "a", "container": "c"})
+	msg, err := json.Marshal([]interface{}{"rsync_then_merge", tmpFilename})
+	require.Nil(t, err)
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("REPLICATE", "/device/1/"+containerHash, bytes.NewBuffer(msg))
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, http.StatusNoContent, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+	require.Equal(t, "4", rsp.Header().Get("X-Container-Object-Count"))
+}
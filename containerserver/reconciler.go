@@ -0,0 +1,505 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// If replicas of a container are created concurrently with different storage
+// policies, replication converges them on the policy that was set first.
+// Objects already written under the losing policy are then misplaced.  The
+// replicator queues a record for each of them in the .misplaced_objects
+// account, in containers named for the hour of the object's timestamp and with
+// names of the form "<policy index>:/<account>/<container>/<object>".  The
+// queue record's etag is the object's timestamp and its content type is
+// application/x-put or application/x-delete.
+//
+// The reconciler works through that queue, copying each misplaced object to
+// the container's storage policy with its original timestamp and then
+// deleting it from the wrong one, or carrying a misplaced delete over to the
+// right policy if nothing newer is there.
+
+package containerserver
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	misplacedObjectsAccount = ".misplaced_objects"
+	misplacedObjectsDivisor = 3600
+	misplacedPut            = "application/x-put"
+	misplacedDelete         = "application/x-delete"
+)
+
+// reconcilerHeaders are the object headers copied when moving an object to the right storage policy.
+var reconcilerHeaders = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Etag", "X-Delete-At",
+	"X-Object-Manifest", "X-Static-Large-Object"}
+
+// timestampWithOffset returns the timestamp with its offset increased by n, which orders it after the original
+// timestamp without changing the time it represents.
+func timestampWithOffset(timestamp string, n int64) string {
+	parts := strings.SplitN(timestamp, "_", 2)
+	var offset int64
+	if len(parts) == 2 {
+		offset, _ = strconv.ParseInt(parts[1], 16, 64)
+	}
+	return fmt.Sprintf("%s_%016x", parts[0], offset+n)
+}
+
+// timestampBeforeTime returns true if timestamp a is at an earlier time than b, ignoring any offsets.
+func timestampBeforeTime(a, b string) bool {
+	at, aerr := common.ParseDate(a)
+	bt, berr := common.ParseDate(b)
+	return aerr == nil && berr == nil && at.Before(bt)
+}
+
+// misplacedQueueName returns the queue container and object names for a misplaced object record.
+func misplacedQueueName(account, container string, record *ObjectRecord) (string, string) {
+	var hour int64
+	if epoch, err := common.GetEpochFromTimestamp(record.CreatedAt); err == nil {
+		if ts, err := strconv.ParseFloat(epoch, 64); err == nil {
+			hour = int64(ts) / misplacedObjectsDivisor * misplacedObjectsDivisor
+		}
+	}
+	return strconv.FormatInt(hour, 10), fmt.Sprintf("%d:/%s/%s/%s", record.StoragePolicyIndex, account, container, record.Name)
+}
+
+// parseMisplacedName splits a queue object name into the misplaced object's storage policy index and path.
+func parseMisplacedName(name string) (int, string, string, string, error) {
+	parts := strings.SplitN(name, ":/", 2)
+	if len(parts) != 2 {
+		return 0, "", "", "", fmt.Errorf("invalid misplaced object name %q", name)
+	}
+	policy, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", "", "", fmt.Errorf("invalid misplaced object policy %q", name)
+	}
+	path := strings.SplitN(parts[1], "/", 3)
+	if len(path) != 3 || path[0] == "" || path[1] == "" || path[2] == "" {
+		return 0, "", "", "", fmt.Errorf("invalid misplaced object path %q", name)
+	}
+	return policy, path[0], path[1], path[2], nil
+}
+
+// updateMisplacedQueue sends a queue record update directly to the container servers holding the queue container,
+// returning true if a majority of them accepted it.
+func updateMisplacedQueue(c common.HTTPClient, r ring.Ring, method, queueContainer, queueObj string, headers http.Header) bool {
+	part := r.GetPartition(misplacedObjectsAccount, queueContainer, "")
+	nodes := r.GetNodes(part)
+	successes := 0
+	for _, node := range nodes {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s/%s", node.Scheme, node.Ip, node.Port, node.Device, part,
+			common.Urlencode(misplacedObjectsAccount), common.Urlencode(queueContainer), common.Urlencode(queueObj)), nil)
+		if err != nil {
+			return false
+		}
+		for key := range headers {
+			req.Header.Set(key, headers.Get(key))
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			successes++
+		}
+	}
+	return successes > len(nodes)/2
+}
+
+// queueMisplacedObject adds a record for a misplaced object to the reconciler's queue.
+func queueMisplacedObject(c common.HTTPClient, r ring.Ring, account, container string, record *ObjectRecord) bool {
+	queueContainer, queueObj := misplacedQueueName(account, container, record)
+	contentType := misplacedPut
+	if record.Deleted == 1 {
+		contentType = misplacedDelete
+	}
+	return updateMisplacedQueue(c, r, "PUT", queueContainer, queueObj, http.Header{
+		"X-Timestamp":                    {record.CreatedAt},
+		"X-Size":                         {"0"},
+		"X-Content-Type":                 {contentType},
+		"X-Etag":                         {record.CreatedAt},
+		"X-Backend-Storage-Policy-Index": {"0"},
+		"X-Backend-Suppress-2xx-Logging": {"t"},
+	})
+}
+
+// Reconciler is the container reconciler daemon object.
+type Reconciler struct {
+	pdc              client.ProxyClient
+	policies         conf.PolicyList
+	Ring             ring.Ring
+	client           common.HTTPClient
+	logger           srv.LowLevelLogger
+	logLevel         zap.AtomicLevel
+	interval         time.Duration
+	metricsCloser    io.Closer
+	objectsMoved     tally.Counter
+	tombstonesMoved  tally.Counter
+	recordsProcessed tally.Counter
+	failures         tally.Counter
+}
+
+func (r *Reconciler) setMetricsScope(scope tally.Scope) {
+	r.objectsMoved = scope.Counter("container_reconciler_objects_moved")
+	r.tombstonesMoved = scope.Counter("container_reconciler_tombstones_moved")
+	r.recordsProcessed = scope.Counter("container_reconciler_records_processed")
+	r.failures = scope.Counter("container_reconciler_failures")
+}
+
+// policyClient returns a request client that treats the container as if it were in the given storage policy.
+func (r *Reconciler) policyClient(account, container string, policy int) client.RequestClient {
+	return r.pdc.NewRequestClient(nil, map[string]*client.ContainerInfo{
+		fmt.Sprintf("container/%s/%s", account, container): {StoragePolicyIndex: policy},
+	}, r.logger)
+}
+
+// reconcileDelete carries a delete recorded under the wrong storage policy over to the right one, unless the right
+// policy has a newer version of the object.
+func (r *Reconciler) reconcileDelete(ctx context.Context, right client.RequestClient, account, container, obj, timestamp string) bool {
+	resp := right.HeadObject(ctx, account, container, obj, http.Header{"X-Newest": {"true"}})
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 && !timestampBeforeTime(resp.Header.Get("X-Backend-Timestamp"), timestamp) {
+		return true
+	}
+	resp = right.DeleteObject(ctx, account, container, obj, http.Header{"X-Timestamp": {timestamp}})
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusConflict {
+		r.logger.Error("Bad status deleting misplaced object.", zap.String("account", account), zap.String("container", container),
+			zap.String("obj", obj), zap.Int("status", resp.StatusCode))
+		return false
+	}
+	r.tombstonesMoved.Inc(1)
+	return true
+}
+
+// reconcilePut copies an object from the wrong storage policy to the right one, keeping its timestamp, and then
+// deletes it from the wrong one.
+func (r *Reconciler) reconcilePut(ctx context.Context, right, wrong client.RequestClient, account, container, obj, timestamp string) bool {
+	src := wrong.GetObject(ctx, account, container, obj, http.Header{"X-Newest": {"true"}})
+	defer src.Body.Close()
+	if src.StatusCode == http.StatusNotFound {
+		// Already moved or since deleted.
+		return true
+	} else if src.StatusCode/100 != 2 {
+		r.logger.Error("Bad status getting misplaced object.", zap.String("account", account), zap.String("container", container),
+			zap.String("obj", obj), zap.Int("status", src.StatusCode))
+		return false
+	}
+	srcTimestamp := src.Header.Get("X-Backend-Timestamp")
+	if timestampBeforeTime(srcTimestamp, timestamp) {
+		// The object servers don't have the queued version yet; try again next pass.
+		return false
+	}
+	headers := http.Header{"X-Timestamp": {srcTimestamp}, "Content-Length": {strconv.FormatInt(src.ContentLength, 10)}}
+	for _, key := range reconcilerHeaders {
+		if v := src.Header.Get(key); v != "" {
+			headers.Set(key, v)
+		}
+	}
+	for key := range src.Header {
+		if strings.HasPrefix(key, "X-Object-Meta-") || strings.HasPrefix(key, "X-Object-Sysmeta-") {
+			headers.Set(key, src.Header.Get(key))
+		}
+	}
+	resp := right.PutObject(ctx, account, container, obj, headers, src.Body)
+	resp.Body.Close()
+	// A conflict means the right policy already has this version or a newer one.
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusConflict {
+		r.logger.Error("Bad status moving misplaced object.", zap.String("account", account), zap.String("container", container),
+			zap.String("obj", obj), zap.Int("status", resp.StatusCode))
+		return false
+	}
+	resp = wrong.DeleteObject(ctx, account, container, obj, http.Header{"X-Timestamp": {timestampWithOffset(srcTimestamp, 1)}})
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusConflict {
+		r.logger.Error("Bad status cleaning up misplaced object.", zap.String("account", account), zap.String("container", container),
+			zap.String("obj", obj), zap.Int("status", resp.StatusCode))
+		return false
+	}
+	r.objectsMoved.Inc(1)
+	return true
+}
+
+// reconcile handles a single queue record, returning true if it's done and can be removed from the queue.
+func (r *Reconciler) reconcile(ctx context.Context, record *ObjectListingRecord) bool {
+	policy, account, container, obj, err := parseMisplacedName(record.Name)
+	if err != nil {
+		r.logger.Error("Invalid misplaced object record.", zap.Error(err))
+		return true
+	}
+	if r.policies[policy] == nil {
+		r.logger.Error("Misplaced object has unknown storage policy.", zap.String("name", record.Name))
+		return true
+	}
+	right := r.pdc.NewRequestClient(nil, nil, r.logger)
+	ci, err := right.GetContainerInfo(ctx, account, container)
+	if err != nil {
+		// The container may not have replicated yet.
+		return false
+	}
+	if ci.StoragePolicyIndex == policy {
+		return true
+	}
+	if record.ContentType == misplacedDelete {
+		return r.reconcileDelete(ctx, right, account, container, obj, record.ETag)
+	}
+	return r.reconcilePut(ctx, right, r.policyClient(account, container, policy), account, container, obj, record.ETag)
+}
+
+// listing returns a page of a JSON listing from the reconciler's queue.
+func (r *Reconciler) listing(ctx context.Context, queueContainer, marker string) ([]*ObjectListingRecord, error) {
+	c := r.pdc.NewRequestClient(nil, nil, r.logger)
+	options := map[string]string{"format": "json", "marker": marker}
+	var resp *http.Response
+	if queueContainer == "" {
+		resp = c.GetAccountRaw(ctx, misplacedObjectsAccount, options, http.Header{})
+	} else {
+		resp = c.GetContainerRaw(ctx, misplacedObjectsAccount, queueContainer, options, http.Header{})
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	} else if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("bad status %d listing %s/%s", resp.StatusCode, misplacedObjectsAccount, queueContainer)
+	}
+	var records []*ObjectListingRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// reconcileQueueContainer works through one queue container, removing it once it's empty and its hour has passed.
+func (r *Reconciler) reconcileQueueContainer(ctx context.Context, queueContainer string) {
+	remaining := 0
+	marker := ""
+	for {
+		records, err := r.listing(ctx, queueContainer, marker)
+		if err != nil {
+			r.logger.Error("Error listing misplaced objects.", zap.String("container", queueContainer), zap.Error(err))
+			return
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			r.recordsProcessed.Inc(1)
+			if !r.reconcile(ctx, record) {
+				r.failures.Inc(1)
+				remaining++
+				continue
+			}
+			if !updateMisplacedQueue(r.client, r.Ring, "DELETE", queueContainer, record.Name, http.Header{
+				"X-Timestamp":                    {timestampWithOffset(record.ETag, 1)},
+				"X-Backend-Storage-Policy-Index": {"0"},
+			}) {
+				r.logger.Error("Unable to remove misplaced object record.", zap.String("name", record.Name))
+				remaining++
+			}
+		}
+		marker = records[len(records)-1].Name
+	}
+	hour, err := strconv.ParseInt(queueContainer, 10, 64)
+	if remaining == 0 && err == nil && hour+misplacedObjectsDivisor < time.Now().Unix() {
+		resp := r.pdc.NewRequestClient(nil, nil, r.logger).DeleteContainer(ctx, misplacedObjectsAccount, queueContainer,
+			http.Header{"X-Timestamp": {common.GetTimestamp()}})
+		resp.Body.Close()
+	}
+}
+
+// Run runs a pass of the reconciler over the whole queue once.
+func (r *Reconciler) Run() {
+	ctx := context.Background()
+	start := time.Now()
+	var queueContainers []string
+	marker := ""
+	for {
+		records, err := r.listing(ctx, "", marker)
+		if err != nil {
+			r.logger.Error("Error listing misplaced object containers.", zap.Error(err))
+			return
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			queueContainers = append(queueContainers, record.Name)
+		}
+		marker = records[len(records)-1].Name
+	}
+	// Work through the oldest records first; the names aren't zero padded.
+	sort.Slice(queueContainers, func(i, j int) bool {
+		a, _ := strconv.ParseInt(queueContainers[i], 10, 64)
+		b, _ := strconv.ParseInt(queueContainers[j], 10, 64)
+		return a < b
+	})
+	for _, queueContainer := range queueContainers {
+		r.reconcileQueueContainer(ctx, queueContainer)
+	}
+	r.logger.Info("Reconciler pass complete.", zap.Float64("seconds", time.Since(start).Seconds()))
+}
+
+// RunForever runs the reconciler in a forever-loop.
+func (r *Reconciler) RunForever() {
+	for {
+		start := time.Now()
+		r.Run()
+		if elapsed := time.Since(start); elapsed < r.interval {
+			time.Sleep(r.interval - elapsed)
+		}
+	}
+}
+
+func (r *Reconciler) Type() string {
+	return "container-reconciler"
+}
+
+func (r *Reconciler) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			r.Run()
+		}()
+		return ch
+	}
+	go r.RunForever()
+	return nil
+}
+
+func (r *Reconciler) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, r.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	r.setMetricsScope(metricsScope)
+	r.pdc.SetMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		r.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", r.logLevel)
+	router.Put("/loglevel", r.logLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(r.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (r *Reconciler) Finalize() {
+	if r.metricsCloser != nil {
+		r.metricsCloser.Close()
+	}
+	r.pdc.Close()
+}
+
+func (r *Reconciler) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (r *Reconciler) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(r.logger, next)
+}
+
+// NewReconciler uses the config settings and command-line flags to configure and return a container reconciler daemon struct.
+func NewReconciler(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	var ipPort *srv.IpPort
+	var err error
+	var logger srv.LowLevelLogger
+	if !serverconf.HasSection("container-reconciler") {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container-reconciler config section")
+	}
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	ring, err := cnf.GetRing("container", hashPathPrefix, hashPathSuffix, 0)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading container ring: %s", err)
+	}
+	policies, err := cnf.GetPolicies()
+	if err != nil {
+		return ipPort, nil, nil, err
+	}
+	logLevelString := serverconf.GetDefault("container-reconciler", "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	if logger, err = srv.SetupLogger("container-reconciler", &logLevel, flags); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	certFile := serverconf.GetDefault("container-reconciler", "cert_file", "")
+	keyFile := serverconf.GetDefault("container-reconciler", "key_file", "")
+	pdc, err := client.NewProxyClient(policies, cnf, logger, certFile, keyFile, "", "", "", serverconf)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Could not make client: %v", err)
+	}
+	ip := serverconf.GetDefault("container-reconciler", "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt("container-reconciler", "bind_port", common.DefaultContainerReconcilerPort))
+	r := &Reconciler{
+		pdc:      pdc,
+		policies: policies,
+		Ring:     ring,
+		logger:   logger,
+		logLevel: logLevel,
+		interval: time.Duration(serverconf.GetFloat("container-reconciler", "interval", 30) * float64(time.Second)),
+		client: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				Dial:                (&net.Dialer{Timeout: time.Second}).Dial,
+				MaxIdleConnsPerHost: 100,
+				MaxIdleConns:        0,
+			},
+		},
+	}
+	r.setMetricsScope(tally.NoopScope)
+	ipPort = &srv.IpPort{Ip: ip, Port: port}
+	return ipPort, r, logger, nil
+}
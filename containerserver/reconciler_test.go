@@ -0,0 +1,132 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// policyObjectClient is a fake request client holding a single object in one storage policy.
+type policyObjectClient struct {
+	client.RequestClient
+	timestamp string
+	body      string
+	puts      []http.Header
+	deletes   []http.Header
+}
+
+func (c *policyObjectClient) resp(status int, body string) *http.Response {
+	header := http.Header{}
+	if c.timestamp != "" {
+		header.Set("X-Backend-Timestamp", c.timestamp)
+		header.Set("Content-Type", "text/plain")
+		header.Set("X-Object-Meta-Color", "blue")
+	}
+	return &http.Response{StatusCode: status, Header: header, ContentLength: int64(len(body)), Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func (c *policyObjectClient) GetObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
+	if c.timestamp == "" {
+		return c.resp(http.StatusNotFound, "")
+	}
+	return c.resp(http.StatusOK, c.body)
+}
+
+func (c *policyObjectClient) HeadObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
+	if c.timestamp == "" {
+		return c.resp(http.StatusNotFound, "")
+	}
+	return c.resp(http.StatusOK, "")
+}
+
+func (c *policyObjectClient) PutObject(ctx context.Context, account, container, obj string, headers http.Header, src io.Reader) *http.Response {
+	body, _ := ioutil.ReadAll(src)
+	c.puts = append(c.puts, headers)
+	c.timestamp, c.body = headers.Get("X-Timestamp"), string(body)
+	return c.resp(http.StatusCreated, "")
+}
+
+func (c *policyObjectClient) DeleteObject(ctx context.Context, account, container, obj string, headers http.Header) *http.Response {
+	c.deletes = append(c.deletes, headers)
+	c.timestamp, c.body = "", ""
+	return c.resp(http.StatusNoContent, "")
+}
+
+func TestMisplacedQueueName(t *testing.T) {
+	container, obj := misplacedQueueName("a", "c", &ObjectRecord{Name: "o/1", CreatedAt: "1500003599.12345", StoragePolicyIndex: 2})
+	require.Equal(t, "1500001200", container)
+	require.Equal(t, "2:/a/c/o/1", obj)
+	policy, account, c, o, err := parseMisplacedName(obj)
+	require.Nil(t, err)
+	require.Equal(t, 2, policy)
+	require.Equal(t, "a", account)
+	require.Equal(t, "c", c)
+	require.Equal(t, "o/1", o)
+	_, _, _, _, err = parseMisplacedName("2:/a/c")
+	require.NotNil(t, err)
+	_, _, _, _, err = parseMisplacedName("x:/a/c/o")
+	require.NotNil(t, err)
+}
+
+func TestTimestampWithOffset(t *testing.T) {
+	require.Equal(t, "1500000000.00000_0000000000000001", timestampWithOffset("1500000000.00000", 1))
+	require.Equal(t, "1500000000.00000_0000000000000010", timestampWithOffset("1500000000.00000_000000000000000f", 1))
+	require.True(t, timestampBeforeTime("1500000000.00000", "1500000000.00001"))
+	require.False(t, timestampBeforeTime("1500000000.00000", "1500000000.00000_0000000000000001"))
+}
+
+func TestReconcilePut(t *testing.T) {
+	r := &Reconciler{logger: zap.NewNop()}
+	r.setMetricsScope(tally.NoopScope)
+	right := &policyObjectClient{}
+	wrong := &policyObjectClient{timestamp: "1500000000.00000", body: "data"}
+	// The wrong policy doesn't have the queued version yet.
+	require.False(t, r.reconcilePut(context.Background(), right, wrong, "a", "c", "o", "1500000001.00000"))
+	require.Equal(t, 0, len(right.puts))
+
+	require.True(t, r.reconcilePut(context.Background(), right, wrong, "a", "c", "o", "1500000000.00000"))
+	require.Equal(t, "1500000000.00000", right.timestamp)
+	require.Equal(t, "data", right.body)
+	require.Equal(t, "blue", right.puts[0].Get("X-Object-Meta-Color"))
+	require.Equal(t, "1500000000.00000_0000000000000001", wrong.deletes[0].Get("X-Timestamp"))
+
+	// Once moved, there's nothing left to do.
+	require.True(t, r.reconcilePut(context.Background(), right, wrong, "a", "c", "o", "1500000000.00000"))
+	require.Equal(t, 1, len(right.puts))
+}
+
+func TestReconcileDelete(t *testing.T) {
+	r := &Reconciler{logger: zap.NewNop()}
+	r.setMetricsScope(tally.NoopScope)
+	right := &policyObjectClient{timestamp: "1500000000.00000", body: "data"}
+	// The tombstone left behind by moving the object doesn't delete it.
+	require.True(t, r.reconcileDelete(context.Background(), right, "a", "c", "o", "1500000000.00000_0000000000000001"))
+	require.Equal(t, 0, len(right.deletes))
+
+	require.True(t, r.reconcileDelete(context.Background(), right, "a", "c", "o", "1500000001.00000"))
+	require.Equal(t, 1, len(right.deletes))
+	require.Equal(t, "1500000001.00000", right.deletes[0].Get("X-Timestamp"))
+}
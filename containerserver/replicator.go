@@ -243,28 +243,29 @@ func (rd *replicationDevice) replicateDatabaseToDevice(dev *ring.Device, c Repli
 		return fmt.Errorf("getting local info from %s: %v", c.RingHash(), err)
 	}
 	if rd.r.accountRing != nil {
-		if info.PutTimestamp > info.ReportedPutTimestamp ||
-			info.DeleteTimestamp > info.ReportedDeleteTimestamp ||
-			info.ObjectCount != info.ReportedObjectCount ||
-			info.BytesUsed != info.ReportedBytesUsed {
-
-			accountPartition := rd.r.accountRing.GetPartition(info.Account, "", "")
+		// Sharded containers report their shards' stats, the same as the server and updater do.
+		stats := info
+		if ranges, err := shardRanges(c); err == nil {
+			stats = withShardStats(info, ranges)
+		}
+		if statsUnreported(stats) {
+			accountPartition := rd.r.accountRing.GetPartition(stats.Account, "", "")
 			accountNodes := rd.r.accountRing.GetNodes(accountPartition)
 			accountNode := accountNodes[ringIndex%len(accountNodes)]
 			if accountUpdateHelper(
 				context.Background(),
-				info,
+				stats,
 				accountNode.Scheme,
 				fmt.Sprintf("%s:%d", accountNode.Ip, accountNode.Port),
 				accountNode.Device,
 				fmt.Sprintf("%d", accountPartition),
-				info.Account,
-				info.Container,
+				stats.Account,
+				stats.Container,
 				common.GetTransactionId(),
 				false,
 				rd.r.client,
 			) == nil {
-				if err = c.Reported(info.PutTimestamp, info.DeleteTimestamp, info.ObjectCount, info.BytesUsed); err != nil {
+				if err = c.Reported(stats.PutTimestamp, stats.DeleteTimestamp, stats.ObjectCount, stats.BytesUsed); err != nil {
 					rd.r.logger.Error("Could not update reported info", zap.Error(err), zap.String("RingHash", c.RingHash()))
 				}
 			}
@@ -274,6 +275,11 @@ func (rd *replicationDevice) replicateDatabaseToDevice(dev *ring.Device, c Repli
 	if err != nil {
 		return err
 	}
+	if rc, ok := c.(ReconcilableContainer); ok && remoteInfo != nil && storagePolicyWins(remoteInfo, info) {
+		if err := rc.SetStoragePolicy(remoteInfo.StoragePolicyIndex, remoteInfo.StatusChangedAt); err != nil {
+			return fmt.Errorf("setting storage policy of %s: %v", c.RingHash(), err)
+		}
+	}
 	strategy := rd.i.chooseReplicationStrategy(info, remoteInfo, rd.r.perUsync*3)
 	rd.i.incrementStat(strategy)
 	switch strategy {
@@ -362,6 +368,11 @@ func (rd *replicationDevice) replicateDatabase(dbFile string) error {
 			}
 		}
 	}
+	if !handoff {
+		if err := rd.r.queueMisplacedObjects(c); err != nil {
+			rd.r.logger.Error("Error queueing misplaced objects.", zap.String("dbFile", dbFile), zap.Error(err))
+		}
+	}
 	if handoff && successes == len(devices) {
 		rd.i.incrementStat("remove")
 		return os.RemoveAll(filepath.Dir(dbFile))
@@ -369,12 +380,67 @@ func (rd *replicationDevice) replicateDatabase(dbFile string) error {
 	return nil
 }
 
+// storagePolicyWins returns true if the remote replica's storage policy should replace the local one.  The policy
+// that was set first wins, with ties going to the lower index.
+func storagePolicyWins(remote, local *ContainerInfo) bool {
+	if remote.StoragePolicyIndex == local.StoragePolicyIndex || remote.StatusChangedAt == "" {
+		return false
+	}
+	if remote.StatusChangedAt != local.StatusChangedAt {
+		return remote.StatusChangedAt < local.StatusChangedAt
+	}
+	return remote.StoragePolicyIndex < local.StoragePolicyIndex
+}
+
+// queueMisplacedObjects queues any object records under the wrong storage policy for the reconciler.
+func (r *Replicator) queueMisplacedObjects(c ReplicableContainer) error {
+	rc, ok := c.(ReconcilableContainer)
+	if !ok {
+		return nil
+	}
+	info, err := rc.GetInfo()
+	if err != nil {
+		return err
+	}
+	point := info.ReconcilerSyncPoint
+	for {
+		records, err := rc.MisplacedItems(point, 1000)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, record := range records {
+			if !queueMisplacedObject(r.client, r.Ring, info.Account, info.Container, record) {
+				if point > info.ReconcilerSyncPoint {
+					rc.SetReconcilerSyncPoint(point)
+				}
+				return fmt.Errorf("unable to queue misplaced object %q", record.Name)
+			}
+			point = record.Rowid
+		}
+	}
+	if info.MaxRow > point {
+		point = info.MaxRow
+	}
+	if point == info.ReconcilerSyncPoint {
+		return nil
+	}
+	return rc.SetReconcilerSyncPoint(point)
+}
+
 func (rd *replicationDevice) findContainerDbs(devicePath string, results chan string) {
+	findContainerDbs(devicePath, results, rd.cancel, rd.r.logger)
+}
+
+// findContainerDbs sends the paths of all container databases on the device to results, closing it when done.
+func findContainerDbs(devicePath string, results chan string, cancel chan struct{}, logger srv.LowLevelLogger) {
 	defer close(results)
 	containersDir := filepath.Join(devicePath, "containers")
 	partitions, err := filepath.Glob(filepath.Join(containersDir, "[0-9]*"))
 	if err != nil {
-		rd.r.logger.Error("Error getting partitions.",
+		logger.Error("Error getting partitions.",
 			zap.String("containersDir", containersDir),
 			zap.Error(err))
 		return
@@ -382,7 +448,7 @@ func (rd *replicationDevice) findContainerDbs(devicePath string, results chan st
 	for _, part := range partitions {
 		suffixes, err := filepath.Glob(filepath.Join(part, "[a-f0-9][a-f0-9][a-f0-9]"))
 		if err != nil {
-			rd.r.logger.Error("Error getting suffixes.",
+			logger.Error("Error getting suffixes.",
 				zap.String("part", part),
 				zap.Error(err))
 			return
@@ -390,7 +456,7 @@ func (rd *replicationDevice) findContainerDbs(devicePath string, results chan st
 		for _, suff := range suffixes {
 			hashes, err := filepath.Glob(filepath.Join(suff, "????????????????????????????????"))
 			if err != nil {
-				rd.r.logger.Error("Error getting hashes",
+				logger.Error("Error getting hashes",
 					zap.String("suff", suff),
 					zap.Error(err))
 				return
@@ -400,7 +466,7 @@ func (rd *replicationDevice) findContainerDbs(devicePath string, results chan st
 				if fs.Exists(dbFile) {
 					select {
 					case results <- dbFile:
-					case <-rd.cancel:
+					case <-cancel:
 						return
 					}
 				}
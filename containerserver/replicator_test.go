@@ -32,6 +32,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
 	"github.com/troubling/hummingbird/common/conf"
 	"github.com/troubling/hummingbird/common/fs"
 	"github.com/troubling/hummingbird/common/ring"
@@ -330,6 +331,40 @@ func TestReplicatorReplicateDatabaseToDevice(t *testing.T) {
 	require.False(t, rsyncCalled)
 }
 
+func TestReplicatorReportsShardStats(t *testing.T) {
+	c, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, c.MergeShardRanges([]*common.ShardRange{
+		{Account: ".shards_a", Container: "c-1", Upper: "m", State: common.ShardRangeActive, Timestamp: "2", MetaTimestamp: "2", ObjectCount: 5, BytesUsed: 50},
+		{Account: ".shards_a", Container: "c-2", Lower: "m", State: common.ShardRangeActive, Timestamp: "2", MetaTimestamp: "2", ObjectCount: 7, BytesUsed: 70},
+	}))
+	var counts, bytes []string
+	dev, closeServer := testServer(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		counts = append(counts, request.Header.Get("X-Object-Count"))
+		bytes = append(bytes, request.Header.Get("X-Bytes-Used"))
+		writer.WriteHeader(http.StatusCreated)
+	}))
+	defer closeServer()
+	rd := newTestReplicationDevice(&ring.Device{}, &Replicator{
+		accountRing: &test.FakeRing{MockDevices: []*ring.Device{dev, dev, dev}},
+		client:      http.DefaultClient,
+	})
+	rd._sync = func(dev *ring.Device, part uint64, ringHash string, info *ContainerInfo) (*ContainerInfo, error) {
+		return &ContainerInfo{}, nil
+	}
+	rd._chooseReplicationStrategy = func(localInfo, remoteInfo *ContainerInfo, usyncThreshold int64) string {
+		return "no_change"
+	}
+	require.Nil(t, rd.replicateDatabaseToDevice(&ring.Device{}, c, 1, 0))
+	require.Equal(t, []string{"12"}, counts)
+	require.Equal(t, []string{"120"}, bytes)
+	info, err := c.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, int64(12), info.ReportedObjectCount)
+	require.Equal(t, int64(120), info.ReportedBytesUsed)
+}
+
 func TestFindContainers(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "")
 	require.Nil(t, err)
@@ -721,3 +756,60 @@ func TestSomeDatabaseFailures(t *testing.T) {
 	require.NotNil(t, rd.rsync(&ring.Device{}, fakeDatabase{}, 1, "complete_rsync"))
 	require.NotNil(t, rd.usync(&ring.Device{}, fakeDatabase{}, 1, "123", 3))
 }
+
+func TestStoragePolicyWins(t *testing.T) {
+	local := &ContainerInfo{StoragePolicyIndex: 1, StatusChangedAt: "100000000.00002"}
+	require.True(t, storagePolicyWins(&ContainerInfo{StoragePolicyIndex: 2, StatusChangedAt: "100000000.00001"}, local))
+	require.False(t, storagePolicyWins(&ContainerInfo{StoragePolicyIndex: 0, StatusChangedAt: "100000000.00003"}, local))
+	require.True(t, storagePolicyWins(&ContainerInfo{StoragePolicyIndex: 0, StatusChangedAt: "100000000.00002"}, local))
+	require.False(t, storagePolicyWins(&ContainerInfo{StoragePolicyIndex: 2, StatusChangedAt: "100000000.00002"}, local))
+	require.False(t, storagePolicyWins(&ContainerInfo{StoragePolicyIndex: 1, StatusChangedAt: "100000000.00001"}, local))
+}
+
+func TestReplicatorConvergesStoragePolicy(t *testing.T) {
+	c, _, cleanup, err := createTestDatabase("1410586890.28563")
+	require.Nil(t, err)
+	defer cleanup()
+	rd := newTestReplicationDevice(&ring.Device{}, &Replicator{})
+	rd._sync = func(dev *ring.Device, part uint64, ringHash string, info *ContainerInfo) (*ContainerInfo, error) {
+		return &ContainerInfo{StoragePolicyIndex: 2, StatusChangedAt: "1410586890.28562"}, nil
+	}
+	rd._chooseReplicationStrategy = func(localInfo, remoteInfo *ContainerInfo, usyncThreshold int64) string {
+		return "no_change"
+	}
+	require.Nil(t, rd.replicateDatabaseToDevice(&ring.Device{}, c, 1, 0))
+	info, err := c.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, 2, info.StoragePolicyIndex)
+	require.Equal(t, "1410586890.28562", info.StatusChangedAt)
+}
+
+func TestQueueMisplacedObjects(t *testing.T) {
+	c, _, cleanup, err := createTestDatabase("1410586890.28563")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(c, []string{"a"}))
+	require.Nil(t, c.MergeItems([]*ObjectRecord{{Name: "b", CreatedAt: "10000000.00002", StoragePolicyIndex: 1}}, ""))
+	require.Nil(t, c.MergeItems([]*ObjectRecord{{Name: "c", CreatedAt: "10000000.00003", StoragePolicyIndex: 1, Deleted: 1}}, ""))
+	devs := []*ring.Device{
+		{Id: 0, Device: "sda", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+		{Id: 1, Device: "sdb", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+		{Id: 2, Device: "sdc", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+	}
+	client := &syncRemoteClient{status: http.StatusCreated}
+	r := &Replicator{client: client, Ring: &test.FakeRing{MockDevices: devs}}
+	require.Nil(t, r.queueMisplacedObjects(c))
+	require.Equal(t, 6, len(client.requests))
+	require.Equal(t, "/sda/0/.misplaced_objects/9997200/1:/a/c/b", client.requests[0].URL.Path)
+	require.Equal(t, "10000000.00002", client.requests[0].Header.Get("X-Timestamp"))
+	require.Equal(t, "application/x-put", client.requests[0].Header.Get("X-Content-Type"))
+	require.Equal(t, "application/x-delete", client.requests[3].Header.Get("X-Content-Type"))
+	info, err := c.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, info.MaxRow, info.ReconcilerSyncPoint)
+
+	// Records are only queued once.
+	client.requests = nil
+	require.Nil(t, r.queueMisplacedObjects(c))
+	require.Equal(t, 0, len(client.requests))
+}
@@ -129,7 +129,8 @@ const (
 				etag TEXT,
 				deleted INTEGER DEFAULT 0,
 				storage_policy_index INTEGER DEFAULT 0,
-				expires INTEGER DEFAULT NULL
+				expires INTEGER DEFAULT NULL,
+				meta TEXT DEFAULT NULL
 			);
 		CREATE INDEX ix_object_deleted_name ON object (deleted, name);
 		CREATE INDEX ix_object_expires ON object(expires) WHERE expires IS NOT NULL;
@@ -174,6 +175,67 @@ const (
 				WHERE ROWID = new.ROWID;
 			END;`
 
+	shardRangeTableScript = `
+		CREATE TABLE shard_range (
+			account TEXT,
+			container TEXT,
+			lower TEXT,
+			upper TEXT,
+			object_count INTEGER DEFAULT 0,
+			bytes_used INTEGER DEFAULT 0,
+			state TEXT,
+			timestamp TEXT,
+			meta_timestamp TEXT DEFAULT '0',
+			deleted INTEGER DEFAULT 0,
+			UNIQUE (account, container)
+		);`
+
+	objectMetaTableScript = `
+		CREATE TABLE object_meta (
+			name TEXT,
+			storage_policy_index INTEGER,
+			key TEXT,
+			value TEXT,
+			PRIMARY KEY (storage_policy_index, name, key)
+		);
+		CREATE INDEX ix_object_meta_key_value ON object_meta (key, value);
+		CREATE TRIGGER object_delete_object_meta AFTER DELETE ON object
+		BEGIN
+			DELETE FROM object_meta
+			WHERE storage_policy_index = old.storage_policy_index AND name = old.name;
+		END;`
+
+	// Rows removed from the object table while a snapshot still covers them are kept in snapshot_object, along with the
+	// newest snapshot at the time, so listings as of older snapshots can still see them.  Tombstones that are older
+	// than every snapshot can't change any snapshot's listing or diff, so they aren't kept.
+	snapshotTableScript = `
+		CREATE TABLE container_snapshot (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE,
+			max_row INTEGER,
+			expires TEXT
+		);
+		CREATE TABLE snapshot_object (
+			row_id INTEGER PRIMARY KEY,
+			name TEXT,
+			created_at TEXT,
+			size INTEGER,
+			content_type TEXT,
+			etag TEXT,
+			deleted INTEGER,
+			storage_policy_index INTEGER,
+			removed_after INTEGER
+		);
+		CREATE TRIGGER object_delete_snapshot_object AFTER DELETE ON object
+		WHEN old.ROWID <= (SELECT MAX(max_row) FROM container_snapshot)
+			AND (old.deleted = 0 OR old.ROWID > (SELECT MIN(max_row) FROM container_snapshot))
+		BEGIN
+			INSERT OR REPLACE INTO snapshot_object (row_id, name, created_at, size, content_type, etag, deleted,
+				storage_policy_index, removed_after)
+			VALUES (old.ROWID, old.name, old.created_at, old.size, old.content_type, old.etag, old.deleted,
+				old.storage_policy_index, (SELECT MAX(id) FROM container_snapshot));
+		END;`
+
 	policyMigrateColumns = `account, container, created_at, put_timestamp, delete_timestamp, reported_put_timestamp,
 		reported_object_count, reported_bytes_used, hash, id, status, status_changed_at, metadata,
 		x_container_sync_point1, x_container_sync_point2`
@@ -202,11 +264,22 @@ const (
 		PRAGMA journal_mode = WAL;
 		PRAGMA busy_timeout = 25000;`
 
+	// compactScript rebuilds the database without its free pages.  VACUUM's scratch copy goes to disk rather than
+	// memory, since the databases worth compacting tend to be big ones, and the checkpoint moves the rebuilt pages
+	// out of the wal so the database file actually shrinks.
+	compactScript = `
+		PRAGMA temp_store = FILE;
+		VACUUM;
+		PRAGMA temp_store = MEMORY;
+		PRAGMA wal_checkpoint(TRUNCATE);`
+
 	// There's no real reason that adding a column with a partial index on non-default values would
 	// require a table scan, but I can't find any way to tell sqlite not to do it that isn't dark magic.
 	xExpireMigrateScript = `
 		ALTER TABLE object ADD COLUMN expires INTEGER DEFAULT NULL;
 		CREATE INDEX ix_object_expires ON object(expires) WHERE expires IS NOT NULL;`
+
+	objectMetaMigrateScript = "ALTER TABLE object ADD COLUMN meta TEXT DEFAULT NULL;" + objectMetaTableScript
 )
 
 func schemaMigrate(db *sql.DB) (bool, error) {
@@ -215,6 +288,9 @@ func schemaMigrate(db *sql.DB) (bool, error) {
 	hasMetadata := false
 	hasPolicyStat := false
 	hasExpireColumn := false
+	hasShardRange := false
+	hasObjectMeta := false
+	hasSnapshot := false
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -223,7 +299,7 @@ func schemaMigrate(db *sql.DB) (bool, error) {
 	defer tx.Rollback()
 
 	// We just pull the schema out of sqlite_master and look at it to get the current state of the database.
-	rows, err := tx.Query("SELECT name, sql FROM sqlite_master WHERE name in ('policy_stat', 'ix_object_deleted_name', 'container_stat')")
+	rows, err := tx.Query("SELECT name, sql FROM sqlite_master WHERE name in ('policy_stat', 'ix_object_deleted_name', 'container_stat', 'ix_object_expires', 'shard_range', 'object_meta', 'container_snapshot')")
 	if err != nil {
 		return false, err
 	}
@@ -241,6 +317,12 @@ func schemaMigrate(db *sql.DB) (bool, error) {
 			hasMetadata = strings.Contains(sql, "metadata")
 		} else if name == "ix_object_expires" {
 			hasExpireColumn = true
+		} else if name == "shard_range" {
+			hasShardRange = true
+		} else if name == "object_meta" {
+			hasObjectMeta = true
+		} else if name == "container_snapshot" {
+			hasSnapshot = true
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -250,7 +332,7 @@ func schemaMigrate(db *sql.DB) (bool, error) {
 		return hasDeletedNameIndex, err
 	}
 
-	if hasSyncPoints && hasMetadata && hasPolicyStat {
+	if hasSyncPoints && hasMetadata && hasPolicyStat && hasExpireColumn && hasShardRange && hasObjectMeta && hasSnapshot {
 		return hasDeletedNameIndex, nil
 	}
 
@@ -274,5 +356,20 @@ func schemaMigrate(db *sql.DB) (bool, error) {
 			return hasDeletedNameIndex, fmt.Errorf("Performing expires migration: %v", err)
 		}
 	}
+	if !hasShardRange {
+		if _, err = tx.Exec(shardRangeTableScript); err != nil {
+			return hasDeletedNameIndex, fmt.Errorf("Adding shard_range table: %v", err)
+		}
+	}
+	if !hasObjectMeta {
+		if _, err = tx.Exec(objectMetaMigrateScript); err != nil {
+			return hasDeletedNameIndex, fmt.Errorf("Adding object_meta table: %v", err)
+		}
+	}
+	if !hasSnapshot {
+		if _, err = tx.Exec(snapshotTableScript); err != nil {
+			return hasDeletedNameIndex, fmt.Errorf("Adding container_snapshot table: %v", err)
+		}
+	}
 	return hasDeletedNameIndex, tx.Commit()
 }
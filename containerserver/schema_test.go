@@ -169,6 +169,10 @@ func TestMigrateCruftyDatabase(t *testing.T) {
 			require.True(t, columnNames[column])
 		}
 	}
-	ensureColumnsExist("object", []string{"storage_policy_index"})
+	ensureColumnsExist("object", []string{"storage_policy_index", "meta"})
 	ensureColumnsExist("container_stat", []string{"metadata", "x_container_sync_point1", "x_container_sync_point2"})
+	ensureColumnsExist("shard_range", []string{"account", "container", "lower", "upper", "state", "meta_timestamp"})
+	ensureColumnsExist("object_meta", []string{"name", "storage_policy_index", "key", "value"})
+	ensureColumnsExist("container_snapshot", []string{"id", "name", "max_row", "expires"})
+	ensureColumnsExist("snapshot_object", []string{"row_id", "name", "deleted", "removed_after"})
 }
@@ -21,6 +21,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	_ "net/http/pprof" // install pprof http handlers
@@ -63,18 +64,25 @@ type ContainerServer struct {
 	syncRealms              conf.SyncRealmList
 	defaultPolicy           int
 	policyList              conf.PolicyList
+	snapshotExpireAfter     int64
 	metricsCloser           io.Closer
 	traceCloser             io.Closer
 	tracer                  opentracing.Tracer
 }
 
 var saveHeaders = map[string]bool{
-	"X-Container-Read":     true,
-	"X-Container-Write":    true,
-	"X-Container-Sync-Key": true,
-	"X-Container-Sync-To":  true,
-	"X-Versions-Location":  true,
-	"X-History-Location":   true,
+	"X-Container-Read":         true,
+	"X-Container-Write":        true,
+	"X-Container-Sync-Key":     true,
+	"X-Container-Sync-To":      true,
+	"X-Versions-Location":      true,
+	"X-History-Location":       true,
+	indexedMetadataKey:         true,
+	InventoryDestinationHeader: true,
+	InventoryFormatHeader:      true,
+	InventoryFrequencyHeader:   true,
+	InventoryFieldsHeader:      true,
+	InventoryPrefixHeader:      true,
 }
 
 func formatTimestamp(ts string) string {
@@ -172,6 +180,18 @@ func (server *ContainerServer) ContainerGetHandler(writer http.ResponseWriter, r
 		srv.StandardResponse(writer, http.StatusNotFound)
 		return
 	} else {
+		ranges, err := shardRanges(db)
+		if err != nil {
+			srv.GetLogger(request).Error("Unable to get shard ranges.", zap.Error(err))
+			srv.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		headers.Set("X-Backend-Sharding-State", common.ShardingState(ranges))
+		if request.Header.Get("X-Backend-Record-Type") == "shard" {
+			writeShardRanges(writer, request, ranges)
+			return
+		}
+		info = withShardStats(info, ranges)
 		headers.Set("X-Container-Object-Count", strconv.FormatInt(info.ObjectCount, 10))
 		headers.Set("X-Container-Bytes-Used", strconv.FormatInt(info.BytesUsed, 10))
 		if ts, err := common.GetEpochFromTimestamp(info.CreatedAt); err == nil {
@@ -211,22 +231,78 @@ func (server *ContainerServer) ContainerGetHandler(writer http.ResponseWriter, r
 		policyIndex = info.StoragePolicyIndex
 	}
 	reverse := common.LooksTrue(request.Form.Get("reverse"))
-	objects, err := db.ListObjects(int(limit), marker, endMarker, prefix, delimiter, path, reverse, policyIndex)
+	filter, err := parseListingFilter(request.Form, indexedKeys(metadata))
 	if err != nil {
-		srv.GetLogger(request).Error("Unable to list objects.", zap.Error(err))
-		srv.StandardResponse(writer, http.StatusInternalServerError)
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
 		return
 	}
-	format := request.Form.Get("format")
-	if format == "" { /* TODO: real accept parsing */
-		accept := request.Header.Get("Accept")
-		if strings.Contains(accept, "application/json") {
-			format = "json"
-		} else if strings.Contains(accept, "application/xml") || strings.Contains(accept, "text/xml") {
-			format = "xml"
-		} else {
-			format = "text"
+	snapshot := request.Form.Get("snapshot")
+	_, listSnapshots := request.Form["snapshots"]
+	from := request.Form.Get("snapshot_diff")
+	if listSnapshots || snapshot != "" {
+		if _, ok := db.(SnapshotContainer); !ok {
+			srv.StandardResponse(writer, http.StatusNotImplemented)
+			return
+		} else if filter != nil {
+			srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Listing filters can't be used with snapshots.")
+			return
+		}
+	}
+	if listSnapshots {
+		snapshots, err := db.(SnapshotContainer).Snapshots()
+		writeSnapshotJSON(writer, request, snapshots, err)
+		return
+	} else if from != "" {
+		if snapshot == "" {
+			srv.SimpleErrorResponse(writer, http.StatusBadRequest, "A snapshot_diff needs a snapshot to diff against.")
+			return
 		}
+		changes, err := db.(SnapshotContainer).DiffSnapshots(from, snapshot, int(limit), marker, policyIndex)
+		writeSnapshotJSON(writer, request, changes, err)
+		return
+	}
+	format := common.ListingFormat(request.Form.Get("format"), request.Header.Get("Accept"))
+	if format == "" {
+		srv.StandardResponse(writer, http.StatusNotAcceptable)
+		return
+	}
+	var list func(limit int, marker string) ([]interface{}, error)
+	if snapshot != "" {
+		list = func(limit int, marker string) ([]interface{}, error) {
+			return db.(SnapshotContainer).ListObjectsSnapshot(snapshot, limit, marker, endMarker, prefix, delimiter, path, reverse, policyIndex)
+		}
+	} else if filter == nil {
+		list = func(limit int, marker string) ([]interface{}, error) {
+			return db.ListObjects(limit, marker, endMarker, prefix, delimiter, path, reverse, policyIndex)
+		}
+	} else if ic, ok := db.(IndexedContainer); ok {
+		list = func(limit int, marker string) ([]interface{}, error) {
+			return ic.ListObjectsFiltered(limit, marker, endMarker, prefix, delimiter, path, reverse, policyIndex, filter)
+		}
+	} else {
+		srv.StandardResponse(writer, http.StatusNotImplemented)
+		return
+	}
+	if common.StreamedListingFormat(format) {
+		status, err := common.StreamListing(writer, format, common.ObjectListingCSVHeader, int(limit), marker, list)
+		if err == ErrorNoSuchSnapshot && status == 0 {
+			srv.StandardResponse(writer, http.StatusNotFound)
+		} else if err != nil {
+			srv.GetLogger(request).Error("Unable to list objects.", zap.Error(err))
+			if status == 0 {
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+	objects, err := list(int(limit), marker)
+	if err == ErrorNoSuchSnapshot {
+		srv.StandardResponse(writer, http.StatusNotFound)
+		return
+	} else if err != nil {
+		srv.GetLogger(request).Error("Unable to list objects.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
 	}
 	if format == "text" {
 		response := ""
@@ -273,9 +349,35 @@ func (server *ContainerServer) ContainerGetHandler(writer http.ResponseWriter, r
 	}
 }
 
+// resetSyncPoints starts container sync over from the beginning if the container is being synced somewhere new.
+func (server *ContainerServer) resetSyncPoints(vars map[string]string, syncTo string, logger srv.LowLevelLogger) {
+	db, err := server.containerEngine.Get(vars)
+	if err != nil {
+		return
+	}
+	defer server.containerEngine.Return(db)
+	sc, ok := db.(SyncableContainer)
+	if !ok {
+		return
+	}
+	if metadata, err := db.GetMetadata(); err != nil || metadata["X-Container-Sync-To"] == syncTo {
+		return
+	}
+	if err := sc.SetSyncPoints(-1, -1); err != nil {
+		logger.Error("Unable to reset sync points.", zap.Error(err))
+	}
+}
+
 // ContainerPutHandler handles PUT requests for a container.
 func (server *ContainerServer) ContainerPutHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := srv.GetVars(request)
+	if request.Header.Get("X-Backend-Record-Type") == "shard" {
+		server.shardRangesPut(writer, request, vars)
+		return
+	} else if request.Header.Get("X-Backend-Record-Type") == "object" {
+		server.objectsPut(writer, request, vars)
+		return
+	}
 	timestamp, err := common.StandardizeTimestamp(request.Header.Get("X-Timestamp"))
 	if err != nil {
 		srv.StandardResponse(writer, http.StatusBadRequest)
@@ -286,6 +388,11 @@ func (server *ContainerServer) ContainerPutHandler(writer http.ResponseWriter, r
 			srv.StandardResponse(writer, http.StatusBadRequest)
 			return
 		}
+		server.resetSyncPoints(vars, syncTo, srv.GetLogger(request))
+	}
+	if err := validateInventoryHeaders(request.Header); err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
 	}
 	policyIndex, err := strconv.Atoi(request.Header.Get("X-Backend-Storage-Policy-Index"))
 	if err != nil {
@@ -312,6 +419,9 @@ func (server *ContainerServer) ContainerPutHandler(writer http.ResponseWriter, r
 	}
 	defer server.containerEngine.Return(db)
 	if info, err := db.GetInfo(); err == nil {
+		if ranges, err := shardRanges(db); err == nil {
+			info = withShardStats(info, ranges)
+		}
 		server.accountUpdate(writer, request, vars, info, srv.GetLogger(request))
 	} else {
 		srv.GetLogger(request).Error("could not GetInfo on cont create.", zap.Error(err))
@@ -349,7 +459,13 @@ func (server *ContainerServer) ContainerDeleteHandler(writer http.ResponseWriter
 		srv.StandardResponse(writer, http.StatusInternalServerError)
 		return
 	}
-	if info.ObjectCount > 0 {
+	ranges, err := shardRanges(db)
+	if err != nil {
+		srv.GetLogger(request).Error("Unable to get shard ranges.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	if info = withShardStats(info, ranges); info.ObjectCount > 0 {
 		srv.StandardResponse(writer, http.StatusConflict)
 		return
 	}
@@ -364,7 +480,7 @@ func (server *ContainerServer) ContainerDeleteHandler(writer http.ResponseWriter
 	}
 	info, err = db.GetInfo()
 	if err == nil {
-		server.accountUpdate(writer, request, vars, info, srv.GetLogger(request))
+		server.accountUpdate(writer, request, vars, withShardStats(info, ranges), srv.GetLogger(request))
 	} else {
 		srv.GetLogger(request).Error("could not GetInfo on cont delete.", zap.Error(err))
 		srv.StandardResponse(writer, http.StatusInternalServerError)
@@ -382,17 +498,27 @@ func (server *ContainerServer) ContainerPostHandler(writer http.ResponseWriter,
 		srv.StandardResponse(writer, http.StatusBadRequest)
 		return
 	}
+	updates := make(map[string][]string)
+	for key := range request.Header {
+		if strings.HasPrefix(key, "X-Container-Meta-") || strings.HasPrefix(key, "X-Container-Sysmeta") || saveHeaders[key] {
+			updates[key] = []string{request.Header.Get(key), timestamp}
+		}
+	}
+	snapshotRequest := request.Header.Get(createSnapshotHeader) != "" || request.Header.Get(deleteSnapshotHeader) != ""
+	if snapshotRequest && len(updates) > 0 {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Container metadata can't be changed by a snapshot request.")
+		return
+	}
 	if syncTo := request.Header.Get("X-Container-Sync-To"); syncTo != "" {
 		if !server.syncRealms.ValidateSyncTo(syncTo) {
 			srv.StandardResponse(writer, http.StatusBadRequest)
 			return
 		}
+		server.resetSyncPoints(vars, syncTo, srv.GetLogger(request))
 	}
-	updates := make(map[string][]string)
-	for key := range request.Header {
-		if strings.HasPrefix(key, "X-Container-Meta-") || strings.HasPrefix(key, "X-Container-Sysmeta") || saveHeaders[key] {
-			updates[key] = []string{request.Header.Get(key), timestamp}
-		}
+	if err := validateInventoryHeaders(request.Header); err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
 	}
 	db, err := server.containerEngine.Get(vars)
 	if err == ErrorNoSuchContainer {
@@ -412,6 +538,10 @@ func (server *ContainerServer) ContainerPostHandler(writer http.ResponseWriter,
 		srv.StandardResponse(writer, http.StatusNotFound)
 		return
 	}
+	if snapshotRequest {
+		server.snapshotPost(writer, request, db, timestamp)
+		return
+	}
 	if err := db.UpdateMetadata(updates, timestamp); err == ErrorInvalidMetadata {
 		srv.StandardResponse(writer, http.StatusBadRequest)
 	} else if err != nil {
@@ -459,8 +589,23 @@ func (server *ContainerServer) ObjPutHandler(writer http.ResponseWriter, request
 		return
 	}
 	defer server.containerEngine.Return(db)
+	if server.redirectToShard(writer, request, db, vars["obj"]) {
+		return
+	}
 	expires := request.Header.Get("X-Delete-At")
-	if err := db.PutObject(vars["obj"], timestamp, size, contentType, etag, policyIndex, expires); err != nil {
+	var meta map[string]string
+	ic, indexed := db.(IndexedContainer)
+	if indexed {
+		if metadata, err := db.GetMetadata(); err == nil {
+			meta = indexedMeta(indexedKeys(metadata), request.Header)
+		}
+	}
+	if len(meta) > 0 {
+		err = ic.PutObjectMeta(vars["obj"], timestamp, size, contentType, etag, policyIndex, expires, meta)
+	} else {
+		err = db.PutObject(vars["obj"], timestamp, size, contentType, etag, policyIndex, expires)
+	}
+	if err != nil {
 		srv.GetLogger(request).Error("Error adding object to container.", zap.Error(err))
 		srv.StandardResponse(writer, http.StatusInternalServerError)
 		return
@@ -498,6 +643,9 @@ func (server *ContainerServer) ObjDeleteHandler(writer http.ResponseWriter, requ
 		return
 	}
 	defer server.containerEngine.Return(db)
+	if server.redirectToShard(writer, request, db, vars["obj"]) {
+		return
+	}
 	if err := db.DeleteObject(vars["obj"], timestamp, policyIndex); err != nil {
 		srv.GetLogger(request).Error("Error adding object to container.", zap.Error(err))
 		srv.StandardResponse(writer, http.StatusInternalServerError)
@@ -507,6 +655,86 @@ func (server *ContainerServer) ObjDeleteHandler(writer http.ResponseWriter, requ
 	writer.Write([]byte(""))
 }
 
+// objectsPut merges the json list of object records in the request body into the container in a single transaction.
+// It is used by object servers and updaters to batch updates for many objects in the same container.
+func (server *ContainerServer) objectsPut(writer http.ResponseWriter, request *http.Request, vars map[string]string) {
+	var records []*ObjectRecord
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &records); err != nil || len(records) == 0 {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	oldest := ""
+	for _, record := range records {
+		if record.CreatedAt, err = common.StandardizeTimestamp(record.CreatedAt); err != nil || record.Name == "" {
+			srv.StandardResponse(writer, http.StatusBadRequest)
+			return
+		}
+		if record.Deleted != 0 {
+			record.Deleted, record.Size, record.ContentType, record.ETag, record.Expires = 1, 0, "", "", nil
+			record.Meta = nil
+		} else if record.ContentType == "" || record.ETag == "" {
+			srv.StandardResponse(writer, http.StatusBadRequest)
+			return
+		}
+		if record.Expires != nil && *record.Expires == "" {
+			record.Expires = nil
+		}
+		record.Rowid = 0
+		if oldest == "" || record.CreatedAt < oldest {
+			oldest = record.CreatedAt
+		}
+	}
+	db, err := server.containerEngine.Get(vars)
+	if err == ErrorNoSuchContainer {
+		if strings.HasPrefix(vars["account"], server.autoCreatePrefix) {
+			if _, db, err = server.containerEngine.Create(vars, oldest, map[string][]string{}, records[0].StoragePolicyIndex, 0); err != nil {
+				srv.GetLogger(request).Error("Unable to auto-create container.", zap.Error(err))
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+				return
+			}
+		} else {
+			srv.StandardResponse(writer, http.StatusNotFound)
+			return
+		}
+	} else if err != nil {
+		srv.GetLogger(request).Error("Unable to get container.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	defer server.containerEngine.Return(db)
+	rc, ok := db.(ReplicableContainer)
+	if !ok {
+		srv.StandardResponse(writer, http.StatusNotImplemented)
+		return
+	}
+	if server.redirectBatchToShard(writer, request, db, records) {
+		return
+	}
+	keys := map[string]bool{}
+	if metadata, err := db.GetMetadata(); err == nil {
+		keys = indexedKeys(metadata)
+	}
+	for _, record := range records {
+		// Object servers send all of an object's metadata; only the container's indexed keys are kept.
+		for key := range record.Meta {
+			if !keys[key] {
+				delete(record.Meta, key)
+			}
+		}
+	}
+	if err := rc.MergeItems(records, ""); err != nil {
+		srv.GetLogger(request).Error("Error merging objects into container.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	srv.StandardResponse(writer, http.StatusAccepted)
+}
+
 // HealthcheckHandler implements a basic health check, that just returns "OK".
 func (server *ContainerServer) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Length", "2")
@@ -648,6 +876,7 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 	server.policyList = policies
 	server.defaultPolicy = policies.Default()
 	server.autoCreatePrefix = serverconf.GetDefault("app:container-server", "auto_create_account_prefix", ".")
+	server.snapshotExpireAfter = serverconf.GetInt("app:container-server", "snapshot_expire_after", 604800)
 	server.driveRoot = serverconf.GetDefault("app:container-server", "devices", "/srv/node")
 	server.checkMounts = serverconf.GetBool("app:container-server", "mount_check", true)
 
@@ -663,7 +892,12 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 	bindPort := int(serverconf.GetInt("app:container-server", "bind_port", common.DefaultContainerServerPort))
 	certFile := serverconf.GetDefault("app:container-server", "cert_file", "")
 	keyFile := serverconf.GetDefault("app:container-server", "key_file", "")
-	server.containerEngine = newLRUEngine(server.driveRoot, server.hashPathPrefix, server.hashPathSuffix, 32)
+	engineName := serverconf.GetDefault("app:container-server", "engine", "sqlite")
+	if newEngine, err := FindContainerEngine(engineName); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container engine type %s: %v", engineName, err)
+	} else if server.containerEngine, err = newEngine(serverconf, server.driveRoot, server.hashPathPrefix, server.hashPathSuffix); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error instantiating container engine type %s: %v", engineName, err)
+	}
 	connTimeout := time.Duration(serverconf.GetFloat("app:container-server", "conn_timeout", 1.0) * float64(time.Second))
 	nodeTimeout := time.Duration(serverconf.GetFloat("app:container-server", "node_timeout", 10.0) * float64(time.Second))
 	transport := &http.Transport{
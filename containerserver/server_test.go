@@ -16,9 +16,11 @@
 package containerserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -366,6 +368,69 @@ func TestContainerPutObjectsGet(t *testing.T) {
 	// TODO parse and validate xml.  or maybe we won't do that.
 }
 
+func TestContainerGetStreamedFormats(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	for _, object := range []string{"1", "2", "3", "d/4"} {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", "/device/1/a/c/"+object, nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", "100000001.00000")
+		req.Header.Set("X-Content-Type", "text/plain")
+		req.Header.Set("X-Size", "2")
+		req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?format=ndjson&delimiter=/", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "application/x-ndjson; charset=utf-8", rsp.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSuffix(rsp.Body.String(), "\n"), "\n")
+	require.Equal(t, 4, len(lines))
+	var record ObjectListingRecord
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, "1", record.Name)
+	require.Equal(t, `{"subdir":"d/"}`, lines[3])
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?limit=2", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "text/csv")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "text/csv; charset=utf-8", rsp.Header().Get("Content-Type"))
+	require.Equal(t, "name,bytes,hash,content_type,last_modified\n"+
+		"1,2,d41d8cd98f00b204e9800998ecf8427e,text/plain,1973-03-03T09:46:41.000000\n"+
+		"2,2,d41d8cd98f00b204e9800998ecf8427e,text/plain,1973-03-03T09:46:41.000000\n", rsp.Body.String())
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?format=ndjson&prefix=nothing", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "image/png")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 406, rsp.Status)
+}
+
 func TestContainerPutObjectsFails(t *testing.T) {
 	server, handler, cleanup, err := makeTestServer2()
 	require.Nil(t, err)
@@ -748,3 +813,345 @@ func TestContainerVerifySync(t *testing.T) {
 	handler.ServeHTTP(rsp, req)
 	require.Equal(t, 204, rsp.Status)
 }
+
+func TestContainerShardRanges(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", strings.NewReader("[]"))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "shard")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 404, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+	require.Equal(t, common.ShardingStateUnsharded, rsp.Header().Get("X-Backend-Sharding-State"))
+
+	ranges := []*common.ShardRange{
+		{Account: ".shards_a", Container: "c-1", Upper: "m", State: common.ShardRangeActive, Timestamp: "1", MetaTimestamp: "1", ObjectCount: 2, BytesUsed: 20},
+		{Account: ".shards_a", Container: "c-2", Lower: "m", State: common.ShardRangeCreated, Timestamp: "1", MetaTimestamp: "1", ObjectCount: 3, BytesUsed: 30},
+	}
+	body, err := json.Marshal(ranges)
+	require.Nil(t, err)
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "shard")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 202, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?includes=x", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "shard")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "shard", rsp.Header().Get("X-Backend-Record-Type"))
+	require.Equal(t, common.ShardingStateSharding, rsp.Header().Get("X-Backend-Sharding-State"))
+	var got []*common.ShardRange
+	require.Nil(t, json.Unmarshal(rsp.Body.Bytes(), &got))
+	require.Equal(t, 1, len(got))
+	require.Equal(t, "c-2", got[0].Container)
+
+	// While sharding, objects are still written to the root container.
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c/x", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Size", "1")
+	req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	ranges[1].State = common.ShardRangeActive
+	ranges[1].Timestamp = "2"
+	body, err = json.Marshal(ranges[1:])
+	require.Nil(t, err)
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "shard")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 202, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("HEAD", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+	require.Equal(t, common.ShardingStateSharded, rsp.Header().Get("X-Backend-Sharding-State"))
+	require.Equal(t, "6", rsp.Header().Get("X-Container-Object-Count"))
+	require.Equal(t, "51", rsp.Header().Get("X-Container-Bytes-Used"))
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("DELETE", "/device/1/a/c/y", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 301, rsp.Status)
+	require.Equal(t, ".shards_a/c-2", rsp.Header().Get("X-Backend-Location"))
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("DELETE", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 409, rsp.Status)
+}
+
+func TestContainerPutObjectBatch(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	records := []*ObjectRecord{
+		{Name: "1", CreatedAt: "100000001.00000", Size: 2, ContentType: "text/plain", ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Name: "2", CreatedAt: "100000001.00000", Size: 3, ContentType: "text/plain", ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Name: "3", CreatedAt: "100000001.00000", Size: 4, ContentType: "text/plain", ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Name: "2", CreatedAt: "100000002.00000", Deleted: 1},
+	}
+	body, err := json.Marshal(records)
+	require.Nil(t, err)
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "object")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 404, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", strings.NewReader(`[{"name": "x", "created_at": "100000001.00000"}]`))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "object")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 400, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("X-Backend-Record-Type", "object")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 202, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?format=json", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "2", rsp.Header().Get("X-Container-Object-Count"))
+	require.Equal(t, "6", rsp.Header().Get("X-Container-Bytes-Used"))
+	var data []ObjectListingRecord
+	require.Nil(t, json.Unmarshal(rsp.Body.Bytes(), &data))
+	require.Equal(t, 2, len(data))
+	require.Equal(t, "1", data[0].Name)
+	require.Equal(t, "3", data[1].Name)
+}
+
+func TestContainerIndexedMetadata(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	req.Header.Set("X-Container-Indexed-Metadata", "Color, Shape")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	for object, color := range map[string]string{"1": "red", "2": "blue", "3": "red"} {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", "/device/1/a/c/"+object, nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", common.GetTimestamp())
+		req.Header.Set("X-Content-Type", "application/octet-stream")
+		req.Header.Set("X-Size", object)
+		req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+		req.Header.Set("X-Object-Meta-Color", color)
+		req.Header.Set("X-Object-Meta-Owner", "someone")
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?meta.color=red&size_gt=1", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "3\n", rsp.Body.String())
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?meta.owner=someone", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 400, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?size_lt=small", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 400, rsp.Status)
+
+	// an object POST that changes indexed metadata sends an update with the POST's timestamp.
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("PUT", "/device/1/a/c/2", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Size", "2")
+	req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+	req.Header.Set("X-Object-Meta-Color", "red")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?meta.color=red&size_gt=1", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "2\n3\n", rsp.Body.String())
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?meta.color=blue", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+}
+
+func TestContainerSnapshotHandlers(t *testing.T) {
+	handler, cleanup, err := makeTestServer()
+	require.Nil(t, err)
+	defer cleanup()
+
+	rsp := test.MakeCaptureResponse()
+	req, err := http.NewRequest("PUT", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", "100000000.00001")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "0")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+
+	for _, object := range []string{"1", "2"} {
+		rsp := test.MakeCaptureResponse()
+		req, err := http.NewRequest("PUT", "/device/1/a/c/"+object, nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", common.GetTimestamp())
+		req.Header.Set("X-Content-Type", "application/octet-stream")
+		req.Header.Set("X-Size", "0")
+		req.Header.Set("X-Etag", "d41d8cd98f00b204e9800998ecf8427e")
+		handler.ServeHTTP(rsp, req)
+		require.Equal(t, 201, rsp.Status)
+	}
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("POST", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Backend-Create-Snapshot", "true")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+	snapshot := rsp.Header().Get("X-Container-Snapshot")
+	require.NotEqual(t, "", snapshot)
+
+	// Metadata isn't silently dropped from a snapshot request.
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("POST", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Backend-Create-Snapshot", "true")
+	req.Header.Set("X-Container-Meta-Color", "blue")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 400, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("DELETE", "/device/1/a/c/1", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("POST", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Backend-Create-Snapshot", "true")
+	req.Header.Set("X-Snapshot-Expire-After", "60")
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 201, rsp.Status)
+	snapshot2 := rsp.Header().Get("X-Container-Snapshot")
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "2\n", rsp.Body.String())
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?snapshot="+snapshot, nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	require.Equal(t, "1\n2\n", rsp.Body.String())
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?snapshots", nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	var snapshots []*ContainerSnapshot
+	require.Nil(t, json.Unmarshal(rsp.Body.Bytes(), &snapshots))
+	require.Equal(t, 2, len(snapshots))
+	require.Equal(t, snapshot, snapshots[0].Name)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?snapshot_diff="+snapshot+"&snapshot="+snapshot2, nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 200, rsp.Status)
+	var changes []map[string]interface{}
+	require.Nil(t, json.Unmarshal(rsp.Body.Bytes(), &changes))
+	require.Equal(t, 1, len(changes))
+	require.Equal(t, "1", changes[0]["name"])
+	require.Equal(t, "deleted", changes[0]["change"])
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("POST", "/device/1/a/c", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	req.Header.Set("X-Backend-Delete-Snapshot", snapshot)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 204, rsp.Status)
+
+	rsp = test.MakeCaptureResponse()
+	req, err = http.NewRequest("GET", "/device/1/a/c?snapshot="+snapshot, nil)
+	require.Nil(t, err)
+	handler.ServeHTTP(rsp, req)
+	require.Equal(t, 404, rsp.Status)
+}
@@ -0,0 +1,165 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/srv"
+	"go.uber.org/zap"
+)
+
+const (
+	shardRootKey      = "X-Container-Sysmeta-Shard-Root"
+	shardLowerKey     = "X-Container-Sysmeta-Shard-Lower"
+	shardUpperKey     = "X-Container-Sysmeta-Shard-Upper"
+	shardTimestampKey = "X-Container-Sysmeta-Shard-Timestamp"
+	// shardCleavedPrefix is followed by a device id; the value is the timestamp of the ranges that device has cleaved.
+	shardCleavedPrefix = "X-Container-Sysmeta-Shard-Cleaved-"
+)
+
+// shardRanges returns the container's shard ranges, or nil if the container doesn't support sharding.
+func shardRanges(db Container) ([]*common.ShardRange, error) {
+	if sc, ok := db.(ShardableContainer); ok {
+		return sc.ShardRanges(false)
+	}
+	return nil, nil
+}
+
+// withShardStats returns the container info with the object stats of a sharded container's shards added in.
+// Any objects left in the root database are ones that haven't been moved to their shards yet.
+func withShardStats(info *ContainerInfo, ranges []*common.ShardRange) *ContainerInfo {
+	if common.ShardingState(ranges) != common.ShardingStateSharded {
+		return info
+	}
+	sharded := *info
+	for _, sr := range ranges {
+		sharded.ObjectCount += sr.ObjectCount
+		sharded.BytesUsed += sr.BytesUsed
+	}
+	return &sharded
+}
+
+// writeShardRanges writes the container's shard ranges as a json listing.  If the "includes" parameter is set,
+// only the range including that object name is returned.
+func writeShardRanges(writer http.ResponseWriter, request *http.Request, ranges []*common.ShardRange) {
+	if name, ok := request.Form["includes"]; ok && len(name) > 0 {
+		if sr := common.FindShardRange(ranges, name[0]); sr != nil {
+			ranges = []*common.ShardRange{sr}
+		} else {
+			ranges = []*common.ShardRange{}
+		}
+	}
+	output, err := json.Marshal(ranges)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("X-Backend-Record-Type", "shard")
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	writer.WriteHeader(http.StatusOK)
+	if request.Method != "HEAD" {
+		writer.Write(output)
+	}
+}
+
+// shardRangesPut merges the json list of shard ranges in the request body into an existing container.
+func (server *ContainerServer) shardRangesPut(writer http.ResponseWriter, request *http.Request, vars map[string]string) {
+	var ranges []*common.ShardRange
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &ranges); err != nil {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	db, err := server.containerEngine.Get(vars)
+	if err == ErrorNoSuchContainer {
+		srv.StandardResponse(writer, http.StatusNotFound)
+		return
+	} else if err != nil {
+		srv.GetLogger(request).Error("Unable to get container.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	defer server.containerEngine.Return(db)
+	sc, ok := db.(ShardableContainer)
+	if !ok {
+		srv.StandardResponse(writer, http.StatusNotImplemented)
+		return
+	}
+	if err := sc.MergeShardRanges(ranges); err != nil {
+		srv.GetLogger(request).Error("Unable to merge shard ranges.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	srv.StandardResponse(writer, http.StatusAccepted)
+}
+
+// redirectToShard responds with a redirect to the shard container responsible for the object, if the container
+// has been sharded.  It returns true if it has responded.
+func (server *ContainerServer) redirectToShard(writer http.ResponseWriter, request *http.Request, db Container, obj string) bool {
+	ranges, err := shardRanges(db)
+	if err != nil {
+		srv.GetLogger(request).Error("Unable to get shard ranges.", zap.Error(err))
+		return false
+	}
+	if common.ShardingState(ranges) != common.ShardingStateSharded {
+		return false
+	}
+	sr := common.FindShardRange(ranges, obj)
+	if sr == nil {
+		return false
+	}
+	writer.Header().Set("X-Backend-Location", sr.Account+"/"+sr.Container)
+	srv.StandardResponse(writer, http.StatusMovedPermanently)
+	return true
+}
+
+// redirectBatchToShard is redirectToShard for a batch of object records.  If the records all belong to the same
+// shard container it is given as the X-Backend-Location, otherwise the location is left empty and the records must
+// be sent individually.
+func (server *ContainerServer) redirectBatchToShard(writer http.ResponseWriter, request *http.Request, db Container, records []*ObjectRecord) bool {
+	ranges, err := shardRanges(db)
+	if err != nil {
+		srv.GetLogger(request).Error("Unable to get shard ranges.", zap.Error(err))
+		return false
+	}
+	if common.ShardingState(ranges) != common.ShardingStateSharded {
+		return false
+	}
+	var shard *common.ShardRange
+	for i, record := range records {
+		sr := common.FindShardRange(ranges, record.Name)
+		if sr == nil || (i > 0 && sr != shard) {
+			shard = nil
+			break
+		}
+		shard = sr
+	}
+	if shard != nil {
+		writer.Header().Set("X-Backend-Location", shard.Account+"/"+shard.Container)
+	}
+	srv.StandardResponse(writer, http.StatusMovedPermanently)
+	return true
+}
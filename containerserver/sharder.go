@@ -0,0 +1,569 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// The sharder splits container databases that have grown past
+// shard_container_threshold objects into shard containers, each holding a
+// range of the root container's object names.  Shard containers live in a
+// hidden account, ".shards_<account>", and are ordinary containers otherwise.
+//
+// The sharder on the root's first primary node chooses the shard ranges and
+// sends them to all the root's replicas.  Every replica then copies ("cleaves")
+// its objects into local copies of the shard databases, which the replicator
+// moves to the shards' own nodes.  Each primary records in the root's metadata
+// that it has cleaved every range, and that mark replicates with the root.  Once
+// every primary has cleaved and each shard has reached a quorum of its own
+// primaries, the first primary marks the ranges active; from then on object updates are redirected
+// to the shards, listings are stitched together from the shards by the proxy,
+// and any objects left in the root databases are moved to their shards and
+// removed from the root.  Shard containers aren't sharded themselves, but they
+// report their object counts back to the root.
+
+package containerserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+// Sharder is the container sharder daemon object.
+type Sharder struct {
+	checkMounts    bool
+	deviceRoot     string
+	serverPort     int
+	Ring           ring.Ring
+	engine         *lruEngine
+	client         common.HTTPClient
+	logger         srv.LowLevelLogger
+	logLevel       zap.AtomicLevel
+	threshold      int64
+	rowsPerShard   int64
+	batchSize      int
+	interval       time.Duration
+	metricsCloser  io.Closer
+	rangesCreated  tally.Counter
+	sharded        tally.Counter
+	objectsCleaved tally.Counter
+	objectsMoved   tally.Counter
+	statsReported  tally.Counter
+	shardingErrors tally.Counter
+}
+
+func (s *Sharder) setMetricsScope(scope tally.Scope) {
+	s.rangesCreated = scope.Counter("container_sharder_ranges_created")
+	s.sharded = scope.Counter("container_sharder_containers_sharded")
+	s.objectsCleaved = scope.Counter("container_sharder_objects_cleaved")
+	s.objectsMoved = scope.Counter("container_sharder_misplaced_objects_moved")
+	s.statsReported = scope.Counter("container_sharder_stats_reported")
+	s.shardingErrors = scope.Counter("container_sharder_errors")
+}
+
+// pushShardRanges sends shard ranges to all of a container's primary nodes, returning an error if less than a
+// quorum accepted them.
+func (s *Sharder) pushShardRanges(account, container string, ranges []*common.ShardRange) error {
+	body, err := json.Marshal(ranges)
+	if err != nil {
+		return err
+	}
+	part := s.Ring.GetPartition(account, container, "")
+	nodes := s.Ring.GetNodes(part)
+	successes := 0
+	for _, node := range nodes {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s", node.Scheme, node.Ip, node.Port, node.Device, part,
+			common.Urlencode(account), common.Urlencode(container)), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Backend-Record-Type", "shard")
+		req.Header.Set("X-Timestamp", common.GetTimestamp())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Backend-Suppress-2xx-Logging", "t")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			s.logger.Error("Error sending shard ranges.", zap.String("Ip", node.Ip), zap.String("Device", node.Device), zap.Error(err))
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			successes++
+		} else {
+			s.logger.Error("Bad status sending shard ranges.", zap.String("Ip", node.Ip), zap.String("Device", node.Device), zap.Int("status", resp.StatusCode))
+		}
+	}
+	if successes < len(nodes)/2+1 {
+		return fmt.Errorf("Only %d of %d nodes accepted shard ranges for %s/%s", successes, len(nodes), account, container)
+	}
+	return nil
+}
+
+// findShardRanges chooses new shard ranges for the container, each holding about rowsPerShard objects.
+func (s *Sharder) findShardRanges(c ShardableContainer, info *ContainerInfo) ([]*common.ShardRange, error) {
+	points, err := c.ShardPoints(s.rowsPerShard)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := common.GetTimestamp()
+	ranges := make([]*common.ShardRange, 0, len(points)+1)
+	lower := ""
+	for i := 0; i <= len(points); i++ {
+		upper := ""
+		if i < len(points) {
+			upper = points[i]
+		}
+		ranges = append(ranges, &common.ShardRange{
+			Account:       common.ShardAccount(info.Account),
+			Container:     fmt.Sprintf("%s-%s-%d", info.Container, timestamp, i),
+			Lower:         lower,
+			Upper:         upper,
+			State:         common.ShardRangeCreated,
+			Timestamp:     timestamp,
+			MetaTimestamp: timestamp,
+		})
+		lower = upper
+	}
+	return ranges, nil
+}
+
+// cleave copies the root container's objects within the shard range into the local copy of the shard's database,
+// creating it if needed.  If remove is set, the copied objects are removed from the root.
+func (s *Sharder) cleave(dev *ring.Device, c ShardableContainer, info *ContainerInfo, sr *common.ShardRange, remove bool) (int64, error) {
+	records, err := c.ItemsInRange(sr.Lower, sr.Upper, s.batchSize)
+	if err != nil || (len(records) == 0 && sr.State == common.ShardRangeActive) {
+		return 0, err
+	}
+	vars := map[string]string{
+		"device":    dev.Device,
+		"partition": strconv.FormatUint(s.Ring.GetPartition(sr.Account, sr.Container, ""), 10),
+		"account":   sr.Account,
+		"container": sr.Container,
+	}
+	metadata := map[string][]string{
+		shardRootKey:      {info.Account + "/" + info.Container, sr.Timestamp},
+		shardLowerKey:     {sr.Lower, sr.Timestamp},
+		shardUpperKey:     {sr.Upper, sr.Timestamp},
+		shardTimestampKey: {sr.Timestamp, sr.Timestamp},
+	}
+	// Shards index the same object metadata as their root, since updates go straight to them.
+	if keys, ok := info.Metadata[indexedMetadataKey]; ok {
+		metadata[indexedMetadataKey] = keys
+	}
+	_, shard, err := s.engine.Create(vars, sr.Timestamp, metadata, info.StoragePolicyIndex, info.StoragePolicyIndex)
+	if err != nil {
+		return 0, err
+	}
+	defer s.engine.Return(shard)
+	rshard, ok := shard.(ReplicableContainer)
+	if !ok {
+		return 0, fmt.Errorf("Shard container does not support replication.")
+	}
+	var count int64
+	for len(records) > 0 {
+		if err := rshard.MergeItems(records, ""); err != nil {
+			return count, err
+		}
+		if remove {
+			if err := c.RemoveItems(records); err != nil {
+				return count, err
+			}
+		}
+		count += int64(len(records))
+		if records, err = c.ItemsInRange(records[len(records)-1].Name, sr.Upper, s.batchSize); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// shardsReplicated reports whether a quorum of each shard's primaries have its database.
+func (s *Sharder) shardsReplicated(ranges []*common.ShardRange) bool {
+	for _, sr := range ranges {
+		part := s.Ring.GetPartition(sr.Account, sr.Container, "")
+		nodes := s.Ring.GetNodes(part)
+		successes := 0
+		for _, node := range nodes {
+			req, err := http.NewRequest("HEAD", fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s", node.Scheme, node.Ip, node.Port, node.Device, part,
+				common.Urlencode(sr.Account), common.Urlencode(sr.Container)), nil)
+			if err != nil {
+				return false
+			}
+			req.Header.Set("X-Backend-Suppress-2xx-Logging", "t")
+			resp, err := s.client.Do(req)
+			if err != nil {
+				s.logger.Error("Error checking shard container.", zap.String("Ip", node.Ip), zap.String("Device", node.Device), zap.Error(err))
+				continue
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				successes++
+			}
+		}
+		if successes < len(nodes)/2+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// recordCleaved marks in the root's metadata that this replica has cleaved every range, so the mark replicates to
+// the other replicas.  It returns whether the ranges may be made active: every primary of the root has cleaved
+// them and each shard has reached a quorum of its own primaries.  Until then the shards may be missing objects
+// only some root replicas had, or be missing altogether.
+func (s *Sharder) recordCleaved(dev *ring.Device, c ShardableContainer, info *ContainerInfo, nodes []*ring.Device, ranges []*common.ShardRange) (bool, error) {
+	epoch := ""
+	for _, sr := range ranges {
+		if sr.Timestamp > epoch {
+			epoch = sr.Timestamp
+		}
+	}
+	cleaved := map[string][]string{}
+	for k, v := range info.Metadata {
+		cleaved[k] = v
+	}
+	for _, node := range nodes {
+		if node.Id == dev.Id {
+			key := shardCleavedPrefix + strconv.Itoa(dev.Id)
+			if metadataValue(info.Metadata, key) != epoch {
+				if err := c.UpdateMetadata(map[string][]string{key: {epoch, epoch}}, epoch); err != nil {
+					return false, err
+				}
+				cleaved[key] = []string{epoch, epoch}
+			}
+		}
+	}
+	for _, node := range nodes {
+		if metadataValue(cleaved, shardCleavedPrefix+strconv.Itoa(node.Id)) != epoch {
+			return false, nil
+		}
+	}
+	return s.shardsReplicated(ranges), nil
+}
+
+// reportShardStats sends a shard container's object stats to its root container.
+func (s *Sharder) reportShardStats(info *ContainerInfo) error {
+	root := strings.SplitN(metadataValue(info.Metadata, shardRootKey), "/", 2)
+	if len(root) != 2 {
+		return nil
+	}
+	sr := &common.ShardRange{
+		Account:       info.Account,
+		Container:     info.Container,
+		Lower:         metadataValue(info.Metadata, shardLowerKey),
+		Upper:         metadataValue(info.Metadata, shardUpperKey),
+		ObjectCount:   info.ObjectCount,
+		BytesUsed:     info.BytesUsed,
+		State:         common.ShardRangeActive,
+		Timestamp:     metadataValue(info.Metadata, shardTimestampKey),
+		MetaTimestamp: common.GetTimestamp(),
+	}
+	if err := s.pushShardRanges(root[0], root[1], []*common.ShardRange{sr}); err != nil {
+		return err
+	}
+	s.statsReported.Inc(1)
+	return nil
+}
+
+func metadataValue(metadata map[string][]string, key string) string {
+	if value, ok := metadata[key]; ok && len(value) > 0 {
+		return value[0]
+	}
+	return ""
+}
+
+// shardDatabase runs a sharding pass over a single container database.
+func (s *Sharder) shardDatabase(dev *ring.Device, dbFile string) error {
+	parts := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(dbFile))))
+	part, err := strconv.ParseUint(parts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Bad partition: %s", parts)
+	}
+	nodes := s.Ring.GetNodes(part)
+	leader := len(nodes) > 0 && nodes[0].Id == dev.Id
+	rc, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	c, ok := rc.(ShardableContainer)
+	if !ok {
+		return nil
+	}
+	if deleted, err := c.IsDeleted(); err != nil || deleted {
+		return err
+	}
+	info, err := c.GetInfo()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(info.Account, common.ShardAccountPrefix) {
+		if leader {
+			return s.reportShardStats(info)
+		}
+		return nil
+	}
+	ranges, err := c.ShardRanges(false)
+	if err != nil {
+		return err
+	}
+	state := common.ShardingState(ranges)
+	if state == common.ShardingStateUnsharded {
+		if !leader || info.ObjectCount <= s.threshold {
+			return nil
+		}
+		if ranges, err = s.findShardRanges(c, info); err != nil {
+			return err
+		}
+		if len(ranges) < 2 {
+			return nil
+		}
+		if err := c.MergeShardRanges(ranges); err != nil {
+			return err
+		}
+		if err := s.pushShardRanges(info.Account, info.Container, ranges); err != nil {
+			return err
+		}
+		s.rangesCreated.Inc(int64(len(ranges)))
+		s.logger.Info("Created shard ranges.", zap.String("account", info.Account), zap.String("container", info.Container),
+			zap.Int("ranges", len(ranges)))
+		state = common.ShardingStateSharding
+	}
+	sharded := state == common.ShardingStateSharded
+	for _, sr := range ranges {
+		count, err := s.cleave(dev, c, info, sr, sharded)
+		if sharded {
+			s.objectsMoved.Inc(count)
+		} else {
+			s.objectsCleaved.Inc(count)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !sharded {
+		if active, err := s.recordCleaved(dev, c, info, nodes, ranges); err != nil || !active {
+			if err != nil || !leader {
+				return err
+			}
+			// Ranges are resent every pass, in case a replica missed them.
+			return s.pushShardRanges(info.Account, info.Container, ranges)
+		}
+	}
+	if !leader {
+		return nil
+	}
+	if !sharded {
+		timestamp := common.GetTimestamp()
+		for _, sr := range ranges {
+			sr.State = common.ShardRangeActive
+			sr.Timestamp = timestamp
+		}
+		if err := c.MergeShardRanges(ranges); err != nil {
+			return err
+		}
+		s.sharded.Inc(1)
+		s.logger.Info("Sharded container.", zap.String("account", info.Account), zap.String("container", info.Container))
+	}
+	// Ranges are resent every pass, in case a replica missed them.
+	return s.pushShardRanges(info.Account, info.Container, ranges)
+}
+
+func (s *Sharder) shardDevice(dev *ring.Device) {
+	devicePath := filepath.Join(s.deviceRoot, dev.Device)
+	if stat, err := os.Stat(devicePath); err != nil || !stat.IsDir() {
+		s.logger.Error("Device doesn't exist.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	if mount, err := fs.IsMount(devicePath); s.checkMounts && (err != nil || !mount) {
+		s.logger.Error("Device not mounted.", zap.String("devicePath", devicePath), zap.Error(err))
+		return
+	}
+	results := make(chan string, 100)
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go findContainerDbs(devicePath, results, cancel, s.logger)
+	for dbFile := range results {
+		if err := s.shardDatabase(dev, dbFile); err != nil {
+			s.shardingErrors.Inc(1)
+			s.logger.Error("Error sharding database.", zap.String("dbFile", dbFile), zap.Error(err))
+		}
+	}
+}
+
+// Run runs a pass of the sharder once.
+func (s *Sharder) Run() {
+	devices, err := s.Ring.LocalDevices(s.serverPort)
+	if err != nil {
+		s.logger.Error("Error getting local devices from ring.", zap.Error(err))
+		return
+	}
+	start := time.Now()
+	for _, dev := range devices {
+		s.shardDevice(dev)
+	}
+	s.logger.Info("Sharding pass complete.", zap.Float64("seconds", time.Since(start).Seconds()))
+}
+
+// RunForever runs the sharder in a forever-loop.
+func (s *Sharder) RunForever() {
+	for {
+		start := time.Now()
+		s.Run()
+		if elapsed := time.Since(start); elapsed < s.interval {
+			time.Sleep(s.interval - elapsed)
+		}
+	}
+}
+
+func (s *Sharder) Type() string {
+	return "container-sharder"
+}
+
+func (s *Sharder) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			s.Run()
+		}()
+		return ch
+	}
+	go s.RunForever()
+	return nil
+}
+
+func (s *Sharder) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, s.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	s.setMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		s.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", s.logLevel)
+	router.Put("/loglevel", s.logLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(s.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (s *Sharder) Finalize() {
+	if s.metricsCloser != nil {
+		s.metricsCloser.Close()
+	}
+	s.engine.Close()
+}
+
+func (s *Sharder) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (s *Sharder) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(s.logger, next)
+}
+
+// NewSharder uses the config settings and command-line flags to configure and return a sharder daemon struct.
+func NewSharder(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	var ipPort *srv.IpPort
+	var err error
+	var logger srv.LowLevelLogger
+	if !serverconf.HasSection("container-sharder") {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container-sharder config section")
+	}
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	ring, err := cnf.GetRing("container", hashPathPrefix, hashPathSuffix, 0)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading container ring: %s", err)
+	}
+	logLevelString := serverconf.GetDefault("container-sharder", "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	if logger, err = srv.SetupLogger("container-sharder", &logLevel, flags); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	threshold := serverconf.GetInt("container-sharder", "shard_container_threshold", 1000000)
+	rowsPerShard := serverconf.GetInt("container-sharder", "rows_per_shard", threshold/2)
+	if rowsPerShard < 1 || rowsPerShard >= threshold {
+		return ipPort, nil, nil, fmt.Errorf("rows_per_shard must be between 1 and shard_container_threshold")
+	}
+	ip := serverconf.GetDefault("container-sharder", "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt("container-sharder", "bind_port", common.DefaultContainerSharderPort))
+	deviceRoot := serverconf.GetDefault("container-sharder", "devices", "/srv/node")
+	s := &Sharder{
+		checkMounts:  serverconf.GetBool("container-sharder", "mount_check", true),
+		deviceRoot:   deviceRoot,
+		serverPort:   int(serverconf.GetInt("container-replicator", "bind_port", common.DefaultContainerReplicatorPort)),
+		Ring:         ring,
+		engine:       newLRUEngine(deviceRoot, hashPathPrefix, hashPathSuffix, 32),
+		logger:       logger,
+		logLevel:     logLevel,
+		threshold:    threshold,
+		rowsPerShard: rowsPerShard,
+		batchSize:    int(serverconf.GetInt("container-sharder", "cleave_batch_size", 10000)),
+		interval:     time.Duration(serverconf.GetFloat("container-sharder", "interval", 30) * float64(time.Second)),
+		client: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				Dial:                (&net.Dialer{Timeout: time.Second}).Dial,
+				MaxIdleConnsPerHost: 100,
+				MaxIdleConns:        0,
+			},
+		},
+	}
+	s.setMetricsScope(tally.NoopScope)
+	ipPort = &srv.IpPort{Ip: ip, Port: port}
+	return ipPort, s, logger, nil
+}
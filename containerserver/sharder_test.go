@@ -0,0 +1,143 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type shardPushClient struct {
+	pushes     [][]*common.ShardRange
+	heads      int
+	headStatus int
+}
+
+func (c *shardPushClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method == "HEAD" {
+		c.heads++
+		return &http.Response{StatusCode: c.headStatus, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+	var ranges []*common.ShardRange
+	if err := json.NewDecoder(req.Body).Decode(&ranges); err != nil {
+		return nil, err
+	}
+	c.pushes = append(c.pushes, ranges)
+	return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestSharderShardDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	devs := []*ring.Device{
+		{Id: 0, Device: "sda", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+		{Id: 1, Device: "sdb", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+		{Id: 2, Device: "sdc", Scheme: "http", Ip: "127.0.0.1", Port: 6001},
+	}
+	client := &shardPushClient{}
+	s := &Sharder{
+		deviceRoot:   dir,
+		Ring:         &test.FakeRing{MockDevices: devs},
+		engine:       newLRUEngine(dir, "changeme", "changeme", 32),
+		client:       client,
+		logger:       zap.NewNop(),
+		threshold:    5,
+		rowsPerShard: 3,
+		batchSize:    2,
+	}
+	s.setMetricsScope(tally.NoopScope)
+	defer s.engine.Close()
+
+	vars := map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c"}
+	_, root, err := s.engine.Create(vars, "100000000.00000", nil, 0, 0)
+	require.Nil(t, err)
+	require.Nil(t, mergeItemsByName(root, []string{"a", "b", "c", "d", "e", "f", "g"}))
+	s.engine.Return(root)
+	dbFiles, err := filepath.Glob(filepath.Join(dir, "sda", "containers", "0", "*", "*", "*.db"))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(dbFiles))
+
+	require.Nil(t, s.shardDatabase(devs[0], dbFiles[0]))
+	// Each push goes to all three primaries.
+	require.Equal(t, 6, len(client.pushes))
+	require.Equal(t, 3, len(client.pushes[0]))
+	require.Equal(t, common.ShardingStateSharding, common.ShardingState(client.pushes[0]))
+	// The other replicas haven't cleaved yet, so the ranges stay sharding.
+	require.Equal(t, common.ShardingStateSharding, common.ShardingState(client.pushes[3]))
+	require.Equal(t, 0, client.heads)
+
+	// Once their marks have replicated in, the shards have to reach their primaries too.
+	epoch := client.pushes[0][0].Timestamp
+	root, err = s.engine.Get(vars)
+	require.Nil(t, err)
+	rootInfo, err := root.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, epoch, metadataValue(rootInfo.Metadata, shardCleavedPrefix+"0"))
+	require.Nil(t, root.UpdateMetadata(map[string][]string{
+		shardCleavedPrefix + "1": {epoch, epoch},
+		shardCleavedPrefix + "2": {epoch, epoch},
+	}, epoch))
+	s.engine.Return(root)
+	client.headStatus = http.StatusNotFound
+	require.Nil(t, s.shardDatabase(devs[0], dbFiles[0]))
+	require.Equal(t, 9, len(client.pushes))
+	require.Equal(t, common.ShardingStateSharding, common.ShardingState(client.pushes[6]))
+	require.NotEqual(t, 0, client.heads)
+
+	client.headStatus = http.StatusNoContent
+	require.Nil(t, s.shardDatabase(devs[0], dbFiles[0]))
+	require.Equal(t, 12, len(client.pushes))
+	ranges := client.pushes[9]
+	require.Equal(t, common.ShardingStateSharded, common.ShardingState(ranges))
+	require.Equal(t, "", ranges[0].Lower)
+	require.Equal(t, "c", ranges[0].Upper)
+	require.Equal(t, "f", ranges[2].Lower)
+	require.Equal(t, "", ranges[2].Upper)
+
+	shard, err := s.engine.Get(map[string]string{"device": "sda", "partition": "0", "account": ranges[1].Account, "container": ranges[1].Container})
+	require.Nil(t, err)
+	listing, err := shard.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(listing))
+	require.Equal(t, "d", listing[0].(*ObjectListingRecord).Name)
+	info, err := shard.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, "a/c", metadataValue(info.Metadata, shardRootKey))
+	require.Equal(t, "c", metadataValue(info.Metadata, shardLowerKey))
+	s.engine.Return(shard)
+
+	// Once sharded, the objects are removed from the root.
+	require.Nil(t, s.shardDatabase(devs[0], dbFiles[0]))
+	root, err = s.engine.Get(vars)
+	require.Nil(t, err)
+	records, err := root.(ShardableContainer).ItemsInRange("", "", 100)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(records))
+	s.engine.Return(root)
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/srv"
+	"go.uber.org/zap"
+)
+
+const (
+	// createSnapshotHeader on a container POST creates a snapshot named for the request's X-Timestamp.
+	createSnapshotHeader = "X-Backend-Create-Snapshot"
+	// deleteSnapshotHeader on a container POST deletes the snapshot it names.
+	deleteSnapshotHeader = "X-Backend-Delete-Snapshot"
+	// snapshotExpireAfterHeader overrides how many seconds a new snapshot is kept.
+	snapshotExpireAfterHeader = "X-Snapshot-Expire-After"
+)
+
+// snapshotPost creates or deletes a container snapshot.  New snapshots are named for the request's timestamp, which
+// the proxy sends to every replica, so the same name works wherever the listing is served from.
+func (server *ContainerServer) snapshotPost(writer http.ResponseWriter, request *http.Request, db Container, timestamp string) {
+	sc, ok := db.(SnapshotContainer)
+	if !ok {
+		srv.StandardResponse(writer, http.StatusNotImplemented)
+		return
+	}
+	if name := request.Header.Get(deleteSnapshotHeader); name != "" {
+		if err := sc.DeleteSnapshot(name); err == ErrorNoSuchSnapshot {
+			srv.StandardResponse(writer, http.StatusNotFound)
+		} else if err != nil {
+			srv.GetLogger(request).Error("Unable to delete snapshot.", zap.Error(err))
+			srv.StandardResponse(writer, http.StatusInternalServerError)
+		} else {
+			writer.WriteHeader(http.StatusNoContent)
+			writer.Write([]byte(""))
+		}
+		return
+	}
+	// A sharded container's objects live in its shards, which a snapshot of the root wouldn't see.
+	ranges, err := shardRanges(db)
+	if err != nil {
+		srv.GetLogger(request).Error("Unable to get shard ranges.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	if common.ShardingState(ranges) != common.ShardingStateUnsharded {
+		srv.SimpleErrorResponse(writer, http.StatusConflict, "Sharded containers can't be snapshotted.")
+		return
+	}
+	expireAfter := server.snapshotExpireAfter
+	if value := request.Header.Get(snapshotExpireAfterHeader); value != "" {
+		if expireAfter, err = strconv.ParseInt(value, 10, 64); err != nil || expireAfter <= 0 {
+			srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Invalid "+snapshotExpireAfterHeader)
+			return
+		}
+	}
+	created, err := strconv.ParseFloat(strings.SplitN(timestamp, "_", 2)[0], 64)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	if err := sc.CreateSnapshot(timestamp, common.CanonicalTimestamp(created+float64(expireAfter))); err != nil {
+		srv.GetLogger(request).Error("Unable to create snapshot.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("X-Container-Snapshot", timestamp)
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write([]byte(""))
+}
+
+// writeSnapshotJSON writes the json response for snapshot requests, which have no other formats.
+func writeSnapshotJSON(writer http.ResponseWriter, request *http.Request, v interface{}, err error) {
+	if err == ErrorNoSuchSnapshot {
+		srv.StandardResponse(writer, http.StatusNotFound)
+		return
+	} else if err == ErrorSnapshotOrder {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		srv.GetLogger(request).Error("Unable to read snapshots.", zap.Error(err))
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(v)
+	if err != nil {
+		srv.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(output)
+}
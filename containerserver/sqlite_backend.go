@@ -18,6 +18,7 @@ package containerserver
 import (
 	"crypto/md5"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -29,6 +30,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,6 +39,7 @@ import (
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/dbdaemon"
 	"github.com/troubling/hummingbird/common/fs"
 	"github.com/troubling/hummingbird/common/pickle"
 )
@@ -100,6 +103,42 @@ type sqliteContainer struct {
 }
 
 var _ Container = &sqliteContainer{}
+var _ ShardableContainer = &sqliteContainer{}
+var _ SyncableContainer = &sqliteContainer{}
+var _ ReconcilableContainer = &sqliteContainer{}
+var _ AuditableContainer = &sqliteContainer{}
+var _ IndexedContainer = &sqliteContainer{}
+var _ SnapshotContainer = &sqliteContainer{}
+var _ CompactableContainer = &sqliteContainer{}
+
+// metaColumn stores an object's indexed metadata as json in the object table's meta column.
+type metaColumn struct {
+	meta *map[string]string
+}
+
+func (c metaColumn) Scan(value interface{}) error {
+	*c.meta = nil
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("Invalid object meta column type %T", value)
+	}
+	return json.Unmarshal(raw, c.meta)
+}
+
+func (c metaColumn) Value() (driver.Value, error) {
+	if len(*c.meta) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(*c.meta)
+	return string(raw), err
+}
 
 func (db *sqliteContainer) connect() error {
 	db.connectLock.Lock()
@@ -150,14 +189,14 @@ func (db *sqliteContainer) GetInfo() (*ContainerInfo, error) {
 							cs.reported_put_timestamp, cs.reported_delete_timestamp,
 							cs.reported_object_count, cs.reported_bytes_used, cs.hash,
 							cs.id, cs.x_container_sync_point1, cs.x_container_sync_point2,
-							cs.storage_policy_index, cs.metadata, maxrowid.max
+							cs.reconciler_sync_point, cs.storage_policy_index, cs.metadata, maxrowid.max
 						FROM container_stat cs, maxrowid`)
 	if err := row.Scan(&info.Account, &info.Container, &info.CreatedAt, &info.PutTimestamp,
 		&info.DeleteTimestamp, &info.StatusChangedAt, &info.ObjectCount,
 		&info.BytesUsed, &info.ReportedPutTimestamp, &info.ReportedDeleteTimestamp,
 		&info.ReportedObjectCount, &info.ReportedBytesUsed, &info.Hash,
 		&info.ID, &info.XContainerSyncPoint1, &info.XContainerSyncPoint2,
-		&info.StoragePolicyIndex, &info.RawMetadata, &info.MaxRow); err != nil {
+		&info.ReconcilerSyncPoint, &info.StoragePolicyIndex, &info.RawMetadata, &info.MaxRow); err != nil {
 		if common.IsCorruptDBError(err) {
 			return nil, fmt.Errorf("Failed to GetInfo: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
 		}
@@ -309,12 +348,18 @@ func (db *sqliteContainer) MergeItems(records []*ObjectRecord, remoteID string)
 	}
 	defer dst.Close()
 
-	ast, err := tx.Prepare("INSERT INTO object (name, created_at, size, content_type, etag, deleted, storage_policy_index, expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	ast, err := tx.Prepare("INSERT INTO object (name, created_at, size, content_type, etag, deleted, storage_policy_index, expires, meta) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer ast.Close()
 
+	mst, err := tx.Prepare("INSERT OR REPLACE INTO object_meta (name, storage_policy_index, key, value) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer mst.Close()
+
 	var maxRowid int64 = -1
 	for _, record := range records {
 		if record.Rowid > maxRowid {
@@ -339,12 +384,21 @@ func (db *sqliteContainer) MergeItems(records []*ObjectRecord, remoteID string)
 	}
 
 	for _, record := range toAdd {
-		if _, err := ast.Exec(record.Name, record.CreatedAt, record.Size, record.ContentType, record.ETag, record.Deleted, record.StoragePolicyIndex, record.Expires); err != nil {
+		if _, err := ast.Exec(record.Name, record.CreatedAt, record.Size, record.ContentType, record.ETag, record.Deleted, record.StoragePolicyIndex, record.Expires, metaColumn{&record.Meta}); err != nil {
 			if common.IsCorruptDBError(err) {
 				return fmt.Errorf("Failed to MergeItems INSERT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
 			}
 			return err
 		}
+		// The old row's index entries were removed along with it by the object_delete_object_meta trigger.
+		for key, value := range record.Meta {
+			if _, err := mst.Exec(record.Name, record.StoragePolicyIndex, key, value); err != nil {
+				if common.IsCorruptDBError(err) {
+					return fmt.Errorf("Failed to MergeItems INSERT meta: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+				}
+				return err
+			}
+		}
 	}
 
 	if remoteID != "" && maxRowid > -1 {
@@ -384,18 +438,80 @@ func updateRecord(rec *ObjectListingRecord) error {
 	whole, nans := math.Modf(f)
 	rec.LastModified = time.Unix(int64(whole), int64(nans*1.0e9)).In(common.GMT).Format("2006-01-02T15:04:05.000000")
 
+	rec.ContentType, rec.SymlinkPath = common.ParseContentTypeForSymlink(rec.ContentType)
 	rec.ContentType, rec.Size, err = common.ParseContentTypeForSlo(
 		rec.ContentType, rec.Size)
 	return err
 }
 
+// filterWheres returns the where clauses and their arguments for a listing filter.
+func filterWheres(filter *ListingFilter, storagePolicyIndex int) ([]string, []interface{}) {
+	var wheres []string
+	var args []interface{}
+	if filter == nil {
+		return wheres, args
+	}
+	if strings.HasSuffix(filter.ContentType, "/") {
+		wheres = append(wheres, "content_type BETWEEN ? AND ?")
+		args = append(args, filter.ContentType, filter.ContentType+"\xFF")
+	} else if filter.ContentType != "" {
+		// Content types may have parameters, like swift_bytes for large objects.
+		wheres = append(wheres, "(content_type = ? OR content_type BETWEEN ? AND ?)")
+		args = append(args, filter.ContentType, filter.ContentType+";", filter.ContentType+";\xFF")
+	}
+	if filter.ModifiedSince != "" {
+		wheres = append(wheres, "created_at > ?")
+		args = append(args, filter.ModifiedSince)
+	}
+	if filter.ModifiedBefore != "" {
+		wheres = append(wheres, "created_at < ?")
+		args = append(args, filter.ModifiedBefore)
+	}
+	if filter.SizeGT != nil {
+		wheres = append(wheres, "size > ?")
+		args = append(args, *filter.SizeGT)
+	}
+	if filter.SizeLT != nil {
+		wheres = append(wheres, "size < ?")
+		args = append(args, *filter.SizeLT)
+	}
+	keys := make([]string, 0, len(filter.Meta))
+	for key := range filter.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		wheres = append(wheres, "name IN (SELECT name FROM object_meta WHERE storage_policy_index = ? AND key = ? AND value = ?)")
+		args = append(args, storagePolicyIndex, key, filter.Meta[key])
+	}
+	return wheres, args
+}
+
 // ListObjects implements object listings.  Path is a string pointer because behavior is different for empty and missing path query parameters.
 func (db *sqliteContainer) ListObjects(limit int, marker string, endMarker string, prefix string, delimiter string,
 	pth *string, reverse bool, storagePolicyIndex int) ([]interface{}, error) {
+	return db.ListObjectsFiltered(limit, marker, endMarker, prefix, delimiter, pth, reverse, storagePolicyIndex, nil)
+}
+
+// ListObjectsFiltered implements object listings of only the objects matching filter.
+func (db *sqliteContainer) ListObjectsFiltered(limit int, marker string, endMarker string, prefix string, delimiter string,
+	pth *string, reverse bool, storagePolicyIndex int, filter *ListingFilter) ([]interface{}, error) {
 	if err := db.connect(); err != nil {
 		return nil, err
 	}
-	var point, pointDirection, queryTail, queryStart string
+	filters, filterArgs := filterWheres(filter, storagePolicyIndex)
+	queryStart := "SELECT name, created_at, size, content_type, etag FROM object WHERE +deleted = 0 AND"
+	if db.hasDeletedNameIndex {
+		queryStart = "SELECT name, created_at, size, content_type, etag FROM object WHERE deleted = 0 AND"
+	}
+	return db.listObjects(queryStart, nil, limit, marker, endMarker, prefix, delimiter, pth, reverse, storagePolicyIndex, filters, filterArgs)
+}
+
+// listObjects pages through the listing selected by queryStart, which is given its own arguments, applying the markers,
+// prefix, and delimiter along with any extra where clauses.
+func (db *sqliteContainer) listObjects(queryStart string, startArgs []interface{}, limit int, marker string, endMarker string,
+	prefix string, delimiter string, pth *string, reverse bool, storagePolicyIndex int, filters []string, filterArgs []interface{}) ([]interface{}, error) {
+	var point, pointDirection, queryTail string
 
 	if pth != nil {
 		if *pth != "" {
@@ -405,11 +521,6 @@ func (db *sqliteContainer) ListObjects(limit int, marker string, endMarker strin
 		delimiter = "/"
 		prefix = *pth
 	}
-	if db.hasDeletedNameIndex {
-		queryStart = "SELECT name, created_at, size, content_type, etag FROM object WHERE deleted = 0 AND"
-	} else {
-		queryStart = "SELECT name, created_at, size, content_type, etag FROM object WHERE +deleted = 0 AND"
-	}
 	if reverse {
 		marker, endMarker = endMarker, marker
 		queryTail = "ORDER BY name DESC LIMIT ?"
@@ -426,7 +537,7 @@ func (db *sqliteContainer) ListObjects(limit int, marker string, endMarker strin
 
 	for len(results) < limit && gotResults {
 		wheres := append(wheres[:0], "storage_policy_index == ?")
-		queryArgs := append(queryArgs[:0], storagePolicyIndex)
+		queryArgs := append(append(queryArgs[:0], startArgs...), storagePolicyIndex)
 		if prefix != "" {
 			wheres = append(wheres, "name BETWEEN ? AND ?")
 			queryArgs = append(queryArgs, prefix, prefix+"\xFF")
@@ -443,6 +554,8 @@ func (db *sqliteContainer) ListObjects(limit int, marker string, endMarker strin
 			wheres = append(wheres, pointDirection)
 			queryArgs = append(queryArgs, point)
 		}
+		wheres = append(wheres, filters...)
+		queryArgs = append(queryArgs, filterArgs...)
 		rows, err := db.Query(queryStart+" "+strings.Join(wheres, " AND ")+" "+queryTail,
 			append(queryArgs, limit-len(results))...)
 		if err != nil {
@@ -525,7 +638,7 @@ func (db *sqliteContainer) NewID() error {
 func (db *sqliteContainer) ItemsSince(start int64, count int) ([]*ObjectRecord, error) {
 	db.flush()
 	records := []*ObjectRecord{}
-	rows, err := db.Query(`SELECT ROWID, name, created_at, size, content_type, etag, deleted, storage_policy_index, expires
+	rows, err := db.Query(`SELECT ROWID, name, created_at, size, content_type, etag, deleted, storage_policy_index, expires, meta
 						   FROM object WHERE ROWID > ? ORDER BY ROWID ASC LIMIT ?`, start, count)
 	if err != nil {
 		if common.IsCorruptDBError(err) {
@@ -535,7 +648,7 @@ func (db *sqliteContainer) ItemsSince(start int64, count int) ([]*ObjectRecord,
 	}
 	for rows.Next() {
 		r := &ObjectRecord{}
-		if err := rows.Scan(&r.Rowid, &r.Name, &r.CreatedAt, &r.Size, &r.ContentType, &r.ETag, &r.Deleted, &r.StoragePolicyIndex, &r.Expires); err != nil {
+		if err := rows.Scan(&r.Rowid, &r.Name, &r.CreatedAt, &r.Size, &r.ContentType, &r.ETag, &r.Deleted, &r.StoragePolicyIndex, &r.Expires, metaColumn{&r.Meta}); err != nil {
 			if common.IsCorruptDBError(err) {
 				return nil, fmt.Errorf("Failed to ItemsSince Scan: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
 			}
@@ -568,7 +681,8 @@ func (db *sqliteContainer) GetMetadata() (map[string]string, error) {
 	return metadata, nil
 }
 
-func (db *sqliteContainer) mergeMetas(a map[string][]string, b map[string][]string, deleteTimestamp string) (string, error) {
+// mergeMetas merges two sets of metadata, keeping the newer value of each key and tombstoning any older than deleteTimestamp.
+func mergeMetas(a map[string][]string, b map[string][]string, deleteTimestamp string) (string, error) {
 	newMeta := map[string][]string{}
 	for k, v := range a {
 		newMeta[k] = v
@@ -629,7 +743,7 @@ func (db *sqliteContainer) UpdateMetadata(newMetadata map[string][]string, times
 	} else if err := json.Unmarshal([]byte(metadataValue), &existingMetadata); err != nil {
 		return err
 	}
-	metastr, err := db.mergeMetas(existingMetadata, newMetadata, deleteTimestamp)
+	metastr, err := mergeMetas(existingMetadata, newMetadata, deleteTimestamp)
 	if err != nil {
 		return err
 	}
@@ -691,7 +805,19 @@ func (db *sqliteContainer) CleanupTombstones(reclaimAge int64) error {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err = tx.Exec("DELETE FROM object WHERE deleted=1 AND created_at < ?", reclaimTimestamp); err != nil {
+	if _, err = tx.Exec("DELETE FROM container_snapshot WHERE expires <= ?", common.CanonicalTimestamp(now)); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to CleanupTombstones DELETE snapshots: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	if err := pruneSnapshotObjects(tx); err != nil {
+		return err
+	}
+	// Tombstones between the oldest and newest snapshots are pinned, since diffs between them still need them.
+	if _, err = tx.Exec(`DELETE FROM object WHERE deleted=1 AND created_at < ? AND NOT (
+						 ROWID > (SELECT IFNULL(MIN(max_row), -1) FROM container_snapshot) AND
+						 ROWID <= (SELECT IFNULL(MAX(max_row), -1) FROM container_snapshot))`, reclaimTimestamp); err != nil {
 		if common.IsCorruptDBError(err) {
 			return fmt.Errorf("Failed to CleanupTombstones DELETE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
 		}
@@ -807,7 +933,7 @@ func (db *sqliteContainer) SyncRemoteData(maxRow int64, hash, id, createdAt, put
 	if deleteTimestamp > localDeleteTimestamp {
 		localDeleteTimestamp = deleteTimestamp
 	}
-	metastr, err := db.mergeMetas(lm, rm, localDeleteTimestamp)
+	metastr, err := mergeMetas(lm, rm, localDeleteTimestamp)
 	if _, err = tx.Exec(`UPDATE container_info SET created_at=MIN(?, created_at), put_timestamp=MAX(?, put_timestamp),
 	  					 delete_timestamp=MAX(?, delete_timestamp), metadata=?`,
 		createdAt, putTimestamp, deleteTimestamp, metastr); err != nil {
@@ -1003,7 +1129,7 @@ func (db *sqliteContainer) flush() error {
 	return db.flushAlreadyLocked()
 }
 
-func (db *sqliteContainer) addObject(name string, timestamp string, size int64, contentType string, etag string, deleted int, storagePolicyIndex int, expires string) error {
+func (db *sqliteContainer) addObject(name string, timestamp string, size int64, contentType string, etag string, deleted int, storagePolicyIndex int, expires string, meta map[string]string) error {
 	lock, err := fs.LockPath(filepath.Dir(db.containerFile), 10*time.Second)
 	if err != nil {
 		return err
@@ -1018,6 +1144,7 @@ func (db *sqliteContainer) addObject(name string, timestamp string, size int64,
 		Deleted:            deleted,
 		StoragePolicyIndex: storagePolicyIndex,
 		Expires:            &expires,
+		Meta:               meta,
 	}
 	if expires == "" {
 		rec.Expires = nil
@@ -1042,12 +1169,17 @@ func (db *sqliteContainer) addObject(name string, timestamp string, size int64,
 
 // PutObject adds an object to the container, by way of pending file.
 func (db *sqliteContainer) PutObject(name string, timestamp string, size int64, contentType string, etag string, storagePolicyIndex int, expires string) error {
-	return db.addObject(name, timestamp, size, contentType, etag, 0, storagePolicyIndex, expires)
+	return db.addObject(name, timestamp, size, contentType, etag, 0, storagePolicyIndex, expires, nil)
+}
+
+// PutObjectMeta adds an object and its indexed metadata to the container, by way of pending file.
+func (db *sqliteContainer) PutObjectMeta(name string, timestamp string, size int64, contentType string, etag string, storagePolicyIndex int, expires string, meta map[string]string) error {
+	return db.addObject(name, timestamp, size, contentType, etag, 0, storagePolicyIndex, expires, meta)
 }
 
 // DeleteObject removes an object from the container, by way of pending file.
 func (db *sqliteContainer) DeleteObject(name string, timestamp string, storagePolicyIndex int) error {
-	return db.addObject(name, timestamp, 0, "", "", 1, storagePolicyIndex, "")
+	return db.addObject(name, timestamp, 0, "", "", 1, storagePolicyIndex, "", nil)
 }
 
 // Close closes the underlying sqlite database connection.
@@ -1105,7 +1237,7 @@ func sqliteCreateExistingContainer(db Container, putTimestamp string, newMetadat
 	} else if err := json.Unmarshal([]byte(cMetadata), &existingMetadata); err != nil {
 		return false, err
 	}
-	metastr, err := cdb.mergeMetas(existingMetadata, newMetadata, cDeleteTimestamp)
+	metastr, err := mergeMetas(existingMetadata, newMetadata, cDeleteTimestamp)
 	if err != nil {
 		return false, err
 	}
@@ -1116,6 +1248,16 @@ func sqliteCreateExistingContainer(db Container, putTimestamp string, newMetadat
 		}
 		return false, err
 	}
+	recreated := cDeleteTimestamp > cPutTimestamp && putTimestamp > cDeleteTimestamp
+	if recreated {
+		// A recreated container may have a new storage policy, which should win over any replicas that missed it.
+		if _, err := tx.Exec("UPDATE container_info SET status_changed_at = ?", putTimestamp); err != nil {
+			if common.IsCorruptDBError(err) {
+				return false, fmt.Errorf("Failed to sqliteCreateExistingContainer UPDATE: %v; %v", err, common.QuarantineDir(path.Dir(cdb.containerFile), 4, "containers"))
+			}
+			return false, err
+		}
+	}
 	defer cdb.invalidateCache()
 	if err := tx.Commit(); err != nil {
 		if common.IsCorruptDBError(err) {
@@ -1123,7 +1265,7 @@ func sqliteCreateExistingContainer(db Container, putTimestamp string, newMetadat
 		}
 		return false, err
 	}
-	return (cDeleteTimestamp > cPutTimestamp && putTimestamp > cDeleteTimestamp), nil
+	return recreated, nil
 }
 
 func sqliteCreateContainer(containerFile string, account string, container string, putTimestamp string,
@@ -1171,7 +1313,7 @@ func sqliteCreateContainer(containerFile string, account string, container strin
 	}
 	defer tx.Rollback()
 	if _, err := tx.Exec(objectTableScript + policyStatTableScript + policyStatTriggerScript +
-		containerInfoTableScript + containerStatViewScript + syncTableScript); err != nil {
+		containerInfoTableScript + containerStatViewScript + syncTableScript + shardRangeTableScript + objectMetaTableScript + snapshotTableScript); err != nil {
 		return err
 	}
 	if _, err := tx.Exec(`INSERT INTO container_info (account, container, created_at, id, put_timestamp,
@@ -1228,3 +1370,586 @@ func (db *sqliteContainer) Reported(putTimestamp, deleteTimestamp string, object
 	}
 	return nil
 }
+
+// ShardRanges returns the container's shard ranges, ordered by lower bound.
+func (db *sqliteContainer) ShardRanges(includeDeleted bool) ([]*common.ShardRange, error) {
+	if err := db.connect(); err != nil {
+		return nil, err
+	}
+	query := `SELECT account, container, lower, upper, object_count, bytes_used, state, timestamp, meta_timestamp, deleted
+			  FROM shard_range`
+	if !includeDeleted {
+		query += " WHERE deleted = 0"
+	}
+	rows, err := db.Query(query + " ORDER BY lower")
+	if err != nil {
+		if common.IsCorruptDBError(err) {
+			return nil, fmt.Errorf("Failed to ShardRanges SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	ranges := []*common.ShardRange{}
+	for rows.Next() {
+		sr := &common.ShardRange{}
+		if err := rows.Scan(&sr.Account, &sr.Container, &sr.Lower, &sr.Upper, &sr.ObjectCount, &sr.BytesUsed,
+			&sr.State, &sr.Timestamp, &sr.MetaTimestamp, &sr.Deleted); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, sr)
+	}
+	return ranges, rows.Err()
+}
+
+// MergeShardRanges merges shard range records into the container.  The bounds and state of a range come from
+// the record with the newest timestamp, and its object stats from the record with the newest meta timestamp.
+func (db *sqliteContainer) MergeShardRanges(ranges []*common.ShardRange) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, sr := range ranges {
+		existing := common.ShardRange{}
+		err := tx.QueryRow(`SELECT lower, upper, object_count, bytes_used, state, timestamp, meta_timestamp, deleted
+							FROM shard_range WHERE account = ? AND container = ?`, sr.Account, sr.Container).Scan(
+			&existing.Lower, &existing.Upper, &existing.ObjectCount, &existing.BytesUsed, &existing.State,
+			&existing.Timestamp, &existing.MetaTimestamp, &existing.Deleted)
+		if err == sql.ErrNoRows {
+			if _, err := tx.Exec(`INSERT INTO shard_range (account, container, lower, upper, object_count, bytes_used,
+								  state, timestamp, meta_timestamp, deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				sr.Account, sr.Container, sr.Lower, sr.Upper, sr.ObjectCount, sr.BytesUsed, sr.State,
+				sr.Timestamp, sr.MetaTimestamp, sr.Deleted); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			if common.IsCorruptDBError(err) {
+				return fmt.Errorf("Failed to MergeShardRanges SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+			}
+			return err
+		}
+		if sr.Timestamp > existing.Timestamp {
+			existing.Lower, existing.Upper, existing.State, existing.Timestamp, existing.Deleted = sr.Lower, sr.Upper, sr.State, sr.Timestamp, sr.Deleted
+		}
+		if sr.MetaTimestamp > existing.MetaTimestamp {
+			existing.ObjectCount, existing.BytesUsed, existing.MetaTimestamp = sr.ObjectCount, sr.BytesUsed, sr.MetaTimestamp
+		}
+		if _, err := tx.Exec(`UPDATE shard_range SET lower = ?, upper = ?, object_count = ?, bytes_used = ?, state = ?,
+							  timestamp = ?, meta_timestamp = ?, deleted = ? WHERE account = ? AND container = ?`,
+			existing.Lower, existing.Upper, existing.ObjectCount, existing.BytesUsed, existing.State,
+			existing.Timestamp, existing.MetaTimestamp, existing.Deleted, sr.Account, sr.Container); err != nil {
+			return err
+		}
+	}
+	defer db.invalidateCache()
+	if err := tx.Commit(); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to MergeShardRanges Commit: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	return nil
+}
+
+// ShardPoints returns the names of every rowsPerShard'th undeleted object, which are used as the upper bounds of
+// new shard ranges.
+func (db *sqliteContainer) ShardPoints(rowsPerShard int64) ([]string, error) {
+	if err := db.flush(); err != nil {
+		return nil, err
+	}
+	query := "SELECT name FROM object WHERE deleted = 0 AND name > ? ORDER BY name LIMIT 1 OFFSET ?"
+	if !db.hasDeletedNameIndex {
+		query = "SELECT name FROM object WHERE +deleted = 0 AND name > ? ORDER BY name LIMIT 1 OFFSET ?"
+	}
+	points := []string{}
+	marker := ""
+	for {
+		var name string
+		if err := db.QueryRow(query, marker, rowsPerShard-1).Scan(&name); err == sql.ErrNoRows {
+			return points, nil
+		} else if err != nil {
+			if common.IsCorruptDBError(err) {
+				return nil, fmt.Errorf("Failed to ShardPoints SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+			}
+			return nil, err
+		}
+		points = append(points, name)
+		marker = name
+	}
+}
+
+// ItemsInRange returns up to count object records, including tombstones, with names greater than marker and no
+// greater than upper.  An empty upper is unbounded.
+func (db *sqliteContainer) ItemsInRange(marker, upper string, count int) ([]*ObjectRecord, error) {
+	if err := db.flush(); err != nil {
+		return nil, err
+	}
+	wheres := "name > ?"
+	if db.hasDeletedNameIndex {
+		wheres = "deleted IN (0, 1) AND name > ?"
+	}
+	args := []interface{}{marker}
+	if upper != "" {
+		wheres += " AND name <= ?"
+		args = append(args, upper)
+	}
+	rows, err := db.Query(`SELECT ROWID, name, created_at, size, content_type, etag, deleted, storage_policy_index, expires, meta
+						   FROM object WHERE `+wheres+" ORDER BY name LIMIT ?", append(args, count)...)
+	if err != nil {
+		if common.IsCorruptDBError(err) {
+			return nil, fmt.Errorf("Failed to ItemsInRange SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	records := []*ObjectRecord{}
+	for rows.Next() {
+		r := &ObjectRecord{}
+		if err := rows.Scan(&r.Rowid, &r.Name, &r.CreatedAt, &r.Size, &r.ContentType, &r.ETag, &r.Deleted, &r.StoragePolicyIndex, &r.Expires, metaColumn{&r.Meta}); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// RemoveItems deletes the object rows outright, without leaving tombstones.  It's used once the rows have been
+// moved to a shard container.
+func (db *sqliteContainer) RemoveItems(records []*ObjectRecord) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := 0; i < len(records); i += maxQueryArgs {
+		j := i + maxQueryArgs
+		if j > len(records) {
+			j = len(records)
+		}
+		rowids := make([]interface{}, 0, j-i)
+		for _, r := range records[i:j] {
+			rowids = append(rowids, r.Rowid)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM object WHERE ROWID IN (%s)",
+			strings.TrimRight(strings.Repeat("?,", len(rowids)), ",")), rowids...); err != nil {
+			if common.IsCorruptDBError(err) {
+				return fmt.Errorf("Failed to RemoveItems DELETE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+			}
+			return err
+		}
+	}
+	defer db.invalidateCache()
+	return tx.Commit()
+}
+
+// SetSyncPoints records the ROWIDs container sync has gotten through.  Point1 is as far as this node has synced the
+// objects it's responsible for, point2 as far as it has double checked the objects other nodes are responsible for.
+func (db *sqliteContainer) SetSyncPoints(point1, point2 int64) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	defer db.invalidateCache()
+	if _, err := db.Exec("UPDATE container_info SET x_container_sync_point1 = ?, x_container_sync_point2 = ?", point1, point2); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to SetSyncPoints UPDATE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	return nil
+}
+
+// SetStoragePolicy changes the container's storage policy.  Objects already recorded under the old policy are left
+// for the reconciler to move.
+func (db *sqliteContainer) SetStoragePolicy(policyIndex int, statusChangedAt string) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE container_info SET storage_policy_index = ?, status_changed_at = ?", policyIndex, statusChangedAt); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to SetStoragePolicy UPDATE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO policy_stat (storage_policy_index) VALUES (?)", policyIndex); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to SetStoragePolicy INSERT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	defer db.invalidateCache()
+	return tx.Commit()
+}
+
+// MisplacedItems returns (count) object records with a rowid greater than (start) that are recorded under a storage
+// policy other than the container's.
+func (db *sqliteContainer) MisplacedItems(start int64, count int) ([]*ObjectRecord, error) {
+	db.flush()
+	records := []*ObjectRecord{}
+	rows, err := db.Query(`SELECT ROWID, name, created_at, size, content_type, etag, deleted, storage_policy_index, expires, meta
+						   FROM object WHERE ROWID > ? AND storage_policy_index != (SELECT storage_policy_index FROM container_info)
+						   ORDER BY ROWID ASC LIMIT ?`, start, count)
+	if err != nil {
+		if common.IsCorruptDBError(err) {
+			return nil, fmt.Errorf("Failed to MisplacedItems SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := &ObjectRecord{}
+		if err := rows.Scan(&r.Rowid, &r.Name, &r.CreatedAt, &r.Size, &r.ContentType, &r.ETag, &r.Deleted, &r.StoragePolicyIndex, &r.Expires, metaColumn{&r.Meta}); err != nil {
+			if common.IsCorruptDBError(err) {
+				return nil, fmt.Errorf("Failed to MisplacedItems Scan: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+			}
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		if common.IsCorruptDBError(err) {
+			return nil, fmt.Errorf("Failed to MisplacedItems Err: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return nil, err
+	}
+	return records, nil
+}
+
+// SetReconcilerSyncPoint records the ROWID through which misplaced objects have been queued for the reconciler.
+func (db *sqliteContainer) SetReconcilerSyncPoint(point int64) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	defer db.invalidateCache()
+	if _, err := db.Exec("UPDATE container_info SET reconciler_sync_point = ?", point); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to SetReconcilerSyncPoint UPDATE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	return nil
+}
+
+// Audit runs sqlite's quick_check on the database and recomputes its hash from the object records.  A hash that
+// changes while being recomputed isn't treated as a failure, since the container may just be busy.
+func (db *sqliteContainer) Audit() error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return dbdaemon.AuditError(fmt.Sprintf("quick_check failed: %s", result))
+	}
+	var before, after string
+	if err := db.QueryRow("SELECT hash FROM container_info").Scan(&before); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT name, created_at FROM object")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	hash := "00000000000000000000000000000000"
+	for rows.Next() {
+		var name, createdAt string
+		if err := rows.Scan(&name, &createdAt); err != nil {
+			return err
+		}
+		hash = chexor(hash, name, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT hash FROM container_info").Scan(&after); err != nil {
+		return err
+	}
+	if before == after && hash != before {
+		return dbdaemon.AuditError(fmt.Sprintf("hash %s doesn't match object records %s", before, hash))
+	}
+	return nil
+}
+
+// freeSpace returns the size of the database and how much of it is free pages that compaction would reclaim.
+func (db *sqliteContainer) freeSpace() (int64, int64, error) {
+	if err := db.connect(); err != nil {
+		return 0, 0, err
+	}
+	var size, free int64
+	if err := db.QueryRow(`SELECT page_size * page_count, page_size * freelist_count
+						   FROM pragma_page_size, pragma_page_count, pragma_freelist_count`).Scan(&size, &free); err != nil {
+		if common.IsCorruptDBError(err) {
+			return 0, 0, fmt.Errorf("Failed to get free space: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return 0, 0, err
+	}
+	return size, free, nil
+}
+
+// Compact rewrites the database without its free pages if it's at least minSize bytes and at least minFree of it is
+// free, returning its size before and after.  The database is vacuumed in place rather than with VACUUM INTO and a
+// rename: the vendored sqlite predates VACUUM INTO, and connections other processes hold to the renamed-over file
+// would keep writing to it and its wal.  Readers aren't blocked while it runs, writers wait on the usual busy timeout.
+func (db *sqliteContainer) Compact(minSize int64, minFree float64) (int64, int64, error) {
+	if err := db.flush(); err != nil {
+		return 0, 0, err
+	}
+	before, free, err := db.freeSpace()
+	if err != nil {
+		return 0, 0, err
+	}
+	if before < minSize || float64(free) < minFree*float64(before) {
+		return before, before, nil
+	}
+	if _, err := db.Exec(compactScript); err != nil {
+		if common.IsCorruptDBError(err) {
+			return 0, 0, fmt.Errorf("Failed to Compact: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return 0, 0, fmt.Errorf("Error compacting database %s: %v", db.containerFile, err)
+	}
+	after, _, err := db.freeSpace()
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// snapshotObjectsQuery selects every object row, including tombstones, as of a snapshot given its max_row (twice) and id.
+const snapshotObjectsQuery = `(SELECT ROWID AS row_id, name, created_at, size, content_type, etag, deleted, storage_policy_index
+							   FROM object WHERE ROWID <= ?
+							   UNION ALL
+							   SELECT row_id, name, created_at, size, content_type, etag, deleted, storage_policy_index
+							   FROM snapshot_object WHERE row_id <= ? AND removed_after >= ?)`
+
+// snapshot returns the id and ROWID high-water mark of an unexpired snapshot.
+func (db *sqliteContainer) snapshot(name string) (int64, int64, error) {
+	var id, maxRow int64
+	now := common.CanonicalTimestamp(float64(time.Now().UnixNano()) / 1000000000.0)
+	err := db.QueryRow("SELECT id, max_row FROM container_snapshot WHERE name = ? AND expires > ?", name, now).Scan(&id, &maxRow)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrorNoSuchSnapshot
+	} else if err != nil {
+		if common.IsCorruptDBError(err) {
+			return 0, 0, fmt.Errorf("Failed to snapshot SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return 0, 0, err
+	}
+	return id, maxRow, nil
+}
+
+// pruneSnapshotObjects removes any rows kept for snapshots that no remaining snapshot can see.
+func pruneSnapshotObjects(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM snapshot_object WHERE NOT EXISTS (
+						   SELECT 1 FROM container_snapshot s
+						   WHERE snapshot_object.row_id <= s.max_row AND snapshot_object.removed_after >= s.id)
+					   OR (deleted = 1 AND row_id <= (SELECT MIN(max_row) FROM container_snapshot))`)
+	return err
+}
+
+// CreateSnapshot records the container's current ROWID high-water mark as the named snapshot.  Creating a snapshot
+// that already exists does nothing, so retried requests keep the original.
+func (db *sqliteContainer) CreateSnapshot(name string, expires string) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO container_snapshot (name, max_row, expires) SELECT ?, max, ? FROM maxrowid",
+		name, expires); err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to CreateSnapshot INSERT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteSnapshot removes a snapshot along with any rows only it was keeping.
+func (db *sqliteContainer) DeleteSnapshot(name string) error {
+	if err := db.connect(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM container_snapshot WHERE name = ?", name)
+	if err != nil {
+		if common.IsCorruptDBError(err) {
+			return fmt.Errorf("Failed to DeleteSnapshot DELETE: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrorNoSuchSnapshot
+	}
+	if err := pruneSnapshotObjects(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snapshots returns the container's unexpired snapshots, oldest first.
+func (db *sqliteContainer) Snapshots() ([]*ContainerSnapshot, error) {
+	if err := db.connect(); err != nil {
+		return nil, err
+	}
+	now := common.CanonicalTimestamp(float64(time.Now().UnixNano()) / 1000000000.0)
+	rows, err := db.Query("SELECT name, max_row, expires FROM container_snapshot WHERE expires > ? ORDER BY id", now)
+	if err != nil {
+		if common.IsCorruptDBError(err) {
+			return nil, fmt.Errorf("Failed to Snapshots SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	snapshots := []*ContainerSnapshot{}
+	for rows.Next() {
+		s := &ContainerSnapshot{}
+		if err := rows.Scan(&s.Name, &s.MaxRow, &s.Expires); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// ListObjectsSnapshot implements object listings as of a snapshot.
+func (db *sqliteContainer) ListObjectsSnapshot(snapshot string, limit int, marker string, endMarker string, prefix string,
+	delimiter string, pth *string, reverse bool, storagePolicyIndex int) ([]interface{}, error) {
+	if err := db.connect(); err != nil {
+		return nil, err
+	}
+	id, maxRow, err := db.snapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	queryStart := "SELECT name, created_at, size, content_type, etag FROM " + snapshotObjectsQuery + " WHERE deleted = 0 AND"
+	return db.listObjects(queryStart, []interface{}{maxRow, maxRow, id}, limit, marker, endMarker, prefix, delimiter, pth,
+		reverse, storagePolicyIndex, nil, nil)
+}
+
+// snapshotNames returns which of names were live objects as of a snapshot.
+func (db *sqliteContainer) snapshotNames(id, maxRow int64, storagePolicyIndex int, names []interface{}) (map[string]bool, error) {
+	found := make(map[string]bool)
+	for i := 0; i < len(names); i += maxQueryArgs {
+		j := i + maxQueryArgs
+		if j > len(names) {
+			j = len(names)
+		}
+		batch := names[i:j]
+		rows, err := db.Query(fmt.Sprintf("SELECT name FROM "+snapshotObjectsQuery+
+			" WHERE deleted = 0 AND storage_policy_index = ? AND name IN (%s)", strings.TrimRight(strings.Repeat("?,", len(batch)), ",")),
+			append([]interface{}{maxRow, maxRow, id, storagePolicyIndex}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found[name] = true
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows.Close()
+	}
+	return found, nil
+}
+
+// DiffSnapshots returns the objects that were added, modified, or deleted between two snapshots, ordered by name.  Only
+// rows newer than the from snapshot can be changes, and the tombstones among them are pinned until it's gone.
+func (db *sqliteContainer) DiffSnapshots(from string, to string, limit int, marker string, storagePolicyIndex int) ([]*SnapshotChange, error) {
+	if err := db.connect(); err != nil {
+		return nil, err
+	}
+	fromID, fromRow, err := db.snapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	toID, toRow, err := db.snapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	if fromID > toID {
+		return nil, ErrorSnapshotOrder
+	}
+	changes := []*SnapshotChange{}
+	for len(changes) < limit {
+		rows, err := db.Query("SELECT name, created_at, size, content_type, etag, deleted FROM "+snapshotObjectsQuery+
+			" WHERE row_id > ? AND storage_policy_index = ? AND name > ? ORDER BY name LIMIT ?",
+			toRow, toRow, toID, fromRow, storagePolicyIndex, marker, limit-len(changes))
+		if err != nil {
+			if common.IsCorruptDBError(err) {
+				return nil, fmt.Errorf("Failed to DiffSnapshots SELECT: %v; %v", err, common.QuarantineDir(path.Dir(db.containerFile), 4, "containers"))
+			}
+			return nil, err
+		}
+		var batch []*SnapshotChange
+		var names []interface{}
+		for rows.Next() {
+			c := &SnapshotChange{}
+			var deleted int
+			if err := rows.Scan(&c.Name, &c.LastModified, &c.Size, &c.ContentType, &c.ETag, &deleted); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if deleted == 1 {
+				c.Change = "deleted"
+			}
+			batch = append(batch, c)
+			names = append(names, c.Name)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+		marker = batch[len(batch)-1].Name
+		existed, err := db.snapshotNames(fromID, fromRow, storagePolicyIndex, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range batch {
+			if c.Change == "deleted" {
+				// Objects created and deleted between the snapshots didn't change anything.
+				if !existed[c.Name] {
+					continue
+				}
+			} else if existed[c.Name] {
+				c.Change = "modified"
+			} else {
+				c.Change = "added"
+			}
+			if err := updateRecord(&c.ObjectListingRecord); err != nil {
+				return nil, err
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
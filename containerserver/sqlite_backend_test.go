@@ -20,11 +20,14 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/dbdaemon"
 	"github.com/troubling/hummingbird/common/fs"
 )
 
@@ -89,6 +92,27 @@ func TestContainerUpdateRecord(t *testing.T) {
 
 	rec = &ObjectListingRecord{Name: "a", ContentType: "text/plain; swift_bytes=X", LastModified: "1.0"}
 	require.NotNil(t, updateRecord(rec))
+
+	rec = &ObjectListingRecord{Name: "a", ContentType: "application/symlink;symlink_path=/v1/a/c/o%20b", LastModified: "1.0"}
+	require.Nil(t, updateRecord(rec))
+	require.Equal(t, "application/symlink", rec.ContentType)
+	require.Equal(t, "/v1/a/c/o b", rec.SymlinkPath)
+}
+
+func TestContainerListingSymlinkPath(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	contentType := common.ContentTypeWithSymlink("application/symlink", "/v1/a/c/target")
+	require.Nil(t, db.PutObject("link", "100000001.00000", 0, contentType, "d41d8cd98f00b204e9800998ecf8427e", 0, ""))
+	require.Nil(t, db.PutObject("plain", "100000001.00000", 3, "text/plain", "202cb962ac59075b964b07152d234b70", 0, ""))
+	require.Nil(t, db.flush())
+	records, err := db.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	require.Equal(t, "application/symlink", records[0].(*ObjectListingRecord).ContentType)
+	require.Equal(t, "/v1/a/c/target", records[0].(*ObjectListingRecord).SymlinkPath)
+	require.Equal(t, "", records[1].(*ObjectListingRecord).SymlinkPath)
 }
 
 func TestContainerListingsLimit(t *testing.T) {
@@ -783,3 +807,334 @@ func TestNotADB(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestContainerMergeShardRanges(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	ranges, err := db.ShardRanges(false)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(ranges))
+	require.Nil(t, db.MergeShardRanges([]*common.ShardRange{
+		{Account: ".shards_a", Container: "c-2", Lower: "m", State: common.ShardRangeCreated, Timestamp: "2", MetaTimestamp: "2"},
+		{Account: ".shards_a", Container: "c-1", Upper: "m", State: common.ShardRangeCreated, Timestamp: "2", MetaTimestamp: "2"},
+	}))
+	require.Nil(t, db.MergeShardRanges([]*common.ShardRange{
+		{Account: ".shards_a", Container: "c-1", Upper: "m", State: common.ShardRangeActive, Timestamp: "3", MetaTimestamp: "1", ObjectCount: 5},
+		{Account: ".shards_a", Container: "c-2", Lower: "m", State: common.ShardRangeActive, Timestamp: "1", MetaTimestamp: "3", ObjectCount: 7, BytesUsed: 10},
+	}))
+	ranges, err = db.ShardRanges(false)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(ranges))
+	require.Equal(t, "c-1", ranges[0].Container)
+	require.Equal(t, common.ShardRangeActive, ranges[0].State)
+	require.Equal(t, int64(0), ranges[0].ObjectCount)
+	require.Equal(t, "c-2", ranges[1].Container)
+	require.Equal(t, common.ShardRangeCreated, ranges[1].State)
+	require.Equal(t, int64(7), ranges[1].ObjectCount)
+	require.Equal(t, int64(10), ranges[1].BytesUsed)
+
+	require.Nil(t, db.MergeShardRanges([]*common.ShardRange{{Account: ".shards_a", Container: "c-2", Lower: "m", Timestamp: "4", Deleted: 1}}))
+	ranges, err = db.ShardRanges(false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ranges))
+	ranges, err = db.ShardRanges(true)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(ranges))
+}
+
+func TestContainerShardPoints(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a", "b", "c", "d", "e", "f", "g"}))
+	points, err := db.ShardPoints(3)
+	require.Nil(t, err)
+	require.Equal(t, []string{"c", "f"}, points)
+	points, err = db.ShardPoints(10)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(points))
+}
+
+func TestContainerItemsInRangeRemoveItems(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a", "b", "c", "d", "e"}))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{{Name: "c", CreatedAt: "10000000.00002", Deleted: 1}}, ""))
+	records, err := db.ItemsInRange("a", "d", 10)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	require.Equal(t, "b", records[0].Name)
+	require.Equal(t, "c", records[1].Name)
+	require.Equal(t, 1, records[1].Deleted)
+	require.Equal(t, "d", records[2].Name)
+	records, err = db.ItemsInRange("c", "", 1)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(records))
+	require.Equal(t, "d", records[0].Name)
+
+	records, err = db.ItemsInRange("", "c", 10)
+	require.Nil(t, err)
+	require.Nil(t, db.RemoveItems(records))
+	listing, err := db.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(listing))
+	require.Equal(t, "d", listing[0].(*ObjectListingRecord).Name)
+	records, err = db.ItemsInRange("", "", 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+}
+
+func TestContainerMisplacedItems(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a"}))
+	require.Nil(t, mergeItemsByName(db, []string{"b"}))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{{Name: "c", CreatedAt: "10000000.00002", StoragePolicyIndex: 1}}, ""))
+	records, err := db.MisplacedItems(-1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(records))
+	require.Equal(t, "c", records[0].Name)
+
+	require.Nil(t, db.SetStoragePolicy(1, "100000000.00001"))
+	info, err := db.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, 1, info.StoragePolicyIndex)
+	require.Equal(t, "100000000.00001", info.StatusChangedAt)
+	require.EqualValues(t, 1, info.ObjectCount)
+	records, err = db.MisplacedItems(-1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(records))
+	require.Equal(t, "ab", records[0].Name+records[1].Name)
+
+	require.EqualValues(t, -1, info.ReconcilerSyncPoint)
+	require.Nil(t, db.SetReconcilerSyncPoint(records[0].Rowid))
+	info, err = db.GetInfo()
+	require.Nil(t, err)
+	require.Equal(t, records[0].Rowid, info.ReconcilerSyncPoint)
+	records, err = db.MisplacedItems(info.ReconcilerSyncPoint, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(records))
+	require.Equal(t, "b", records[0].Name)
+}
+
+func TestContainerAudit(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, mergeItemsByName(db, []string{"a", "b", "c"}))
+	require.Nil(t, db.Audit())
+	_, err = db.Exec("UPDATE container_info SET hash = '00000000000000000000000000000001'")
+	require.Nil(t, err)
+	_, ok := db.Audit().(dbdaemon.AuditError)
+	require.True(t, ok)
+}
+
+func TestContainerListObjectsFiltered(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	require.Nil(t, db.MergeItems([]*ObjectRecord{
+		{Name: "a", CreatedAt: "0100000001.00000", Size: 1, ContentType: "text/plain", ETag: "x", Meta: map[string]string{"color": "red"}},
+		{Name: "b", CreatedAt: "0100000002.00000", Size: 2, ContentType: "text/html;charset=utf-8", ETag: "x", Meta: map[string]string{"color": "blue"}},
+		{Name: "c", CreatedAt: "0100000003.00000", Size: 3, ContentType: "image/png", ETag: "x", Meta: map[string]string{"color": "red", "shape": "round"}},
+	}, ""))
+	names := func(filter *ListingFilter) string {
+		listing, err := db.ListObjectsFiltered(10000, "", "", "", "", nil, false, 0, filter)
+		require.Nil(t, err)
+		s := ""
+		for _, o := range listing {
+			s += o.(*ObjectListingRecord).Name
+		}
+		return s
+	}
+	size := int64(1)
+	require.Equal(t, "abc", names(nil))
+	require.Equal(t, "a", names(&ListingFilter{ContentType: "text/plain"}))
+	require.Equal(t, "b", names(&ListingFilter{ContentType: "text/html"}))
+	require.Equal(t, "ab", names(&ListingFilter{ContentType: "text/"}))
+	require.Equal(t, "bc", names(&ListingFilter{ModifiedSince: "0100000001.00000"}))
+	require.Equal(t, "a", names(&ListingFilter{ModifiedBefore: "0100000002.00000"}))
+	require.Equal(t, "bc", names(&ListingFilter{SizeGT: &size}))
+	require.Equal(t, "", names(&ListingFilter{SizeLT: &size}))
+	require.Equal(t, "ac", names(&ListingFilter{Meta: map[string]string{"color": "red"}}))
+	require.Equal(t, "c", names(&ListingFilter{Meta: map[string]string{"color": "red", "shape": "round"}}))
+
+	// Newer rows replace the old row's index entries.
+	require.Nil(t, db.MergeItems([]*ObjectRecord{
+		{Name: "a", CreatedAt: "0100000004.00000", Size: 1, ContentType: "text/plain", ETag: "x", Meta: map[string]string{"color": "green"}},
+		{Name: "c", CreatedAt: "0100000004.00000", Deleted: 1},
+	}, ""))
+	require.Equal(t, "", names(&ListingFilter{Meta: map[string]string{"color": "red"}}))
+	require.Equal(t, "a", names(&ListingFilter{Meta: map[string]string{"color": "green"}}))
+	records, err := db.ItemsSince(-1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	meta := map[string]map[string]string{}
+	for _, r := range records {
+		meta[r.Name] = r.Meta
+	}
+	require.Equal(t, map[string]string{"color": "green"}, meta["a"])
+	require.Equal(t, map[string]string{"color": "blue"}, meta["b"])
+	require.Nil(t, meta["c"])
+}
+
+func TestContainerSnapshots(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	expires := common.CanonicalTimestamp(float64(time.Now().Unix() + 3600))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{
+		{Name: "a", CreatedAt: "0100000001.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+		{Name: "b", CreatedAt: "0100000002.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+		{Name: "c", CreatedAt: "0100000003.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+	}, ""))
+	require.Nil(t, db.CreateSnapshot("s1", expires))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{
+		{Name: "a", CreatedAt: "0100000004.00000", Size: 2, ContentType: "text/plain", ETag: "y"},
+		{Name: "b", CreatedAt: "0100000004.00000", Deleted: 1},
+		{Name: "d", CreatedAt: "0100000004.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+		{Name: "e", CreatedAt: "0100000004.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+	}, ""))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{{Name: "e", CreatedAt: "0100000005.00000", Deleted: 1}}, ""))
+	require.Nil(t, db.CreateSnapshot("s2", expires))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{{Name: "c", CreatedAt: "0100000006.00000", Deleted: 1}}, ""))
+
+	names := func(snapshot string) string {
+		listing, err := db.ListObjectsSnapshot(snapshot, 10000, "", "", "", "", nil, false, 0)
+		require.Nil(t, err)
+		s := ""
+		for _, o := range listing {
+			s += o.(*ObjectListingRecord).Name
+		}
+		return s
+	}
+	diff := func(from, to string) string {
+		changes, err := db.DiffSnapshots(from, to, 10000, "", 0)
+		require.Nil(t, err)
+		s := []string{}
+		for _, c := range changes {
+			s = append(s, c.Change+" "+c.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	require.Equal(t, "abc", names("s1"))
+	require.Equal(t, "acd", names("s2"))
+	listing, err := db.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(listing))
+	listing, err = db.ListObjectsSnapshot("s1", 1, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), listing[0].(*ObjectListingRecord).Size)
+	require.Equal(t, "modified a,deleted b,added d", diff("s1", "s2"))
+	_, err = db.DiffSnapshots("s2", "s1", 10000, "", 0)
+	require.Equal(t, ErrorSnapshotOrder, err)
+	_, err = db.ListObjectsSnapshot("nope", 10000, "", "", "", "", nil, false, 0)
+	require.Equal(t, ErrorNoSuchSnapshot, err)
+
+	tombstones := func() int {
+		var count int
+		require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM object WHERE deleted = 1").Scan(&count))
+		return count
+	}
+	// Tombstones between the snapshots are pinned, the one after them isn't.
+	require.Nil(t, db.CleanupTombstones(0))
+	require.Equal(t, 2, tombstones())
+	require.Equal(t, "modified a,deleted b,added d", diff("s1", "s2"))
+
+	require.Nil(t, db.CreateSnapshot("expired", common.CanonicalTimestamp(1)))
+	snapshots, err := db.Snapshots()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(snapshots))
+	require.Equal(t, "s1", snapshots[0].Name)
+	require.Equal(t, "s2", snapshots[1].Name)
+
+	require.Nil(t, db.DeleteSnapshot("s1"))
+	require.Equal(t, ErrorNoSuchSnapshot, db.DeleteSnapshot("s1"))
+	var kept int
+	require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM snapshot_object").Scan(&kept))
+	require.Equal(t, 1, kept)
+	require.Nil(t, db.CleanupTombstones(0))
+	require.Equal(t, 0, tombstones())
+	require.Equal(t, "acd", names("s2"))
+}
+
+func TestContainerSnapshotsPerReplica(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	replica, _, cleanup2, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup2()
+	expires := common.CanonicalTimestamp(float64(time.Now().Unix() + 3600))
+	require.Nil(t, db.MergeItems([]*ObjectRecord{
+		{Name: "a", CreatedAt: "0100000001.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+	}, ""))
+	require.Nil(t, replica.MergeItems([]*ObjectRecord{
+		{Name: "b", CreatedAt: "0100000002.00000", Size: 1, ContentType: "text/plain", ETag: "x"},
+	}, ""))
+	require.Nil(t, db.CreateSnapshot("s1", expires))
+	require.Nil(t, replica.CreateSnapshot("s1", expires))
+
+	// Replication merges the object rows, but each replica's snapshot still only covers the rows it had when
+	// the snapshot was taken, even ones that were older than the snapshot.
+	records, err := db.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Nil(t, replica.MergeItems(records, "db"))
+	records, err = replica.ItemsSince(-1, 100)
+	require.Nil(t, err)
+	require.Nil(t, db.MergeItems(records, "replica"))
+	names := func(c *sqliteContainer) string {
+		listing, err := c.ListObjectsSnapshot("s1", 10000, "", "", "", "", nil, false, 0)
+		require.Nil(t, err)
+		s := ""
+		for _, o := range listing {
+			s += o.(*ObjectListingRecord).Name
+		}
+		return s
+	}
+	require.Equal(t, "a", names(db))
+	require.Equal(t, "b", names(replica))
+	listing, err := db.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(listing))
+
+	// Snapshots themselves aren't replicated.
+	require.Nil(t, db.CreateSnapshot("s2", expires))
+	_, err = replica.ListObjectsSnapshot("s2", 10000, "", "", "", "", nil, false, 0)
+	require.Equal(t, ErrorNoSuchSnapshot, err)
+}
+
+func TestCompact(t *testing.T) {
+	db, _, cleanup, err := createTestDatabase("100000000.00000")
+	require.Nil(t, err)
+	defer cleanup()
+	var names []string
+	for i := 0; i < 1000; i++ {
+		names = append(names, strings.Repeat("o", 200)+strconv.Itoa(i))
+	}
+	require.Nil(t, mergeItemsByName(db, names))
+	_, err = db.Exec("DELETE FROM object WHERE name != ?", names[0])
+	require.Nil(t, err)
+
+	// Databases that are too small, or don't have enough free space, are left alone.
+	before, after, err := db.Compact(0, 0.99)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+	before, after, err = db.Compact(before+1, 0)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+
+	before, after, err = db.Compact(0, 0.5)
+	require.Nil(t, err)
+	require.True(t, after < before/2)
+	_, free, err := db.freeSpace()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), free)
+	listing, err := db.ListObjects(10000, "", "", "", "", nil, false, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(listing))
+	require.Equal(t, names[0], listing[0].(*ObjectListingRecord).Name)
+}
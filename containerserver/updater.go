@@ -0,0 +1,486 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// The container server only waits a few seconds for its account updates, and
+// doesn't retry them.  The updater finds containers whose object stats have
+// changed since they were last reported and sends them to the account servers,
+// batching the containers in each account into a single request per account
+// node, and backing off containers whose updates keep failing.
+
+package containerserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/middleware"
+	"github.com/uber-go/tally"
+	promreporter "github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+// statsUnreported returns true if the container's stats have changed since they were last sent to the account.
+func statsUnreported(info *ContainerInfo) bool {
+	return info.PutTimestamp > info.ReportedPutTimestamp ||
+		info.DeleteTimestamp > info.ReportedDeleteTimestamp ||
+		info.ObjectCount != info.ReportedObjectCount ||
+		info.BytesUsed != info.ReportedBytesUsed
+}
+
+type containerUpdate struct {
+	dbFile string
+	info   *ContainerInfo
+}
+
+type updateBackoff struct {
+	failures uint
+	next     time.Time
+}
+
+// Updater is the container updater daemon object.
+type Updater struct {
+	checkMounts    bool
+	deviceRoot     string
+	reconCachePath string
+	accountRing    ring.Ring
+	client         common.HTTPClient
+	logger         srv.LowLevelLogger
+	logLevel       zap.AtomicLevel
+	interval       time.Duration
+	batchSize      int
+	concurrency    int
+	backoffMin     time.Duration
+	backoffMax     time.Duration
+	backoffLock    sync.Mutex
+	backoffs       map[string]*updateBackoff
+	metricsCloser  io.Closer
+	successes      tally.Counter
+	failures       tally.Counter
+	backlog        tally.Gauge
+}
+
+func (u *Updater) setMetricsScope(scope tally.Scope) {
+	u.successes = scope.Counter("container_updater_successes")
+	u.failures = scope.Counter("container_updater_failures")
+	u.backlog = scope.Gauge("container_updater_backlog")
+}
+
+// due returns true if the container isn't backing off from failed updates.
+func (u *Updater) due(ringHash string, now time.Time) bool {
+	u.backoffLock.Lock()
+	defer u.backoffLock.Unlock()
+	b := u.backoffs[ringHash]
+	return b == nil || !now.Before(b.next)
+}
+
+// backoff doubles the time before the container's next update attempt, up to backoffMax.
+func (u *Updater) backoff(ringHash string) {
+	u.backoffLock.Lock()
+	defer u.backoffLock.Unlock()
+	b := u.backoffs[ringHash]
+	if b == nil {
+		b = &updateBackoff{}
+		u.backoffs[ringHash] = b
+	}
+	wait := u.backoffMax
+	if b.failures < 32 && u.backoffMin<<b.failures < u.backoffMax {
+		wait = u.backoffMin << b.failures
+	}
+	b.failures++
+	b.next = time.Now().Add(wait)
+}
+
+// accountRecord is a container's row in its account, as sent to the account servers in batches.
+type accountRecord struct {
+	Name               string `json:"name"`
+	PutTimestamp       string `json:"put_timestamp"`
+	DeleteTimestamp    string `json:"delete_timestamp"`
+	ObjectCount        int64  `json:"object_count"`
+	BytesUsed          int64  `json:"bytes_used"`
+	StoragePolicyIndex int    `json:"storage_policy_index"`
+}
+
+// sendAccountBatch sends a batch of container records to one of the account's nodes, returning the response status
+// or 0 if the request could not be made.
+func (u *Updater) sendAccountBatch(node *ring.Device, part uint64, account string, body []byte) int {
+	accountUrl := fmt.Sprintf("%s://%s:%d/%s/%d/%s", node.Scheme, node.Ip, node.Port, node.Device, part, common.Urlencode(account))
+	req, err := http.NewRequest("PUT", accountUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("X-Backend-Record-Type", "container")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Backend-Suppress-2xx-Logging", "t")
+	req.Header.Set("X-Trans-Id", common.GetTransactionId())
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// updateAccount sends the stats of containers in the same account to each of the account's nodes as a single batch,
+// returning which of the containers a majority of the nodes accepted.
+func (u *Updater) updateAccount(account string, updates []*containerUpdate) []bool {
+	part := u.accountRing.GetPartition(account, "", "")
+	nodes := u.accountRing.GetNodes(part)
+	accepted := make([]bool, len(updates))
+	records := make([]*accountRecord, len(updates))
+	for i, cu := range updates {
+		records[i] = &accountRecord{
+			Name:               cu.info.Container,
+			PutTimestamp:       cu.info.PutTimestamp,
+			DeleteTimestamp:    cu.info.DeleteTimestamp,
+			ObjectCount:        cu.info.ObjectCount,
+			BytesUsed:          cu.info.BytesUsed,
+			StoragePolicyIndex: cu.info.StoragePolicyIndex,
+		}
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		u.logger.Error("Unable to marshal account update batch.", zap.String("account", account), zap.Error(err))
+		return accepted
+	}
+	successes := make([]int, len(updates))
+	for _, node := range nodes {
+		status := u.sendAccountBatch(node, part, account, body)
+		if status/100 == 2 {
+			for i := range successes {
+				successes[i]++
+			}
+			continue
+		}
+		// Account servers that don't know about batches reject them for their missing X-Timestamp, and need the
+		// updates one at a time.
+		if status != http.StatusBadRequest && status != http.StatusNotImplemented {
+			u.logger.Debug("Account update batch failed.", zap.String("account", account),
+				zap.String("Ip", node.Ip), zap.String("Device", node.Device), zap.Int("status", status))
+			continue
+		}
+		for i, cu := range updates {
+			if err := accountUpdateHelper(context.Background(), cu.info, node.Scheme, fmt.Sprintf("%s:%d", node.Ip, node.Port),
+				node.Device, strconv.FormatUint(part, 10), account, cu.info.Container, common.GetTransactionId(), false, u.client); err != nil {
+				u.logger.Debug("Account update failed.", zap.String("account", account), zap.String("container", cu.info.Container),
+					zap.String("Ip", node.Ip), zap.String("Device", node.Device), zap.Error(err))
+				continue
+			}
+			successes[i]++
+		}
+	}
+	for i := range accepted {
+		accepted[i] = successes[i] > len(nodes)/2
+	}
+	return accepted
+}
+
+// updated records the outcome of a container's update, backing it off if the account servers didn't accept it.
+func (u *Updater) updated(cu *containerUpdate, accepted bool) {
+	ringHash := filepath.Base(filepath.Dir(cu.dbFile))
+	if !accepted {
+		u.failures.Inc(1)
+		u.backoff(ringHash)
+		return
+	}
+	u.backoffLock.Lock()
+	delete(u.backoffs, ringHash)
+	u.backoffLock.Unlock()
+	u.successes.Inc(1)
+	c, err := sqliteOpenContainer(cu.dbFile)
+	if err != nil {
+		u.logger.Error("Error opening container to record update.", zap.String("dbFile", cu.dbFile), zap.Error(err))
+		return
+	}
+	defer c.Close()
+	if err := c.Reported(cu.info.PutTimestamp, cu.info.DeleteTimestamp, cu.info.ObjectCount, cu.info.BytesUsed); err != nil {
+		u.logger.Error("Could not update reported info", zap.String("dbFile", cu.dbFile), zap.Error(err))
+	}
+}
+
+// sendBatch groups container updates by account and sends up to batchSize of an account's updates in each request,
+// with concurrency accounts being updated at a time.
+func (u *Updater) sendBatch(batch []*containerUpdate) {
+	var accounts []string
+	byAccount := map[string][]*containerUpdate{}
+	for _, cu := range batch {
+		if _, ok := byAccount[cu.info.Account]; !ok {
+			accounts = append(accounts, cu.info.Account)
+		}
+		byAccount[cu.info.Account] = append(byAccount[cu.info.Account], cu)
+	}
+	sem := make(chan struct{}, u.concurrency)
+	wg := sync.WaitGroup{}
+	for _, account := range accounts {
+		updates := byAccount[account]
+		for len(updates) > 0 {
+			chunk := updates
+			if len(chunk) > u.batchSize {
+				chunk = chunk[:u.batchSize]
+			}
+			updates = updates[len(chunk):]
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(account string, chunk []*containerUpdate) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				for i, accepted := range u.updateAccount(account, chunk) {
+					u.updated(chunk[i], accepted)
+				}
+			}(account, chunk)
+		}
+	}
+	wg.Wait()
+}
+
+// unreportedInfo returns the info of the container to report to its account, or nil if there's nothing to report.
+func (u *Updater) unreportedInfo(dbFile string) (*ContainerInfo, error) {
+	c, err := sqliteOpenContainer(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	info, err := c.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	// Shard containers report their stats to their root container instead.
+	if strings.HasPrefix(info.Account, common.ShardAccountPrefix) {
+		return nil, nil
+	}
+	ranges, err := shardRanges(c)
+	if err != nil {
+		return nil, err
+	}
+	if info = withShardStats(info, ranges); !statsUnreported(info) {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// updateDevice sends any unreported container stats on the device to the account servers, returning how many
+// containers had stats to report.
+func (u *Updater) updateDevice(devicePath string) int64 {
+	defer srv.LogPanics(u.logger, "PANIC WHILE UPDATING DEVICE")
+	if mount, err := fs.IsMount(devicePath); u.checkMounts && (err != nil || !mount) {
+		u.logger.Error("Device not mounted.", zap.String("devicePath", devicePath), zap.Error(err))
+		return 0
+	}
+	results := make(chan string, 100)
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go findContainerDbs(devicePath, results, cancel, u.logger)
+	var backlog int64
+	var batch []*containerUpdate
+	for dbFile := range results {
+		info, err := u.unreportedInfo(dbFile)
+		if err != nil {
+			u.logger.Error("Error getting container info.", zap.String("dbFile", dbFile), zap.Error(err))
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		backlog++
+		if !u.due(filepath.Base(filepath.Dir(dbFile)), time.Now()) {
+			continue
+		}
+		// Gathering enough updates for every request in flight to be a full batch gives containers in the same
+		// account a chance to share one.
+		if batch = append(batch, &containerUpdate{dbFile: dbFile, info: info}); len(batch) >= u.batchSize*u.concurrency {
+			u.sendBatch(batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		u.sendBatch(batch)
+	}
+	return backlog
+}
+
+// Run runs a pass of the updater over every device once.
+func (u *Updater) Run() {
+	start := time.Now()
+	devices, err := fs.ReadDirNames(u.deviceRoot)
+	if err != nil {
+		u.logger.Error("Unable to list devices.", zap.String("deviceRoot", u.deviceRoot), zap.Error(err))
+		return
+	}
+	var backlog int64
+	for _, dev := range devices {
+		backlog += u.updateDevice(filepath.Join(u.deviceRoot, dev))
+	}
+	// Any container still backing off was seen this pass, so older entries are for containers that have gone.
+	u.backoffLock.Lock()
+	for ringHash, b := range u.backoffs {
+		if b.next.Before(start) {
+			delete(u.backoffs, ringHash)
+		}
+	}
+	u.backoffLock.Unlock()
+	u.backlog.Update(float64(backlog))
+	elapsed := time.Since(start).Seconds()
+	middleware.DumpReconCache(u.reconCachePath, "container", map[string]interface{}{
+		"container_updater_sweep":   elapsed,
+		"container_updater_backlog": backlog,
+	})
+	u.logger.Info("Container update pass complete.", zap.Int64("backlog", backlog), zap.Float64("seconds", elapsed))
+}
+
+// RunForever runs the updater in a forever-loop.
+func (u *Updater) RunForever() {
+	for {
+		start := time.Now()
+		u.Run()
+		if elapsed := time.Since(start); elapsed < u.interval {
+			time.Sleep(u.interval - elapsed)
+		}
+	}
+}
+
+func (u *Updater) Type() string {
+	return "container-updater"
+}
+
+func (u *Updater) Background(flags *flag.FlagSet) chan struct{} {
+	once := false
+	if f := flags.Lookup("once"); f != nil {
+		once = f.Value.(flag.Getter).Get() == true
+	}
+	if once {
+		ch := make(chan struct{})
+		go func() {
+			defer close(ch)
+			u.Run()
+		}()
+		return ch
+	}
+	go u.RunForever()
+	return nil
+}
+
+func (u *Updater) GetHandler(config conf.Config, metricsPrefix string) http.Handler {
+	var metricsScope tally.Scope
+	metricsScope, u.metricsCloser = tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         metricsPrefix,
+		Tags:           map[string]string{},
+		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
+		Separator:      promreporter.DefaultSeparator,
+	}, time.Second)
+	u.setMetricsScope(metricsScope)
+	commonHandlers := alice.New(
+		middleware.NewDebugResponses(config.GetBool("debug", "debug_x_source_code", false)),
+		u.LogRequest,
+		middleware.RecoverHandler,
+		middleware.ValidateRequest,
+	)
+	router := srv.NewRouter()
+	router.Get("/metrics", prometheus.Handler())
+	router.Get("/loglevel", u.logLevel)
+	router.Put("/loglevel", u.logLevel)
+	router.Get("/healthcheck", commonHandlers.ThenFunc(u.HealthcheckHandler))
+	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
+	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
+	return alice.New(middleware.Metrics(metricsScope)).Then(router)
+}
+
+func (u *Updater) Finalize() {
+	if u.metricsCloser != nil {
+		u.metricsCloser.Close()
+	}
+}
+
+func (u *Updater) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Length", "2")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+func (u *Updater) LogRequest(next http.Handler) http.Handler {
+	return srv.LogRequest(u.logger, next)
+}
+
+// NewUpdater uses the config settings and command-line flags to configure and return a container updater daemon struct.
+func NewUpdater(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader) (*srv.IpPort, srv.Server, srv.LowLevelLogger, error) {
+	var ipPort *srv.IpPort
+	var err error
+	var logger srv.LowLevelLogger
+	if !serverconf.HasSection("container-updater") {
+		return ipPort, nil, nil, fmt.Errorf("Unable to find container-updater config section")
+	}
+	hashPathPrefix, hashPathSuffix, err := cnf.GetHashPrefixAndSuffix()
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Unable to get hash prefix and suffix: %s", err)
+	}
+	accountRing, err := cnf.GetRing("account", hashPathPrefix, hashPathSuffix, 0)
+	if err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error loading account ring: %s", err)
+	}
+	logLevelString := serverconf.GetDefault("container-updater", "log_level", "INFO")
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
+	if logger, err = srv.SetupLogger("container-updater", &logLevel, flags); err != nil {
+		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	ip := serverconf.GetDefault("container-updater", "bind_ip", "0.0.0.0")
+	port := int(serverconf.GetInt("container-updater", "bind_port", common.DefaultContainerUpdaterPort))
+	u := &Updater{
+		checkMounts:    serverconf.GetBool("container-updater", "mount_check", true),
+		deviceRoot:     serverconf.GetDefault("container-updater", "devices", "/srv/node"),
+		reconCachePath: serverconf.GetDefault("container-updater", "recon_cache_path", "/var/cache/swift"),
+		accountRing:    accountRing,
+		logger:         logger,
+		logLevel:       logLevel,
+		interval:       time.Duration(serverconf.GetFloat("container-updater", "interval", 300) * float64(time.Second)),
+		batchSize:      int(serverconf.GetInt("container-updater", "batch_size", 100)),
+		concurrency:    int(serverconf.GetInt("container-updater", "concurrency", 4)),
+		backoffMin:     time.Duration(serverconf.GetFloat("container-updater", "backoff_min", 60) * float64(time.Second)),
+		backoffMax:     time.Duration(serverconf.GetFloat("container-updater", "backoff_max", 3600) * float64(time.Second)),
+		backoffs:       map[string]*updateBackoff{},
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				Dial:                (&net.Dialer{Timeout: time.Second}).Dial,
+				MaxIdleConnsPerHost: 100,
+				MaxIdleConns:        0,
+			},
+		},
+	}
+	if u.batchSize < 1 || u.concurrency < 1 {
+		return ipPort, nil, nil, fmt.Errorf("batch_size and concurrency must be at least 1")
+	}
+	u.setMetricsScope(tally.NoopScope)
+	ipPort = &srv.IpPort{Ip: ip, Port: port}
+	return ipPort, u, logger, nil
+}
@@ -0,0 +1,141 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package containerserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/ring"
+	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type accountBatchClient struct {
+	lock        sync.Mutex
+	batches     [][]*accountRecord
+	singles     []string
+	batchStatus int
+	status      int
+}
+
+func (c *accountBatchClient) Do(req *http.Request) (*http.Response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	status := c.status
+	if req.Header.Get("X-Backend-Record-Type") == "container" {
+		var records []*accountRecord
+		if err := json.NewDecoder(req.Body).Decode(&records); err != nil {
+			return nil, err
+		}
+		c.batches = append(c.batches, records)
+		status = c.batchStatus
+	} else {
+		c.singles = append(c.singles, req.URL.Path)
+	}
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestUpdaterUpdateDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	engine := newLRUEngine(dir, "changeme", "changeme", 32)
+	defer engine.Close()
+	for i, path := range []string{"a/c1", "a/c2", "b/c3"} {
+		parts := strings.Split(path, "/")
+		vars := map[string]string{"device": "sda", "partition": "0", "account": parts[0], "container": parts[1]}
+		_, c, err := engine.Create(vars, "100000000.00000", nil, 0, 0)
+		require.Nil(t, err)
+		require.Nil(t, mergeItemsByName(c, []string{"o1", "o2"}[:i%2+1]))
+		engine.Return(c)
+	}
+
+	devs := []*ring.Device{
+		{Id: 0, Device: "sda", Scheme: "http", Ip: "127.0.0.1", Port: 6002},
+		{Id: 1, Device: "sdb", Scheme: "http", Ip: "127.0.0.1", Port: 6002},
+		{Id: 2, Device: "sdc", Scheme: "http", Ip: "127.0.0.1", Port: 6002},
+	}
+	client := &accountBatchClient{batchStatus: http.StatusInternalServerError, status: http.StatusCreated}
+	u := &Updater{
+		deviceRoot:  dir,
+		accountRing: &test.FakeRing{MockDevices: devs},
+		client:      client,
+		logger:      zap.NewNop(),
+		batchSize:   10,
+		concurrency: 2,
+		backoffMin:  time.Hour,
+		backoffMax:  time.Hour,
+		backoffs:    map[string]*updateBackoff{},
+	}
+	u.setMetricsScope(tally.NoopScope)
+	devicePath := filepath.Join(dir, "sda")
+
+	// Each account's containers go to each of its nodes in one batch, and a failed batch backs them off.
+	require.EqualValues(t, 3, u.updateDevice(devicePath))
+	require.Equal(t, 6, len(client.batches))
+	require.Equal(t, 0, len(client.singles))
+	counts := map[string]int64{}
+	for _, records := range client.batches {
+		for _, record := range records {
+			counts[record.Name] = record.ObjectCount
+		}
+		if records[0].Name == "c3" {
+			require.Equal(t, 1, len(records))
+		} else {
+			require.Equal(t, 2, len(records))
+		}
+	}
+	require.Equal(t, map[string]int64{"c1": 1, "c2": 2, "c3": 1}, counts)
+	require.Equal(t, 3, len(u.backoffs))
+	client.batches = nil
+	require.EqualValues(t, 3, u.updateDevice(devicePath))
+	require.Equal(t, 0, len(client.batches))
+
+	// Account servers that don't take batches get the updates one at a time, and once they succeed there's
+	// nothing left to report.
+	for _, b := range u.backoffs {
+		b.next = time.Now()
+	}
+	client.batchStatus = http.StatusBadRequest
+	require.EqualValues(t, 3, u.updateDevice(devicePath))
+	require.Equal(t, 6, len(client.batches))
+	require.Equal(t, 9, len(client.singles))
+	require.Equal(t, 0, len(u.backoffs))
+	client.batches, client.singles = nil, nil
+	require.EqualValues(t, 0, u.updateDevice(devicePath))
+	require.Equal(t, 0, len(client.batches))
+
+	// A successful batch is all it takes.
+	c, err := engine.Get(map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c1"})
+	require.Nil(t, err)
+	require.Nil(t, mergeItemsByName(c, []string{"o3"}))
+	engine.Return(c)
+	client.batchStatus = http.StatusAccepted
+	require.EqualValues(t, 1, u.updateDevice(devicePath))
+	require.Equal(t, 3, len(client.batches))
+	require.Equal(t, 0, len(client.singles))
+	require.EqualValues(t, 0, u.updateDevice(devicePath))
+}
@@ -618,7 +618,7 @@ func ReconHandler(driveRoot string, reconCachePath string, mountCheck bool, writ
 		}
 	case "updater":
 		if vars["recon_type"] == "container" {
-			content, err = fromReconCache(reconCachePath, "container", "container_updater_sweep")
+			content, err = fromReconCache(reconCachePath, "container", "container_updater_sweep", "container_updater_backlog")
 		} else if vars["recon_type"] == "object" {
 			content, err = fromReconCache(reconCachePath, "object", "object_updater_sweep")
 		}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"io"
 	"math/bits"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -72,12 +73,19 @@ type Auditor struct {
 	errors, totalErrors           int64
 }
 
-func slowCopyMd5(file *os.File, bps int64) (int64, string, error) {
+// slowCopyMd5 returns the md5 of the file's contents, reading at no more than
+// bps bytes per second. The contents are also written to any dsts, such as a
+// common.Checksummer's Writer.
+func slowCopyMd5(file *os.File, bps int64, dsts ...io.Writer) (int64, string, error) {
 	h := md5.New()
+	var w io.Writer = h
+	if len(dsts) > 0 {
+		w = io.MultiWriter(append([]io.Writer{h}, dsts...)...)
+	}
 	st := time.Now()
 	bytesRead := int64(0)
 	for {
-		if b, err := io.CopyN(h, file, 64*1024); err != nil {
+		if b, err := io.CopyN(w, file, 64*1024); err != nil {
 			if err != io.EOF {
 				return bytesRead, "", err
 			}
@@ -142,7 +150,17 @@ func (ecAuditor) AuditItem(path string, item *IndexDBItem, md5BytesPerSec int64)
 			return 0, fmt.Errorf("Error opening file: %s", err)
 		}
 		defer file.Close()
-		bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec)
+		// Checksums cover the whole object, so can only be checked on the
+		// nursery copy rather than on individual shards.
+		checksumHeaders := http.Header{}
+		if item.Nursery {
+			checksumHeaders = common.ChecksumHeaders(metadata)
+		}
+		checksummer, err := common.NewChecksummer(checksumHeaders)
+		if err != nil {
+			return 0, fmt.Errorf("Error reading checksums from metadata: %s", err)
+		}
+		bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec, checksummer.Writer())
 		if err != nil {
 			return bytesRead, fmt.Errorf("Error calc md5 file: %s", err)
 		}
@@ -152,6 +170,9 @@ func (ecAuditor) AuditItem(path string, item *IndexDBItem, md5BytesPerSec int64)
 		if calcHsh != hsh {
 			return bytesRead, fmt.Errorf("File contents don't match object hash")
 		}
+		if err := checksummer.Verify(); err != nil {
+			return bytesRead, fmt.Errorf("File contents don't match checksum: %s", err)
+		}
 		return bytesRead, nil
 	}
 	return 0, nil
@@ -191,7 +212,11 @@ func (repAuditor) AuditItem(path string, item *IndexDBItem, md5BytesPerSec int64
 			return 0, fmt.Errorf("Error opening file: %s", err)
 		}
 		defer file.Close()
-		bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec)
+		checksummer, err := common.NewChecksummer(common.ChecksumHeaders(metadata))
+		if err != nil {
+			return 0, fmt.Errorf("Error reading checksums from metadata: %s", err)
+		}
+		bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec, checksummer.Writer())
 		if err != nil {
 			return bytesRead, fmt.Errorf("Error calc md5 file: %s", err)
 		}
@@ -201,13 +226,16 @@ func (repAuditor) AuditItem(path string, item *IndexDBItem, md5BytesPerSec int64
 		if calcHsh != hsh {
 			return bytesRead, fmt.Errorf("File contents don't match object hash")
 		}
+		if err := checksummer.Verify(); err != nil {
+			return bytesRead, fmt.Errorf("File contents don't match checksum: %s", err)
+		}
 		return bytesRead, nil
 	}
 	return 0, nil
 }
 
 func QuarantineItem(db *IndexDB, item *IndexDBItem) error {
-	itemPath, err := db.WholeObjectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery)
+	itemPath, err := db.ItemPath(item)
 	if err != nil {
 		return err
 	}
@@ -304,7 +332,13 @@ func auditHash(hashPath string, md5BytesPerSec int64) (bytesProcessed int64, err
 				if err != nil {
 					return bytesProcessed, fmt.Errorf("Error opening file: %s", err)
 				}
-				bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec)
+				checksummer, err := common.NewChecksummer(common.ChecksumHeaders(metadata))
+				if err != nil {
+					file.Close()
+					return bytesProcessed, fmt.Errorf("Error reading checksums from metadata: %s", err)
+				}
+				bytesRead, calcHsh, err := slowCopyMd5(file, md5BytesPerSec, checksummer.Writer())
+				file.Close()
 				if err != nil {
 					return bytesRead, fmt.Errorf("Error calc md5 file: %s", err)
 				}
@@ -312,6 +346,9 @@ func auditHash(hashPath string, md5BytesPerSec int64) (bytesProcessed int64, err
 				if calcHsh != metadata["ETag"] {
 					return bytesProcessed, fmt.Errorf("File contents don't match etag")
 				}
+				if err := checksummer.Verify(); err != nil {
+					return bytesProcessed, fmt.Errorf("File contents don't match checksum: %s", err)
+				}
 			}
 		} else if ext == ".ts" {
 			for _, reqEntry := range []string{"name", "X-Timestamp"} {
@@ -375,7 +412,15 @@ func (a *Auditor) auditDB(devPath string, objRing ring.Ring, policy *conf.Policy
 		a.logger.Error("No auditor set policy", zap.String("policy-type", policy.Type), zap.Int("policy-index", policy.Index))
 		return
 	}
-	db, err := NewIndexDB(dbpath, path, temppath, ringPartPower, int(dbPartPower), subdirs, 0, zapLogger, a.idbAuditors[policy.Index])
+	var db *IndexDB
+	if fastRoot := policy.Config["fast_devices"]; fastRoot != "" {
+		// Hybrid placement; items may be on either device so both get audited.
+		fastpath := filepath.Join(fastRoot, filepath.Base(devPath), PolicyDir(policy.Index), policy.Type)
+		fasttemppath := filepath.Join(fastRoot, filepath.Base(devPath), "tmp")
+		db, err = NewHybridIndexDB(dbpath, fastpath, path, fasttemppath, temppath, ringPartPower, int(dbPartPower), subdirs, 0, zapLogger, a.idbAuditors[policy.Index])
+	} else {
+		db, err = NewIndexDB(dbpath, path, temppath, ringPartPower, int(dbPartPower), subdirs, 0, zapLogger, a.idbAuditors[policy.Index])
+	}
 	if err != nil {
 		a.errors++
 		a.totalErrors++
@@ -392,7 +437,7 @@ func (a *Auditor) auditDB(devPath string, objRing ring.Ring, policy *conf.Policy
 			return
 		}
 		for _, item := range items {
-			itemPath, err := db.WholeObjectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery)
+			itemPath, err := db.ItemPath(item)
 			if err != nil {
 				a.logger.Error("Error getting indexdb path for hash",
 					zap.String("hash", item.Hash), zap.Error(err))
@@ -580,6 +580,28 @@ func TestAuditDB(t *testing.T) {
 	require.Equal(t, nurseryPath, fake.nurseryPaths[0])
 }
 
+func TestRepAuditChecksum(t *testing.T) {
+	auditFuncs := repAuditor{}
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	fName := filepath.Join(dir, "12345")
+	f, _ := os.Create(fName)
+	f.Write([]byte("testcontents"))
+	f.Close()
+	meta := []byte("{\"Content-Length\": \"12\", \"ETag\": \"d3ac5112fe464b81184352ccba743001\", " +
+		"\"X-Object-Checksum-Sha256\": \"7097a82a108e78da1f0a6b994cefbb3f97d14cf581734619c38d2eb8ef4f2e60\"}")
+	item := IndexDBItem{Metabytes: meta}
+	bytes, err := auditFuncs.AuditItem(fName, &item, 10000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), bytes)
+
+	meta = []byte("{\"Content-Length\": \"12\", \"ETag\": \"d3ac5112fe464b81184352ccba743001\", " +
+		"\"X-Object-Checksum-Sha256\": \"0000000000000000000000000000000000000000000000000000000000000000\"}")
+	item = IndexDBItem{Metabytes: meta}
+	_, err = auditFuncs.AuditItem(fName, &item, 10000)
+	assert.NotNil(t, err)
+}
+
 func TestAuditShardPasses(t *testing.T) {
 	auditFuncs := ecAuditor{}
 	dir, _ := ioutil.TempDir("", "")
@@ -73,6 +73,9 @@ type ecEngine struct {
 	nurseryNotifyStabilizeFailure  tally.Counter
 	nurseryNotifyStabilizeSuccess  tally.Counter
 	nurseryNotifyStabilizeSkips    tally.Counter
+	shardHedgeDelay                time.Duration
+	shardHedges                    tally.Counter
+	shardHedgeWins                 tally.Counter
 }
 
 func (f *ecEngine) getDB(device string) (*IndexDB, error) {
@@ -113,6 +116,9 @@ func (f *ecEngine) New(vars map[string]string, needData bool, asyncWG *sync.Wait
 		metadata:        map[string]string{},
 		nurseryReplicas: f.nurseryReplicas,
 		txnId:           vars["txnId"],
+		hedgeDelay:      f.shardHedgeDelay,
+		hedges:          f.shardHedges,
+		hedgeWins:       f.shardHedgeWins,
 	}
 	if idb, err := f.getDB(vars["device"]); err == nil {
 		obj.idb = idb
@@ -484,13 +490,16 @@ func (f *ecEngine) GetObjectsToReplicate(prirep PriorityRepJob, c chan ObjectSta
 				client:       f.client,
 				metadata:     map[string]string{},
 				txnId:        fmt.Sprintf("%s-%s", common.UUID(), prirep.FromDevice.Device),
+				hedgeDelay:   f.shardHedgeDelay,
+				hedges:       f.shardHedges,
+				hedgeWins:    f.shardHedgeWins,
 			}
 			if err = json.Unmarshal(item.Metabytes, &obj.metadata); err != nil {
 				//TODO: this should quarantine right?
 				f.logger.Error("error unmarshal metabytes", zap.Error(err))
 				continue
 			}
-			if obj.Path, err = idb.WholeObjectPath(obj.Hash, obj.Shard, obj.Timestamp, obj.Nursery); err != nil {
+			if obj.Path, err = idb.ItemPath(item); err != nil {
 				//TODO: this should quarantine right?
 				f.logger.Error("error building obj path", zap.Error(err))
 				continue
@@ -541,6 +550,8 @@ func (f *ecEngine) RegisterHandlers(addRoute func(method, path string, handler h
 	f.nurseryNotifyStabilizeFailure = metScope.Counter(fmt.Sprintf("%d_stabilize_notify_failures", f.policy))
 	f.nurseryNotifyStabilizeSuccess = metScope.Counter(fmt.Sprintf("%d_stabilize_notify_successes", f.policy))
 	f.nurseryNotifyStabilizeSkips = metScope.Counter(fmt.Sprintf("%d_stabilize_notify_skips", f.policy))
+	f.shardHedges = metScope.Counter(fmt.Sprintf("%d_ec_shard_hedges", f.policy))
+	f.shardHedgeWins = metScope.Counter(fmt.Sprintf("%d_ec_shard_hedge_wins", f.policy))
 	addRoute("PUT", "/ec-nursery/:device/:hash", f.ecNurseryPutHandler)
 	addRoute("POST", "/ec-nursery/:device/:hash/:mhash/:ts", f.ecNurseryPostHandler)
 	addRoute("GET", "/ec-shard/:device/:hash/:index", f.ecShardGetHandler)
@@ -702,6 +713,9 @@ func ecEngineConstructor(config conf.Config, policy *conf.Policy, flags *flag.Fl
 		numSubDirs:     subdirs,
 		client:         httpClient,
 	}
+	if engine.shardHedgeDelay, err = policy.GetShardHedgeDelay(); err != nil {
+		return nil, err
+	}
 	if engine.logger, err = srv.SetupLogger("ecengine", &logLevel, flags); err != nil {
 		return nil, fmt.Errorf("Error setting up logger: %v", err)
 	}
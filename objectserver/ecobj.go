@@ -16,6 +16,7 @@
 package objectserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +36,7 @@ import (
 	"github.com/troubling/hummingbird/common/fs"
 	"github.com/troubling/hummingbird/common/ring"
 	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
 )
 
 const dataShardTimeout = time.Millisecond * 25
@@ -54,6 +56,16 @@ type ecObject struct {
 	client          common.HTTPClient
 	nurseryReplicas int
 	txnId           string
+	hedgeDelay      time.Duration
+	hedges          tally.Counter
+	hedgeWins       tally.Counter
+}
+
+// incCounter increments the counter, if the engine's metrics have been set up.
+func incCounter(c tally.Counter) {
+	if c != nil {
+		c.Inc(1)
+	}
 }
 
 func (o *ecObject) Metadata() map[string]string {
@@ -137,7 +149,7 @@ func (o *ecObject) Copy(dsts ...io.Writer) (written int64, err error) {
 	bods := make(chan *bod)
 	errs := make(chan error)
 	done := make(chan struct{})
-	grabShard := func(i int, node *ring.Device) {
+	grabShard := func(ctx context.Context, i int, node *ring.Device) {
 		req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s:%d/ec-shard/%s/%s/%d", node.Scheme, node.Ip, node.Port, node.Device, o.Hash, i), nil)
 		if err != nil {
 			select {
@@ -146,6 +158,7 @@ func (o *ecObject) Copy(dsts ...io.Writer) (written int64, err error) {
 			}
 			return
 		}
+		req = req.WithContext(ctx)
 		req.Header.Set("X-Backend-Storage-Policy-Index", strconv.Itoa(o.policy))
 		req.Header.Set("X-Shard-Timestamp", strconv.FormatInt(o.Timestamp, 10))
 		req.Header.Set("X-Trans-Id", o.txnId)
@@ -167,15 +180,37 @@ func (o *ecObject) Copy(dsts ...io.Writer) (written int64, err error) {
 		}
 	}
 	bodies := make([]io.Reader, len(nodes))
+	cancels := make([]context.CancelFunc, len(nodes))
+	hedged := make([]bool, len(nodes))
+	defer func() {
+		for _, cancel := range cancels {
+			if cancel != nil {
+				cancel()
+			}
+		}
+	}()
+	launch := func(i int) {
+		var ctx context.Context
+		ctx, cancels[i] = context.WithCancel(context.Background())
+		go grabShard(ctx, i, nodes[i])
+	}
 	bodcount := 0
 	errcount := 0
 	// launch requests for the object's data shards
 	nodeI := 0
 	for ; nodeI < dataShards; nodeI++ {
-		go grabShard(nodeI, nodes[nodeI])
+		launch(nodeI)
+	}
+	hedgeDelay := o.hedgeDelay
+	if hedgeDelay == 0 {
+		hedgeDelay = dataShardTimeout
+	}
+	var tick <-chan time.Time
+	if hedgeDelay > 0 {
+		ticker := time.NewTicker(hedgeDelay)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
-	ticker := time.NewTicker(dataShardTimeout)
-	defer ticker.Stop()
 	for {
 		select {
 		case b := <-bods:
@@ -184,6 +219,18 @@ func (o *ecObject) Copy(dsts ...io.Writer) (written int64, err error) {
 			bodcount++
 			if bodcount >= dataShards {
 				close(done)
+				// cancel any shard requests we no longer need.
+				for i, cancel := range cancels {
+					if cancel != nil && bodies[i] == nil {
+						cancel()
+					}
+				}
+				for i := range bodies {
+					if bodies[i] != nil && hedged[i] {
+						incCounter(o.hedgeWins)
+						break
+					}
+				}
 				return contentLength, ecGlue(dataShards, parityShards, bodies, chunkSize, contentLength, dsts...)
 			}
 		// if we get an error or a little time passes, request a parity shard.
@@ -192,12 +239,14 @@ func (o *ecObject) Copy(dsts ...io.Writer) (written int64, err error) {
 				close(done)
 				return 0, fmt.Errorf("Unable to retrieve enough shards to reconstruct: %v", err)
 			} else if nodeI < len(nodes) {
-				go grabShard(nodeI, nodes[nodeI])
+				launch(nodeI)
 				nodeI++
 			}
-		case <-ticker.C:
+		case <-tick:
 			if nodeI < len(nodes) {
-				go grabShard(nodeI, nodes[nodeI])
+				hedged[nodeI] = true
+				incCounter(o.hedges)
+				launch(nodeI)
 				nodeI++
 			}
 		}
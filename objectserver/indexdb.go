@@ -24,6 +24,7 @@ const (
 	shardAny                 = -1
 	shardNursery             = 0
 	numStabilizeObjects      = 100
+	numDemoteObjects         = 1000
 	maxStableObjectCacheSize = 1000000
 )
 
@@ -40,6 +41,7 @@ type IndexDBItem struct {
 	ShardHash   string
 	Restabilize bool
 	Expires     *int64
+	Fast        bool `json:"-"`
 }
 
 // IndexDB will track a set of objects.
@@ -61,9 +63,16 @@ type IndexDBItem struct {
 //
 // A given IndexDB may not even store any metadata, such as in an EC
 // system, with just "key" IndexDBs storing the metadata.
+//
+// A hybrid IndexDB (see NewHybridIndexDB) writes new content files to a
+// separate fast path, usually on an SSD, and DemoteObjects later moves them to
+// the regular filepath. The fast column of each row records which of the two
+// locations holds the item's content, so readers just follow the row.
 type IndexDB struct {
 	dbpath        string
 	filepath      string
+	fastpath      string
+	fasttemppath  string
 	RingPartPower uint // GLH: Temp exported for fakelist
 	dbPartPower   uint
 	subdirs       int
@@ -138,6 +147,35 @@ func NewIndexDB(dbpath, filepath, temppath string, ringPartPower, dbPartPower, s
 	return ot, nil
 }
 
+// NewHybridIndexDB creates an IndexDB like NewIndexDB, but new object content
+// files are placed under fastpath rather than filepath. The fasttemppath must
+// be on the same filesystem as fastpath, just as temppath must be for
+// filepath. Content stays on the fast path until DemoteObjects moves it.
+func NewHybridIndexDB(dbpath, fastpath, filepath, fasttemppath, temppath string, ringPartPower, dbPartPower, subdirs int, reserve int64, logger srv.LowLevelLogger, auditor IndexDBAuditor) (*IndexDB, error) {
+	ot, err := NewIndexDB(dbpath, filepath, temppath, ringPartPower, dbPartPower, subdirs, reserve, logger, auditor)
+	if err != nil {
+		return nil, err
+	}
+	ot.fastpath = fastpath
+	ot.fasttemppath = fasttemppath
+	if err = os.MkdirAll(ot.fasttemppath, 0700); err != nil {
+		ot.Close()
+		return nil, err
+	}
+	for i := 0; i < ot.subdirs; i++ {
+		if err = os.MkdirAll(path.Join(ot.fastpath, fmt.Sprintf("index.db.dir.%02x", i)), 0700); err != nil {
+			ot.Close()
+			return nil, err
+		}
+	}
+	return ot, nil
+}
+
+// Hybrid returns true if the IndexDB places new content on a fast path.
+func (ot *IndexDB) Hybrid() bool {
+	return ot.fastpath != ""
+}
+
 func (ot *IndexDB) init(dbi int) error {
 	db := ot.dbs[dbi]
 	if _, err := db.Exec(`
@@ -166,6 +204,7 @@ func (ot *IndexDB) init(dbi int) error {
 			shardhash TEXT, -- NULLable because not every object is a shard
 			restabilize BOOLEAN NOT NULL,
 			expires INTEGER DEFAULT NULL,
+			fast BOOLEAN NOT NULL DEFAULT 0,
 			CONSTRAINT ix_objects_hash_shard_timestamp PRIMARY KEY (hash, shard, timestamp, nursery)
 		) WITHOUT ROWID;
 	`)
@@ -178,6 +217,19 @@ func (ot *IndexDB) init(dbi int) error {
 	if _, err = tx.Exec("CREATE INDEX IF NOT EXISTS ix_object_expires ON objects(expires) WHERE expires IS NOT NULL"); err != nil {
 		return err
 	}
+	// Databases created before hybrid placement existed won't have the fast column.
+	var objectsSQL string
+	if err = tx.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'objects'").Scan(&objectsSQL); err != nil {
+		return err
+	}
+	if !strings.Contains(objectsSQL, "fast") {
+		if _, err = tx.Exec("ALTER TABLE objects ADD COLUMN fast BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("CREATE INDEX IF NOT EXISTS ix_object_fast ON objects (timestamp) WHERE fast = 1"); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -200,12 +252,7 @@ func (ot *IndexDB) TempFile(hsh string, shard int, timestamp int64, sizeHint int
 	if item != nil && item.Timestamp >= timestamp {
 		if item.Timestamp > timestamp || !item.Nursery || newWriteToNursery {
 			// quick audit on disk object before returning all clear
-			itemPath, err := ot.WholeObjectPath(
-				item.Hash, item.Shard, item.Timestamp, item.Nursery)
-			if err != nil {
-				return nil, err
-			}
-			if _, err = ot.auditor.AuditItem(itemPath, item, 0); err != nil {
+			if _, err = ot.auditor.AuditItem(item.Path, item, 0); err != nil {
 				if qerr := QuarantineItem(ot, item); qerr != nil {
 					return nil, qerr
 				}
@@ -214,11 +261,15 @@ func (ot *IndexDB) TempFile(hsh string, shard int, timestamp int64, sizeHint int
 			return nil, nil
 		}
 	}
-	dir, err := ot.wholeObjectDir(hsh)
+	dir, err := ot.wholeObjectDir(hsh, ot.Hybrid())
 	if err != nil {
 		return nil, err
 	}
-	afw, err := fs.NewAtomicFileWriter(ot.temppath, dir)
+	temppath := ot.temppath
+	if ot.Hybrid() {
+		temppath = ot.fasttemppath
+	}
+	afw, err := fs.NewAtomicFileWriter(temppath, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +289,9 @@ func (ot *IndexDB) TempFile(hsh string, shard int, timestamp int64, sizeHint int
 //
 // Timestamp is the timestamp for the object contents, not necessarily the
 // metadata.
+//
+// For a hybrid IndexDB, f must come from TempFile so that it lands on the fast
+// path; metadata only updates leave the content wherever it already is.
 func (ot *IndexDB) Commit(f fs.AtomicFileWriter, hsh string, shard int, timestamp int64, method string, metadata map[string]string, nursery bool, shardhash string) error {
 	hsh, _, dbPart, _, err := ValidateHash(hsh, ot.RingPartPower, ot.dbPartPower, ot.subdirs)
 	if err != nil {
@@ -286,8 +340,9 @@ func (ot *IndexDB) Commit(f fs.AtomicFileWriter, hsh string, shard int, timestam
 		return err
 	}
 	deletion := method == "DELETE"
+	fast := f != nil && ot.Hybrid()
 	rows, err = tx.Query(`
-        SELECT timestamp, metahash, metadata, shardhash
+        SELECT timestamp, metahash, metadata, shardhash, fast
         FROM objects
         WHERE hash = ? AND shard = ? AND nursery = ?
         ORDER BY timestamp DESC
@@ -308,14 +363,16 @@ func (ot *IndexDB) Commit(f fs.AtomicFileWriter, hsh string, shard int, timestam
 	} else {
 		var dbMetahash, dbShardHash string
 		var dbMetadata []byte
-		if err = rows.Scan(&dbTimestamp, &dbMetahash, &dbMetadata, &dbShardHash); err != nil {
+		var dbFast bool
+		if err = rows.Scan(&dbTimestamp, &dbMetahash, &dbMetadata, &dbShardHash, &dbFast); err != nil {
 			return err
 		}
 		if f == nil && !deletion {
 			// We keep the original file's timestamp if just committing new metadata. (not the x-timestamp header)
 			timestamp = dbTimestamp
+			fast = dbFast
 		}
-		dbWholeObjectPath, err = ot.WholeObjectPath(hsh, shard, dbTimestamp, nursery)
+		dbWholeObjectPath, err = ot.objectPath(hsh, shard, dbTimestamp, nursery, dbFast)
 		if err != nil {
 			return err
 		}
@@ -371,25 +428,25 @@ func (ot *IndexDB) Commit(f fs.AtomicFileWriter, hsh string, shard int, timestam
 	}
 	rows.Close()
 	var pth string
-	pth, err = ot.WholeObjectPath(hsh, shard, timestamp, nursery)
+	pth, err = ot.objectPath(hsh, shard, timestamp, nursery, fast)
 	if err != nil {
 		return err
 	}
 	restabilize := false
 	if dbWholeObjectPath == "" {
 		_, err = tx.Exec(`
-            INSERT INTO objects (hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-        `, hsh, shard, timestamp, deletion, metahash, metabytes, nursery, shardhash, restabilize, expires)
+            INSERT INTO objects (hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires, fast)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        `, hsh, shard, timestamp, deletion, metahash, metabytes, nursery, shardhash, restabilize, expires, fast)
 	} else {
 		if !nursery && method == "POST" {
 			restabilize = true
 		}
 		_, err = tx.Exec(`
             UPDATE objects
-            SET timestamp = ?, deletion = ?, metahash = ?, metadata = ?, nursery = ?, shardhash = ?, restabilize = ?, expires = ?
+            SET timestamp = ?, deletion = ?, metahash = ?, metadata = ?, nursery = ?, shardhash = ?, restabilize = ?, expires = ?, fast = ?
             WHERE hash = ? AND shard = ? AND nursery = ?
-        `, timestamp, deletion, metahash, metabytes, nursery, shardhash, restabilize, expires, hsh, shard, nursery)
+        `, timestamp, deletion, metahash, metabytes, nursery, shardhash, restabilize, expires, fast, hsh, shard, nursery)
 		if err != nil {
 			return err
 		}
@@ -433,9 +490,18 @@ func (ot *IndexDB) SetStabilized(hsh string, shard int, timestamp int64, stabili
 		return err
 	}
 	if stabilizePath {
+		// The content stays on whichever device it is already on.
+		var fast bool
+		if err = tx.QueryRow(`
+			SELECT fast FROM objects
+			WHERE hash = ? AND shard = ? AND timestamp = ?
+			LIMIT 1
+			`, hsh, shard, timestamp).Scan(&fast); err != nil {
+			return err
+		}
 		var wasPath, toPath string
-		if wasPath, err = ot.WholeObjectPath(hsh, shard, timestamp, true); err == nil {
-			if toPath, err = ot.WholeObjectPath(hsh, shard, timestamp, false); err == nil {
+		if wasPath, err = ot.objectPath(hsh, shard, timestamp, true, fast); err == nil {
+			if toPath, err = ot.objectPath(hsh, shard, timestamp, false, fast); err == nil {
 				err = os.Rename(wasPath, toPath)
 			}
 		}
@@ -446,23 +512,43 @@ func (ot *IndexDB) SetStabilized(hsh string, shard int, timestamp int64, stabili
 	return tx.Commit()
 }
 
-func (ot *IndexDB) wholeObjectDir(hsh string) (string, error) {
+func (ot *IndexDB) rootPath(fast bool) string {
+	if fast {
+		return ot.fastpath
+	}
+	return ot.filepath
+}
+
+func (ot *IndexDB) wholeObjectDir(hsh string, fast bool) (string, error) {
 	hsh, _, _, dirNm, err := ValidateHash(hsh, ot.RingPartPower, ot.dbPartPower, ot.subdirs)
 	if err != nil {
 		return "", err
 	}
-	return path.Join(ot.filepath, fmt.Sprintf("index.db.dir.%02x", dirNm)), nil
+	return path.Join(ot.rootPath(fast), fmt.Sprintf("index.db.dir.%02x", dirNm)), nil
 }
 
+// WholeObjectPath returns the path to the content file on the regular
+// filepath; use ItemPath for items that may be on the fast path of a hybrid
+// IndexDB.
 func (ot *IndexDB) WholeObjectPath(hsh string, shard int, timestamp int64, nursery bool) (string, error) {
+	return ot.objectPath(hsh, shard, timestamp, nursery, false)
+}
+
+// ItemPath returns the path to the content file of the item, following the
+// item's fast flag.
+func (ot *IndexDB) ItemPath(item *IndexDBItem) (string, error) {
+	return ot.objectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery, item.Fast)
+}
+
+func (ot *IndexDB) objectPath(hsh string, shard int, timestamp int64, nursery bool, fast bool) (string, error) {
 	hsh, _, _, dirNm, err := ValidateHash(hsh, ot.RingPartPower, ot.dbPartPower, ot.subdirs)
 	if err != nil {
 		return "", err
 	}
 	if nursery {
-		return path.Join(ot.filepath, fmt.Sprintf("index.db.dir.%02x/%s.n.%019d", dirNm, hsh, timestamp)), nil
+		return path.Join(ot.rootPath(fast), fmt.Sprintf("index.db.dir.%02x/%s.n.%019d", dirNm, hsh, timestamp)), nil
 	}
-	return path.Join(ot.filepath, fmt.Sprintf("index.db.dir.%02x/%s.%02x.%019d", dirNm, hsh, shard, timestamp)), nil
+	return path.Join(ot.rootPath(fast), fmt.Sprintf("index.db.dir.%02x/%s.%02x.%019d", dirNm, hsh, shard, timestamp)), nil
 }
 
 // Remove removes an entry from the database and its backing disk file.
@@ -472,7 +558,23 @@ func (ot *IndexDB) Remove(hsh string, shard int, timestamp int64, nursery bool,
 		return 0, err
 	}
 	db := ot.dbs[dbPart]
-	res, err := db.Exec(`
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// The fast flag is read in the same transaction as the DELETE, so a
+	// concurrent demote can't move the content out from under us.
+	var fast bool
+	if err = tx.QueryRow(`
+		SELECT fast FROM objects
+		WHERE hash = ? AND shard = ? AND timestamp = ? AND nursery = ? AND metahash = ?
+	`, hsh, shard, timestamp, nursery, metahash).Scan(&fast); err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
         DELETE
 		FROM objects
         WHERE hash = ? AND shard = ? AND timestamp = ? AND nursery = ? AND metahash = ?
@@ -480,14 +582,20 @@ func (ot *IndexDB) Remove(hsh string, shard int, timestamp int64, nursery bool,
 	if err != nil {
 		return 0, err
 	}
-	af := int64(0)
-	if af, err = res.RowsAffected(); err == nil && af > 0 {
-		path, err := ot.WholeObjectPath(hsh, shard, timestamp, nursery)
+	af, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	if af > 0 {
+		path, err := ot.objectPath(hsh, shard, timestamp, nursery, fast)
 		if err != nil {
 			return af, err
 		}
 		if err = os.Remove(path); err != nil {
-			ot.logger.Debug("remove row had no file", zap.String("hash", hsh), zap.Int64("ts", timestamp), zap.Int("shard", shard), zap.String("metahash", metahash), zap.Bool("nursery", nursery), zap.Int64("numRem", af), zap.Error(err))
+			ot.logger.Debug("remove row had no file", zap.String("hash", hsh), zap.Int64("ts", timestamp), zap.Int("shard", shard), zap.String("metahash", metahash), zap.Bool("nursery", nursery), zap.Bool("fast", fast), zap.Int64("numRem", af), zap.Error(err))
 		}
 	}
 	return af, nil
@@ -507,14 +615,14 @@ func (ot *IndexDB) Lookup(hsh string, shard int, justStable bool) (*IndexDBItem,
 	var rows *sql.Rows
 	if justStable {
 		rows, err = db.Query(`
-			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires
+			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires, fast
 			FROM objects
 			WHERE hash = ? AND shard = ? AND nursery = 0
 			LIMIT 1
 		`, hsh, shard)
 	} else if shard == shardAny {
 		rows, err = db.Query(`
-			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires
+			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires, fast
 			FROM objects
 			WHERE hash = ? AND metadata IS NOT NULL
 			ORDER BY nursery DESC, shard ASC
@@ -522,7 +630,7 @@ func (ot *IndexDB) Lookup(hsh string, shard int, justStable bool) (*IndexDBItem,
 		`, hsh)
 	} else {
 		rows, err = db.Query(`
-			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires
+			SELECT timestamp, deletion, metahash, metadata, nursery, shard, shardhash, restabilize, expires, fast
 			FROM objects
 			WHERE hash = ? AND shard = ?
 			ORDER BY nursery DESC
@@ -538,10 +646,10 @@ func (ot *IndexDB) Lookup(hsh string, shard int, justStable bool) (*IndexDBItem,
 	}
 	item := &IndexDBItem{Hash: hsh}
 	if err = rows.Scan(&item.Timestamp, &item.Deletion, &item.Metahash,
-		&item.Metabytes, &item.Nursery, &item.Shard, &item.ShardHash, &item.Restabilize, &item.Expires); err != nil {
+		&item.Metabytes, &item.Nursery, &item.Shard, &item.ShardHash, &item.Restabilize, &item.Expires, &item.Fast); err != nil {
 		return nil, err
 	}
-	item.Path, err = ot.WholeObjectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery)
+	item.Path, err = ot.ItemPath(item)
 	return item, err
 }
 
@@ -551,7 +659,7 @@ func (ot *IndexDB) ListObjectsToStabilize() ([]*IndexDBItem, error) {
 	for _, db := range ot.dbs {
 		if err := func() error {
 			rows, err := db.Query(`
-				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, restabilize, expires
+				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, restabilize, expires, fast
 				FROM objects
 				WHERE nursery = 1 OR restabilize = 1
                 ORDER BY timestamp LIMIT ?`, numStabilizeObjects)
@@ -562,10 +670,10 @@ func (ot *IndexDB) ListObjectsToStabilize() ([]*IndexDBItem, error) {
 			for rows.Next() {
 				item := &IndexDBItem{}
 				if err = rows.Scan(&item.Hash, &item.Shard, &item.Timestamp, &item.Deletion, &item.Metahash,
-					&item.Metabytes, &item.Nursery, &item.Restabilize, &item.Expires); err != nil {
+					&item.Metabytes, &item.Nursery, &item.Restabilize, &item.Expires, &item.Fast); err != nil {
 					return err
 				}
-				item.Path, err = ot.WholeObjectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery)
+				item.Path, err = ot.ItemPath(item)
 				if err != nil {
 					return err
 				}
@@ -605,7 +713,7 @@ func (ot *IndexDB) List(startHash, stopHash, marker string, limit int) ([]*Index
 		var rows *sql.Rows
 		if limit > 0 {
 			rows, err = db.Query(`
-				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires
+				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires, fast
 			FROM objects
 			WHERE hash BETWEEN ? AND ? AND hash > ?
 			ORDER BY hash
@@ -613,7 +721,7 @@ func (ot *IndexDB) List(startHash, stopHash, marker string, limit int) ([]*Index
 		    `, startHash, stopHash, marker, limit)
 		} else {
 			rows, err = db.Query(`
-				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires
+				SELECT hash, shard, timestamp, deletion, metahash, metadata, nursery, shardhash, restabilize, expires, fast
 			FROM objects
 			WHERE hash BETWEEN ? AND ? AND hash > ?
 			ORDER BY hash
@@ -626,7 +734,7 @@ func (ot *IndexDB) List(startHash, stopHash, marker string, limit int) ([]*Index
 		for rows.Next() {
 			item := &IndexDBItem{}
 			if err = rows.Scan(&item.Hash, &item.Shard, &item.Timestamp, &item.Deletion, &item.Metahash,
-				&item.Metabytes, &item.Nursery, &item.ShardHash, &item.Restabilize, &item.Expires); err != nil {
+				&item.Metabytes, &item.Nursery, &item.ShardHash, &item.Restabilize, &item.Expires, &item.Fast); err != nil {
 				return listing, err
 			}
 			listing = append(listing, item)
@@ -644,9 +752,10 @@ func (ot *IndexDB) ExpireObjects() error {
 		timestamp int64
 		shard     int
 		nursery   bool
+		fast      bool
 	}
 	for dbIndex, db := range ot.dbs {
-		rows, err := db.Query("SELECT hash, shard, timestamp, nursery FROM objects WHERE expires < ?", time.Now().Unix())
+		rows, err := db.Query("SELECT hash, shard, timestamp, nursery, fast FROM objects WHERE expires < ?", time.Now().Unix())
 		if err != nil {
 			ot.logger.Error("database error", zap.Error(err), zap.Int("db", dbIndex))
 			return err
@@ -655,11 +764,11 @@ func (ot *IndexDB) ExpireObjects() error {
 		remove := []result{}
 		for i := 0; rows.Next(); i++ {
 			var r result
-			if err = rows.Scan(&r.hash, &r.shard, &r.timestamp, &r.nursery); err != nil {
+			if err = rows.Scan(&r.hash, &r.shard, &r.timestamp, &r.nursery, &r.fast); err != nil {
 				ot.logger.Error("database error", zap.Error(err), zap.Int("db", dbIndex))
 				return err
 			}
-			if path, err := ot.WholeObjectPath(r.hash, r.shard, r.timestamp, r.nursery); err == nil {
+			if path, err := ot.objectPath(r.hash, r.shard, r.timestamp, r.nursery, r.fast); err == nil {
 				if err := os.Remove(path); err == nil || os.IsNotExist(err) {
 					remove = append(remove, r)
 				} else {
@@ -696,6 +805,136 @@ func (ot *IndexDB) ExpireObjects() error {
 	return nil
 }
 
+// DemoteObjects moves content files from the fast path of a hybrid IndexDB to
+// the regular filepath. Items are demoted once they are older than maxAge or
+// their content is at least minSize bytes; a zero maxAge or minSize disables
+// that check. At most limit items per database are considered, oldest first.
+// It returns the number of items demoted.
+func (ot *IndexDB) DemoteObjects(maxAge time.Duration, minSize int64, limit int) (int, error) {
+	if !ot.Hybrid() {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-maxAge).UnixNano()
+	demoted := 0
+	for dbIndex, db := range ot.dbs {
+		items, err := ot.listFast(db, limit)
+		if err != nil {
+			ot.logger.Error("database error", zap.Error(err), zap.Int("db", dbIndex))
+			return demoted, err
+		}
+		for _, item := range items {
+			if maxAge <= 0 || item.Timestamp >= cutoff {
+				if minSize <= 0 {
+					continue
+				}
+				if fi, err := os.Stat(item.Path); err != nil || fi.Size() < minSize {
+					continue
+				}
+			}
+			moved, err := ot.demote(db, item)
+			if err != nil {
+				ot.logger.Error("error demoting item", zap.Error(err), zap.String("path", item.Path))
+				continue
+			}
+			if moved {
+				demoted++
+			}
+		}
+	}
+	return demoted, nil
+}
+
+func (ot *IndexDB) listFast(db *sql.DB, limit int) ([]*IndexDBItem, error) {
+	rows, err := db.Query(`
+		SELECT hash, shard, timestamp, nursery
+		FROM objects
+		WHERE fast = 1
+		ORDER BY timestamp LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	listing := []*IndexDBItem{}
+	for rows.Next() {
+		item := &IndexDBItem{Fast: true}
+		if err = rows.Scan(&item.Hash, &item.Shard, &item.Timestamp, &item.Nursery); err != nil {
+			return nil, err
+		}
+		if item.Path, err = ot.ItemPath(item); err != nil {
+			return nil, err
+		}
+		listing = append(listing, item)
+	}
+	return listing, rows.Err()
+}
+
+// demote copies the item's content to the regular filepath and flips its row
+// to match in one transaction. It returns false without error if the row
+// changed while the content was being copied.
+func (ot *IndexDB) demote(db *sql.DB, item *IndexDBItem) (bool, error) {
+	toPath, err := ot.objectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery, false)
+	if err != nil {
+		return false, err
+	}
+	dir, err := ot.wholeObjectDir(item.Hash, false)
+	if err != nil {
+		return false, err
+	}
+	src, err := os.Open(item.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Overwritten or stabilized since it was listed.
+			return false, nil
+		}
+		return false, err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+	afw, err := fs.NewAtomicFileWriter(ot.temppath, dir)
+	if err != nil {
+		return false, err
+	}
+	defer afw.Abandon()
+	if err = afw.Preallocate(fi.Size(), ot.reserve); err != nil {
+		return false, err
+	}
+	if _, err = common.Copy(src, afw); err != nil {
+		return false, err
+	}
+	if err = afw.Sync(); err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		UPDATE objects SET fast = 0
+		WHERE hash = ? AND shard = ? AND timestamp = ? AND nursery = ? AND fast = 1
+		`, item.Hash, item.Shard, item.Timestamp, item.Nursery)
+	if err != nil {
+		return false, err
+	}
+	if af, err := res.RowsAffected(); err != nil || af == 0 {
+		return false, err
+	}
+	if err = afw.Finalize(toPath); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		os.Remove(toPath)
+		return false, err
+	}
+	if err = os.Remove(item.Path); err != nil {
+		ot.logger.Error("error removing demoted file", zap.Error(err), zap.String("path", item.Path))
+	}
+	return true, nil
+}
+
 func ValidateHash(hsh string, ringPartPower, dbPartPower uint, subdirs int) (hshOut string, ringPart, dbPart, dirNm int, err error) {
 	hsh = strings.ToLower(hsh)
 	if len(hsh) != 32 {
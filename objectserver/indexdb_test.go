@@ -661,3 +661,72 @@ func TestIndexDB_Expire(t *testing.T) {
 	require.Nil(t, err)
 	require.False(t, fs.Exists(path))
 }
+
+func TestIndexDB_HybridDemote(t *testing.T) {
+	pth, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(pth)
+	ot, err := NewHybridIndexDB(pth+"/db", pth+"/fast", pth+"/slow", pth+"/fasttmp", pth+"/tmp", 2, 1, 1, 0, zap.L(), fakeIndexDBAuditor{})
+	errnil(t, err)
+	defer ot.Close()
+	hsh := md5hash("object1")
+	timestamp := time.Now().UnixNano()
+	body := "just testing"
+	f, err := ot.TempFile(hsh, 0, timestamp, int64(len(body)), false)
+	errnil(t, err)
+	f.Write([]byte(body))
+	require.Nil(t, ot.Commit(f, hsh, 0, timestamp, "PUT", map[string]string{"Content-Length": "12"}, false, ""))
+	item, err := ot.Lookup(hsh, 0, false)
+	require.Nil(t, err)
+	require.True(t, item.Fast)
+	fastPath := item.Path
+	slowPath, err := ot.WholeObjectPath(hsh, 0, timestamp, false)
+	require.Nil(t, err)
+	require.NotEqual(t, fastPath, slowPath)
+	require.True(t, fs.Exists(fastPath))
+	require.False(t, fs.Exists(slowPath))
+	// A metadata update leaves the content where it is.
+	require.Nil(t, ot.Commit(nil, hsh, 0, timestamp+1, "POST", map[string]string{"X-Object-Meta-A": "b"}, false, ""))
+	item, err = ot.Lookup(hsh, 0, false)
+	require.Nil(t, err)
+	require.True(t, item.Fast)
+	// Too young and too small to be demoted.
+	demoted, err := ot.DemoteObjects(time.Hour, 1024, 10)
+	require.Nil(t, err)
+	require.Equal(t, 0, demoted)
+	// Large enough by size.
+	demoted, err = ot.DemoteObjects(time.Hour, 10, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, demoted)
+	item, err = ot.Lookup(hsh, 0, false)
+	require.Nil(t, err)
+	require.False(t, item.Fast)
+	require.Equal(t, slowPath, item.Path)
+	require.False(t, fs.Exists(fastPath))
+	data, err := ioutil.ReadFile(slowPath)
+	require.Nil(t, err)
+	require.Equal(t, body, string(data))
+	demoted, err = ot.DemoteObjects(time.Nanosecond, 0, 10)
+	require.Nil(t, err)
+	require.Equal(t, 0, demoted)
+	// Removing the demoted item removes its content from the slow path.
+	_, err = ot.Remove(item.Hash, item.Shard, item.Timestamp, item.Nursery, item.Metahash)
+	require.Nil(t, err)
+	require.False(t, fs.Exists(slowPath))
+	// Content that's still on the fast path is removed from there.
+	hsh = md5hash("object2")
+	f, err = ot.TempFile(hsh, 0, timestamp, int64(len(body)), false)
+	errnil(t, err)
+	f.Write([]byte(body))
+	require.Nil(t, ot.Commit(f, hsh, 0, timestamp, "PUT", map[string]string{"Content-Length": "12"}, false, ""))
+	item, err = ot.Lookup(hsh, 0, false)
+	require.Nil(t, err)
+	require.True(t, item.Fast)
+	require.True(t, fs.Exists(item.Path))
+	removed, err := ot.Remove(item.Hash, item.Shard, item.Timestamp, item.Nursery, item.Metahash)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), removed)
+	require.False(t, fs.Exists(item.Path))
+	removed, err = ot.Remove(item.Hash, item.Shard, item.Timestamp, item.Nursery, item.Metahash)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), removed)
+}
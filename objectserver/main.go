@@ -26,6 +26,7 @@ import (
 	_ "net/http/pprof"
 	"net/textproto"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -54,6 +55,7 @@ type ObjectServer struct {
 	reconCachePath     string
 	checkEtags         bool
 	checkMounts        bool
+	checksums          []string
 	allowedHeaders     map[string]bool
 	logger             srv.LowLevelLogger
 	logLevel           zap.AtomicLevel
@@ -68,6 +70,11 @@ type ObjectServer struct {
 	traceCloser        io.Closer
 	tracer             opentracing.Tracer
 	updateClientCloser io.Closer
+	updateBatchWindow  time.Duration
+	updateNodeTimeout  time.Duration
+	updateBatchSize    int
+	updateBatchLock    sync.Mutex
+	updateBatches      map[string]*updateBatch
 }
 
 func (server *ObjectServer) Type() string {
@@ -166,7 +173,8 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 	for key, value := range metadata {
 		if allowed, ok := server.allowedHeaders[key]; (ok && allowed) ||
 			strings.HasPrefix(key, "X-Object-Meta-") ||
-			strings.HasPrefix(key, "X-Object-Sysmeta-") {
+			strings.HasPrefix(key, "X-Object-Sysmeta-") ||
+			common.IsChecksumHeader(key) {
 			headers.Set(key, value)
 		}
 	}
@@ -231,11 +239,20 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 	if request.Method == "GET" {
 		if server.checkEtags {
 			hash := md5.New()
-			_, err := obj.Copy(writer, hash)
+			checksummer, err := common.NewChecksummer(common.ChecksumHeaders(metadata))
+			if err != nil {
+				srv.GetLogger(request).Error("Invalid checksum metadata", zap.Error(err))
+				obj.Quarantine()
+				return
+			}
+			_, err = obj.Copy(writer, hash, checksummer.Writer())
 			if err != nil {
 				srv.GetLogger(request).Error("Error copying body", zap.Error(err))
 			} else if hex.EncodeToString(hash.Sum(nil)) != metadata["ETag"] {
 				obj.Quarantine()
+			} else if err := checksummer.Verify(); err != nil {
+				srv.GetLogger(request).Error("Object failed checksum", zap.Error(err))
+				obj.Quarantine()
 			}
 		} else {
 			_, err := obj.Copy(writer)
@@ -300,6 +317,12 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 		}
 	}
 
+	checksummer, err := common.NewChecksummer(request.Header, server.checksums...)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	tempFile, err := obj.SetData(request.ContentLength)
 	if err == DriveFullError {
 		srv.GetLogger(request).Debug("Not enough space available")
@@ -312,7 +335,7 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 	}
 
 	hash := md5.New()
-	totalSize, err := common.Copy(request.Body, tempFile, hash)
+	totalSize, err := common.Copy(request.Body, tempFile, hash, checksummer.Writer())
 	if err == io.ErrUnexpectedEOF || (request.ContentLength >= 0 && totalSize != request.ContentLength) {
 		srv.StandardResponse(writer, 499)
 		return
@@ -340,7 +363,15 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 		http.Error(writer, "Unprocessable Entity", 422)
 		return
 	}
+	if err := checksummer.Verify(); err != nil {
+		http.Error(writer, "Unprocessable Entity", 422)
+		return
+	}
 	outHeaders.Set("ETag", metadata["ETag"])
+	for key, sum := range checksummer.Sums() {
+		metadata[key] = sum
+		outHeaders.Set(key, sum)
+	}
 
 	if err := obj.Commit(metadata); err != nil {
 		srv.ErrorResponse(writer, err)
@@ -420,6 +451,15 @@ func (server *ObjectServer) ObjPostHandler(writer http.ResponseWriter, request *
 		srv.StandardResponse(writer, http.StatusInternalServerError)
 		return
 	}
+	// If metadata the container indexes changed, its listing has to be updated to match.
+	if !reflect.DeepEqual(indexedObjectMeta(origMetadata, request), indexedObjectMeta(metadata, request)) {
+		for key, value := range origMetadata {
+			if key == "Content-Length" || key == "Content-Type" || key == "ETag" || strings.HasPrefix(key, "X-Object-Sysmeta-") {
+				metadata[key] = value
+			}
+		}
+		server.containerUpdates(writer, request, metadata, request.Header.Get("X-Delete-At"), vars, srv.GetLogger(request))
+	}
 	srv.StandardResponse(writer, http.StatusAccepted)
 }
 
@@ -638,6 +678,7 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 			"X-Object-Manifest":     true,
 			"X-Static-Large-Object": true,
 		},
+		updateBatches: make(map[string]*updateBatch),
 	}
 	server.hashPathPrefix, server.hashPathSuffix, err = cnf.GetHashPrefixAndSuffix()
 	if err != nil {
@@ -651,6 +692,14 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 	server.reconCachePath = serverconf.GetDefault("app:object-server", "recon_cache_path", "/var/cache/swift")
 	server.checkMounts = serverconf.GetBool("app:object-server", "mount_check", true)
 	server.checkEtags = serverconf.GetBool("app:object-server", "check_etags", false)
+	for _, checksum := range strings.Split(serverconf.GetDefault("app:object-server", "checksums", ""), ",") {
+		if checksum = strings.TrimSpace(checksum); checksum != "" {
+			server.checksums = append(server.checksums, checksum)
+		}
+	}
+	if _, err := common.NewChecksummer(nil, server.checksums...); err != nil {
+		return ipPort, nil, nil, err
+	}
 	server.diskInUse = common.NewKeyedLimit(serverconf.GetLimit("app:object-server", "disk_limit", 25, 0))
 	server.accountDiskInUse = common.NewKeyedLimit(serverconf.GetLimit("app:object-server", "account_rate_limit", 0, 0))
 	server.expiringDivisor = serverconf.GetInt("app:object-server", "expiring_objects_container_divisor", 86400)
@@ -671,6 +720,8 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 		return ipPort, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
 	}
 	server.updateTimeout = time.Duration(serverconf.GetFloat("app:object-server", "container_update_timeout", 0.25) * float64(time.Second))
+	server.updateBatchWindow = time.Duration(serverconf.GetFloat("app:object-server", "container_update_batch_window", 0) * float64(time.Second))
+	server.updateBatchSize = int(serverconf.GetInt("app:object-server", "container_update_batch_size", 100))
 	connTimeout := time.Duration(serverconf.GetFloat("app:object-server", "conn_timeout", 1.0) * float64(time.Second))
 	nodeTimeout := time.Duration(serverconf.GetFloat("app:object-server", "node_timeout", 10.0) * float64(time.Second))
 	transport := &http.Transport{
@@ -690,6 +741,7 @@ func NewServer(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLoader
 			return ipPort, nil, nil, fmt.Errorf("Error setting up http2: %v", err)
 		}
 	}
+	server.updateNodeTimeout = nodeTimeout
 	httpClient := &http.Client{
 		Timeout:   nodeTimeout,
 		Transport: transport,
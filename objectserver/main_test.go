@@ -260,6 +260,61 @@ func TestPostContentType(t *testing.T) {
 	assert.Equal(t, 409, resp.StatusCode)
 }
 
+func TestPostIndexedMetadataUpdatesContainer(t *testing.T) {
+	testRing := &test.FakeRing{}
+	confLoader := srv.NewTestConfigLoader(testRing)
+	ts, err := makeObjectServer(confLoader)
+	assert.Nil(t, err)
+	defer ts.Close()
+
+	var updates []http.Header
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		updates = append(updates, r.Header)
+		w.WriteHeader(201)
+	}))
+	defer cs.Close()
+	u, err := url.Parse(cs.URL)
+	assert.Nil(t, err)
+	send := func(method, timestamp string, body io.Reader, headers map[string]string) {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), body)
+		assert.Nil(t, err)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Container-Partition", "1")
+		req.Header.Set("X-Container-Host", u.Host)
+		req.Header.Set("X-Container-Device", "sdb")
+		req.Header.Set("X-Backend-Container-Indexed-Metadata", "color")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+	}
+
+	send("PUT", common.GetTimestamp(), bytes.NewBuffer([]byte("SOME DATA")), map[string]string{
+		"Content-Type": "text/plain", "Content-Length": "9", "X-Object-Meta-Color": "red", "X-Object-Meta-Owner": "bob"})
+	assert.Equal(t, 1, len(updates))
+	assert.Equal(t, "red", updates[0].Get("X-Object-Meta-Color"))
+	// only the metadata the container indexes is sent along.
+	assert.Equal(t, "", updates[0].Get("X-Object-Meta-Owner"))
+
+	timestamp := common.GetTimestamp()
+	send("POST", timestamp, nil, map[string]string{"X-Object-Meta-Color": "blue", "X-Object-Meta-Owner": "bob"})
+	assert.Equal(t, 2, len(updates))
+	assert.Equal(t, "blue", updates[1].Get("X-Object-Meta-Color"))
+	assert.Equal(t, timestamp, updates[1].Get("X-Timestamp"))
+	assert.Equal(t, "text/plain", updates[1].Get("X-Content-Type"))
+	assert.Equal(t, "9", updates[1].Get("X-Size"))
+	assert.Equal(t, updates[0].Get("X-Etag"), updates[1].Get("X-Etag"))
+
+	// posts that don't change indexed metadata leave the listing alone.
+	send("POST", common.GetTimestamp(), nil, map[string]string{"X-Object-Meta-Color": "blue", "X-Object-Meta-Owner": "alice"})
+	assert.Equal(t, 2, len(updates))
+	send("POST", common.GetTimestamp(), nil, map[string]string{"X-Object-Meta-Owner": "alice"})
+	assert.Equal(t, 3, len(updates))
+	assert.Equal(t, "", updates[2].Get("X-Object-Meta-Color"))
+}
+
 func TestPostNotFound(t *testing.T) {
 	testRing := &test.FakeRing{}
 	confLoader := srv.NewTestConfigLoader(testRing)
@@ -409,6 +464,75 @@ func (f *fakeResponse) WriteHeader(s int) {
 	f.status = s
 }
 
+func TestChecksums(t *testing.T) {
+	testRing := &test.FakeRing{}
+	confLoader := srv.NewTestConfigLoader(testRing)
+	ts, err := makeObjectServer(confLoader)
+	assert.Nil(t, err)
+	defer ts.Close()
+
+	sha := "d6ec6898de87ddac6e5b3611708a7aa1c2d298293349cc1a6c299a1db7149d38"
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port),
+		bytes.NewBuffer([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "26")
+	req.Header.Set("X-Object-Checksum-SHA256", strings.ToUpper(sha))
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, sha, resp.Header.Get("X-Object-Checksum-Sha256"))
+
+	req, err = http.NewRequest("POST", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+	assert.Nil(t, err)
+	req.Header.Set("X-Object-Meta-Color", "blue")
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 202, resp.StatusCode)
+
+	resp, err = ts.Do("HEAD", "/sda/0/a/c/o", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, sha, resp.Header.Get("X-Object-Checksum-Sha256"))
+	assert.Equal(t, "blue", resp.Header.Get("X-Object-Meta-Color"))
+}
+
+func TestBadChecksum(t *testing.T) {
+	testRing := &test.FakeRing{}
+	confLoader := srv.NewTestConfigLoader(testRing)
+	ts, err := makeObjectServer(confLoader)
+	assert.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port),
+		bytes.NewBuffer([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "26")
+	req.Header.Set("X-Object-Checksum-Crc32c", "00000000")
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 422, resp.StatusCode)
+	// The mismatch is caught before the object is committed.
+	resp, err = ts.Do("HEAD", "/sda/0/a/c/o", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port),
+		bytes.NewBuffer([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "26")
+	req.Header.Set("X-Object-Checksum-Crc32c", "not hex")
+	req.Header.Set("X-Timestamp", common.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
 func TestDisconnectOnPut(t *testing.T) {
 	testRing := &test.FakeRing{}
 	confLoader := srv.NewTestConfigLoader(testRing)
@@ -5,6 +5,8 @@ import (
 	"hash/fnv"
 	"sort"
 	"strings"
+
+	"github.com/troubling/hummingbird/common"
 )
 
 // MetadataHash returns a hash of the contents of the metadata.
@@ -38,7 +40,7 @@ func MetadataMerge(a map[string]string, b map[string]string) map[string]string {
 		}
 	}
 	for key, value := range b {
-		if strings.HasPrefix(key, "X-Object-Sysmeta-") {
+		if strings.HasPrefix(key, "X-Object-Sysmeta-") || common.IsChecksumHeader(key) {
 			if _, ok := a[key]; !ok {
 				a[key] = value
 			}
@@ -46,6 +46,7 @@ type nurseryDevice struct {
 	stabilizationLastPassCountMetric    tally.Gauge
 	stabilizationLastPassDurationMetric tally.Timer
 	stabilizationSkipMetric             tally.Counter
+	demotionsMetric                     tally.Counter
 }
 
 type PriorityReplicationResult struct {
@@ -103,6 +104,14 @@ func (nrd *nurseryDevice) Scan() {
 			return
 		}
 	}
+	if te, ok := nrd.objEngine.(TieredObjectEngine); ok {
+		if demoted, err := te.DemoteObjects(nrd.dev); err != nil {
+			nrd.r.logger.Error("[stabilizeDevice] error demoting objects", zap.String("device", nrd.dev.Device), zap.Error(err))
+		} else if demoted > 0 {
+			nrd.demotionsMetric.Inc(int64(demoted))
+			nrd.UpdateStat("ObjectsDemoted", int64(demoted))
+		}
+	}
 	nrd.stabilizationLastPassCountMetric.Update(float64(count))
 	// We don't use Tally's Timer Start().Stop() since we don't want to record canceled passes.
 	nrd.stabilizationLastPassDurationMetric.Record(time.Since(start))
@@ -193,5 +202,6 @@ func GetNurseryDevice(oring ring.Ring, dev *ring.Device, policy int, r *Replicat
 	nrd.stabilizationFailuresMetric = r.metricsScope.Counter(fmt.Sprintf("%d_%s_stabilization_failures", policy, dev.Device))
 	nrd.stabilizationLastPassCountMetric = r.metricsScope.Gauge(fmt.Sprintf("%d_%s_stabilization_last_pass_count", policy, dev.Device))
 	nrd.stabilizationLastPassDurationMetric = r.metricsScope.Timer(fmt.Sprintf("%d_%s_stabilization_last_pass_duration", policy, dev.Device))
+	nrd.demotionsMetric = r.metricsScope.Counter(fmt.Sprintf("%d_%s_demotions", policy, dev.Device))
 	return nrd, nil
 }
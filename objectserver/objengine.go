@@ -94,6 +94,13 @@ type NurseryObjectEngine interface {
 	UpdateItemStabilized(device, hash, ts string, stabilized bool) bool
 }
 
+// TieredObjectEngine is a NurseryObjectEngine that writes new objects to a
+// fast device and later demotes them to slower storage.
+type TieredObjectEngine interface {
+	NurseryObjectEngine
+	DemoteObjects(device *ring.Device) (int, error)
+}
+
 type PolicyHandlerRegistrator interface {
 	RegisterHandlers(addRoute func(method, path string, handler http.HandlerFunc), metScope tally.Scope)
 }
@@ -137,6 +137,7 @@ type Replicator struct {
 	containerRing           ring.Ring
 	replicateConcurrencySem chan struct{}
 	updateConcurrencySem    chan struct{}
+	updateBatchSize         int
 	nurseryConcurrencySem   chan struct{}
 	updateStat              chan statUpdate
 	onceDone                chan struct{}
@@ -527,6 +528,7 @@ func NewReplicator(serverconf conf.Config, flags *flag.FlagSet, cnf srv.ConfigLo
 		objectRings:             make(map[int]ring.Ring),
 		replicateConcurrencySem: make(chan struct{}, concurrency),
 		updateConcurrencySem:    make(chan struct{}, updaterConcurrency),
+		updateBatchSize:         int(serverconf.GetInt("object-updater", "batch_size", 100)),
 		nurseryConcurrencySem:   make(chan struct{}, nurseryConcurrency),
 		rcTimeout:               time.Duration(serverconf.GetInt("object-replicator", "replication_timeout_sec", 0)) * time.Second,
 		updateStat:              make(chan statUpdate),
@@ -54,6 +54,14 @@ func repEngineConstructor(config conf.Config, policy *conf.Policy, flags *flag.F
 	if err != nil {
 		return nil, err
 	}
+	demoteAge, err := policy.GetDemoteAge()
+	if err != nil {
+		return nil, err
+	}
+	demoteSize, err := policy.GetDemoteSize()
+	if err != nil {
+		return nil, err
+	}
 	logLevelString := config.GetDefault("app:object-server", "log_level", "INFO")
 	logLevel := zap.NewAtomicLevel()
 	logLevel.UnmarshalText([]byte(strings.ToLower(logLevelString)))
@@ -90,6 +98,9 @@ func repEngineConstructor(config conf.Config, policy *conf.Policy, flags *flag.F
 		idbs:           map[string]*IndexDB{},
 		dbPartPower:    int(dbPartPower),
 		numSubDirs:     subdirs,
+		fastRoot:       policy.Config["fast_devices"],
+		demoteAge:      demoteAge,
+		demoteSize:     demoteSize,
 		client: &http.Client{
 			Timeout:   120 * time.Minute,
 			Transport: transport,
@@ -115,6 +126,9 @@ type repEngine struct {
 	dblock         sync.Mutex
 	dbPartPower    int
 	numSubDirs     int
+	fastRoot       string
+	demoteAge      time.Duration
+	demoteSize     int64
 	client         *http.Client
 }
 
@@ -129,7 +143,13 @@ func (re *repEngine) getDB(device string) (*IndexDB, error) {
 	path := filepath.Join(re.driveRoot, device, PolicyDir(re.policy), "repng")
 	temppath := filepath.Join(re.driveRoot, device, "tmp")
 	ringPartPower := bits.Len64(re.ring.PartitionCount() - 1)
-	re.idbs[device], err = NewIndexDB(dbpath, path, temppath, ringPartPower, re.dbPartPower, re.numSubDirs, re.reserve, re.logger, repAuditor{})
+	if re.fastRoot != "" {
+		fastpath := filepath.Join(re.fastRoot, device, PolicyDir(re.policy), "repng")
+		fasttemppath := filepath.Join(re.fastRoot, device, "tmp")
+		re.idbs[device], err = NewHybridIndexDB(dbpath, fastpath, path, fasttemppath, temppath, ringPartPower, re.dbPartPower, re.numSubDirs, re.reserve, re.logger, repAuditor{})
+	} else {
+		re.idbs[device], err = NewIndexDB(dbpath, path, temppath, ringPartPower, re.dbPartPower, re.numSubDirs, re.reserve, re.logger, repAuditor{})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -252,7 +272,7 @@ func (re *repEngine) GetObjectsToReplicate(prirep PriorityRepJob, c chan ObjectS
 			//TODO: this should prob quarantine- also in ec thing that does this too
 			continue
 		}
-		if obj.Path, err = idb.WholeObjectPath(item.Hash, item.Shard, item.Timestamp, item.Nursery); err != nil {
+		if obj.Path, err = idb.ItemPath(item); err != nil {
 			continue // TODO: quarantine here too
 		}
 		if sendItem {
@@ -309,6 +329,17 @@ func (re *repEngine) getObjectsToStabilize(device *ring.Device, c chan ObjectSta
 	}
 }
 
+func (re *repEngine) DemoteObjects(device *ring.Device) (int, error) {
+	if re.fastRoot == "" {
+		return 0, nil
+	}
+	idb, err := re.getDB(device.Device)
+	if err != nil {
+		return 0, err
+	}
+	return idb.DemoteObjects(re.demoteAge, re.demoteSize, numDemoteObjects)
+}
+
 func (re *repEngine) UpdateItemStabilized(device, hash, ts string, stabilized bool) bool {
 	//TODO: this for some stabilization optimization later
 	return false
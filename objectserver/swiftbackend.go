@@ -323,7 +323,7 @@ func applyMetaFile(metaFile string, datafileMetadata map[string]string) (map[str
 		return nil, err
 	} else {
 		for k, v := range datafileMetadata {
-			if k == "Content-Length" || k == "Content-Type" || k == "deleted" || k == "ETag" || k == "X-Backend-Data-Timestamp" || strings.HasPrefix(k, "X-Object-Sysmeta-") {
+			if k == "Content-Length" || k == "Content-Type" || k == "deleted" || k == "ETag" || k == "X-Backend-Data-Timestamp" || strings.HasPrefix(k, "X-Object-Sysmeta-") || common.IsChecksumHeader(k) {
 				metadata[k] = v
 			}
 		}
@@ -16,14 +16,17 @@
 package objectserver
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +46,88 @@ import (
 const zeroByteHash = "d41d8cd98f00b204e9800998ecf8427e"
 const deleteAtAccount = ".expiring_objects"
 
+// containerUpdateOverridePrefix starts the object sysmeta that replaces the X-Content-Type, X-Etag, or X-Size sent in
+// container updates.
+const containerUpdateOverridePrefix = "X-Object-Sysmeta-Container-Update-Override-"
+
+// containerIndexedMetadataHeader is set by the proxy to the object metadata keys the object's container indexes.
+// Only those are sent along with container updates.
+const containerIndexedMetadataHeader = "X-Backend-Container-Indexed-Metadata"
+
+// indexedObjectMeta returns the object's X-Object-Meta- headers whose keys the container indexes.
+func indexedObjectMeta(metadata map[string]string, request *http.Request) map[string]string {
+	indexed := make(map[string]string)
+	for _, key := range strings.Split(request.Header.Get(containerIndexedMetadataHeader), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			header := http.CanonicalHeaderKey("X-Object-Meta-" + key)
+			if value, ok := metadata[header]; ok {
+				indexed[header] = value
+			}
+		}
+	}
+	return indexed
+}
+
+// containerRecord is an object row, as accepted by a container server's batched object update.
+type containerRecord struct {
+	Name               string            `json:"name"`
+	CreatedAt          string            `json:"created_at"`
+	Size               int64             `json:"size"`
+	ContentType        string            `json:"content_type"`
+	ETag               string            `json:"etag"`
+	Deleted            int               `json:"deleted"`
+	StoragePolicyIndex int               `json:"storage_policy_index"`
+	Expires            *string           `json:"expires"`
+	Meta               map[string]string `json:"meta,omitempty"`
+}
+
+// newContainerRecord builds the container row for an update from the headers that would be sent with it.
+func newContainerRecord(method, obj string, headers http.Header) *containerRecord {
+	rec := &containerRecord{Name: obj, CreatedAt: headers.Get("X-Timestamp")}
+	rec.StoragePolicyIndex, _ = strconv.Atoi(headers.Get("X-Backend-Storage-Policy-Index"))
+	if method == "DELETE" {
+		rec.Deleted = 1
+		return rec
+	}
+	rec.Size, _ = strconv.ParseInt(headers.Get("X-Size"), 10, 64)
+	rec.ContentType = headers.Get("X-Content-Type")
+	rec.ETag = headers.Get("X-Etag")
+	if deleteAt := headers.Get("X-Delete-At"); deleteAt != "" {
+		rec.Expires = &deleteAt
+	}
+	for key := range headers {
+		if strings.HasPrefix(key, "X-Object-Meta-") {
+			if rec.Meta == nil {
+				rec.Meta = make(map[string]string)
+			}
+			rec.Meta[strings.ToLower(strings.TrimPrefix(key, "X-Object-Meta-"))] = headers.Get(key)
+		}
+	}
+	return rec
+}
+
+// pendingUpdate is a container update waiting in an updateBatch.
+type pendingUpdate struct {
+	method  string
+	obj     string
+	device  string
+	headers http.Header
+	logger  srv.LowLevelLogger
+}
+
+// updateBatch collects the updates for one container over the object server's container_update_batch_window.
+type updateBatch struct {
+	partition string
+	account   string
+	container string
+	hosts     []string
+	devices   []string
+	schemes   []string
+	updates   []*pendingUpdate
+	sent      bool
+	done      chan struct{}
+}
+
 func splitHeader(header string) []string {
 	if header == "" {
 		return []string{}
@@ -85,6 +170,105 @@ func (server *ObjectServer) sendContainerUpdate(ctx context.Context, scheme, hos
 	return false
 }
 
+// sendContainerBatch sends a batch of object rows to a container server, returning the response status or 0 if
+// the request could not be made.
+func (server *ObjectServer) sendContainerBatch(ctx context.Context, scheme, host, device, partition, account, container string, body []byte, headers http.Header) int {
+	containerUrl := fmt.Sprintf("%s://%s/%s/%s/%s/%s", scheme, host, device, partition,
+		common.Urlencode(account), common.Urlencode(container))
+	req, err := http.NewRequest("PUT", containerUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	req = req.WithContext(ctx)
+	req.Header = headers
+	resp, err := server.updateClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// batchContainerUpdate adds an update to the batch for its container, returning a channel that is closed once the
+// batch has been sent.
+func (server *ObjectServer) batchContainerUpdate(partition, account, container string, hosts, devices, schemes []string, update *pendingUpdate) chan struct{} {
+	key := strings.Join([]string{partition, account, container, strings.Join(hosts, ","), strings.Join(devices, ",")}, "/")
+	server.updateBatchLock.Lock()
+	b, ok := server.updateBatches[key]
+	if !ok {
+		b = &updateBatch{partition: partition, account: account, container: container,
+			hosts: hosts, devices: devices, schemes: schemes, done: make(chan struct{})}
+		server.updateBatches[key] = b
+		server.asyncWG.Add(1)
+		time.AfterFunc(server.updateBatchWindow, func() { server.flushUpdateBatch(key, b) })
+	}
+	b.updates = append(b.updates, update)
+	full := len(b.updates) >= server.updateBatchSize
+	server.updateBatchLock.Unlock()
+	if full {
+		go server.flushUpdateBatch(key, b)
+	}
+	return b.done
+}
+
+// flushUpdateBatch sends a batch of updates to each of its container servers, saving asyncs for any that fail.
+// The batch holds updates from many requests, so it isn't sent with any one request's context; it gets node_timeout
+// for each container server instead.
+func (server *ObjectServer) flushUpdateBatch(key string, b *updateBatch) {
+	server.updateBatchLock.Lock()
+	if b.sent {
+		server.updateBatchLock.Unlock()
+		return
+	}
+	b.sent = true
+	if server.updateBatches[key] == b {
+		delete(server.updateBatches, key)
+	}
+	server.updateBatchLock.Unlock()
+	defer server.asyncWG.Done()
+	defer close(b.done)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(b.hosts))*server.updateNodeTimeout)
+	defer cancel()
+
+	records := make([]*containerRecord, len(b.updates))
+	for i, u := range b.updates {
+		records[i] = newContainerRecord(u.method, u.obj, u.headers)
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		b.updates[0].logger.Error("Unable to marshal container update batch", zap.Error(err))
+		return
+	}
+	headers := http.Header{
+		"X-Backend-Record-Type": {"object"},
+		"Content-Type":          {"application/json"},
+		"User-Agent":            {fmt.Sprintf("object-server %d", os.Getpid())},
+		"X-Trans-Id":            b.updates[0].headers["X-Trans-Id"],
+	}
+	failed := make([]bool, len(b.updates))
+	for index := range b.hosts {
+		status := server.sendContainerBatch(ctx, b.schemes[index], b.hosts[index], b.devices[index], b.partition, b.account, b.container, body, headers)
+		if status/100 == 2 {
+			continue
+		}
+		// Sharded containers and container servers that don't know about batches need the updates one at a time.
+		fallback := status == http.StatusMovedPermanently || status == http.StatusBadRequest || status == http.StatusNotImplemented
+		for i, u := range b.updates {
+			if !fallback || !server.sendContainerUpdate(ctx, b.schemes[index], b.hosts[index], b.devices[index], u.method, b.partition, b.account, b.container, u.obj, u.headers) {
+				u.logger.Error("ERROR container update failed (saving for async update later)",
+					zap.String("Host", b.hosts[index]),
+					zap.String("Device", b.devices[index]))
+				failed[i] = true
+			}
+		}
+	}
+	for i, u := range b.updates {
+		if failed[i] {
+			server.saveAsync(u.method, b.account, b.container, u.obj, u.device, u.headers, u.logger)
+		}
+	}
+}
+
 func (server *ObjectServer) saveAsync(method, account, container, obj, localDevice string, headers http.Header, logger srv.LowLevelLogger) {
 	hash := server.hashPath(account, container, obj)
 	asyncFile := filepath.Join(server.driveRoot, localDevice, "async_pending", hash[29:32], hash+"-"+headers.Get("X-Timestamp"))
@@ -133,10 +317,30 @@ func (server *ObjectServer) updateContainer(ctx context.Context, metadata map[st
 		requestHeaders.Add("X-Content-Type", metadata["Content-Type"])
 		requestHeaders.Add("X-Size", metadata["Content-Length"])
 		requestHeaders.Add("X-Etag", metadata["ETag"])
+		// Containers may index some of the object's metadata.
+		for key, value := range indexedObjectMeta(metadata, request) {
+			requestHeaders.Set(key, value)
+		}
+		// Middleware may change how the object is listed, as symlinks do by adding their targets to their content types.
+		for key, value := range metadata {
+			if strings.HasPrefix(key, containerUpdateOverridePrefix) {
+				requestHeaders.Set("X-"+strings.TrimPrefix(key, containerUpdateOverridePrefix), value)
+			}
+		}
+	}
+	// Updates for objects in sharded containers go to the shard container holding the object's name.
+	account, container := vars["account"], vars["container"]
+	if parts := strings.SplitN(request.Header.Get("X-Backend-Container-Path"), "/", 2); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		account, container = parts[0], parts[1]
+	}
+	if server.updateBatchWindow > 0 {
+		<-server.batchContainerUpdate(partition, account, container, hosts, devices, schemes,
+			&pendingUpdate{method: request.Method, obj: vars["obj"], device: vars["device"], headers: requestHeaders, logger: logger})
+		return
 	}
 	failures := 0
 	for index := range hosts {
-		if !server.sendContainerUpdate(ctx, schemes[index], hosts[index], devices[index], request.Method, partition, vars["account"], vars["container"], vars["obj"], requestHeaders) {
+		if !server.sendContainerUpdate(ctx, schemes[index], hosts[index], devices[index], request.Method, partition, account, container, vars["obj"], requestHeaders) {
 			logger.Error("ERROR container update failed (saving for async update later)",
 				zap.String("Host", hosts[index]),
 				zap.String("Device", devices[index]))
@@ -144,7 +348,7 @@ func (server *ObjectServer) updateContainer(ctx context.Context, metadata map[st
 		}
 	}
 	if failures > 0 {
-		server.saveAsync(request.Method, vars["account"], vars["container"], vars["obj"], vars["device"], requestHeaders, logger)
+		server.saveAsync(request.Method, account, container, vars["obj"], vars["device"], requestHeaders, logger)
 	}
 }
 
@@ -16,11 +16,14 @@
 package objectserver
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -114,6 +117,42 @@ func TestUpdateContainer(t *testing.T) {
 	require.Equal(t, asyncData["obj"], "o")
 }
 
+func TestUpdateContainerOverride(t *testing.T) {
+	testRing := &test.FakeRing{}
+	confLoader := srv.NewTestConfigLoader(testRing)
+	ts, err := makeObjectServer(confLoader)
+	require.Nil(t, err)
+	server := ts.objServer
+	defer ts.Close()
+
+	requestSent := false
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/symlink;symlink_path=/v1/a/c/target", r.Header.Get("X-Content-Type"))
+		require.Equal(t, "0", r.Header.Get("X-Size"))
+		requestSent = true
+	}))
+	defer cs.Close()
+	u, err := url.Parse(cs.URL)
+	require.Nil(t, err)
+	req, err := http.NewRequest("PUT", "/I/dont/think/this/matters", nil)
+	require.Nil(t, err)
+	req.Header.Add("X-Container-Partition", "1")
+	req.Header.Add("X-Container-Host", u.Host)
+	req.Header.Add("X-Container-Device", "sdb")
+	req.Header.Add("X-Timestamp", "12345.6789")
+	vars := map[string]string{"account": "a", "container": "c", "obj": "o", "device": "sda"}
+	req = srv.SetVars(req, vars)
+	metadata := map[string]string{
+		"X-Timestamp":    "12345.789",
+		"Content-Type":   "application/symlink",
+		"Content-Length": "0",
+		"ETag":           "d41d8cd98f00b204e9800998ecf8427e",
+		"X-Object-Sysmeta-Container-Update-Override-Content-Type": "application/symlink;symlink_path=/v1/a/c/target",
+	}
+	server.updateContainer(req.Context(), metadata, req, vars, zap.NewNop())
+	require.True(t, requestSent)
+}
+
 func TestUpdateContainerNoHeaders(t *testing.T) {
 	testRing := &test.FakeRing{}
 	confLoader := srv.NewTestConfigLoader(testRing)
@@ -150,3 +189,87 @@ func TestUpdateContainerNoHeaders(t *testing.T) {
 	expectedFile := filepath.Join(ts.root, "sda", "async_pending", "099", "2f714cd91b0e5d803cde2012b01d7099-12345.6789")
 	require.False(t, fs.Exists(expectedFile))
 }
+
+func TestUpdateContainerBatch(t *testing.T) {
+	testRing := &test.FakeRing{}
+	confLoader := srv.NewTestConfigLoader(testRing)
+	ts, err := makeObjectServer(confLoader)
+	require.Nil(t, err)
+	server := ts.objServer
+	defer ts.Close()
+	server.updateBatchWindow = 50 * time.Millisecond
+
+	var lock sync.Mutex
+	batchSupported := true
+	var batches [][]*containerRecord
+	var singles []string
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("X-Backend-Record-Type") != "object" {
+			singles = append(singles, r.URL.Path)
+			w.WriteHeader(201)
+			return
+		}
+		if !batchSupported {
+			w.WriteHeader(400)
+			return
+		}
+		require.Equal(t, "/sdb/1/a/c", r.URL.Path)
+		var records []*containerRecord
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&records))
+		batches = append(batches, records)
+		w.WriteHeader(202)
+	}))
+	defer cs.Close()
+	u, err := url.Parse(cs.URL)
+	require.Nil(t, err)
+
+	sendUpdates := func(clientGone bool) {
+		var wg sync.WaitGroup
+		for _, obj := range []string{"o1", "o2", "o3"} {
+			wg.Add(1)
+			go func(obj string) {
+				defer wg.Done()
+				req, err := http.NewRequest("PUT", "/I/dont/think/this/matters", nil)
+				require.Nil(t, err)
+				req.Header.Add("X-Container-Partition", "1")
+				req.Header.Add("X-Container-Host", u.Host)
+				req.Header.Add("X-Container-Device", "sdb")
+				req.Header.Add("X-Timestamp", "12345.6789")
+				vars := map[string]string{"account": "a", "container": "c", "obj": obj, "device": "sda"}
+				req = srv.SetVars(req, vars)
+				metadata := map[string]string{
+					"Content-Type":   "text/plain",
+					"Content-Length": "30",
+					"ETag":           "ffffffffffffffffffffffffffffffff",
+				}
+				ctx, cancel := context.WithCancel(req.Context())
+				if clientGone {
+					cancel()
+				}
+				defer cancel()
+				server.updateContainer(ctx, metadata, req, vars, zap.NewNop())
+			}(obj)
+		}
+		wg.Wait()
+	}
+
+	sendUpdates(false)
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, 3, len(batches[0]))
+	require.Equal(t, "text/plain", batches[0][0].ContentType)
+	require.Equal(t, int64(30), batches[0][0].Size)
+	require.Equal(t, "12345.6789", batches[0][0].CreatedAt)
+	require.Equal(t, 0, len(singles))
+
+	// The batch isn't sent with any one request's context, so clients going away don't cancel it.
+	sendUpdates(true)
+	require.Equal(t, 2, len(batches))
+	require.Equal(t, 3, len(batches[1]))
+
+	batchSupported = false
+	sendUpdates(false)
+	require.Equal(t, 2, len(batches))
+	require.Equal(t, 3, len(singles))
+}
@@ -16,12 +16,15 @@
 package objectserver
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -86,7 +89,12 @@ func (ud *updateDevice) listAsyncs(c chan string, cancel chan struct{}) {
 }
 
 func (ud *updateDevice) updateContainers(ap *asyncPending) bool {
+	return ud.updateContainersAt(ap, false)
+}
+
+func (ud *updateDevice) updateContainersAt(ap *asyncPending, redirected bool) bool {
 	successes := uint64(0)
+	location := ""
 	part := ud.r.containerRing.GetPartition(ap.Account, ap.Container, "")
 	header := common.Map2Headers(ap.Headers)
 	header.Set("User-Agent", fmt.Sprintf("object-updater %d", os.Getpid()))
@@ -106,25 +114,86 @@ func (ud *updateDevice) updateContainers(ap *asyncPending) bool {
 		resp.Body.Close()
 		if resp.StatusCode/100 == 2 {
 			successes++
+		} else if resp.StatusCode == http.StatusMovedPermanently && location == "" {
+			location = resp.Header.Get("X-Backend-Location")
 		}
 	}
-	return successes >= (ud.r.containerRing.ReplicaCount()/2)+1
+	if successes >= (ud.r.containerRing.ReplicaCount()/2)+1 {
+		return true
+	}
+	// The container has been sharded since the update was saved, so send it to the shard holding the object.
+	if parts := strings.SplitN(location, "/", 2); len(parts) == 2 && parts[0] != "" && parts[1] != "" && !redirected {
+		ap.Account, ap.Container = parts[0], parts[1]
+		return ud.updateContainersAt(ap, true)
+	}
+	return false
 }
 
-func (ud *updateDevice) processAsync(async string) {
+// updateContainersBatch sends the updates for many objects in one container to the container servers as a single
+// batch, which they apply in one transaction.
+func (ud *updateDevice) updateContainersBatch(account, container string, aps []*asyncPending, redirected bool) bool {
+	records := make([]*containerRecord, len(aps))
+	for i, ap := range aps {
+		records[i] = newContainerRecord(ap.Method, ap.Object, common.Map2Headers(ap.Headers))
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		ud.r.logger.Error("updateContainersBatch marshalling records", zap.Error(err))
+		return false
+	}
+	successes := uint64(0)
+	location := ""
+	part := ud.r.containerRing.GetPartition(account, container, "")
+	for _, node := range ud.r.containerRing.GetNodes(part) {
+		containerUrl := fmt.Sprintf("%s://%s:%d/%s/%d/%s/%s", node.Scheme, node.Ip, node.Port, node.Device, part,
+			common.Urlencode(account), common.Urlencode(container))
+		req, err := http.NewRequest("PUT", containerUrl, bytes.NewReader(body))
+		if err != nil {
+			ud.r.logger.Error("updateContainersBatch creating new request", zap.Error(err))
+			continue
+		}
+		req.Header.Set("X-Backend-Record-Type", "object")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", fmt.Sprintf("object-updater %d", os.Getpid()))
+		resp, err := ud.r.client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			successes++
+		} else if resp.StatusCode == http.StatusMovedPermanently && location == "" {
+			location = resp.Header.Get("X-Backend-Location")
+		}
+	}
+	if successes >= (ud.r.containerRing.ReplicaCount()/2)+1 {
+		return true
+	}
+	// All of the objects belong to the same shard container, so the batch can be sent there.
+	if parts := strings.SplitN(location, "/", 2); len(parts) == 2 && parts[0] != "" && parts[1] != "" && !redirected {
+		return ud.updateContainersBatch(parts[0], parts[1], aps, true)
+	}
+	return false
+}
+
+func (ud *updateDevice) loadAsync(async string) (*asyncPending, bool) {
 	data, err := ioutil.ReadFile(async)
 	if err != nil {
 		ud.updateStat("Error", 1)
 		ud.r.logger.Error("read async_pending fail", zap.String("file", async), zap.Error(err))
-		return
+		return nil, false
 	}
 	var ap asyncPending
 	if err := pickle.Unmarshal(data, &ap); err != nil {
 		ud.updateStat("Error", 1)
 		ud.r.logger.Error("unmarshal async_pending fail", zap.String("file", async), zap.Error(err))
-		return
+		return nil, false
 	}
-	if ud.updateContainers(&ap) {
+	return &ap, true
+}
+
+func (ud *updateDevice) asyncUpdated(async string, success bool) {
+	if success {
 		ud.updateStat("Success", 1)
 		os.Remove(async)
 		os.Remove(filepath.Dir(async))
@@ -133,6 +202,61 @@ func (ud *updateDevice) processAsync(async string) {
 	}
 }
 
+func (ud *updateDevice) processAsync(async string) {
+	if ap, ok := ud.loadAsync(async); ok {
+		ud.asyncUpdated(async, ud.updateContainers(ap))
+	}
+}
+
+// processAsyncs groups asyncs by container, sending each container's updates as one batch.  Updates that can't be
+// batched are retried one at a time.
+func (ud *updateDevice) processAsyncs(asyncs []string) {
+	type containerAsyncs struct {
+		files []string
+		aps   []*asyncPending
+	}
+	var order []string
+	groups := make(map[string]*containerAsyncs)
+	for _, async := range asyncs {
+		ap, ok := ud.loadAsync(async)
+		if !ok {
+			continue
+		}
+		key := ap.Account + "/" + ap.Container
+		g, ok := groups[key]
+		if !ok {
+			g = &containerAsyncs{}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.files = append(g.files, async)
+		g.aps = append(g.aps, ap)
+	}
+	for _, key := range order {
+		g := groups[key]
+		func() {
+			ud.r.updateConcurrencySem <- struct{}{}
+			defer func() {
+				<-ud.r.updateConcurrencySem
+			}()
+			if len(g.aps) > 1 && ud.updateContainersBatch(g.aps[0].Account, g.aps[0].Container, g.aps, false) {
+				for _, async := range g.files {
+					ud.asyncUpdated(async, true)
+				}
+				return
+			}
+			for i, async := range g.files {
+				ud.asyncUpdated(async, ud.updateContainers(g.aps[i]))
+			}
+		}()
+		select {
+		case <-time.After(asyncPendingSleep):
+		case <-ud.canchan:
+			return
+		}
+	}
+}
+
 func (ud *updateDevice) reconReportAsync() {
 	ud.reconLock.Lock()
 	if ud.reconRunning {
@@ -179,17 +303,25 @@ func (ud *updateDevice) update() {
 	go ud.listAsyncs(c, cancel)
 	for async := range c {
 		ud.updateStat("checkin", 1)
-		func() {
-			ud.r.updateConcurrencySem <- struct{}{}
-			defer func() {
-				<-ud.r.updateConcurrencySem
-			}()
-			ud.processAsync(async)
-		}()
+		// Gather up whatever asyncs have already been listed, so updates for the same container can be batched.
+		asyncs := []string{async}
+	gather:
+		for len(asyncs) < ud.r.updateBatchSize {
+			select {
+			case async, ok := <-c:
+				if !ok {
+					break gather
+				}
+				asyncs = append(asyncs, async)
+			default:
+				break gather
+			}
+		}
+		ud.processAsyncs(asyncs)
 		select {
-		case <-time.After(asyncPendingSleep):
 		case <-ud.canchan:
 			return
+		default:
 		}
 		if time.Since(ud.lastReconDump) > time.Hour {
 			ud.lastReconDump = time.Now()
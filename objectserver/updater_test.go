@@ -16,6 +16,7 @@
 package objectserver
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -23,9 +24,11 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/fs"
 	"github.com/troubling/hummingbird/common/pickle"
 	"github.com/troubling/hummingbird/common/ring"
 	"github.com/troubling/hummingbird/common/test"
@@ -101,3 +104,63 @@ func TestUpdaterProcessAsync(t *testing.T) {
 	require.True(t, requestedPaths["/sdb/0/a/c/o"])
 	require.True(t, requestedPaths["/sdc/0/a/c/o"])
 }
+
+func TestUpdaterProcessAsyncsBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	var asyncs []string
+	for _, ap := range []asyncPending{
+		{Headers: map[string]string{"X-Timestamp": "1.00000", "X-Size": "1", "X-Content-Type": "text/plain", "X-Etag": "d41d8cd98f00b204e9800998ecf8427e"}, Object: "o1", Account: "a", Container: "c", Method: "PUT"},
+		{Headers: map[string]string{"X-Timestamp": "2.00000"}, Object: "o2", Account: "a", Container: "c", Method: "DELETE"},
+		{Headers: map[string]string{"X-Timestamp": "3.00000"}, Object: "o3", Account: "a", Container: "c2", Method: "DELETE"},
+	} {
+		f, err := ioutil.TempFile(dir, "")
+		require.Nil(t, err)
+		f.Write(pickle.PickleDumps(&ap))
+		f.Close()
+		asyncs = append(asyncs, f.Name())
+	}
+
+	var lock sync.Mutex
+	requestedPaths := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requestedPaths[r.URL.Path]++
+		if r.URL.Path == "/sda/0/a/c" {
+			require.Equal(t, "object", r.Header.Get("X-Backend-Record-Type"))
+			var records []*containerRecord
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&records))
+			require.Equal(t, 2, len(records))
+			require.Equal(t, "o1", records[0].Name)
+			require.Equal(t, "text/plain", records[0].ContentType)
+			require.Equal(t, 1, records[1].Deleted)
+		}
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.Nil(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.Nil(t, err)
+
+	fakering := &test.FakeRing{
+		MockDevices: []*ring.Device{
+			{Ip: u.Hostname(), Port: port, Device: "sda", Scheme: "http"},
+			{Ip: u.Hostname(), Port: port, Device: "sdb", Scheme: "http"},
+			{Ip: u.Hostname(), Port: port, Device: "sdc", Scheme: "http"},
+		},
+	}
+	r := &Replicator{updateStat: make(chan statUpdate, 100), client: http.DefaultClient, containerRing: fakering,
+		updateConcurrencySem: make(chan struct{}, 1), updateBatchSize: 100}
+	updater := newUpdateDevice(&ring.Device{Device: "sda"}, 0, r)
+
+	updater.processAsyncs(asyncs)
+	require.Equal(t, 1, requestedPaths["/sda/0/a/c"])
+	require.Equal(t, 0, requestedPaths["/sda/0/a/c/o1"])
+	require.Equal(t, 1, requestedPaths["/sda/0/a/c2/o3"])
+	for _, async := range asyncs {
+		require.False(t, fs.Exists(async))
+	}
+}
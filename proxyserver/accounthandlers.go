@@ -18,11 +18,13 @@ package proxyserver
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/troubling/hummingbird/common"
 	"github.com/troubling/hummingbird/common/srv"
 	"github.com/troubling/hummingbird/proxyserver/middleware"
+	"go.uber.org/zap"
 )
 
 func (server *ProxyServer) AccountGetHandler(writer http.ResponseWriter, request *http.Request) {
@@ -39,12 +41,21 @@ func (server *ProxyServer) AccountGetHandler(writer http.ResponseWriter, request
 		}
 	}
 	options := make(map[string]string)
+	fullListing := false
 	if request.ParseForm() == nil {
 		for k, v := range request.Form {
 			if listingQueryParms[k] && len(v) > 0 {
 				options[k] = v[0]
 			}
 		}
+		fullListing = common.LooksTrue(request.Form.Get("full_listing"))
+	}
+	var format string
+	var limit int
+	if fullListing {
+		if format, limit = fullListingOptions(writer, request, options); format == "" {
+			return
+		}
 	}
 	resp := ctx.C.GetAccountRaw(request.Context(), vars["account"], options, request.Header)
 	if resp.StatusCode == http.StatusNotFound && server.accountAutoCreate &&
@@ -58,6 +69,16 @@ func (server *ProxyServer) AccountGetHandler(writer http.ResponseWriter, request
 			writer.Header().Set(k, resp.Header.Get(k))
 		}
 	}
+	if fullListing && resp.StatusCode == http.StatusOK {
+		if err := streamFullListing(writer, resp, format, limit, func(marker string, pageSize int) *http.Response {
+			options["marker"] = marker
+			options["limit"] = strconv.Itoa(pageSize)
+			return ctx.C.GetAccountRaw(request.Context(), vars["account"], options, request.Header)
+		}); err != nil {
+			ctx.Logger.Error("Error streaming full account listing", zap.String("account", vars["account"]), zap.Error(err))
+		}
+		return
+	}
 	writer.WriteHeader(resp.StatusCode)
 	defer resp.Body.Close()
 	common.Copy(resp.Body, writer)
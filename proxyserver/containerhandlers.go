@@ -18,11 +18,13 @@ package proxyserver
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/troubling/hummingbird/common"
 	"github.com/troubling/hummingbird/common/srv"
 	"github.com/troubling/hummingbird/proxyserver/middleware"
+	"go.uber.org/zap"
 )
 
 var listingQueryParms = map[string]bool{
@@ -36,6 +38,22 @@ var listingQueryParms = map[string]bool{
 	"path":       true,
 }
 
+// listingFilterParms are the query parameters that filter container listings, along with any "meta." parameters.
+var listingFilterParms = map[string]bool{
+	"content_type":    true,
+	"modified_since":  true,
+	"modified_before": true,
+	"size_gt":         true,
+	"size_lt":         true,
+}
+
+// snapshotParms are the query parameters for listing, diffing, and enumerating container snapshots.
+var snapshotParms = map[string]bool{
+	"snapshot":      true,
+	"snapshot_diff": true,
+	"snapshots":     true,
+}
+
 func (server *ProxyServer) ContainerGetHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := srv.GetVars(request)
 	ctx := middleware.GetProxyContext(request)
@@ -48,13 +66,24 @@ func (server *ProxyServer) ContainerGetHandler(writer http.ResponseWriter, reque
 		return
 	}
 	options := make(map[string]string)
+	fullListing := false
 	if request.ParseForm() == nil {
 		for k, v := range request.Form {
-			if listingQueryParms[k] && len(v) > 0 {
+			if (listingQueryParms[k] || listingFilterParms[k] || snapshotParms[k] || strings.HasPrefix(k, "meta.")) && len(v) > 0 {
 				options[k] = v[0]
 			}
 		}
+		fullListing = common.LooksTrue(request.Form.Get("full_listing"))
 	}
+	var format string
+	var limit int
+	if fullListing {
+		if format, limit = fullListingOptions(writer, request, options); format == "" {
+			return
+		}
+	}
+	// Shard range listings are only for backend use.
+	request.Header.Del("X-Backend-Record-Type")
 	resp := ctx.C.GetContainerRaw(request.Context(), vars["account"], vars["container"], options, request.Header)
 	defer resp.Body.Close()
 	ctx.C.SetContainerInfo(request.Context(), vars["account"], vars["container"], resp)
@@ -70,6 +99,17 @@ func (server *ProxyServer) ContainerGetHandler(writer http.ResponseWriter, reque
 			writer.Header().Set(k, resp.Header.Get(k))
 		}
 	}
+	if fullListing && resp.StatusCode == http.StatusOK {
+		if err := streamFullListing(writer, resp, format, limit, func(marker string, pageSize int) *http.Response {
+			options["marker"] = marker
+			options["limit"] = strconv.Itoa(pageSize)
+			return ctx.C.GetContainerRaw(request.Context(), vars["account"], vars["container"], options, request.Header)
+		}); err != nil {
+			ctx.Logger.Error("Error streaming full container listing", zap.String("account", vars["account"]),
+				zap.String("container", vars["container"]), zap.Error(err))
+		}
+		return
+	}
 	writer.WriteHeader(resp.StatusCode)
 	common.Copy(resp.Body, writer)
 }
@@ -139,8 +179,14 @@ func (server *ProxyServer) ContainerPostHandler(writer http.ResponseWriter, requ
 			request.Header.Set(strings.Replace(strings.ToLower(k), "-remove", "", 1), "")
 		}
 	}
+	if _, ok := request.URL.Query()["snapshot"]; ok {
+		request.Header.Set("X-Backend-Create-Snapshot", "true")
+	}
 	resp := ctx.C.PostContainer(request.Context(), vars["account"], vars["container"], request.Header)
 	resp.Body.Close()
+	if snapshot := resp.Header.Get("X-Container-Snapshot"); snapshot != "" {
+		writer.Header().Set("X-Container-Snapshot", snapshot)
+	}
 	srv.StandardResponse(writer, resp.StatusCode)
 }
 
@@ -209,6 +255,19 @@ func (server *ProxyServer) ContainerDeleteHandler(writer http.ResponseWriter, re
 			return
 		}
 	}
+	// Snapshots are deleted with a POST to the container servers, so one that doesn't know about them can't mistake
+	// this for deleting the container.
+	if snapshot, ok := request.URL.Query()["snapshot"]; ok {
+		if len(snapshot) == 0 || snapshot[0] == "" {
+			srv.StandardResponse(writer, 400)
+			return
+		}
+		request.Header.Set("X-Backend-Delete-Snapshot", snapshot[0])
+		resp := ctx.C.PostContainer(request.Context(), vars["account"], vars["container"], request.Header)
+		resp.Body.Close()
+		srv.StandardResponse(writer, resp.StatusCode)
+		return
+	}
 	resp := ctx.C.DeleteContainer(request.Context(), vars["account"], vars["container"], request.Header)
 	resp.Body.Close()
 	srv.StandardResponse(writer, resp.StatusCode)
@@ -201,3 +201,18 @@ func (server *ProxyServer) EndpointsAccountGetHandler2(writer http.ResponseWrite
 	writer.WriteHeader(200)
 	writer.Write(body)
 }
+
+// ErrorLimitsHandler reports the backend devices the proxy has seen errors
+// from recently and whether they are currently being suppressed.
+func (server *ProxyServer) ErrorLimitsHandler(writer http.ResponseWriter, request *http.Request) {
+	body, err := json.Marshal(server.proxyClient.ErrorLimits())
+	if err != nil {
+		server.logger.Error("could not marshal error limits", zap.Error(err))
+		srv.StandardResponse(writer, 500)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	writer.WriteHeader(200)
+	writer.Write(body)
+}
@@ -0,0 +1,159 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/srv"
+)
+
+// fullListingPageLimit is how many records each backend request of a full listing asks for, which is the most a
+// backend listing returns.
+const fullListingPageLimit = 10000
+
+// fullListingOptions checks a full listing request's format and limit, and sets the options for its first backend
+// request.  It returns the listing's format and the most records it should return, which is -1 for all of them.  If
+// the request can't be a full listing, it writes an error response and returns an empty format.
+func fullListingOptions(writer http.ResponseWriter, request *http.Request, options map[string]string) (string, int) {
+	format := common.ListingFormat(options["format"], request.Header.Get("Accept"))
+	switch format {
+	case "":
+		srv.StandardResponse(writer, http.StatusNotAcceptable)
+		return "", 0
+	case common.ListingFormatText, common.ListingFormatNDJSON, common.ListingFormatCSV:
+	default:
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Full listings must be text, ndjson, or csv.")
+		return "", 0
+	}
+	limit := -1
+	if l, err := strconv.Atoi(options["limit"]); err == nil && l >= 0 {
+		limit = l
+	}
+	options["format"] = format
+	options["limit"] = strconv.Itoa(fullListingPageSize(limit, 0))
+	return format, limit
+}
+
+// fullListingPageSize returns how many records to ask for in the next page of a full listing.
+func fullListingPageSize(limit, written int) int {
+	if limit >= 0 && limit-written < fullListingPageLimit {
+		return limit - written
+	}
+	return fullListingPageLimit
+}
+
+// copyListingPage copies one page of a text, ndjson, or csv listing, leaving out the csv header row unless header is
+// true.  It returns how many records the page held and the name of the last one.
+func copyListingPage(w io.Writer, body io.Reader, format string, header bool) (int, string, error) {
+	count, last := 0, ""
+	if format == common.ListingFormatCSV {
+		cr := csv.NewReader(body)
+		cw := csv.NewWriter(w)
+		for first := true; ; first = false {
+			row, err := cr.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return count, last, err
+			}
+			if first {
+				if header {
+					cw.Write(row)
+				}
+				continue
+			}
+			if err := cw.Write(row); err != nil {
+				return count, last, err
+			}
+			count++
+			last = row[0]
+		}
+		cw.Flush()
+		return count, last, cw.Error()
+	}
+	br := bufio.NewReader(body)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if _, err := io.WriteString(w, line); err != nil {
+				return count, last, err
+			}
+			count++
+			if format == common.ListingFormatNDJSON {
+				var record struct {
+					Name   string `json:"name"`
+					Subdir string `json:"subdir"`
+				}
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					return count, last, err
+				}
+				last = record.Name
+				if record.Subdir != "" {
+					last = record.Subdir
+				}
+			} else {
+				last = line[:len(line)-1]
+			}
+		}
+		if err == io.EOF {
+			return count, last, nil
+		} else if err != nil {
+			return count, last, err
+		}
+	}
+}
+
+// streamFullListing writes the listing page in resp, then the rest of the listing, getting each following page from
+// next, which is given the marker and limit for the page.  Once the first page has been written, an error can only
+// end the response early.
+func streamFullListing(writer http.ResponseWriter, resp *http.Response, format string, limit int, next func(marker string, limit int) *http.Response) error {
+	writer.Header().Del("Content-Length")
+	writer.WriteHeader(resp.StatusCode)
+	pageSize := fullListingPageSize(limit, 0)
+	count, last, err := copyListingPage(writer, resp.Body, format, true)
+	resp.Body.Close()
+	for written := count; err == nil && count == pageSize && pageSize > 0; written += count {
+		if f, ok := writer.(http.Flusher); ok {
+			f.Flush()
+		}
+		if pageSize = fullListingPageSize(limit, written); pageSize == 0 {
+			break
+		}
+		resp = next(last, pageSize)
+		if resp.StatusCode == http.StatusNoContent {
+			resp.Body.Close()
+			break
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("listing page after %q returned %d", last, resp.StatusCode)
+		}
+		count, last, err = copyListingPage(writer, resp.Body, format, false)
+		resp.Body.Close()
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func listingResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func TestFullListingOptions(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/v1/a/c?full_listing=true", nil)
+	require.Nil(t, err)
+	options := map[string]string{"format": "csv", "limit": "25000"}
+	format, limit := fullListingOptions(w, req, options)
+	require.Equal(t, "csv", format)
+	require.Equal(t, 25000, limit)
+	require.Equal(t, "10000", options["limit"])
+
+	options = map[string]string{"format": "", "limit": "5"}
+	format, limit = fullListingOptions(w, req, options)
+	require.Equal(t, "text", format)
+	require.Equal(t, 5, limit)
+	require.Equal(t, "5", options["limit"])
+
+	options = map[string]string{"format": "json"}
+	format, _ = fullListingOptions(w, req, options)
+	require.Equal(t, "", format)
+	require.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Accept", "text/html")
+	format, _ = fullListingOptions(w, req, map[string]string{})
+	require.Equal(t, "", format)
+	require.Equal(t, 406, w.Code)
+}
+
+func TestCopyListingPageCSV(t *testing.T) {
+	w := httptest.NewRecorder()
+	count, last, err := copyListingPage(w, strings.NewReader("name,bytes\na,1\n\"b,c\",2\n"), "csv", true)
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, "b,c", last)
+	require.Equal(t, "name,bytes\na,1\n\"b,c\",2\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	count, last, err = copyListingPage(w, strings.NewReader("name,bytes\nd,4\n"), "csv", false)
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, "d", last)
+	require.Equal(t, "d,4\n", w.Body.String())
+}
+
+func TestStreamFullListingPages(t *testing.T) {
+	var markers []string
+	var limits []int
+	w := httptest.NewRecorder()
+	first := strings.Repeat("a\n", fullListingPageLimit-1) + "b\n"
+	err := streamFullListing(w, listingResponse(200, first), "text", -1, func(marker string, limit int) *http.Response {
+		markers = append(markers, marker)
+		limits = append(limits, limit)
+		if len(markers) == 1 {
+			return listingResponse(200, "c\nd")
+		}
+		return listingResponse(204, "")
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"b"}, markers)
+	require.Equal(t, []int{fullListingPageLimit}, limits)
+	require.Equal(t, 200, w.Code)
+	require.True(t, strings.HasSuffix(w.Body.String(), "b\nc\nd\n"))
+}
+
+func TestStreamFullListingNDJSONMarker(t *testing.T) {
+	var markers []string
+	w := httptest.NewRecorder()
+	first := strings.Repeat("{\"name\":\"a\"}\n", fullListingPageLimit-1) + "{\"subdir\":\"b/\"}\n"
+	err := streamFullListing(w, listingResponse(200, first), "ndjson", fullListingPageLimit+1, func(marker string, limit int) *http.Response {
+		markers = append(markers, marker)
+		require.Equal(t, 1, limit)
+		return listingResponse(200, "{\"name\":\"c\"}\n")
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"b/"}, markers)
+	require.True(t, strings.HasSuffix(w.Body.String(), "{\"subdir\":\"b/\"}\n{\"name\":\"c\"}\n"))
+}
+
+func TestStreamFullListingPageError(t *testing.T) {
+	w := httptest.NewRecorder()
+	first := strings.Repeat("a\n", fullListingPageLimit)
+	err := streamFullListing(w, listingResponse(200, first), "text", -1, func(marker string, limit int) *http.Response {
+		return listingResponse(500, "")
+	})
+	require.NotNil(t, err)
+	require.Equal(t, 200, w.Code)
+}
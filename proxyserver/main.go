@@ -80,6 +80,7 @@ func (server *ProxyServer) GetHandler(config conf.Config, metricsPrefix string)
 		CachedReporter: promreporter.NewReporter(promreporter.Options{}),
 		Separator:      promreporter.DefaultSeparator,
 	}, time.Second)
+	server.proxyClient.SetMetricsScope(metricsScope)
 	router := srv.NewRouter()
 	if obfuscatedPrefix != "" {
 		op := obfuscatedPrefix
@@ -91,6 +92,7 @@ func (server *ProxyServer) GetHandler(config conf.Config, metricsPrefix string)
 		router.Put(path.Join("/", op, "loglevel"), server.logLevel)
 		router.Get(path.Join("/", op, "debug/pprof/:parm"), http.DefaultServeMux)
 		router.Post(path.Join("/", op, "debug/pprof/:parm"), http.DefaultServeMux)
+		router.Get(path.Join("/", op, "errorlimits"), http.HandlerFunc(server.ErrorLimitsHandler))
 		router.Get(path.Join("/", op, "endpoints/v1/:account/:container/*obj"), http.HandlerFunc(server.EndpointsObjectGetHandler))
 		router.Get(path.Join("/", op, "endpoints/v1/:account/:container"), http.HandlerFunc(server.EndpointsContainerGetHandler))
 		router.Get(path.Join("/", op, "endpoints/v1/:account"), http.HandlerFunc(server.EndpointsAccountGetHandler))
@@ -146,24 +148,32 @@ func (server *ProxyServer) GetHandler(config conf.Config, metricsPrefix string)
 			section   string
 		}{
 			{middleware.NewCatchError, "filter:catch_errors"},
+			{middleware.NewGatekeeper, "filter:gatekeeper"},
 			{middleware.NewHealthcheck, "filter:healthcheck"},
 			{middleware.NewRequestLogger, "filter:proxy-logging"},
+			{middleware.NewAuditLog, "filter:audit_log"},
+			{middleware.NewCnameLookup, "filter:cname_lookup"},
+			{middleware.NewDomainRemap, "filter:domain_remap"},
 			{middleware.NewS3Auth, "filter:s3api"},
 			{middleware.NewCrossDomain, "filter:crossdomain"},
 			{middleware.NewCors, "filter:cors"}, // TODO: i dont want to have to have a seciton for this
 			{middleware.NewFormPost, "filter:formpost"},
 			{middleware.NewTempURL, "filter:tempurl"},
+			{middleware.NewContainerSync, "filter:container_sync"},
+			{middleware.NewJWTAuth, "filter:jwtauth"},
 			{middleware.NewTempAuth, "filter:tempauth"},
 			{middleware.NewS3Api, "filter:s3api"},
 			{middleware.NewBulk, "filter:bulk"},
 			{middleware.NewMultirange, "filter:multirange"},
 			{middleware.NewRatelimiter, "filter:ratelimit"},
 			{middleware.NewStaticWeb, "filter:staticweb"},
+			{middleware.NewNotifications, "filter:notifications"},
 			{middleware.NewCopyMiddleware, "filter:copy"},
 			{middleware.NewAccountQuota, "filter:account-quotas"},
 			{middleware.NewContainerQuota, "filter:container-quotas"},
 			{middleware.NewVersionedWrites, "filter:versioned_writes"},
 			{middleware.NewXlo, "filter:slo"},
+			{middleware.NewSymlink, "filter:symlink"},
 		}
 	} else {
 		middlewares = []struct {
@@ -171,13 +181,19 @@ func (server *ProxyServer) GetHandler(config conf.Config, metricsPrefix string)
 			section   string
 		}{
 			{middleware.NewCatchError, "filter:catch_errors"},
+			{middleware.NewGatekeeper, "filter:gatekeeper"},
 			{middleware.NewHealthcheck, "filter:healthcheck"},
 			{middleware.NewRequestLogger, "filter:proxy-logging"},
+			{middleware.NewAuditLog, "filter:audit_log"},
+			{middleware.NewCnameLookup, "filter:cname_lookup"},
+			{middleware.NewDomainRemap, "filter:domain_remap"},
 			{middleware.NewS3Auth, "filter:s3api"},
 			{middleware.NewCrossDomain, "filter:crossdomain"},
 			{middleware.NewCors, "filter:cors"},
 			{middleware.NewFormPost, "filter:formpost"},
 			{middleware.NewTempURL, "filter:tempurl"},
+			{middleware.NewContainerSync, "filter:container_sync"},
+			{middleware.NewJWTAuth, "filter:jwtauth"},
 			{middleware.NewAuthToken, "filter:authtoken"},
 			{middleware.NewS3Api, "filter:s3api"},
 			{middleware.NewKeystoneAuth, "filter:keystoneauth"},
@@ -185,11 +201,13 @@ func (server *ProxyServer) GetHandler(config conf.Config, metricsPrefix string)
 			{middleware.NewMultirange, "filter:multirange"},
 			{middleware.NewRatelimiter, "filter:ratelimit"},
 			{middleware.NewStaticWeb, "filter:staticweb"},
+			{middleware.NewNotifications, "filter:notifications"},
 			{middleware.NewCopyMiddleware, "filter:copy"},
 			{middleware.NewAccountQuota, "filter:account-quotas"},
 			{middleware.NewContainerQuota, "filter:container-quotas"},
 			{middleware.NewVersionedWrites, "filter:versioned_writes"},
 			{middleware.NewXlo, "filter:slo"},
+			{middleware.NewSymlink, "filter:symlink"},
 		}
 	}
 	pipeline := alice.New(globalmiddleware.ServerTracer(server.tracer), middleware.NewContext(config.GetBool("debug", "debug_x_source_code", false),
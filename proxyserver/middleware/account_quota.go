@@ -18,6 +18,7 @@ package middleware
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/troubling/hummingbird/common/conf"
 	"github.com/troubling/hummingbird/common/srv"
@@ -25,7 +26,11 @@ import (
 	"github.com/uber-go/tally"
 )
 
-func accountQuota(metric tally.Counter) func(http.Handler) http.Handler {
+// policyQuotaPrefix is the account metadata (after X-Account-Meta-) holding a byte quota for a single storage policy,
+// followed by the policy name.
+const policyQuotaPrefix = "Quota-Bytes-Policy-"
+
+func accountQuota(metric tally.Counter, policyList conf.PolicyList) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			if !(request.Method == "PUT" || request.Method == "POST") {
@@ -37,12 +42,15 @@ func accountQuota(metric tally.Counter) func(http.Handler) http.Handler {
 			_, account, container, obj := getPathParts(request)
 
 			if container == "" {
-				var quotaSet bool
-				if _, ok := request.Header["X-Account-Meta-Quota-Bytes"]; ok {
-					quotaSet = true
+				var quotaSet, removeQuota bool
+				for k := range request.Header {
+					if isQuotaHeader(k, "X-Account-Meta-") {
+						quotaSet = true
+					} else if isQuotaHeader(k, "X-Remove-Account-Meta-") && request.Header.Get(k) != "" {
+						removeQuota = true
+					}
 				}
-				removeQuota := request.Header.Get("X-Remove-Account-Meta-Quota-Bytes")
-				if quotaSet || removeQuota != "" {
+				if quotaSet || removeQuota {
 					if ctx.Authorize != nil {
 						if ok, st := ctx.Authorize(request); !ok {
 							srv.StandardResponse(writer, st)
@@ -54,12 +62,21 @@ func accountQuota(metric tally.Counter) func(http.Handler) http.Handler {
 						return
 					}
 				}
-				quota := request.Header.Get("X-Account-Meta-Quota-Bytes")
-				if quota != "" {
-					if _, err := strconv.ParseInt(quota, 10, 64); err != nil {
-						srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Invalid bytes quota.")
+				for k := range request.Header {
+					if !isQuotaHeader(k, "X-Account-Meta-") {
+						continue
+					}
+					if strings.HasPrefix(k, "X-Account-Meta-"+policyQuotaPrefix) &&
+						policyList.NameLookup(k[len("X-Account-Meta-"+policyQuotaPrefix):]) == nil {
+						srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Invalid storage policy for quota.")
 						return
 					}
+					if quota := request.Header.Get(k); quota != "" {
+						if _, err := strconv.ParseInt(quota, 10, 64); err != nil {
+							srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Invalid bytes quota.")
+							return
+						}
+					}
 				}
 				next.ServeHTTP(writer, request)
 				return
@@ -86,12 +103,45 @@ func accountQuota(metric tally.Counter) func(http.Handler) http.Handler {
 					}
 				}
 			}
+			if policyQuotaSet(ai.Metadata) {
+				if ci, err := ctx.C.GetContainerInfo(request.Context(), account, container); err == nil {
+					if policy := policyList[ci.StoragePolicyIndex]; policy != nil {
+						qBytes := ai.Metadata[http.CanonicalHeaderKey(policyQuotaPrefix+policy.Name)]
+						if quota, err := strconv.ParseInt(qBytes, 10, 64); err == nil {
+							newSize := ai.PolicyBytes[strings.ToLower(policy.Name)] + request.ContentLength
+							if quota < newSize {
+								srv.SimpleErrorResponse(writer, http.StatusRequestEntityTooLarge, "Upload exceeds storage policy quota.")
+								return
+							}
+						}
+					}
+				}
+			}
 			next.ServeHTTP(writer, request)
 		})
 	}
 }
 
+// isQuotaHeader returns whether the header sets the account's byte quota, or one of its per-policy byte quotas.
+func isQuotaHeader(header, prefix string) bool {
+	return header == prefix+"Quota-Bytes" || strings.HasPrefix(header, prefix+policyQuotaPrefix)
+}
+
+// policyQuotaSet returns whether any per-policy byte quotas are set in the account metadata.
+func policyQuotaSet(metadata map[string]string) bool {
+	for k := range metadata {
+		if strings.HasPrefix(k, policyQuotaPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func NewAccountQuota(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
 	RegisterInfo("account_quotas", map[string]interface{}{})
-	return accountQuota(metricsScope.Counter("account_quotas")), nil
+	policyList, err := conf.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return accountQuota(metricsScope.Counter("account_quotas"), policyList), nil
 }
@@ -81,3 +81,81 @@ func TestBadAccountQuotaBytes(t *testing.T) {
 	require.Equal(t, 400, resp.StatusCode)
 	require.Equal(t, "Invalid bytes quota.", string(body))
 }
+
+func TestAccountQuotaPolicyBytes(t *testing.T) {
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	})
+	h := accountQuota(common.NewTestScope().Counter("account_quotas"), staticPolicyList)(next)
+	f, err := client.NewProxyClient(staticPolicyList, srv.NewTestConfigLoader(&test.FakeRing{}),
+		nil, "", "", "", "", "", conf.Config{})
+	require.Nil(t, err)
+
+	ctx := &ProxyContext{
+		Logger: zap.NewNop(),
+		C: f.NewRequestClient(nil, map[string]*client.ContainerInfo{
+			"container/a/c": {StoragePolicyIndex: 0},
+		}, zap.NewNop()),
+		accountInfoCache: map[string]*AccountInfo{
+			"account/a": {
+				ObjectBytes: 5,
+				Metadata:    map[string]string{"Quota-Bytes": "100", "Quota-Bytes-Policy-Gold": "8"},
+				PolicyBytes: map[string]int64{"gold": 5},
+			},
+		},
+	}
+
+	req, err := http.NewRequest("PUT", "/v1/a/c/o", strings.NewReader("MORE"))
+	require.Nil(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Equal(t, 413, resp.StatusCode)
+	require.Equal(t, "Upload exceeds storage policy quota.", string(body))
+
+	req, err = http.NewRequest("PUT", "/v1/a/c/o", strings.NewReader("FIT"))
+	require.Nil(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Result().StatusCode)
+}
+
+func TestBadAccountQuotaPolicy(t *testing.T) {
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	})
+	h := accountQuota(common.NewTestScope().Counter("account_quotas"), staticPolicyList)(next)
+	ctx := NewFakeProxyContext(h)
+	ctx.ResellerRequest = true
+
+	req, err := http.NewRequest("POST", "/v1/a", nil)
+	require.Nil(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	req.Header.Set("X-Account-Meta-Quota-Bytes-Policy-Silver", "10")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Equal(t, 400, resp.StatusCode)
+	require.Equal(t, "Invalid storage policy for quota.", string(body))
+
+	req, err = http.NewRequest("POST", "/v1/a", nil)
+	require.Nil(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	req.Header.Set("X-Account-Meta-Quota-Bytes-Policy-Gold", "10")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Result().StatusCode)
+
+	ctx.ResellerRequest = false
+	req, err = http.NewRequest("POST", "/v1/a", nil)
+	require.Nil(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	req.Header.Set("X-Account-Meta-Quota-Bytes-Policy-Gold", "10")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, 403, w.Result().StatusCode)
+}
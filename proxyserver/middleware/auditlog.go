@@ -0,0 +1,233 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time               string  `json:"time"`
+	TxId               string  `json:"txn"`
+	ClientIP           string  `json:"clientIp"`
+	RemoteAddr         string  `json:"remoteAddr"`
+	ForwardedFor       string  `json:"forwardedFor,omitempty"`
+	Method             string  `json:"method"`
+	Path               string  `json:"path"`
+	Account            string  `json:"account,omitempty"`
+	Container          string  `json:"container,omitempty"`
+	Object             string  `json:"object,omitempty"`
+	User               string  `json:"user,omitempty"`
+	AuthMethod         string  `json:"authMethod,omitempty"`
+	S3Operation        string  `json:"s3Operation,omitempty"`
+	Status             int     `json:"status"`
+	BytesIn            int     `json:"bytesIn"`
+	BytesOut           int     `json:"bytesOut"`
+	PolicyIndex        *int    `json:"policyIndex,omitempty"`
+	UserAgent          string  `json:"userAgent,omitempty"`
+	RequestTimeSeconds float64 `json:"requestTimeSeconds"`
+}
+
+type auditLog struct {
+	next           http.Handler
+	lock           sync.Mutex
+	out            io.Writer
+	trustedProxies []*net.IPNet
+	recordsMetric  tally.Counter
+	errorsMetric   tally.Counter
+}
+
+// clientIP returns the address the request came from, trusting X-Forwarded-For only as far back as it was added by trusted proxies.
+func (a *auditLog) clientIP(request *http.Request) string {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+	forwarded := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0 && a.trusted(ip); i-- {
+		if hop := strings.TrimSpace(forwarded[i]); hop != "" {
+			ip = hop
+		}
+	}
+	return ip
+}
+
+func (a *auditLog) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range a.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *auditLog) write(record *auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.out.Write(append(line, '\n'))
+	return err
+}
+
+func (a *auditLog) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ctx := GetProxyContext(request)
+	if ctx.Source != "" {
+		a.next.ServeHTTP(writer, request)
+		return
+	}
+	start := time.Now()
+	policyIndex := ""
+	newWriter := &srv.WebWriter{ResponseWriter: writer, Status: 500}
+	newReader := &srv.CountingReadCloser{ReadCloser: request.Body}
+	request.Body = newReader
+	// The policy index is noted before the gatekeeper strips it from the response.
+	a.next.ServeHTTP(srv.NewCustomWriter(newWriter, func(w http.ResponseWriter, status int) int {
+		policyIndex = w.Header().Get("X-Backend-Storage-Policy-Index")
+		return status
+	}), request)
+
+	record := &auditRecord{
+		Time:               start.UTC().Format(time.RFC3339Nano),
+		TxId:               ctx.TxId,
+		ClientIP:           a.clientIP(request),
+		RemoteAddr:         request.RemoteAddr,
+		ForwardedFor:       request.Header.Get("X-Forwarded-For"),
+		Method:             request.Method,
+		Path:               request.URL.Path,
+		User:               ctx.RemoteUser,
+		AuthMethod:         ctx.AuthMethod,
+		Status:             newWriter.Status,
+		BytesIn:            newReader.ByteCount,
+		BytesOut:           newWriter.ByteCount,
+		UserAgent:          request.Header.Get("User-Agent"),
+		RequestTimeSeconds: time.Since(start).Seconds(),
+	}
+	if ctx.S3Auth != nil && !strings.HasPrefix(strings.ToLower(request.URL.Path), "/v1/") {
+		record.Account = "AUTH_" + ctx.S3Auth.Account
+		record.Container, record.Object = s3PathSplit(request.URL.Path)
+		record.User = ctx.S3Auth.Key
+		record.AuthMethod = "s3"
+		record.S3Operation = s3Operation(request)
+	} else if apiReq, account, container, object := getPathParts(request); apiReq {
+		record.Account, record.Container, record.Object = account, container, object
+	}
+	if i, err := strconv.Atoi(policyIndex); err == nil {
+		record.PolicyIndex = &i
+	} else if record.Container != "" && newWriter.Status/100 == 2 {
+		if ci, err := ctx.C.GetContainerInfo(request.Context(), record.Account, record.Container); err == nil {
+			record.PolicyIndex = &ci.StoragePolicyIndex
+		}
+	}
+	a.recordsMetric.Inc(1)
+	if err := a.write(record); err != nil {
+		a.errorsMetric.Inc(1)
+		ctx.Logger.Error("Error writing audit log", zap.Error(err))
+	}
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"USER": syslog.LOG_USER, "AUTH": syslog.LOG_AUTH, "AUTHPRIV": syslog.LOG_AUTHPRIV, "DAEMON": syslog.LOG_DAEMON,
+	"LOCAL0": syslog.LOG_LOCAL0, "LOCAL1": syslog.LOG_LOCAL1, "LOCAL2": syslog.LOG_LOCAL2, "LOCAL3": syslog.LOG_LOCAL3,
+	"LOCAL4": syslog.LOG_LOCAL4, "LOCAL5": syslog.LOG_LOCAL5, "LOCAL6": syslog.LOG_LOCAL6, "LOCAL7": syslog.LOG_LOCAL7,
+}
+
+// openAuditLog opens the file or syslog socket the audit log goes to, or returns nil if neither is configured.
+func openAuditLog(config conf.Section) (io.Writer, error) {
+	if path := config.GetDefault("log_path", ""); path != "" {
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	}
+	address := config.GetDefault("syslog_address", "")
+	if address == "" {
+		return nil, nil
+	}
+	facility, ok := syslogFacilities[strings.ToUpper(config.GetDefault("syslog_facility", "LOCAL0"))]
+	if !ok {
+		return nil, fmt.Errorf("Unknown syslog facility %q", config.GetDefault("syslog_facility", ""))
+	}
+	tag := config.GetDefault("syslog_tag", "hummingbird-audit")
+	out, err := syslog.Dial("unixgram", address, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		out, err = syslog.Dial("unix", address, facility|syslog.LOG_INFO, tag)
+	}
+	return out, err
+}
+
+func newAuditLog(config conf.Section, metricsScope tally.Scope, out io.Writer) (func(http.Handler) http.Handler, error) {
+	var trustedProxies []*net.IPNet
+	for _, proxy := range common.SliceFromCSV(config.GetDefault("trusted_proxies", "")) {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %q: %v", proxy, err)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	RegisterInfo("audit_log", map[string]interface{}{})
+	recordsMetric := metricsScope.Counter("audit_log_records")
+	errorsMetric := metricsScope.Counter("audit_log_errors")
+	return func(next http.Handler) http.Handler {
+		return &auditLog{
+			next:           next,
+			out:            out,
+			trustedProxies: trustedProxies,
+			recordsMetric:  recordsMetric,
+			errorsMetric:   errorsMetric,
+		}
+	}, nil
+}
+
+func NewAuditLog(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	out, err := openAuditLog(config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open audit log: %v", err)
+	}
+	if out == nil {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	return newAuditLog(config, metricsScope, out)
+}
@@ -0,0 +1,139 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func auditLogTest(t *testing.T, configString string, out *bytes.Buffer, next http.Handler) http.Handler {
+	config, err := conf.StringConfig("[filter:audit_log]\n" + configString)
+	require.Nil(t, err)
+	mid, err := newAuditLog(config.GetSection("filter:audit_log"), tally.NoopScope, out)
+	require.Nil(t, err)
+	return mid(next)
+}
+
+func auditLogTestRequest(method, path, body string, ctx *ProxyContext) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	ctx.ProxyContextMiddleware = &ProxyContextMiddleware{}
+	ctx.Logger = zap.NewNop()
+	ctx.TxId = "tx123"
+	return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+}
+
+func TestAuditLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	mid := auditLogTest(t, "", out, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ioutil.ReadAll(request.Body)
+		ctx := GetProxyContext(request)
+		ctx.RemoteUser = "test:tester"
+		ctx.AuthMethod = "tempauth"
+		writer.Header().Set("X-Backend-Storage-Policy-Index", "1")
+		writer.WriteHeader(201)
+		writer.Write([]byte("ok"))
+	}))
+	mid.ServeHTTP(httptest.NewRecorder(), auditLogTestRequest("PUT", "/v1/AUTH_test/c/o", "hello", &ProxyContext{}))
+	var record map[string]interface{}
+	require.Nil(t, json.Unmarshal(out.Bytes(), &record))
+	require.Equal(t, "tx123", record["txn"])
+	require.Equal(t, "PUT", record["method"])
+	require.Equal(t, "AUTH_test", record["account"])
+	require.Equal(t, "c", record["container"])
+	require.Equal(t, "o", record["object"])
+	require.Equal(t, "test:tester", record["user"])
+	require.Equal(t, "tempauth", record["authMethod"])
+	require.EqualValues(t, 201, record["status"])
+	require.EqualValues(t, 5, record["bytesIn"])
+	require.EqualValues(t, 2, record["bytesOut"])
+	require.EqualValues(t, 1, record["policyIndex"])
+	require.Equal(t, "192.0.2.1", record["clientIp"])
+	require.Nil(t, record["s3Operation"])
+	require.True(t, strings.HasSuffix(out.String(), "}\n"))
+}
+
+func TestAuditLogS3(t *testing.T) {
+	out := &bytes.Buffer{}
+	mid := auditLogTest(t, "", out, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Backend-Storage-Policy-Index", "0")
+		writer.WriteHeader(200)
+	}))
+	ctx := &ProxyContext{S3Auth: &S3AuthInfo{Key: "AKIDTEST", Account: "test"}}
+	mid.ServeHTTP(httptest.NewRecorder(), auditLogTestRequest("GET", "/bucket?acl", "", ctx))
+	var record map[string]interface{}
+	require.Nil(t, json.Unmarshal(out.Bytes(), &record))
+	require.Equal(t, "AUTH_test", record["account"])
+	require.Equal(t, "bucket", record["container"])
+	require.Equal(t, "AKIDTEST", record["user"])
+	require.Equal(t, "s3", record["authMethod"])
+	require.Equal(t, "REST.GET.ACL", record["s3Operation"])
+}
+
+func TestAuditLogSubrequest(t *testing.T) {
+	out := &bytes.Buffer{}
+	mid := auditLogTest(t, "", out, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	}))
+	mid.ServeHTTP(httptest.NewRecorder(), auditLogTestRequest("GET", "/v1/a/c/o", "", &ProxyContext{Source: "SLO"}))
+	require.Equal(t, 0, out.Len())
+}
+
+func TestAuditLogClientIP(t *testing.T) {
+	mid := auditLogTest(t, "trusted_proxies = 192.0.2.1, 10.0.0.0/8\n", &bytes.Buffer{}, nil)
+	a := mid.(*auditLog)
+	req := httptest.NewRequest("GET", "/v1/a", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.1.2.3")
+	require.Equal(t, "198.51.100.7", a.clientIP(req))
+	req.RemoteAddr = "198.51.100.1:1234"
+	require.Equal(t, "198.51.100.1", a.clientIP(req))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	require.Equal(t, "10.1.2.3", a.clientIP(req))
+}
+
+func TestS3Operation(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, copySource, operation string
+	}{
+		{"GET", "/", "", "REST.GET.SERVICE"},
+		{"PUT", "/bucket", "", "REST.PUT.BUCKET"},
+		{"GET", "/bucket/key", "", "REST.GET.OBJECT"},
+		{"PUT", "/bucket/key", "/bucket/other", "REST.COPY.OBJECT"},
+		{"POST", "/bucket/key?uploads", "", "REST.POST.UPLOADS"},
+		{"PUT", "/bucket/key?partNumber=1&uploadId=x", "", "REST.PUT.PART"},
+		{"POST", "/bucket/key?uploadId=x", "", "REST.POST.UPLOAD"},
+		{"POST", "/bucket?delete", "", "REST.POST.MULTI_OBJECT_DELETE"},
+		{"PUT", "/bucket/key?tagging", "", "REST.PUT.TAGGING"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.copySource != "" {
+			req.Header.Set("X-Amz-Copy-Source", tc.copySource)
+		}
+		require.Equal(t, tc.operation, s3Operation(req), tc.path)
+	}
+}
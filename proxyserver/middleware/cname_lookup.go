@@ -0,0 +1,147 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// cnameResolver looks up the canonical name for a host.  *net.Resolver is one.
+type cnameResolver interface {
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+type cnameLookup struct {
+	next           http.Handler
+	storageDomains []string
+	resolver       cnameResolver
+	lookupTimeout  time.Duration
+	cacheTime      time.Duration
+	lookupsMetric  tally.Counter
+	failuresMetric tally.Counter
+}
+
+// cname returns the host's canonical name, from memcache if it's been looked up recently.
+func (c *cnameLookup) cname(request *http.Request, host string) (string, error) {
+	ctx := GetProxyContext(request)
+	key := "cname-" + host
+	var cname string
+	if ctx.Cache != nil {
+		if err := ctx.Cache.GetStructured(request.Context(), key, &cname); err == nil && cname != "" {
+			return cname, nil
+		}
+	}
+	c.lookupsMetric.Inc(1)
+	lookupCtx, cancel := context.WithTimeout(request.Context(), c.lookupTimeout)
+	defer cancel()
+	cname, err := c.resolver.LookupCNAME(lookupCtx, host)
+	if err != nil {
+		return "", err
+	}
+	cname = strings.TrimSuffix(strings.ToLower(cname), ".")
+	if ctx.Cache != nil {
+		ctx.Cache.Set(request.Context(), key, cname, int(c.cacheTime/time.Second))
+	}
+	return cname, nil
+}
+
+func (c *cnameLookup) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	host := requestHost(request)
+	if host == "" || net.ParseIP(host) != nil {
+		c.next.ServeHTTP(writer, request)
+		return
+	}
+	for _, domain := range c.storageDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			c.next.ServeHTTP(writer, request)
+			return
+		}
+	}
+	cname, err := c.cname(request, host)
+	if err != nil {
+		c.failuresMetric.Inc(1)
+		GetProxyContext(request).Logger.Debug("CNAME lookup failed", zap.String("host", host), zap.Error(err))
+		c.next.ServeHTTP(writer, request)
+		return
+	}
+	// Customer domains are only remapped when they point into the cluster's own storage domain.
+	if _, ok := domainPrefix(cname, c.storageDomains); ok && cname != host {
+		request.Host = cname
+	}
+	c.next.ServeHTTP(writer, request)
+}
+
+// nameserverResolver returns a resolver that queries the given nameservers, instead of the system's.
+func nameserverResolver(nameservers []string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, nameservers[rand.Intn(len(nameservers))])
+		},
+	}
+}
+
+func newCnameLookup(config conf.Section, metricsScope tally.Scope, resolver cnameResolver) (func(http.Handler) http.Handler, error) {
+	storageDomains := common.SliceFromCSV(strings.ToLower(config.GetDefault("storage_domain", "")))
+	if len(storageDomains) == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	for i := range storageDomains {
+		storageDomains[i] = strings.Trim(storageDomains[i], ".")
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+		if nameservers := common.SliceFromCSV(config.GetDefault("nameservers", "")); len(nameservers) > 0 {
+			for i, ns := range nameservers {
+				if _, _, err := net.SplitHostPort(ns); err != nil {
+					nameservers[i] = net.JoinHostPort(ns, "53")
+				}
+			}
+			resolver = nameserverResolver(nameservers)
+		}
+	}
+	RegisterInfo("cname_lookup", map[string]interface{}{})
+	lookupsMetric := metricsScope.Counter("cname_lookup_lookups")
+	failuresMetric := metricsScope.Counter("cname_lookup_failures")
+	return func(next http.Handler) http.Handler {
+		return &cnameLookup{
+			next:           next,
+			storageDomains: storageDomains,
+			resolver:       resolver,
+			lookupTimeout:  time.Duration(config.GetFloat("lookup_timeout", 2.0) * float64(time.Second)),
+			cacheTime:      time.Duration(config.GetInt("cache_time", 300)) * time.Second,
+			lookupsMetric:  lookupsMetric,
+			failuresMetric: failuresMetric,
+		}
+	}, nil
+}
+
+func NewCnameLookup(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	return newCnameLookup(config, metricsScope, nil)
+}
@@ -0,0 +1,89 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type fakeCnameResolver struct {
+	cnames  map[string]string
+	lookups int
+}
+
+func (r *fakeCnameResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	r.lookups++
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return "", errors.New("no such host")
+}
+
+func TestCnameLookup(t *testing.T) {
+	resolver := &fakeCnameResolver{cnames: map[string]string{
+		"www.customer.com":   "c.auth-test.storage.example.com.",
+		"other.customer.com": "elsewhere.example.net.",
+	}}
+	config, err := conf.StringConfig("[filter:cname_lookup]\nstorage_domain = storage.example.com\n")
+	require.Nil(t, err)
+	mid, err := newCnameLookup(config.GetSection("filter:cname_lookup"), tally.NoopScope, resolver)
+	require.Nil(t, err)
+	var host string
+	handler := mid(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host = request.Host
+	}))
+	cache := &test.FakeMemcacheRing{}
+	tests := []struct{ host, expected string }{
+		{"www.customer.com", "c.auth-test.storage.example.com"},
+		{"www.customer.com:8080", "c.auth-test.storage.example.com"},
+		{"other.customer.com", "other.customer.com"},
+		{"missing.customer.com", "missing.customer.com"},
+		{"c.auth-test.storage.example.com", "c.auth-test.storage.example.com"},
+		{"127.0.0.1:8080", "127.0.0.1:8080"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/obj", nil)
+		req.Host = test.host
+		ctx := &ProxyContext{ProxyContextMiddleware: &ProxyContextMiddleware{Cache: cache}, Logger: zap.NewNop()}
+		req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, test.expected, host, test.host)
+	}
+	require.Equal(t, 4, resolver.lookups)
+	require.Equal(t, []interface{}{"c.auth-test.storage.example.com", "c.auth-test.storage.example.com", "elsewhere.example.net"}, cache.MockSetValues)
+}
+
+func TestCnameLookupDisabled(t *testing.T) {
+	resolver := &fakeCnameResolver{}
+	mid, err := newCnameLookup(conf.Section{}, tally.NoopScope, resolver)
+	require.Nil(t, err)
+	called := false
+	mid(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		called = true
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.True(t, called)
+	require.Equal(t, 0, resolver.lookups)
+}
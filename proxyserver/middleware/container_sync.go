@@ -0,0 +1,113 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+)
+
+func syncAuthError(writer http.ResponseWriter, msg string) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(http.StatusUnauthorized)
+	writer.Write([]byte(msg))
+}
+
+// containerSync authorizes object requests from a remote cluster's container sync daemon, which are signed with
+// the realm's key and the destination container's X-Container-Sync-Key.
+func containerSync(realms conf.SyncRealmList, requestsMetric tally.Counter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			auth := request.Header.Get("X-Container-Sync-Auth")
+			ctx := GetProxyContext(request)
+			if auth == "" || ctx == nil {
+				next.ServeHTTP(writer, request)
+				return
+			}
+			requestsMetric.Inc(1)
+			parts := strings.Fields(auth)
+			if len(parts) != 3 {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid")
+				return
+			}
+			realm, ok := realms[parts[0]]
+			if !ok {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid; invalid realm")
+				return
+			}
+			apiReq, account, container, obj := getPathParts(request)
+			if !apiReq || container == "" || obj == "" {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid")
+				return
+			}
+			timestamp, err := common.StandardizeTimestamp(ctx.clientTimestamp)
+			if err != nil {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid; invalid X-Timestamp")
+				return
+			}
+			ci, err := ctx.C.GetContainerInfo(request.Context(), account, container)
+			if err != nil || ci.SyncKey == "" {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid; X-Container-Sync-Key not set")
+				return
+			}
+			valid := false
+			for _, key := range []string{realm.Key1, realm.Key2} {
+				if key != "" && hmac.Equal([]byte(parts[2]),
+					[]byte(conf.SyncSignature(request.Method, request.URL.Path, ctx.clientTimestamp, parts[1], key, ci.SyncKey))) {
+					valid = true
+				}
+			}
+			if !valid {
+				syncAuthError(writer, "X-Container-Sync-Auth header not valid")
+				return
+			}
+			ctx.RemoteUsers = []string{".container_sync"}
+			ctx.AuthMethod = "container_sync"
+			ctx.Authorize = func(r *http.Request) (bool, int) {
+				ar, a, c, _ := getPathParts(r)
+				if ar && a == account && c == container {
+					return true, http.StatusOK
+				}
+				return false, http.StatusUnauthorized
+			}
+			// Synced objects keep the timestamps they have in the source cluster.
+			request.Header.Set("X-Timestamp", timestamp)
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+func NewContainerSync(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	realms, err := conf.GetSyncRealms()
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]interface{}{}
+	for name, realm := range realms {
+		clusters := map[string]interface{}{}
+		for cluster := range realm.Clusters {
+			clusters[cluster] = map[string]interface{}{}
+		}
+		info[name] = map[string]interface{}{"clusters": clusters}
+	}
+	RegisterInfo("container_sync", map[string]interface{}{"realms": info})
+	return containerSync(realms, metricsScope.Counter("container_sync_requests")), nil
+}
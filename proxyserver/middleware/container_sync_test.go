@@ -0,0 +1,104 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/common/test"
+	"go.uber.org/zap"
+)
+
+func containerSyncRequest(t *testing.T, method, path, auth, timestamp string) (*http.Request, *ProxyContext) {
+	f, err := client.NewProxyClient(staticPolicyList, srv.NewTestConfigLoader(&test.FakeRing{}),
+		nil, "", "", "", "", "", conf.Config{})
+	require.Nil(t, err)
+	ctx := &ProxyContext{
+		Logger: zap.NewNop(),
+		C: f.NewRequestClient(nil, map[string]*client.ContainerInfo{
+			"container/a/c": {SyncKey: "userkey"},
+			"container/a/d": {},
+		}, zap.NewNop()),
+		clientTimestamp: timestamp,
+	}
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("X-Container-Sync-Auth", auth)
+	return r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx)), ctx
+}
+
+func TestContainerSyncMiddleware(t *testing.T) {
+	realms := conf.SyncRealmList{"realm": {Name: "realm", Key1: "oldkey", Key2: "realmkey"}}
+	served := false
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		served = true
+		require.Equal(t, "1500000000.00000", request.Header.Get("X-Timestamp"))
+	})
+	mid := containerSync(realms, common.NewTestScope().Counter("test_container_sync"))(handler)
+
+	sig := conf.SyncSignature("PUT", "/v1/a/c/o", "1500000000", "nonce", "realmkey", "userkey")
+	r, ctx := containerSyncRequest(t, "PUT", "/v1/a/c/o", "realm nonce "+sig, "1500000000")
+	w := httptest.NewRecorder()
+	mid.ServeHTTP(w, r)
+	require.True(t, served)
+	require.Equal(t, []string{".container_sync"}, ctx.RemoteUsers)
+	ok, _ := ctx.Authorize(httptest.NewRequest("DELETE", "/v1/a/c/o2", nil))
+	require.True(t, ok)
+	ok, _ = ctx.Authorize(httptest.NewRequest("DELETE", "/v1/a/d/o", nil))
+	require.False(t, ok)
+}
+
+func TestContainerSyncMiddleware401(t *testing.T) {
+	realms := conf.SyncRealmList{"realm": {Name: "realm", Key1: "realmkey"}}
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		t.Fatal("request should not have been served")
+	})
+	mid := containerSync(realms, common.NewTestScope().Counter("test_container_sync"))(handler)
+	sig := conf.SyncSignature("PUT", "/v1/a/c/o", "1500000000", "nonce", "realmkey", "userkey")
+	for _, tc := range []struct{ path, auth, timestamp string }{
+		{"/v1/a/c/o", "realm nonce", "1500000000"},
+		{"/v1/a/c/o", "other nonce " + sig, "1500000000"},
+		{"/v1/a/c/o", "realm nonce " + sig, "1500000001"},
+		{"/v1/a/c/o", "realm nonce " + sig, "X"},
+		{"/v1/a/c/o2", "realm nonce " + sig, "1500000000"},
+		{"/v1/a/d/o", "realm nonce " + conf.SyncSignature("PUT", "/v1/a/d/o", "1500000000", "nonce", "realmkey", ""), "1500000000"},
+		{"/v1/a/c", "realm nonce " + sig, "1500000000"},
+	} {
+		r, _ := containerSyncRequest(t, "PUT", tc.path, tc.auth, tc.timestamp)
+		w := httptest.NewRecorder()
+		mid.ServeHTTP(w, r)
+		require.Equal(t, 401, w.Code, tc.path+" "+tc.auth+" "+tc.timestamp)
+	}
+}
+
+func TestContainerSyncMiddlewarePassNoHeader(t *testing.T) {
+	served := false
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		served = true
+	})
+	mid := containerSync(conf.SyncRealmList{}, common.NewTestScope().Counter("test_container_sync"))(handler)
+	r, ctx := containerSyncRequest(t, "PUT", "/v1/a/c/o", "", "1500000000")
+	mid.ServeHTTP(httptest.NewRecorder(), r)
+	require.True(t, served)
+	require.Nil(t, ctx.Authorize)
+}
@@ -35,15 +35,8 @@ import (
 )
 
 var (
-	serverInfo     = make(map[string]interface{})
-	sil            sync.Mutex
-	excludeHeaders = []string{
-		"X-Account-Sysmeta-",
-		"X-Container-Sysmeta-",
-		"X-Object-Sysmeta-",
-		"X-Object-Transient-Sysmeta-",
-		"X-Backend-",
-	}
+	serverInfo = make(map[string]interface{})
+	sil        sync.Mutex
 )
 
 func RegisterInfo(name string, data interface{}) {
@@ -83,6 +76,7 @@ type AccountInfo struct {
 	ObjectBytes    int64
 	Metadata       map[string]string
 	SysMetadata    map[string]string
+	PolicyBytes    map[string]int64
 	StatusCode     int `json:"status"`
 }
 
@@ -102,6 +96,8 @@ type ProxyContext struct {
 	C                client.RequestClient
 	Authorize        AuthorizeFunc
 	RemoteUsers      []string
+	RemoteUser       string // the authenticated user, for per-user accounting; RemoteUsers may only hold its groups.
+	AuthMethod       string // how the request was authorized, like "tempauth" or "tempurl", for auditing.
 	StorageOwner     bool
 	ResellerRequest  bool
 	ACL              string
@@ -114,6 +110,7 @@ type ProxyContext struct {
 	depth            int
 	Source           string
 	S3Auth           *S3AuthInfo
+	clientTimestamp  string
 }
 
 func GetProxyContext(r *http.Request) *ProxyContext {
@@ -181,6 +178,7 @@ func (pc *ProxyContext) GetAccountInfo(ctx context.Context, account string) (*Ac
 		ai = &AccountInfo{
 			Metadata:    make(map[string]string),
 			SysMetadata: make(map[string]string),
+			PolicyBytes: make(map[string]int64),
 			StatusCode:  resp.StatusCode,
 		}
 		var err error
@@ -198,6 +196,10 @@ func (pc *ProxyContext) GetAccountInfo(ctx context.Context, account string) (*Ac
 				ai.Metadata[k[15:]] = resp.Header.Get(k)
 			} else if strings.HasPrefix(k, "X-Account-Sysmeta-") {
 				ai.SysMetadata[k[18:]] = resp.Header.Get(k)
+			} else if len(k) > 36 && strings.HasPrefix(k, "X-Account-Storage-Policy-") && strings.HasSuffix(k, "-Bytes-Used") {
+				if bytes, err := strconv.ParseInt(resp.Header.Get(k), 10, 64); err == nil {
+					ai.PolicyBytes[strings.ToLower(k[25:len(k)-11])] = bytes
+				}
 			}
 		}
 		pc.Cache.Set(ctx, key, ai, 30)
@@ -240,6 +242,8 @@ func (pc *ProxyContext) newSubrequest(method, urlStr string, body io.Reader, req
 		ProxyContextMiddleware: pc.ProxyContextMiddleware,
 		Authorize:              pc.Authorize,
 		RemoteUsers:            pc.RemoteUsers,
+		RemoteUser:             pc.RemoteUser,
+		AuthMethod:             pc.AuthMethod,
 		subrequestCopy:         pc.subrequestCopy,
 		Logger:                 pc.Logger.With(zap.String("src", source)),
 		C:                      pc.C,
@@ -307,13 +311,9 @@ func (m *ProxyContextMiddleware) ServeHTTP(writer http.ResponseWriter, request *
 		}
 	}
 
-	for k := range request.Header {
-		for _, ex := range excludeHeaders {
-			if strings.HasPrefix(k, ex) || k == "X-Timestamp" {
-				delete(request.Header, k)
-			}
-		}
-	}
+	// Only container sync may set an object's timestamp, and it needs the original to check its signature.
+	clientTimestamp := request.Header.Get("X-Timestamp")
+	request.Header.Del("X-Timestamp")
 
 	transId := common.GetTransactionId()
 	request.Header.Set("X-Trans-Id", transId)
@@ -328,6 +328,7 @@ func (m *ProxyContextMiddleware) ServeHTTP(writer http.ResponseWriter, request *
 		TxId:                   transId,
 		status:                 500,
 		accountInfoCache:       make(map[string]*AccountInfo),
+		clientTimestamp:        clientTimestamp,
 		C:                      m.proxyClientFactory.NewRequestClient(m.Cache, make(map[string]*client.ContainerInfo), logr),
 	}
 	// we'll almost certainly need the AccountInfo and ContainerInfo for the current path, so pre-fetch them in parallel.
@@ -349,17 +350,6 @@ func (m *ProxyContextMiddleware) ServeHTTP(writer http.ResponseWriter, request *
 		wg.Wait()
 	}
 	newWriter := srv.NewCustomWriter(writer, func(w http.ResponseWriter, status int) int {
-		// strip out any bad headers before calling real WriteHeader
-		for k := range w.Header() {
-			if k == "X-Account-Sysmeta-Project-Domain-Id" {
-				w.Header().Set("X-Account-Project-Domain-Id", w.Header().Get(k))
-			}
-			for _, ex := range excludeHeaders {
-				if strings.HasPrefix(k, ex) {
-					delete(w.Header(), k)
-				}
-			}
-		}
 		if status == http.StatusUnauthorized && w.Header().Get("Www-Authenticate") == "" {
 			if account != "" {
 				w.Header().Set("Www-Authenticate", fmt.Sprintf("Swift realm=\"%s\"", common.Urlencode(account)))
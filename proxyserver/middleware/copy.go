@@ -108,6 +108,7 @@ func (c *copyMiddleware) handlePostAsCopy(writer *CopyWriter, request *http.Requ
 	}
 	values.Set("multipart-manifest", "get")
 	values.Set("format", "raw")
+	values.Set("symlink", "get")
 	request.URL.RawQuery = values.Encode()
 
 	c.handlePut(writer, request)
@@ -296,6 +297,8 @@ func (c *copyMiddleware) handlePut(writer *CopyWriter, request *http.Request) {
 		// since we're not copying the source etag, make sure that any
 		// container update override values are not copied.
 		RemoveItemsWithPrefix(request.Header, "X-Object-Sysmeta-Container-Update-Override-")
+		// likewise the source's checksums won't match the copied content.
+		RemoveItemsWithPrefix(request.Header, common.ChecksumHeaderPrefix)
 	}
 
 	request.Header.Del("X-Copy-From")
@@ -0,0 +1,139 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+)
+
+type domainRemap struct {
+	next                  http.Handler
+	storageDomains        []string
+	s3Domains             []string
+	pathRoot              string
+	resellerPrefixes      []string
+	defaultResellerPrefix string
+	requestsMetric        tally.Counter
+}
+
+// requestHost returns the request's lowercased host name, without any port.
+func requestHost(request *http.Request) string {
+	host := strings.ToLower(request.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// domainPrefix returns what comes before one of the domains in host, or false if host isn't a subdomain of any of them.
+func domainPrefix(host string, domains []string) (string, bool) {
+	for _, domain := range domains {
+		if strings.HasSuffix(host, "."+domain) {
+			return strings.TrimSuffix(host, "."+domain), true
+		}
+	}
+	return "", false
+}
+
+// remapAccount turns an account name from a host name, like auth-test, back into the account, like AUTH_test.
+func (d *domainRemap) remapAccount(account string) string {
+	for _, prefix := range d.resellerPrefixes {
+		if strings.HasPrefix(account, strings.ToLower(prefix)+"-") {
+			return prefix + "_" + account[len(prefix)+1:]
+		}
+	}
+	if d.defaultResellerPrefix != "" {
+		return d.defaultResellerPrefix + "_" + account
+	}
+	return account
+}
+
+func (d *domainRemap) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	host := requestHost(request)
+	if bucket, ok := domainPrefix(host, d.s3Domains); ok {
+		// Virtual-hosted S3 requests become path-style ones, which is also what their signatures are made from.
+		d.requestsMetric.Inc(1)
+		request.URL.Path = "/" + bucket + request.URL.Path
+		request.URL.RawPath = ""
+		d.next.ServeHTTP(writer, request)
+		return
+	}
+	prefix, ok := domainPrefix(host, d.storageDomains)
+	if !ok {
+		d.next.ServeHTTP(writer, request)
+		return
+	}
+	var account, container string
+	parts := strings.Split(prefix, ".")
+	switch len(parts) {
+	case 1:
+		account = parts[0]
+	case 2:
+		container, account = parts[0], parts[1]
+	default:
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Bad domain in host header")
+		return
+	}
+	d.requestsMetric.Inc(1)
+	path := "/" + d.pathRoot + "/" + d.remapAccount(account)
+	if container != "" {
+		path += "/" + container
+	}
+	// Paths that already name the account and container, like those built from storage URLs, are left alone.
+	if request.URL.Path != path && !strings.HasPrefix(request.URL.Path, path+"/") {
+		request.URL.Path = path + request.URL.Path
+		request.URL.RawPath = ""
+	}
+	d.next.ServeHTTP(writer, request)
+}
+
+func NewDomainRemap(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	storageDomains := common.SliceFromCSV(strings.ToLower(config.GetDefault("storage_domain", "")))
+	s3Domains := common.SliceFromCSV(strings.ToLower(config.GetDefault("s3_domain", "")))
+	if len(storageDomains) == 0 && len(s3Domains) == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	for i := range storageDomains {
+		storageDomains[i] = strings.Trim(storageDomains[i], ".")
+	}
+	for i := range s3Domains {
+		s3Domains[i] = strings.Trim(s3Domains[i], ".")
+	}
+	resellerPrefixes := common.SliceFromCSV(config.GetDefault("reseller_prefixes", "AUTH"))
+	defaultResellerPrefix := config.GetDefault("default_reseller_prefix", "")
+	RegisterInfo("domain_remap", map[string]interface{}{"default_reseller_prefix": defaultResellerPrefix})
+	requestsMetric := metricsScope.Counter("domain_remap_requests")
+	return func(next http.Handler) http.Handler {
+		return &domainRemap{
+			next:                  next,
+			storageDomains:        storageDomains,
+			s3Domains:             s3Domains,
+			pathRoot:              strings.Trim(config.GetDefault("path_root", "v1"), "/"),
+			resellerPrefixes:      resellerPrefixes,
+			defaultResellerPrefix: defaultResellerPrefix,
+			requestsMetric:        requestsMetric,
+		}
+	}, nil
+}
@@ -0,0 +1,68 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+)
+
+func TestDomainRemap(t *testing.T) {
+	config, err := conf.StringConfig("[filter:domain_remap]\nstorage_domain = storage.example.com\ns3_domain = s3.example.com\n")
+	require.Nil(t, err)
+	mid, err := NewDomainRemap(config.GetSection("filter:domain_remap"), tally.NoopScope)
+	require.Nil(t, err)
+	var path string
+	handler := mid(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		path = request.URL.Path
+	}))
+	tests := []struct{ host, path, expected string }{
+		{"c.auth-test.storage.example.com", "/obj", "/v1/AUTH_test/c/obj"},
+		{"C.AUTH-test.storage.example.com:8080", "/", "/v1/AUTH_test/c/"},
+		{"auth-test.storage.example.com", "/c/obj", "/v1/AUTH_test/c/obj"},
+		{"c.auth-test.storage.example.com", "/v1/AUTH_test/c/obj", "/v1/AUTH_test/c/obj"},
+		{"bucket.s3.example.com", "/key", "/bucket/key"},
+		{"my.bucket.s3.example.com", "/", "/my.bucket/"},
+		{"other.example.com", "/v1/AUTH_test/c", "/v1/AUTH_test/c"},
+		{"storage.example.com", "/v1/AUTH_test", "/v1/AUTH_test"},
+	}
+	for _, test := range tests {
+		path = ""
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Host = test.host
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, test.expected, path, test.host)
+	}
+
+	req := httptest.NewRequest("GET", "/obj", nil)
+	req.Host = "a.b.c.storage.example.com"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, 400, w.Code)
+}
+
+func TestDomainRemapResellerPrefix(t *testing.T) {
+	d := &domainRemap{resellerPrefixes: []string{"AUTH", "SERVICE"}, defaultResellerPrefix: "AUTH"}
+	require.Equal(t, "SERVICE_abc", d.remapAccount("service-abc"))
+	require.Equal(t, "AUTH_abc", d.remapAccount("abc"))
+	d.defaultResellerPrefix = ""
+	require.Equal(t, "abc", d.remapAccount("abc"))
+}
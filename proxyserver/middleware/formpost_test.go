@@ -62,7 +62,7 @@ func makeFormpostRequest(t *testing.T, body, boundary string, next http.Handler)
 		},
 	}
 	newr = newr.WithContext(context.WithValue(newr.Context(), "proxycontext", ctx))
-	formpost(common.NewTestScope().Counter("test_formpost"))(next).ServeHTTP(neww, newr)
+	formpost(tempurlDigests, common.NewTestScope().Counter("test_formpost"))(next).ServeHTTP(neww, newr)
 	return neww
 }
 
@@ -187,30 +187,43 @@ func TestAuthenticateFormpost(t *testing.T) {
 	}
 
 	require.Equal(t, FP_ERROR,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "X"}))
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "X"}))
 	require.Equal(t, FP_EXPIRED,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "12345"}))
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "12345"}))
 	require.Equal(t, FP_ERROR,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999", "signature": "X"}))
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999", "signature": "X"}))
 
 	// account key 1
 	require.Equal(t, FP_SCOPE_ACCOUNT,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
 			"signature": "1d4cb17a0d70b32f7987fe49b1990020bab52ae6"}))
 	// account key 2
 	require.Equal(t, FP_SCOPE_ACCOUNT,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
 			"signature": "9749158451be0383af1ec6d8e10f09a2d0d5f2b1"}))
 	// container key 1
 	require.Equal(t, FP_SCOPE_CONTAINER,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
 			"signature": "3320ff06b119d287a1c8d18d4356cd91e8518fe7"}))
 	// container key 2
 	require.Equal(t, FP_SCOPE_CONTAINER,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
 			"signature": "a3c3dd56ad65f5b87eeb384f9e0406c79511b556"}))
+	// sha256 and sha512 signatures
+	require.Equal(t, FP_SCOPE_ACCOUNT,
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+			"signature": "47a4558bdfcd65b7e9eb457346fcbb9eec4fb6856207494ea8ef064814e87600"}))
+	require.Equal(t, FP_SCOPE_CONTAINER,
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+			"signature": "sha512:XIelL6qQFCE6Gl9KHInuw6OiI46LFZpDHQjC4bVOzNsreA687eGoy6vEeFdLl0diFWp0Eo7Of4DEIeOsIpC42A=="}))
+	// a good signature made with a digest that isn't allowed
+	sha256Only, err := allowedDigests([]string{"sha256"})
+	require.Nil(t, err)
+	require.Equal(t, FP_INVALID,
+		authenticateFormpost(context.Background(), pc, sha256Only, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+			"signature": "1d4cb17a0d70b32f7987fe49b1990020bab52ae6"}))
 	// invalid key
 	require.Equal(t, FP_INVALID,
-		authenticateFormpost(context.Background(), pc, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
+		authenticateFormpost(context.Background(), pc, tempurlDigests, "a", "c", "/v1/a/c", map[string]string{"expires": "9999999999",
 			"signature": "1111111111111111111111111111111111111111"}))
 }
@@ -0,0 +1,93 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+)
+
+// defaultReservedPrefixes are the header prefixes only the proxy itself may set, and that clients never see.
+var defaultReservedPrefixes = []string{
+	"X-Account-Sysmeta-",
+	"X-Container-Sysmeta-",
+	"X-Object-Sysmeta-",
+	"X-Object-Transient-Sysmeta-",
+	"X-Backend-",
+}
+
+type gatekeeper struct {
+	next             http.Handler
+	reservedPrefixes []string
+	strippedMetric   tally.Counter
+}
+
+func (g *gatekeeper) reserved(header string) bool {
+	for _, prefix := range g.reservedPrefixes {
+		if strings.HasPrefix(header, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *gatekeeper) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// Subrequests are made by middlewares, which are trusted to set and read internal headers.
+	if ctx := GetProxyContext(request); ctx != nil && ctx.Source != "" {
+		g.next.ServeHTTP(writer, request)
+		return
+	}
+	for k := range request.Header {
+		if g.reserved(k) {
+			g.strippedMetric.Inc(1)
+			delete(request.Header, k)
+		}
+	}
+	newWriter := srv.NewCustomWriter(writer, func(w http.ResponseWriter, status int) int {
+		if v := w.Header().Get("X-Account-Sysmeta-Project-Domain-Id"); v != "" {
+			w.Header().Set("X-Account-Project-Domain-Id", v)
+		}
+		for k := range w.Header() {
+			if g.reserved(k) {
+				delete(w.Header(), k)
+			}
+		}
+		return status
+	})
+	g.next.ServeHTTP(newWriter, request)
+}
+
+func NewGatekeeper(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	// Configured prefixes are reserved as well as the defaults, never instead of them.
+	reservedPrefixes := append([]string{}, defaultReservedPrefixes...)
+	for _, prefix := range common.SliceFromCSV(config.GetDefault("reserved_prefixes", "")) {
+		reservedPrefixes = append(reservedPrefixes, http.CanonicalHeaderKey(prefix))
+	}
+	RegisterInfo("gatekeeper", map[string]interface{}{})
+	strippedMetric := metricsScope.Counter("gatekeeper_stripped")
+	return func(next http.Handler) http.Handler {
+		return &gatekeeper{
+			next:             next,
+			reservedPrefixes: reservedPrefixes,
+			strippedMetric:   strippedMetric,
+		}
+	}, nil
+}
@@ -0,0 +1,96 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func gatekeeperTestRequest(source string) *http.Request {
+	req := httptest.NewRequest("PUT", "/v1/a/c/o", nil)
+	req.Header.Set("X-Object-Sysmeta-Thing", "sneaky")
+	req.Header.Set("X-Backend-Storage-Policy-Index", "1")
+	req.Header.Set("X-Object-Meta-Color", "blue")
+	ctx := &ProxyContext{
+		ProxyContextMiddleware: &ProxyContextMiddleware{},
+		Logger:                 zap.NewNop(),
+		Source:                 source,
+	}
+	return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+}
+
+func gatekeeperTestApp(seen *http.Header) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		*seen = request.Header
+		writer.Header().Set("X-Object-Sysmeta-Thing", "secret")
+		writer.Header().Set("X-Account-Sysmeta-Project-Domain-Id", "default")
+		writer.Header().Set("X-Object-Meta-Color", "blue")
+		writer.WriteHeader(201)
+	})
+}
+
+func TestGatekeeperClientRequest(t *testing.T) {
+	var seen http.Header
+	mid, err := NewGatekeeper(conf.Section{}, tally.NewTestScope("", map[string]string{}))
+	require.Nil(t, err)
+	w := httptest.NewRecorder()
+	mid(gatekeeperTestApp(&seen)).ServeHTTP(w, gatekeeperTestRequest(""))
+	require.Equal(t, 201, w.Code)
+	require.Equal(t, "", seen.Get("X-Object-Sysmeta-Thing"))
+	require.Equal(t, "", seen.Get("X-Backend-Storage-Policy-Index"))
+	require.Equal(t, "blue", seen.Get("X-Object-Meta-Color"))
+	require.Equal(t, "", w.Header().Get("X-Object-Sysmeta-Thing"))
+	require.Equal(t, "", w.Header().Get("X-Account-Sysmeta-Project-Domain-Id"))
+	require.Equal(t, "default", w.Header().Get("X-Account-Project-Domain-Id"))
+	require.Equal(t, "blue", w.Header().Get("X-Object-Meta-Color"))
+}
+
+func TestGatekeeperSubrequest(t *testing.T) {
+	var seen http.Header
+	mid, err := NewGatekeeper(conf.Section{}, tally.NewTestScope("", map[string]string{}))
+	require.Nil(t, err)
+	w := httptest.NewRecorder()
+	mid(gatekeeperTestApp(&seen)).ServeHTTP(w, gatekeeperTestRequest("VW"))
+	require.Equal(t, "sneaky", seen.Get("X-Object-Sysmeta-Thing"))
+	require.Equal(t, "1", seen.Get("X-Backend-Storage-Policy-Index"))
+	require.Equal(t, "secret", w.Header().Get("X-Object-Sysmeta-Thing"))
+}
+
+func TestGatekeeperReservedPrefixes(t *testing.T) {
+	var seen http.Header
+	config, err := conf.StringConfig("[filter:gatekeeper]\nreserved_prefixes = x-secret-\n")
+	require.Nil(t, err)
+	mid, err := NewGatekeeper(config.GetSection("filter:gatekeeper"), tally.NewTestScope("", map[string]string{}))
+	require.Nil(t, err)
+	w := httptest.NewRecorder()
+	req := gatekeeperTestRequest("")
+	req.Header.Set("X-Secret-Thing", "sneaky")
+	mid(gatekeeperTestApp(&seen)).ServeHTTP(w, req)
+	require.Equal(t, "", seen.Get("X-Secret-Thing"))
+	// the defaults are still reserved
+	require.Equal(t, "", seen.Get("X-Object-Sysmeta-Thing"))
+	require.Equal(t, "", seen.Get("X-Backend-Storage-Policy-Index"))
+	require.Equal(t, "blue", seen.Get("X-Object-Meta-Color"))
+	require.Equal(t, "", w.Header().Get("X-Object-Sysmeta-Thing"))
+}
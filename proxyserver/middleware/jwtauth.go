@@ -0,0 +1,609 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// jwtAlgorithms are the signature algorithms bearer tokens may use.
+var jwtAlgorithms = []string{"RS256", "ES256"}
+
+const jwksBodyLimit = 1 << 20
+
+type jwtKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parseJWKS returns the signing keys in a JSON Web Key Set, skipping the ones it can't use.
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				continue
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+				continue
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, alg: k.Alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				continue
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil || !elliptic.P256().IsOnCurve(x, y) {
+				continue
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, alg: k.Alg, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+// jwksCache keeps the keys from a JWKS url or file, fetching them again every refresh, or sooner when a token names a
+// key it doesn't have, so keys can be rotated.  It won't refetch more often than minRefresh.
+type jwksCache struct {
+	lock       sync.Mutex
+	url        string
+	file       string
+	client     *http.Client
+	refresh    time.Duration
+	minRefresh time.Duration
+	keys       []jwtKey
+	fetched    time.Time
+	attempted  time.Time
+}
+
+func (c *jwksCache) fetch() ([]byte, error) {
+	if c.file != "" {
+		return ioutil.ReadFile(c.file)
+	}
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s returned %d", c.url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, jwksBodyLimit))
+}
+
+// load fetches the keys.  The lock is only held to store them, so a slow key server doesn't hold up tokens signed
+// with keys that are already loaded.
+func (c *jwksCache) load() error {
+	c.lock.Lock()
+	c.attempted = time.Now()
+	c.lock.Unlock()
+	data, err := c.fetch()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.keys = keys
+	c.fetched = time.Now()
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *jwksCache) find(kid string) []jwtKey {
+	var found []jwtKey
+	for _, k := range c.keys {
+		if kid == "" || k.kid == kid {
+			found = append(found, k)
+		}
+	}
+	return found
+}
+
+// get returns the keys a token with the given key id may be signed with.  If the keys can't be refetched, the ones
+// already loaded are still used.
+func (c *jwksCache) get(kid string) ([]jwtKey, error) {
+	c.lock.Lock()
+	found := c.find(kid)
+	reload := (len(found) == 0 || time.Since(c.fetched) > c.refresh) && time.Since(c.attempted) >= c.minRefresh
+	if reload {
+		// Claim the refetch so other requests keep using the loaded keys until it's done.
+		c.attempted = time.Now()
+	}
+	c.lock.Unlock()
+	if reload {
+		err := c.load()
+		c.lock.Lock()
+		found = c.find(kid)
+		loaded := len(c.keys) > 0
+		c.lock.Unlock()
+		if err != nil && !loaded {
+			return nil, fmt.Errorf("Unable to load JWKS: %v", err)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return found, nil
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, digest, sig []byte) bool {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		return ecdsa.Verify(pub, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	return false
+}
+
+// claimValues returns a claim as a list of strings, whether it's a string or a list.  Dots in the name reach into
+// nested claims, like realm_access.roles.
+func claimValues(claims map[string]interface{}, name string) []string {
+	var v interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	var values []string
+	switch t := v.(type) {
+	case string:
+		values = append(values, t)
+	case json.Number:
+		values = append(values, t.String())
+	case []interface{}:
+		for _, item := range t {
+			switch s := item.(type) {
+			case string:
+				values = append(values, s)
+			case json.Number:
+				values = append(values, s.String())
+			}
+		}
+	}
+	return values
+}
+
+func numericClaim(claims map[string]interface{}, name string) (float64, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return 0, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false, fmt.Errorf("%s claim isn't a number", name)
+	}
+	f, err := n.Float64()
+	return f, err == nil, err
+}
+
+// claimRule grants accounts, roles, and groups to tokens whose claim has the given value.
+type claimRule struct {
+	claim    string
+	value    string
+	accounts []string
+	roles    []string
+	groups   []string
+}
+
+// parseClaimRule parses a rule like "groups=storage-team account:storage role:admin group:readers".
+func parseClaimRule(rule string) (*claimRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("rule %q has no grants", rule)
+	}
+	match := strings.SplitN(fields[0], "=", 2)
+	if len(match) != 2 || match[0] == "" {
+		return nil, fmt.Errorf("rule %q doesn't start with claim=value", rule)
+	}
+	cr := &claimRule{claim: match[0], value: match[1]}
+	for _, grant := range fields[1:] {
+		parts := strings.SplitN(grant, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("rule %q has invalid grant %q", rule, grant)
+		}
+		switch parts[0] {
+		case "account":
+			cr.accounts = append(cr.accounts, parts[1])
+		case "role":
+			cr.roles = append(cr.roles, parts[1])
+		case "group":
+			cr.groups = append(cr.groups, parts[1])
+		default:
+			return nil, fmt.Errorf("rule %q has invalid grant %q", rule, grant)
+		}
+	}
+	return cr, nil
+}
+
+type jwtAuth struct {
+	next               http.Handler
+	keys               *jwksCache
+	algorithms         []string
+	issuer             string
+	audience           string
+	leeway             float64
+	reseller           string
+	resellers          []string
+	userClaim          string
+	accountClaim       string
+	rolesClaim         string
+	groupsClaim        string
+	adminRoles         []string
+	resellerAdminRoles []string
+	rules              []*claimRule
+	validMetric        tally.Counter
+	invalidMetric      tally.Counter
+}
+
+// validate checks a token's signature, lifetime, issuer, and audience, and returns its claims.
+func (ja *jwtAuth) validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	if !common.StringInSlice(header.Alg, ja.algorithms) {
+		return nil, fmt.Errorf("algorithm %q not allowed", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	keys, err := ja.keys.get(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, k := range keys {
+		if (k.alg == "" || k.alg == header.Alg) && verifyJWTSignature(header.Alg, k.key, digest[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+	claims := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok, err := numericClaim(claims, "exp"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("token has no expiration")
+	} else if now > exp+ja.leeway {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok, err := numericClaim(claims, "nbf"); err != nil {
+		return nil, err
+	} else if ok && now < nbf-ja.leeway {
+		return nil, errors.New("token not yet valid")
+	}
+	if ja.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != ja.issuer {
+			return nil, fmt.Errorf("issuer %q not trusted", iss)
+		}
+	}
+	if ja.audience != "" && !common.StringInSlice(ja.audience, claimValues(claims, "aud")) {
+		return nil, errors.New("token not for this audience")
+	}
+	return claims, nil
+}
+
+// identity maps a token's claims to its user and the groups it's known by: its groups, each of its accounts and
+// account:user, the reseller account for accounts it's an admin of, and .reseller_admin.
+func (ja *jwtAuth) identity(claims map[string]interface{}) (string, []string) {
+	users := claimValues(claims, ja.userClaim)
+	if len(users) == 0 || users[0] == "" {
+		return "", nil
+	}
+	user := users[0]
+	var accounts, roles, groups []string
+	if ja.accountClaim != "" {
+		accounts = claimValues(claims, ja.accountClaim)
+	}
+	roles = claimValues(claims, ja.rolesClaim)
+	for _, g := range claimValues(claims, ja.groupsClaim) {
+		if ja.allowedName(g) {
+			groups = append(groups, g)
+		}
+	}
+	for _, rule := range ja.rules {
+		if common.StringInSlice(rule.value, claimValues(claims, rule.claim)) {
+			accounts = append(accounts, rule.accounts...)
+			roles = append(roles, rule.roles...)
+			groups = append(groups, rule.groups...)
+		}
+	}
+	isAdmin := false
+	var remoteUsers []string
+	if ja.allowedName(user) {
+		remoteUsers = append(remoteUsers, user)
+	}
+	for _, role := range roles {
+		if common.StringInSlice(strings.ToLower(role), ja.resellerAdminRoles) && !common.StringInSlice(".reseller_admin", remoteUsers) {
+			remoteUsers = append(remoteUsers, ".reseller_admin")
+		}
+		if common.StringInSlice(strings.ToLower(role), ja.adminRoles) {
+			isAdmin = true
+		}
+	}
+	for _, account := range accounts {
+		for _, g := range []string{account, account + ":" + user} {
+			if !common.StringInSlice(g, remoteUsers) {
+				remoteUsers = append(remoteUsers, g)
+			}
+		}
+		if isAdmin && !common.StringInSlice(ja.reseller+account, remoteUsers) {
+			remoteUsers = append(remoteUsers, ja.reseller+account)
+		}
+	}
+	for _, g := range groups {
+		if !common.StringInSlice(g, remoteUsers) {
+			remoteUsers = append(remoteUsers, g)
+		}
+	}
+	return user, remoteUsers
+}
+
+// allowedName returns whether a user or group name from the token may be used as one of its groups.  Those are only
+// meant for ACLs; they can't claim reserved names like .reseller_admin or reseller-prefixed account names.
+func (ja *jwtAuth) allowedName(name string) bool {
+	_, reseller := ja.getReseller(name)
+	return name != "" && !strings.HasPrefix(name, ".") && (!reseller || ja.reseller == "")
+}
+
+func (ja *jwtAuth) getReseller(account string) (string, bool) {
+	for _, r := range ja.resellers {
+		if strings.HasPrefix(account, r) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+func (ja *jwtAuth) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ctx := GetProxyContext(request)
+	authorization := request.Header.Get("Authorization")
+	if ctx == nil || ctx.Authorize != nil || ctx.S3Auth != nil || len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		ja.next.ServeHTTP(writer, request)
+		return
+	}
+	var user string
+	var groups []string
+	claims, err := ja.validate(strings.TrimSpace(authorization[7:]))
+	if err == nil {
+		if user, groups = ja.identity(claims); user == "" {
+			err = fmt.Errorf("token has no %s claim", ja.userClaim)
+		}
+	}
+	if err != nil {
+		ja.invalidMetric.Inc(1)
+		ctx.Logger.Debug("Invalid bearer token", zap.Error(err))
+		ctx.Authorize = func(r *http.Request) (bool, int) {
+			return false, http.StatusUnauthorized
+		}
+		ja.next.ServeHTTP(writer, request)
+		return
+	}
+	ja.validMetric.Inc(1)
+	ctx.RemoteUsers = groups
+	ctx.RemoteUser = user
+	ctx.AuthMethod = "jwt"
+	ctx.Authorize = ja.authorize
+	ja.next.ServeHTTP(writer, request)
+}
+
+func (ja *jwtAuth) authorize(r *http.Request) (bool, int) {
+	pathParts, err := common.ParseProxyPath(r.URL.Path)
+	if err != nil {
+		return false, http.StatusNotFound
+	}
+	if r.Method == "OPTIONS" {
+		return true, http.StatusOK
+	}
+	ctx := GetProxyContext(r)
+	if ctx == nil {
+		return false, http.StatusUnauthorized
+	}
+	if _, ok := ja.getReseller(pathParts["account"]); !ok {
+		return false, http.StatusForbidden
+	}
+	if common.StringInSlice(".reseller_admin", ctx.RemoteUsers) &&
+		!common.StringInSlice(pathParts["account"], ja.resellers) &&
+		!strings.HasPrefix(pathParts["account"], ".") {
+		ctx.StorageOwner = true
+		return true, http.StatusOK
+	}
+	if common.StringInSlice(pathParts["account"], ctx.RemoteUsers) &&
+		(pathParts["container"] != "" || !common.StringInSlice(r.Method, []string{"PUT", "DELETE"})) {
+		ctx.StorageOwner = true
+		return true, http.StatusOK
+	}
+	referrers, roles := ParseACL(ctx.ACL)
+	if auth, _ := AuthorizeUnconfirmedIdentity(r, pathParts["object"], referrers, roles); auth {
+		return true, http.StatusOK
+	}
+	for _, ru := range ctx.RemoteUsers {
+		if common.StringInSlice(ru, roles) {
+			return true, http.StatusOK
+		}
+	}
+	return false, http.StatusForbidden
+}
+
+func lowerCSV(s string) []string {
+	var values []string
+	for _, v := range common.SliceFromCSV(s) {
+		values = append(values, strings.ToLower(v))
+	}
+	return values
+}
+
+func NewJWTAuth(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	jwksURL := config.GetDefault("jwks_url", "")
+	jwksFile := config.GetDefault("jwks_file", "")
+	if jwksURL == "" && jwksFile == "" {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	algorithms := common.SliceFromCSV(config.GetDefault("algorithms", strings.Join(jwtAlgorithms, ",")))
+	for _, alg := range algorithms {
+		if !common.StringInSlice(alg, jwtAlgorithms) {
+			return nil, fmt.Errorf("Unsupported JWT algorithm %q", alg)
+		}
+	}
+	var rules []*claimRule
+	for key, val := range config.Section {
+		if strings.HasPrefix(key, "rule_") {
+			rule, err := parseClaimRule(val)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+	}
+	resellerPrefixes, _ := conf.ReadResellerOptions(config, map[string][]string{})
+	keys := &jwksCache{
+		url:        jwksURL,
+		file:       jwksFile,
+		client:     &http.Client{Timeout: time.Duration(config.GetFloat("jwks_timeout", 10) * float64(time.Second))},
+		refresh:    time.Duration(config.GetFloat("jwks_refresh", 3600) * float64(time.Second)),
+		minRefresh: time.Duration(config.GetFloat("jwks_min_refresh", 60) * float64(time.Second)),
+	}
+	// A missing or bad key file is a configuration error; a key server that's down is retried as tokens come in.
+	if err := keys.load(); err != nil && jwksFile != "" {
+		return nil, fmt.Errorf("Unable to load JWKS: %v", err)
+	}
+	RegisterInfo("jwtauth", map[string]interface{}{"algorithms": algorithms})
+	validMetric := metricsScope.Counter("jwtauth_valid_tokens")
+	invalidMetric := metricsScope.Counter("jwtauth_invalid_tokens")
+	return func(next http.Handler) http.Handler {
+		return &jwtAuth{
+			next:               next,
+			keys:               keys,
+			algorithms:         algorithms,
+			issuer:             config.GetDefault("issuer", ""),
+			audience:           config.GetDefault("audience", ""),
+			leeway:             config.GetFloat("leeway", 60),
+			reseller:           resellerPrefixes[0],
+			resellers:          resellerPrefixes,
+			userClaim:          config.GetDefault("user_claim", "sub"),
+			accountClaim:       config.GetDefault("account_claim", ""),
+			rolesClaim:         config.GetDefault("roles_claim", "roles"),
+			groupsClaim:        config.GetDefault("groups_claim", "groups"),
+			adminRoles:         lowerCSV(config.GetDefault("admin_roles", "admin")),
+			resellerAdminRoles: lowerCSV(config.GetDefault("reseller_admin_roles", "ResellerAdmin")),
+			rules:              rules,
+			validMetric:        validMetric,
+			invalidMetric:      invalidMetric,
+		}
+	}, nil
+}
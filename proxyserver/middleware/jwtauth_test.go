@@ -0,0 +1,316 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type jwtTestKeys struct {
+	dir  string
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	file string
+}
+
+func padded(i *big.Int, size int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func (k *jwtTestKeys) writeJWKS(t *testing.T, rsaKid, ecKid string) {
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": rsaKid, "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(k.rsa.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": ecKid, "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(padded(k.ec.X, 32)),
+			"y": base64.RawURLEncoding.EncodeToString(padded(k.ec.Y, 32)),
+		},
+		{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"},
+	}}
+	data, err := json.Marshal(jwks)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(k.file, data, 0600))
+}
+
+func newJWTTestKeys(t *testing.T) *jwtTestKeys {
+	dir, err := ioutil.TempDir("", "jwtauth")
+	require.Nil(t, err)
+	k := &jwtTestKeys{dir: dir, file: filepath.Join(dir, "jwks.json")}
+	k.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	k.writeJWKS(t, "rsa1", "ec1")
+	return k
+}
+
+func (k *jwtTestKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	require.Nil(t, err)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.Nil(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		require.Nil(t, err)
+		sig = append(padded(r, 32), padded(s, 32)...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwtAuthTest(t *testing.T, k *jwtTestKeys, configString string, next http.Handler) *jwtAuth {
+	config, err := conf.StringConfig("[filter:jwtauth]\njwks_file = " + k.file + "\nissuer = https://sso.example.com\naudience = hummingbird\n" + configString)
+	require.Nil(t, err)
+	mid, err := NewJWTAuth(config.GetSection("filter:jwtauth"), tally.NoopScope)
+	require.Nil(t, err)
+	return mid(next).(*jwtAuth)
+}
+
+func jwtTestClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss": "https://sso.example.com",
+		"aud": []string{"hummingbird", "other"},
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestJWTAuthValidate(t *testing.T) {
+	k := newJWTTestKeys(t)
+	defer os.RemoveAll(k.dir)
+	ja := jwtAuthTest(t, k, "", nil)
+
+	claims, err := ja.validate(k.sign(t, "RS256", "rsa1", jwtTestClaims(nil)))
+	require.Nil(t, err)
+	require.Equal(t, "alice", claims["sub"])
+	_, err = ja.validate(k.sign(t, "ES256", "ec1", jwtTestClaims(nil)))
+	require.Nil(t, err)
+	_, err = ja.validate(k.sign(t, "ES256", "", jwtTestClaims(nil)))
+	require.Nil(t, err)
+
+	for name, token := range map[string]string{
+		"expired":        k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"not yet valid":  k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"wrong issuer":   k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"wrong audience": k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"aud": "other"})),
+		"wrong key":      k.sign(t, "RS256", "ec1", jwtTestClaims(nil)),
+		"unknown key":    k.sign(t, "RS256", "rsa2", jwtTestClaims(nil)),
+		"symmetric":      k.sign(t, "HS256", "symmetric", jwtTestClaims(nil)),
+		"unsigned":       k.sign(t, "none", "", jwtTestClaims(nil)),
+		"malformed":      "not.a-token",
+	} {
+		_, err := ja.validate(token)
+		require.NotNil(t, err, name)
+	}
+
+	// a tampered payload doesn't match the signature
+	token := k.sign(t, "RS256", "rsa1", jwtTestClaims(nil))
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(jwtTestClaims(map[string]interface{}{"sub": "mallory"}))
+	_, err = ja.validate(parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2])
+	require.NotNil(t, err)
+}
+
+func TestJWTAuthKeyRotation(t *testing.T) {
+	k := newJWTTestKeys(t)
+	defer os.RemoveAll(k.dir)
+	ja := jwtAuthTest(t, k, "jwks_min_refresh = 0\n", nil)
+	token := k.sign(t, "RS256", "rsa2", jwtTestClaims(nil))
+	_, err := ja.validate(token)
+	require.NotNil(t, err)
+	k.writeJWKS(t, "rsa2", "ec2")
+	_, err = ja.validate(token)
+	require.Nil(t, err)
+}
+
+func TestJWTAuthIdentity(t *testing.T) {
+	k := newJWTTestKeys(t)
+	defer os.RemoveAll(k.dir)
+	ja := jwtAuthTest(t, k, "account_claim = tenant\nroles_claim = realm_access.roles\n"+
+		"rule_storage = groups=storage-team account:shared role:admin\n"+
+		"rule_ops = email=ops@example.com role:ResellerAdmin group:auditors\n", nil)
+
+	user, groups := ja.identity(map[string]interface{}{
+		"sub":    "alice",
+		"tenant": "eng",
+		"groups": []interface{}{"readers", ".reseller_admin", "AUTH_other"},
+	})
+	require.Equal(t, "alice", user)
+	require.Equal(t, []string{"alice", "eng", "eng:alice", "readers"}, groups)
+
+	_, groups = ja.identity(map[string]interface{}{
+		"sub":          "bob",
+		"tenant":       "eng",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"Admin"}},
+	})
+	require.Equal(t, []string{"bob", "eng", "eng:bob", "AUTH_eng"}, groups)
+
+	_, groups = ja.identity(map[string]interface{}{
+		"sub":    "carol",
+		"groups": []interface{}{"storage-team"},
+		"email":  "ops@example.com",
+	})
+	require.Equal(t, []string{"carol", ".reseller_admin", "shared", "shared:carol", "AUTH_shared", "storage-team", "auditors"}, groups)
+
+	user, _ = ja.identity(map[string]interface{}{"tenant": "eng"})
+	require.Equal(t, "", user)
+
+	// user names can't claim reserved or reseller-prefixed names either
+	user, groups = ja.identity(map[string]interface{}{"sub": ".reseller_admin", "tenant": "eng"})
+	require.Equal(t, ".reseller_admin", user)
+	require.Equal(t, []string{"eng", "eng:.reseller_admin"}, groups)
+	_, groups = ja.identity(map[string]interface{}{"sub": "AUTH_victim"})
+	require.Equal(t, 0, len(groups))
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	k := newJWTTestKeys(t)
+	defer os.RemoveAll(k.dir)
+	var ctx *ProxyContext
+	ja := jwtAuthTest(t, k, "account_claim = tenant\n", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	}))
+	serve := func(method, path, token string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		ctx = &ProxyContext{ProxyContextMiddleware: &ProxyContextMiddleware{}, Logger: zap.NewNop()}
+		req = req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+		ja.ServeHTTP(httptest.NewRecorder(), req)
+		return req
+	}
+
+	admin := k.sign(t, "ES256", "ec1", jwtTestClaims(map[string]interface{}{"tenant": "eng", "roles": []string{"admin"}}))
+	req := serve("PUT", "/v1/AUTH_eng/c", admin)
+	require.Equal(t, "alice", ctx.RemoteUser)
+	require.Equal(t, "jwt", ctx.AuthMethod)
+	ok, _ := ctx.Authorize(req)
+	require.True(t, ok)
+	require.True(t, ctx.StorageOwner)
+	req = serve("DELETE", "/v1/AUTH_eng", admin)
+	ok, status := ctx.Authorize(req)
+	require.False(t, ok)
+	require.Equal(t, 403, status)
+	req = serve("GET", "/v1/AUTH_other/c", admin)
+	ok, status = ctx.Authorize(req)
+	require.False(t, ok)
+	require.Equal(t, 403, status)
+
+	// members that aren't admins get in through ACLs
+	member := k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"tenant": "eng"}))
+	req = serve("GET", "/v1/AUTH_eng/c/o", member)
+	ok, _ = ctx.Authorize(req)
+	require.False(t, ok)
+	ctx.ACL = "eng:alice"
+	ok, _ = ctx.Authorize(req)
+	require.True(t, ok)
+	require.False(t, ctx.StorageOwner)
+
+	req = serve("GET", "/v1/AUTH_eng/c", k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"exp": 1})))
+	ok, status = ctx.Authorize(req)
+	require.False(t, ok)
+	require.Equal(t, 401, status)
+	require.Equal(t, "", ctx.RemoteUser)
+
+	serve("GET", "/v1/AUTH_eng/c", "")
+	require.Nil(t, ctx.Authorize)
+
+	// a user claim naming a reserved group or someone else's account gets nothing from it
+	req = serve("GET", "/v1/AUTH_victim/c", k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"sub": ".reseller_admin"})))
+	ok, _ = ctx.Authorize(req)
+	require.False(t, ok)
+	require.False(t, ctx.StorageOwner)
+	req = serve("GET", "/v1/AUTH_victim/c", k.sign(t, "RS256", "rsa1", jwtTestClaims(map[string]interface{}{"sub": "AUTH_victim"})))
+	ok, _ = ctx.Authorize(req)
+	require.False(t, ok)
+	require.False(t, ctx.StorageOwner)
+}
+
+func TestJWTAuthSlowKeyServer(t *testing.T) {
+	k := newJWTTestKeys(t)
+	defer os.RemoveAll(k.dir)
+	jwks, err := ioutil.ReadFile(k.file)
+	require.Nil(t, err)
+	var fetches int32
+	stall := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-stall
+		}
+		writer.Write(jwks)
+	}))
+	defer ts.Close()
+	defer close(stall)
+	keys := &jwksCache{url: ts.URL, client: http.DefaultClient, refresh: time.Hour}
+	require.Nil(t, keys.load())
+	// a token with an unknown key refetches while tokens with known keys carry on
+	go keys.get("rsa2")
+	for i := 0; i < 500 && atomic.LoadInt32(&fetches) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	done := make(chan error)
+	go func() {
+		_, err := keys.get("rsa1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("get blocked on the key server")
+	}
+}
@@ -53,6 +53,8 @@ func (ka *keystoneAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ctx.RemoteUsers = []string{identityMap["tenantName"]}
+	ctx.RemoteUser = identityMap["userID"]
+	ctx.AuthMethod = "keystone"
 	ctx.Authorize = ka.authorize
 	ctx.addSubrequestCopy(keystoneSubrequestCopy)
 }
@@ -383,6 +383,8 @@ func (xlo *xloMiddleware) byteFeeder(sw *xloIdentifyWriter, request *http.Reques
 
 func (xlo *xloMiddleware) handleDloGet(sw *xloIdentifyWriter, request *http.Request) {
 	xlo.dloGetRequestsMetric.Inc(1)
+	// Any checksums are of the manifest, not the content we'll return.
+	RemoveItemsWithPrefix(sw.Header(), common.ChecksumHeaderPrefix)
 	pathMap, err := common.ParseProxyPath(request.URL.Path)
 	if err != nil || pathMap["object"] == "" {
 		srv.SimpleErrorResponse(sw.ResponseWriter, 400, fmt.Sprintf(
@@ -428,6 +430,7 @@ func (xlo *xloMiddleware) handleSloGet(sw *xloIdentifyWriter, request *http.Requ
 		sw.ResponseWriter.Write(manifestBytes)
 		return
 	}
+	RemoveItemsWithPrefix(sw.Header(), common.ChecksumHeaderPrefix)
 	sloEtag := sw.Header().Get("X-Object-Sysmeta-Slo-Etag")
 	savedContentLength := sw.Header().Get("X-Object-Sysmeta-Slo-Size")
 
@@ -623,6 +626,8 @@ func (xlo *xloMiddleware) handleSloPut(writer http.ResponseWriter, request *http
 	request.Header.Set("X-Object-Sysmeta-Slo-Size", fmt.Sprintf("%d", totalSize))
 	request.Header.Set("Etag", fmt.Sprintf("%x", md5.Sum(newBody)))
 	request.Header.Set("Content-Length", strconv.Itoa(len(newBody)))
+	// The manifest has been rewritten, so client checksums of it won't match.
+	RemoveItemsWithPrefix(request.Header, common.ChecksumHeaderPrefix)
 
 	etagWriter := &etagQuoteWriter{ResponseWriter: writer}
 	xlo.next.ServeHTTP(etagWriter, request)
@@ -0,0 +1,483 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/fs"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	// NotificationUrlHeader is the endpoint a container's object events are POSTed to.
+	NotificationUrlHeader = "X-Container-Notification-Url"
+	// NotificationKeyHeader is the key events are signed with.  It's never returned.
+	NotificationKeyHeader = "X-Container-Notification-Key"
+	// NotificationEventsHeader is a comma separated list of the events to send, like ObjectCreated or
+	// ObjectRemoved:Delete.  All events are sent if it's empty.
+	NotificationEventsHeader = "X-Container-Notification-Events"
+	// NotificationSignatureHeader carries the hex HMAC-SHA256 of an event's body, made with the container's key.
+	NotificationSignatureHeader = "X-Hummingbird-Signature"
+
+	notificationHeaderPrefix  = "X-Container-Notification-"
+	notificationSysmetaPrefix = "X-Container-Sysmeta-Notification-"
+	notificationMaxBackoff    = time.Hour
+)
+
+// notificationPrivateNets are the address ranges, beyond loopback and link-local, that events aren't sent to unless
+// their host is allowed by name: private networks, carrier-grade NAT, and IPv6 unique local addresses.
+var notificationPrivateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipnet)
+	}
+	return nets
+}()
+
+// notificationSettings are the notification headers a container can have, after their prefix.
+var notificationSettings = []string{"Url", "Key", "Events"}
+
+// notifySources are the requests events are sent for: client requests, and the object requests s3api makes for them.
+var notifySources = map[string]bool{"": true, "s3api": true}
+
+type notificationEvent struct {
+	EventVersion string `json:"eventVersion"`
+	EventSource  string `json:"eventSource"`
+	EventTime    string `json:"eventTime"`
+	EventName    string `json:"eventName"`
+	Account      string `json:"account"`
+	Container    string `json:"container"`
+	Object       string `json:"object"`
+	Etag         string `json:"etag,omitempty"`
+	Size         *int64 `json:"size,omitempty"`
+	User         string `json:"user,omitempty"`
+	TxId         string `json:"txn"`
+}
+
+// queuedNotification is an event waiting in the queue to be sent.
+type queuedNotification struct {
+	URL       string `json:"url"`
+	Signature string `json:"signature,omitempty"`
+	Body      string `json:"body"`
+	Attempts  int    `json:"attempts"`
+}
+
+// notifier keeps events in a queue directory until they've been delivered.  Queued files are named for when they
+// should next be sent, so a sorted directory listing has the ones that are due first.
+type notifier struct {
+	queueDir        string
+	allowedHosts    []string
+	client          *http.Client
+	workers         int
+	maxAttempts     int
+	wake            chan struct{}
+	queuedMetric    tally.Counter
+	deliveredMetric tally.Counter
+	retriedMetric   tally.Counter
+	failedMetric    tally.Counter
+}
+
+// blockedIP reports whether ip is inside the cluster's network, where events could reach services that aren't meant
+// to be public.
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, ipnet := range notificationPrivateNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validNotificationURL reports whether events may be sent to rawurl.  If allowedHosts is set, the URL's host has to
+// be one of them; otherwise any host is fine except ones that are plainly inside the cluster's network.
+func validNotificationURL(rawurl string, allowedHosts []string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(allowedHosts) > 0 {
+		return common.StringInSlice(host, allowedHosts)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedIP(ip) {
+		return false
+	}
+	return true
+}
+
+// dialPublic connects to an event's endpoint, refusing it if any of the host's addresses are inside the cluster's
+// network.  It checks when the event is sent rather than when the URL is set, since what the name resolves to can
+// change in between.
+func dialPublic(dialer *net.Dialer) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("No addresses found for %s", host)
+		}
+		for _, ip := range ips {
+			if blockedIP(ip) {
+				return nil, fmt.Errorf("Refusing to send notification to %s at %s", host, ip)
+			}
+		}
+		return dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+	}
+}
+
+func (n *notifier) save(next time.Time, qn *queuedNotification) error {
+	data, err := json.Marshal(qn)
+	if err != nil {
+		return err
+	}
+	w, err := fs.NewAtomicFileWriter(filepath.Join(n.queueDir, "tmp"), n.queueDir)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abandon()
+		return err
+	}
+	return w.Save(filepath.Join(n.queueDir, fmt.Sprintf("%019d-%s.json", next.UnixNano(), common.UUID())))
+}
+
+// enqueue durably queues an event for delivery to url, signed with key if there is one.
+func (n *notifier) enqueue(url, key string, body []byte) error {
+	qn := &queuedNotification{URL: url, Body: string(body)}
+	if key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		qn.Signature = hex.EncodeToString(mac.Sum(nil))
+	}
+	if err := n.save(time.Now(), qn); err != nil {
+		return err
+	}
+	n.queuedMetric.Inc(1)
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (n *notifier) send(qn *queuedNotification) bool {
+	req, err := http.NewRequest("POST", qn.URL, strings.NewReader(qn.Body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if qn.Signature != "" {
+		req.Header.Set(NotificationSignatureHeader, qn.Signature)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode/100 == 2
+}
+
+// deliver sends a queued event, requeueing it with a backoff if that fails, and setting it aside once it's failed maxAttempts times.
+func (n *notifier) deliver(name string) {
+	path := filepath.Join(n.queueDir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	qn := &queuedNotification{}
+	if err := json.Unmarshal(data, qn); err != nil || !validNotificationURL(qn.URL, n.allowedHosts) {
+		n.failedMetric.Inc(1)
+		os.Rename(path, filepath.Join(n.queueDir, "failed", name))
+		return
+	}
+	if n.send(qn) {
+		n.deliveredMetric.Inc(1)
+		os.Remove(path)
+		return
+	}
+	qn.Attempts++
+	if qn.Attempts >= n.maxAttempts {
+		n.failedMetric.Inc(1)
+		os.Rename(path, filepath.Join(n.queueDir, "failed", name))
+		return
+	}
+	backoff := time.Second << uint(qn.Attempts)
+	if backoff > notificationMaxBackoff || backoff <= 0 {
+		backoff = notificationMaxBackoff
+	}
+	if err := n.save(time.Now().Add(backoff), qn); err == nil {
+		n.retriedMetric.Inc(1)
+		os.Remove(path)
+	}
+}
+
+// deliverDue sends the queued events that are due, a few at a time.
+func (n *notifier) deliverDue() {
+	entries, err := ioutil.ReadDir(n.queueDir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	now := time.Now().UnixNano()
+	sem := make(chan struct{}, n.workers)
+	wg := sync.WaitGroup{}
+	for _, name := range names {
+		if next, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64); err == nil && next > now {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			n.deliver(name)
+			<-sem
+		}(name)
+	}
+	wg.Wait()
+}
+
+func (n *notifier) run(interval time.Duration) {
+	for {
+		n.deliverDue()
+		select {
+		case <-n.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+type notifications struct {
+	next         http.Handler
+	n            *notifier
+	allowedHosts []string
+}
+
+// eventName names the event a successful object request makes, or returns "" if it doesn't make one.
+func eventName(request *http.Request) string {
+	switch request.Method {
+	case "PUT":
+		if request.URL.Query().Get("multipart-manifest") == "put" {
+			return "ObjectCreated:CompleteMultipartUpload"
+		} else if request.Header.Get("X-Copy-From") != "" {
+			return "ObjectCreated:Copy"
+		}
+		return "ObjectCreated:Put"
+	case "COPY":
+		return "ObjectCreated:Copy"
+	case "POST":
+		return "ObjectMetadataUpdated:Post"
+	case "DELETE":
+		return "ObjectRemoved:Delete"
+	}
+	return ""
+}
+
+// wantsEvent reports whether a container with the given events setting wants the named event.
+func wantsEvent(events, name string) bool {
+	if events == "" {
+		return true
+	}
+	for _, e := range common.SliceFromCSV(events) {
+		if e == name || strings.HasPrefix(name, e+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveContainer keeps notification settings in container sysmeta, where clients can't see the key.
+func (nm *notifications) serveContainer(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == "PUT" || request.Method == "POST" {
+		for _, setting := range notificationSettings {
+			if v, ok := request.Header[notificationHeaderPrefix+setting]; ok {
+				if setting == "Url" && len(v) > 0 && v[0] != "" && !validNotificationURL(v[0], nm.allowedHosts) {
+					srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Invalid "+NotificationUrlHeader)
+					return
+				}
+				request.Header.Del(notificationHeaderPrefix + setting)
+				request.Header.Set(notificationSysmetaPrefix+setting, strings.Join(v, ","))
+			}
+		}
+	} else if request.Method == "GET" || request.Method == "HEAD" {
+		writer = srv.NewCustomWriter(writer, func(w http.ResponseWriter, status int) int {
+			for _, header := range []string{NotificationUrlHeader, NotificationEventsHeader} {
+				if v := w.Header().Get(notificationSysmetaPrefix + header[len(notificationHeaderPrefix):]); v != "" {
+					w.Header().Set(header, v)
+				}
+			}
+			return status
+		})
+	}
+	nm.next.ServeHTTP(writer, request)
+}
+
+func (nm *notifications) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	apiReq, account, container, object := getPathParts(request)
+	if !apiReq || container == "" {
+		nm.next.ServeHTTP(writer, request)
+		return
+	}
+	if object == "" {
+		nm.serveContainer(writer, request)
+		return
+	}
+	ctx := GetProxyContext(request)
+	name := eventName(request)
+	if name == "" || !notifySources[ctx.Source] {
+		nm.next.ServeHTTP(writer, request)
+		return
+	}
+	if request.Method == "COPY" {
+		var err error
+		if container, object, err = getHeaderContainerObjectName(request, "Destination"); err != nil {
+			nm.next.ServeHTTP(writer, request)
+			return
+		}
+		if destAccount := request.Header.Get("Destination-Account"); destAccount != "" {
+			account = destAccount
+		}
+	}
+	event := &notificationEvent{
+		EventVersion: "1.0",
+		EventSource:  "hummingbird",
+		EventName:    name,
+		Account:      account,
+		Container:    container,
+		Object:       object,
+		User:         ctx.RemoteUser,
+		TxId:         ctx.TxId,
+	}
+	if request.Method == "PUT" && request.ContentLength >= 0 && name == "ObjectCreated:Put" {
+		size := request.ContentLength
+		event.Size = &size
+	}
+	status := 0
+	nm.next.ServeHTTP(srv.NewCustomWriter(writer, func(w http.ResponseWriter, s int) int {
+		status = s
+		event.Etag = strings.Trim(w.Header().Get("Etag"), `"`)
+		return s
+	}), request)
+	if status/100 != 2 {
+		return
+	}
+	ci, err := ctx.C.GetContainerInfo(request.Context(), account, container)
+	if err != nil || ci.SysMetadata["Notification-Url"] == "" || !wantsEvent(ci.SysMetadata["Notification-Events"], name) {
+		return
+	}
+	event.EventTime = time.Now().UTC().Format(time.RFC3339Nano)
+	body, err := json.Marshal(event)
+	if err == nil {
+		err = nm.n.enqueue(ci.SysMetadata["Notification-Url"], ci.SysMetadata["Notification-Key"], body)
+	}
+	if err != nil {
+		ctx.Logger.Error("Error queueing object notification", zap.String("event", name), zap.Error(err))
+	}
+}
+
+func newNotifier(config conf.Section, metricsScope tally.Scope, queueDir string) (*notifier, error) {
+	for _, dir := range []string{queueDir, filepath.Join(queueDir, "tmp"), filepath.Join(queueDir, "failed")} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
+	}
+	timeout := time.Duration(config.GetFloat("timeout", 10) * float64(time.Second))
+	allowedHosts := common.SliceFromCSV(strings.ToLower(config.GetDefault("allowed_hosts", "")))
+	dialer := &net.Dialer{Timeout: timeout}
+	dial := dialer.Dial
+	if len(allowedHosts) == 0 {
+		dial = dialPublic(dialer)
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Dial:                dial,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 4,
+		},
+	}
+	return &notifier{
+		queueDir:        queueDir,
+		allowedHosts:    allowedHosts,
+		client:          client,
+		workers:         int(config.GetInt("workers", 4)),
+		maxAttempts:     int(config.GetInt("max_attempts", 15)),
+		wake:            make(chan struct{}, 1),
+		queuedMetric:    metricsScope.Counter("notifications_queued"),
+		deliveredMetric: metricsScope.Counter("notifications_delivered"),
+		retriedMetric:   metricsScope.Counter("notifications_retried"),
+		failedMetric:    metricsScope.Counter("notifications_failed"),
+	}, nil
+}
+
+func NewNotifications(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	queueDir := config.GetDefault("queue_dir", "")
+	if queueDir == "" {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	n, err := newNotifier(config, metricsScope, queueDir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to set up notification queue: %v", err)
+	}
+	go n.run(time.Duration(config.GetFloat("poll_interval", 5) * float64(time.Second)))
+	RegisterInfo("notifications", map[string]interface{}{
+		"events": []string{"ObjectCreated:Put", "ObjectCreated:Copy", "ObjectCreated:CompleteMultipartUpload", "ObjectMetadataUpdated:Post", "ObjectRemoved:Delete"},
+	})
+	return func(next http.Handler) http.Handler {
+		return &notifications{next: next, n: n, allowedHosts: n.allowedHosts}
+	}, nil
+}
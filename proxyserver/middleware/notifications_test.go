@@ -0,0 +1,285 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func newTestNotifier(t *testing.T, maxAttempts int, configString string) (*notifier, string) {
+	dir, err := ioutil.TempDir("", "notifications")
+	require.Nil(t, err)
+	config, err := conf.StringConfig("[filter:notifications]\n" + configString)
+	require.Nil(t, err)
+	n, err := newNotifier(config.GetSection("filter:notifications"), tally.NoopScope, dir)
+	require.Nil(t, err)
+	n.maxAttempts = maxAttempts
+	return n, dir
+}
+
+func queuedFiles(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestEventName(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, copyFrom, name string
+	}{
+		{"PUT", "/v1/a/c/o", "", "ObjectCreated:Put"},
+		{"PUT", "/v1/a/c/o", "c/o2", "ObjectCreated:Copy"},
+		{"PUT", "/v1/a/c/o?multipart-manifest=put", "", "ObjectCreated:CompleteMultipartUpload"},
+		{"COPY", "/v1/a/c/o", "", "ObjectCreated:Copy"},
+		{"POST", "/v1/a/c/o", "", "ObjectMetadataUpdated:Post"},
+		{"DELETE", "/v1/a/c/o", "", "ObjectRemoved:Delete"},
+		{"GET", "/v1/a/c/o", "", ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.copyFrom != "" {
+			req.Header.Set("X-Copy-From", tc.copyFrom)
+		}
+		require.Equal(t, tc.name, eventName(req))
+	}
+	require.True(t, wantsEvent("", "ObjectRemoved:Delete"))
+	require.True(t, wantsEvent("ObjectCreated, ObjectRemoved:Delete", "ObjectRemoved:Delete"))
+	require.True(t, wantsEvent("ObjectCreated", "ObjectCreated:Copy"))
+	require.False(t, wantsEvent("ObjectCreated", "ObjectRemoved:Delete"))
+	require.False(t, wantsEvent("ObjectCreated:Put", "ObjectCreated:Copy"))
+}
+
+func TestNotifierDeliver(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ = ioutil.ReadAll(request.Body)
+		signature = request.Header.Get("X-Hummingbird-Signature")
+		writer.WriteHeader(204)
+	}))
+	defer server.Close()
+	n, dir := newTestNotifier(t, 3, "allowed_hosts = 127.0.0.1\n")
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, n.enqueue(server.URL, "secret", []byte(`{"eventName":"ObjectCreated:Put"}`)))
+	require.Equal(t, 1, len(queuedFiles(t, dir)))
+	n.deliverDue()
+	require.Equal(t, `{"eventName":"ObjectCreated:Put"}`, string(body))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	require.Equal(t, hex.EncodeToString(mac.Sum(nil)), signature)
+	require.Equal(t, 0, len(queuedFiles(t, dir)))
+}
+
+func TestNotifierRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(503)
+	}))
+	defer server.Close()
+	n, dir := newTestNotifier(t, 2, "allowed_hosts = 127.0.0.1\n")
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, n.enqueue(server.URL, "", []byte(`{}`)))
+	first := queuedFiles(t, dir)
+	n.deliverDue()
+	require.Equal(t, 1, calls)
+	// it's requeued for later, so it isn't sent again right away.
+	retry := queuedFiles(t, dir)
+	require.Equal(t, 1, len(retry))
+	require.True(t, retry[0] > first[0])
+	n.deliverDue()
+	require.Equal(t, 1, calls)
+	data, err := ioutil.ReadFile(filepath.Join(dir, retry[0]))
+	require.Nil(t, err)
+	qn := &queuedNotification{}
+	require.Nil(t, json.Unmarshal(data, qn))
+	require.Equal(t, 1, qn.Attempts)
+
+	// once it's out of attempts, it's set aside.
+	n.deliver(retry[0])
+	require.Equal(t, 2, calls)
+	require.Equal(t, 0, len(queuedFiles(t, dir)))
+	require.Equal(t, 1, len(queuedFiles(t, filepath.Join(dir, "failed"))))
+}
+
+func TestValidNotificationURL(t *testing.T) {
+	for rawurl, valid := range map[string]bool{
+		"https://hooks.example.com/events": true,
+		"http://203.0.113.7:8080/":         true,
+		"ftp://hooks.example.com/":         false,
+		"http:///events":                   false,
+		"http://localhost/":                false,
+		"http://metadata.localhost/":       false,
+		"http://127.0.0.1:8080/":           false,
+		"http://[::1]/":                    false,
+		"http://169.254.169.254/latest":    false,
+		"http://10.1.2.3/":                 false,
+		"http://172.20.0.1/":               false,
+		"http://192.168.1.1/":              false,
+		"http://[fd00::1]/":                false,
+		"http://0.0.0.0/":                  false,
+	} {
+		require.Equal(t, valid, validNotificationURL(rawurl, nil), rawurl)
+	}
+	allowed := []string{"hooks.example.com", "10.1.2.3"}
+	require.True(t, validNotificationURL("http://Hooks.Example.com/", allowed))
+	require.True(t, validNotificationURL("http://10.1.2.3/", allowed))
+	require.False(t, validNotificationURL("http://elsewhere.example.com/", allowed))
+}
+
+func TestNotifierRefusesPrivateAddresses(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(204)
+	}))
+	defer server.Close()
+	n, dir := newTestNotifier(t, 3, "")
+	defer os.RemoveAll(dir)
+
+	// names are checked when the event is sent, once they've been resolved.
+	require.False(t, n.send(&queuedNotification{URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1), Body: "{}"}))
+	require.Equal(t, 0, calls)
+
+	// events queued for urls that aren't allowed any more are set aside.
+	require.Nil(t, n.enqueue(server.URL, "", []byte(`{}`)))
+	n.deliverDue()
+	require.Equal(t, 0, calls)
+	require.Equal(t, 0, len(queuedFiles(t, dir)))
+	require.Equal(t, 1, len(queuedFiles(t, filepath.Join(dir, "failed"))))
+}
+
+func notificationsTestRequest(t *testing.T, method, path string, sysmeta map[string]string) *http.Request {
+	f, err := client.NewProxyClient(staticPolicyList, srv.NewTestConfigLoader(&test.FakeRing{}),
+		nil, "", "", "", "", "", conf.Config{})
+	require.Nil(t, err)
+	req := httptest.NewRequest(method, path, strings.NewReader("hello"))
+	ctx := &ProxyContext{
+		ProxyContextMiddleware: &ProxyContextMiddleware{},
+		Logger:                 zap.NewNop(),
+		TxId:                   "tx123",
+		RemoteUser:             "test:tester",
+		C: f.NewRequestClient(nil, map[string]*client.ContainerInfo{
+			"container/a/c": {SysMetadata: sysmeta},
+		}, zap.NewNop()),
+	}
+	return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+}
+
+func TestNotificationsObjectEvents(t *testing.T) {
+	n, dir := newTestNotifier(t, 3, "")
+	defer os.RemoveAll(dir)
+	status := 201
+	nm := &notifications{n: n, next: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Etag", `"abc"`)
+		writer.WriteHeader(status)
+	})}
+	sysmeta := map[string]string{"Notification-Url": "http://hooks.example.com/", "Notification-Events": "ObjectCreated"}
+
+	nm.ServeHTTP(httptest.NewRecorder(), notificationsTestRequest(t, "PUT", "/v1/a/c/o", sysmeta))
+	files := queuedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0]))
+	require.Nil(t, err)
+	qn := &queuedNotification{}
+	require.Nil(t, json.Unmarshal(data, qn))
+	require.Equal(t, "http://hooks.example.com/", qn.URL)
+	require.Equal(t, "", qn.Signature)
+	event := &notificationEvent{}
+	require.Nil(t, json.Unmarshal([]byte(qn.Body), event))
+	require.Equal(t, "ObjectCreated:Put", event.EventName)
+	require.Equal(t, "a", event.Account)
+	require.Equal(t, "c", event.Container)
+	require.Equal(t, "o", event.Object)
+	require.Equal(t, "abc", event.Etag)
+	require.EqualValues(t, 5, *event.Size)
+	require.Equal(t, "test:tester", event.User)
+	require.Equal(t, "tx123", event.TxId)
+
+	// deletes aren't wanted
+	status = 204
+	nm.ServeHTTP(httptest.NewRecorder(), notificationsTestRequest(t, "DELETE", "/v1/a/c/o", sysmeta))
+	require.Equal(t, 1, len(queuedFiles(t, dir)))
+
+	// failed requests don't make events
+	status = 503
+	nm.ServeHTTP(httptest.NewRecorder(), notificationsTestRequest(t, "PUT", "/v1/a/c/o", sysmeta))
+	require.Equal(t, 1, len(queuedFiles(t, dir)))
+
+	// neither do subrequests
+	status = 201
+	req := notificationsTestRequest(t, "PUT", "/v1/a/c/o", sysmeta)
+	GetProxyContext(req).Source = "VW"
+	nm.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, 1, len(queuedFiles(t, dir)))
+}
+
+func TestNotificationsContainerHeaders(t *testing.T) {
+	var seen http.Header
+	nm := &notifications{allowedHosts: []string{"hooks.example.com"}, next: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		seen = request.Header
+		writer.Header().Set("X-Container-Sysmeta-Notification-Url", "http://hooks.example.com/")
+		writer.Header().Set("X-Container-Sysmeta-Notification-Key", "secret")
+		writer.WriteHeader(204)
+	})}
+
+	req := notificationsTestRequest(t, "POST", "/v1/a/c", nil)
+	req.Header.Set("X-Container-Notification-Url", "http://hooks.example.com/")
+	req.Header.Set("X-Container-Notification-Key", "secret")
+	w := httptest.NewRecorder()
+	nm.ServeHTTP(w, req)
+	require.Equal(t, 204, w.Code)
+	require.Equal(t, "http://hooks.example.com/", seen.Get("X-Container-Sysmeta-Notification-Url"))
+	require.Equal(t, "secret", seen.Get("X-Container-Sysmeta-Notification-Key"))
+	require.Equal(t, "", seen.Get("X-Container-Notification-Url"))
+
+	req = notificationsTestRequest(t, "POST", "/v1/a/c", nil)
+	req.Header.Set("X-Container-Notification-Url", "http://elsewhere.example.com/")
+	w = httptest.NewRecorder()
+	nm.ServeHTTP(w, req)
+	require.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	nm.ServeHTTP(w, notificationsTestRequest(t, "HEAD", "/v1/a/c", nil))
+	require.Equal(t, "http://hooks.example.com/", w.Header().Get("X-Container-Notification-Url"))
+	require.Equal(t, "", w.Header().Get("X-Container-Notification-Key"))
+}
@@ -75,6 +75,11 @@ func PipedGet(urlStr string, request *http.Request, source string, auth Authoriz
 	if request.URL.Query().Get("multipart-manifest") == "get" {
 		subRequest.URL.RawQuery = "multipart-manifest=get&format=raw"
 	}
+	if request.URL.Query().Get("symlink") == "get" {
+		query := subRequest.URL.Query()
+		query.Set("symlink", "get")
+		subRequest.URL.RawQuery = query.Encode()
+	}
 	CopyItems(subRequest.Header, request.Header)
 	// FIXME. Are we going to do X-Newest?
 	subRequest.Header.Set("X-Newest", "true")
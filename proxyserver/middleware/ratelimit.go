@@ -18,8 +18,10 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/troubling/hummingbird/common"
@@ -45,7 +47,229 @@ var writeMethods = map[string]bool{"PUT": true, "DELETE": true, "POST": true}
 type ratelimiter struct {
 	accountLimit   int64
 	containerLimit int64
+	accountTraffic trafficLimits
+	userTraffic    trafficLimits
+	buckets        *tokenBuckets
+	sharedBuckets  bool
 	next           http.Handler
+	requestsMetric tally.Counter
+	uploadMetric   tally.Counter
+	downloadMetric tally.Counter
+}
+
+// bucketLimit is a token bucket's fill rate and size, in requests or bytes; a zero rate is no limit.
+type bucketLimit struct {
+	rate  int64
+	burst int64
+}
+
+type trafficLimits struct {
+	requests bucketLimit
+	upload   bucketLimit
+	download bucketLimit
+}
+
+// bytesChunk is how many bytes are counted up before they're taken from a bucket, to keep memcache traffic down.
+const bytesChunk = 64 * 1024
+
+// maxLocalBuckets is how many local buckets are kept before idle ones are dropped.
+const maxLocalBuckets = 10000
+
+// tokenBuckets takes tokens from buckets shared through memcache, or kept in this process if memcache isn't available.
+// Each bucket is stored as the time it will next be full, which is pushed forward as tokens are taken.
+type tokenBuckets struct {
+	lock  sync.Mutex
+	local map[string]int64
+}
+
+// take removes amount tokens from the bucket, returning how many nanoseconds the bucket is overdrawn by.
+func (b *tokenBuckets) take(ctx context.Context, mc ring.MemcacheRing, key string, amount int64, limit bucketLimit) int64 {
+	cost := amount * nsPerSecond / limit.rate
+	burst := limit.burst * nsPerSecond / limit.rate
+	now := nowNano()
+	if mc != nil {
+		if full, err := mc.Incr(ctx, key, cost, 3600); err == nil {
+			if full-cost < now {
+				mc.Set(ctx, key, now+cost, 3600)
+				return 0
+			}
+			return full - now - burst
+		}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.local) > maxLocalBuckets {
+		for k, full := range b.local {
+			if full < now {
+				delete(b.local, k)
+			}
+		}
+	}
+	full := b.local[key]
+	if full < now {
+		full = now
+	}
+	b.local[key] = full + cost
+	return full + cost - now - burst
+}
+
+// giveBack returns tokens that were taken for a request that was turned away.
+func (b *tokenBuckets) giveBack(ctx context.Context, mc ring.MemcacheRing, key string, amount int64, limit bucketLimit) {
+	cost := amount * nsPerSecond / limit.rate
+	if mc != nil {
+		if _, err := mc.Decr(ctx, key, cost, 3600); err == nil {
+			return
+		}
+	}
+	b.lock.Lock()
+	b.local[key] -= cost
+	b.lock.Unlock()
+}
+
+// bucket is one of a request's bandwidth buckets.
+type bucket struct {
+	key   string
+	limit bucketLimit
+}
+
+// throttle takes bytes from each of the buckets, sleeping while any of them is overdrawn.
+func (r *ratelimiter) throttle(ctx context.Context, mc ring.MemcacheRing, buckets []bucket, bytes int64, metric tally.Counter) {
+	wait := int64(0)
+	for _, b := range buckets {
+		if w := r.buckets.take(ctx, mc, b.key, bytes, b.limit); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		metric.Inc(bytes)
+		if wait > maxSleep {
+			wait = maxSleep
+		}
+		sleep(time.Duration(wait))
+	}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	mc      ring.MemcacheRing
+	r       *ratelimiter
+	buckets []bucket
+	pending int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.pending += int64(n)
+	if t.pending >= bytesChunk || (err != nil && t.pending > 0) {
+		t.r.throttle(t.ctx, t.mc, t.buckets, t.pending, t.r.uploadMetric)
+		t.pending = 0
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	mc      ring.MemcacheRing
+	r       *ratelimiter
+	buckets []bucket
+	pending int64
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	n, err := t.ResponseWriter.Write(p)
+	t.pending += int64(n)
+	if t.pending >= bytesChunk {
+		t.r.throttle(t.ctx, t.mc, t.buckets, t.pending, t.r.downloadMetric)
+		t.pending = 0
+	}
+	return n, err
+}
+
+// finish counts any bytes written since the last chunk, so small responses aren't free.
+func (t *throttledWriter) finish() {
+	if t.pending > 0 {
+		t.r.throttle(t.ctx, t.mc, t.buckets, t.pending, t.r.downloadMetric)
+		t.pending = 0
+	}
+}
+
+// accountTrafficLimits returns the account's traffic limits, with any overrides from its sysmeta.
+func (r *ratelimiter) accountTrafficLimits(ai *AccountInfo) trafficLimits {
+	limits := r.accountTraffic
+	if ai == nil {
+		return limits
+	}
+	override := func(limit *bucketLimit, name string) {
+		if v, err := strconv.ParseInt(ai.SysMetadata["Ratelimit-"+name+"-Per-Sec"], 10, 64); err == nil && v >= 0 {
+			limit.rate = v
+			limit.burst = v
+		}
+		if v, err := strconv.ParseInt(ai.SysMetadata["Ratelimit-"+name+"-Burst"], 10, 64); err == nil && v > 0 {
+			limit.burst = v
+		}
+	}
+	override(&limits.requests, "Requests")
+	override(&limits.upload, "Upload-Bytes")
+	override(&limits.download, "Download-Bytes")
+	return limits
+}
+
+// limitTraffic applies the request rate and bandwidth limits to a client request, returning false if it was turned away.
+func (r *ratelimiter) limitTraffic(writer http.ResponseWriter, request *http.Request, ctx *ProxyContext, account string) (http.ResponseWriter, bool) {
+	var limits []trafficLimits
+	var keys []string
+	if account != "" {
+		var ai *AccountInfo
+		if info, err := ctx.GetAccountInfo(request.Context(), account); err == nil {
+			ai = info
+		}
+		limits = append(limits, r.accountTrafficLimits(ai))
+		keys = append(keys, "ratelimit/account/"+account)
+	}
+	if ctx.RemoteUser != "" {
+		limits = append(limits, r.userTraffic)
+		keys = append(keys, "ratelimit/user/"+ctx.RemoteUser)
+	}
+	var mc ring.MemcacheRing
+	if r.sharedBuckets {
+		mc = ctx.Cache
+	}
+	var requests, upload, download []bucket
+	for i, l := range limits {
+		if l.requests.rate > 0 {
+			requests = append(requests, bucket{key: keys[i] + "/requests", limit: l.requests})
+		}
+		if l.upload.rate > 0 {
+			upload = append(upload, bucket{key: keys[i] + "/upload", limit: l.upload})
+		}
+		if l.download.rate > 0 {
+			download = append(download, bucket{key: keys[i] + "/download", limit: l.download})
+		}
+	}
+	wait := int64(0)
+	for _, b := range requests {
+		if w := r.buckets.take(request.Context(), mc, b.key, 1, b.limit); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		for _, b := range requests {
+			r.buckets.giveBack(request.Context(), mc, b.key, 1, b.limit)
+		}
+		r.requestsMetric.Inc(1)
+		writer.Header().Set("Retry-After", strconv.FormatInt((wait+nsPerSecond-1)/nsPerSecond, 10))
+		srv.StandardResponse(writer, 498)
+		return writer, false
+	}
+	if len(upload) > 0 && request.Body != nil {
+		request.Body = &throttledReader{ReadCloser: request.Body, ctx: request.Context(), mc: mc, r: r, buckets: upload}
+	}
+	if len(download) > 0 {
+		writer = &throttledWriter{ResponseWriter: writer, ctx: request.Context(), mc: mc, r: r, buckets: download}
+	}
+	return writer, true
 }
 
 var sleep = func(s time.Duration) {
@@ -78,8 +302,18 @@ func (r *ratelimiter) getSleepTime(ctx context.Context, mc ring.MemcacheRing, ke
 }
 
 func (r *ratelimiter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	isWrite := writeMethods[request.Method]
 	pathParts, err := common.ParseProxyPath(request.URL.Path)
+	// Only client requests count against traffic limits; subrequests are part of the client request that made them.
+	if ctx := GetProxyContext(request); ctx != nil && ctx.Source == "" {
+		var ok bool
+		if writer, ok = r.limitTraffic(writer, request, ctx, pathParts["account"]); !ok {
+			return
+		}
+		if tw, ok := writer.(*throttledWriter); ok {
+			defer tw.finish()
+		}
+	}
+	isWrite := writeMethods[request.Method]
 	if !isWrite || err != nil || pathParts["container"] == "" {
 		r.next.ServeHTTP(writer, request)
 		return
@@ -127,6 +361,7 @@ func (r *ratelimiter) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 		if err == nil {
 			if sleepTime > maxSleep {
 				sleep(time.Second)
+				writer.Header().Set("Retry-After", strconv.FormatInt((sleepTime-maxSleep+nsPerSecond-1)/nsPerSecond, 10))
 				srv.StandardResponse(writer, 498)
 				return
 			}
@@ -138,16 +373,47 @@ func (r *ratelimiter) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 	r.next.ServeHTTP(writer, request)
 }
 
+// loadTrafficLimits reads the traffic limits for accounts or users, whose settings start with prefix.
+func loadTrafficLimits(config conf.Section, prefix string) trafficLimits {
+	limit := func(name string) bucketLimit {
+		rate := config.GetInt(prefix+name+"_per_sec", 0)
+		burst := config.GetInt(prefix+name+"_burst", 0)
+		if burst <= 0 {
+			burst = rate
+		}
+		return bucketLimit{rate: rate, burst: burst}
+	}
+	return trafficLimits{
+		requests: limit("requests"),
+		upload:   limit("upload_bytes"),
+		download: limit("download_bytes"),
+	}
+}
+
 func NewRatelimiter(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
 
 	accLimit := int64(config.GetInt("account_db_max_writes_per_sec", 0))
 	contLimit := int64(config.GetInt("container_db_max_writes_per_sec", 0))
-	RegisterInfo("ratelimit", map[string]interface{}{"account_ratelimit": accLimit, "container_ratelimits": [][]int64{{contLimit}}, "max_sleep_time_seconds": float64(60.0)})
+	accountTraffic := loadTrafficLimits(config, "account_")
+	userTraffic := loadTrafficLimits(config, "user_")
+	RegisterInfo("ratelimit", map[string]interface{}{"account_ratelimit": accLimit, "container_ratelimits": [][]int64{{contLimit}}, "max_sleep_time_seconds": float64(60.0),
+		"account_requests_per_sec": accountTraffic.requests.rate, "user_requests_per_sec": userTraffic.requests.rate})
+	buckets := &tokenBuckets{local: map[string]int64{}}
+	requestsMetric := metricsScope.Counter("ratelimit_throttled_requests")
+	uploadMetric := metricsScope.Counter("ratelimit_throttled_upload_bytes")
+	downloadMetric := metricsScope.Counter("ratelimit_throttled_download_bytes")
 	return func(next http.Handler) http.Handler {
 		return &ratelimiter{
 			accountLimit:   accLimit,
 			containerLimit: contLimit,
+			accountTraffic: accountTraffic,
+			userTraffic:    userTraffic,
+			buckets:        buckets,
+			sharedBuckets:  config.GetBool("shared_buckets", true),
 			next:           next,
+			requestsMetric: requestsMetric,
+			uploadMetric:   uploadMetric,
+			downloadMetric: downloadMetric,
 		}
 	}, nil
 }
@@ -18,11 +18,15 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/troubling/hummingbird/common/conf"
 	"github.com/troubling/hummingbird/common/test"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
 )
 
 var now = 10 * nsPerSecond
@@ -125,3 +129,135 @@ func TestServeHTTP(t *testing.T) {
 	assert.Equal(t, s.SleepVals[len(s.SleepVals)-1], time.Second)
 }
 */
+
+func TestTokenBucketsLocal(t *testing.T) {
+	oldNowNano := nowNano
+	defer func() {
+		nowNano = oldNowNano
+	}()
+	nowNano = fakeNowNano
+	b := &tokenBuckets{local: map[string]int64{}}
+	limit := bucketLimit{rate: 10, burst: 2}
+	assert.True(t, b.take(context.Background(), nil, "k", 1, limit) <= 0)
+	assert.True(t, b.take(context.Background(), nil, "k", 1, limit) <= 0)
+	assert.Equal(t, nsPerSecond/10, b.take(context.Background(), nil, "k", 1, limit))
+	b.giveBack(context.Background(), nil, "k", 1, limit)
+	assert.Equal(t, now+2*nsPerSecond/10, b.local["k"])
+	// other buckets are separate
+	assert.True(t, b.take(context.Background(), nil, "k2", 1, limit) <= 0)
+}
+
+func TestTokenBucketsMemcache(t *testing.T) {
+	oldNowNano := nowNano
+	defer func() {
+		nowNano = oldNowNano
+	}()
+	nowNano = fakeNowNano
+	b := &tokenBuckets{local: map[string]int64{}}
+	limit := bucketLimit{rate: 10, burst: 2}
+	// an idle bucket is refilled
+	fakeMr := &test.FakeMemcacheRing{MockIncrResults: []int64{0}}
+	assert.True(t, b.take(context.Background(), fakeMr, "k", 1, limit) <= 0)
+	assert.Equal(t, now+nsPerSecond/10, fakeMr.MockSetValues[0])
+	// a bucket that's a second ahead is overdrawn by what's over the burst
+	fakeMr = &test.FakeMemcacheRing{MockIncrResults: []int64{now + nsPerSecond}}
+	assert.Equal(t, nsPerSecond-nsPerSecond/5+nsPerSecond/10, b.take(context.Background(), fakeMr, "k", 1, limit))
+	assert.Equal(t, []string{"k"}, fakeMr.MockIncrKeys)
+	assert.Equal(t, 0, len(b.local))
+}
+
+func TestRatelimitTraffic(t *testing.T) {
+	oldNowNano := nowNano
+	oldSleep := sleep
+	defer func() {
+		nowNano = oldNowNano
+		sleep = oldSleep
+	}()
+	nowNano = fakeNowNano
+	s := sleeper{}
+	sleep = s.fakeSleep
+	config, err := conf.StringConfig("[filter:ratelimit]\naccount_requests_per_sec = 1\nuser_download_bytes_per_sec = 10\nshared_buckets = false\n")
+	assert.Nil(t, err)
+	mid, err := NewRatelimiter(config.GetSection("filter:ratelimit"), tally.NoopScope)
+	assert.Nil(t, err)
+	rl := mid(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+		writer.Write([]byte("some bytes to download"))
+	}))
+	newRequest := func(account, source string) *http.Request {
+		req := httptest.NewRequest("GET", "/v1/"+account+"/c/o", nil)
+		ctx := &ProxyContext{
+			ProxyContextMiddleware: &ProxyContextMiddleware{},
+			Logger:                 zap.NewNop(),
+			RemoteUser:             "test:tester",
+			Source:                 source,
+			accountInfoCache: map[string]*AccountInfo{
+				"account/a": {SysMetadata: map[string]string{}},
+				"account/b": {SysMetadata: map[string]string{"Ratelimit-Requests-Per-Sec": "0"}},
+			},
+		}
+		return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	}
+
+	w := httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest("a", ""))
+	assert.Equal(t, 200, w.Code)
+	// the user's download limit kicks in once the response is done
+	assert.Equal(t, 1, len(s.SleepVals))
+
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest("a", ""))
+	assert.Equal(t, 498, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// subrequests aren't counted
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest("a", "SLO"))
+	assert.Equal(t, 200, w.Code)
+
+	// the account's sysmeta can lift its limit
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest("b", ""))
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest("b", ""))
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestRatelimitTrafficShared(t *testing.T) {
+	oldNowNano := nowNano
+	defer func() {
+		nowNano = oldNowNano
+	}()
+	nowNano = fakeNowNano
+	config, err := conf.StringConfig("[filter:ratelimit]\naccount_requests_per_sec = 1\nshared_buckets = true\n")
+	assert.Nil(t, err)
+	mid, err := NewRatelimiter(config.GetSection("filter:ratelimit"), tally.NoopScope)
+	assert.Nil(t, err)
+	rl := mid(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	}))
+	// the bucket is full a second from now after the first request, and two seconds from now after the second.
+	fakeMr := &test.FakeMemcacheRing{MockIncrResults: []int64{now, now + nsPerSecond}}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/v1/a/c/o", nil)
+		ctx := &ProxyContext{
+			ProxyContextMiddleware: &ProxyContextMiddleware{Cache: fakeMr},
+			Logger:                 zap.NewNop(),
+			accountInfoCache: map[string]*AccountInfo{
+				"account/a": {SysMetadata: map[string]string{}},
+			},
+		}
+		return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+	}
+
+	w := httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest())
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	rl.ServeHTTP(w, newRequest())
+	assert.Equal(t, 498, w.Code)
+	assert.Equal(t, []string{"ratelimit/account/a/requests", "ratelimit/account/a/requests"}, fakeMr.MockIncrKeys)
+	// the buckets were all kept in memcache
+	assert.Equal(t, 0, len(rl.(*ratelimiter).buckets.local))
+}
@@ -42,6 +42,7 @@ package middleware
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -84,9 +85,12 @@ var s3Responses = map[int]s3Response{
 	503:   {"ServiceUnavailable", "Reduce your request rate."},
 	40000: {"InvalidBucketName", "The specified bucket is not valid."},
 	40001: {"BucketAlreadyExists", "The specified bucket is not valid."},
+	40002: {"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."},
+	40003: {"InvalidArgument", "Invalid Argument"},
 	40300: {"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."},
 	40400: {"NoSuchBucket", "The specified bucket does not exist."},
 	40401: {"NoSuchKey", "The specified key does not exist."},
+	40402: {"NoSuchConfiguration", "The specified configuration does not exist."},
 }
 
 type s3Owner struct {
@@ -406,6 +410,44 @@ func s3PathSplit(path string) (string, string) {
 	}
 }
 
+// s3OperationResources are the subresources that name what an S3 request operates on, in the order they're looked for.
+var s3OperationResources = []string{"acl", "cors", "delete", "inventory", "lifecycle", "location", "logging", "notification",
+	"policy", "restore", "tagging", "torrent", "versioning", "versions", "website"}
+
+// s3Operation names an S3 request's operation the way S3's server access logs do, like REST.GET.OBJECT.
+func s3Operation(request *http.Request) string {
+	container, object := s3PathSplit(request.URL.Path)
+	q := request.URL.Query()
+	method := request.Method
+	resource := "SERVICE"
+	if object != "" {
+		resource = "OBJECT"
+	} else if container != "" {
+		resource = "BUCKET"
+	}
+	if _, ok := q["uploads"]; ok {
+		resource = "UPLOADS"
+	} else if _, ok := q["uploadId"]; ok && object != "" {
+		resource = "UPLOAD"
+		if _, ok := q["partNumber"]; ok {
+			resource = "PART"
+		}
+	} else if _, ok := q["delete"]; ok && method == "POST" {
+		resource = "MULTI_OBJECT_DELETE"
+	} else {
+		for _, sub := range s3OperationResources {
+			if _, ok := q[sub]; ok {
+				resource = strings.ToUpper(sub)
+				break
+			}
+		}
+	}
+	if method == "PUT" && resource == "OBJECT" && request.Header.Get("X-Amz-Copy-Source") != "" {
+		method = "COPY"
+	}
+	return "REST." + method + "." + resource
+}
+
 var bucketIsIP = regexp.MustCompile(`(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}`)
 var bucketValidChars = regexp.MustCompile(`^[-.a-z0-9]*$`)
 
@@ -462,6 +504,21 @@ func BucketAlreadyExistsResponse(writer http.ResponseWriter, request *http.Reque
 	writer.Write(nil)
 }
 
+func MalformedXMLResponse(writer http.ResponseWriter, request *http.Request) {
+	writer.WriteHeader(40002)
+	writer.Write(nil)
+}
+
+func InvalidArgumentResponse(writer http.ResponseWriter, request *http.Request) {
+	writer.WriteHeader(40003)
+	writer.Write(nil)
+}
+
+func NoSuchConfigurationResponse(writer http.ResponseWriter, request *http.Request) {
+	writer.WriteHeader(40402)
+	writer.Write(nil)
+}
+
 func s3DateString(s string) string {
 	// This is just trimming out some extra precision off our seconds for
 	// the swift s3api func tests.
@@ -475,6 +532,51 @@ func s3DateString(s string) string {
 	return s[:len(s)-3] + "Z"
 }
 
+// s3Checksums maps the S3 checksum headers, which are base64, to our own
+// checksum headers, which are hex.
+var s3Checksums = map[string]string{
+	"X-Amz-Checksum-Sha256": common.ChecksumSHA256,
+	"X-Amz-Checksum-Crc32c": common.ChecksumCRC32C,
+}
+
+// s3ChecksumsToSwift sets the checksum headers on dst from any S3 checksum
+// headers in src.
+func s3ChecksumsToSwift(dst, src http.Header) error {
+	for s3Key, key := range s3Checksums {
+		if v := src.Get(s3Key); v != "" {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return fmt.Errorf("Invalid %s value %q", s3Key, v)
+			}
+			dst.Set(key, hex.EncodeToString(b))
+		}
+	}
+	return nil
+}
+
+// s3ChecksumsFromSwift sets the S3 checksum headers on dst from any checksum
+// headers in src.
+func s3ChecksumsFromSwift(dst, src http.Header) {
+	for s3Key, key := range s3Checksums {
+		if v := src.Get(key); v != "" {
+			if b, err := hex.DecodeString(v); err == nil {
+				dst.Set(s3Key, base64.StdEncoding.EncodeToString(b))
+			}
+		}
+	}
+}
+
+// s3ChecksumWriter adds the S3 checksum headers to a GET or HEAD response, for
+// requests with x-amz-checksum-mode: ENABLED.
+type s3ChecksumWriter struct {
+	http.ResponseWriter
+}
+
+func (w *s3ChecksumWriter) WriteHeader(status int) {
+	s3ChecksumsFromSwift(w.Header(), w.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
 func (s *s3ApiHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	ctx := GetProxyContext(request)
 	// Check if this is an S3 request
@@ -582,6 +684,10 @@ func (s *s3ApiHandler) handleObjectRequest(writer http.ResponseWriter, request *
 		newReq.Header.Set("If-None-Match", request.Header.Get("If-None-Match"))
 		newReq.Header.Set("If-Modified-Since", request.Header.Get("If-Modified-Since"))
 		newReq.Header.Set("If-UnModified-Since", request.Header.Get("If-UnModified-Since"))
+		if strings.EqualFold(request.Header.Get("X-Amz-Checksum-Mode"), "ENABLED") {
+			ctx.serveHTTPSubrequest(&s3ChecksumWriter{ResponseWriter: writer}, newReq)
+			return
+		}
 		ctx.serveHTTPSubrequest(writer, newReq)
 		return
 	}
@@ -676,6 +782,12 @@ func (s *s3ApiHandler) handleObjectRequest(writer http.ResponseWriter, request *
 		}
 		newReq.Header.Set("Content-Length", request.Header.Get("Content-Length"))
 		newReq.Header.Set("Content-Type", request.Header.Get("Content-Type"))
+		if copySource == "" {
+			if err := s3ChecksumsToSwift(newReq.Header, request.Header); err != nil {
+				srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
 		cap := NewCaptureWriter()
 		ctx.serveHTTPSubrequest(cap, newReq)
 		if cap.status/100 != 2 {
@@ -697,6 +809,7 @@ func (s *s3ApiHandler) handleObjectRequest(writer http.ResponseWriter, request *
 			} else {
 				writer.Header().Set("ETag", "\""+cap.Header().Get("ETag")+"\"")
 				writer.Header().Set("Content-Length", cap.Header().Get("Content-Length"))
+				s3ChecksumsFromSwift(writer.Header(), cap.Header())
 				writer.WriteHeader(200)
 			}
 			return
@@ -815,12 +928,31 @@ func (s *s3ApiHandler) handleObjectRequest(writer http.ResponseWriter, request *
 	srv.StandardResponse(writer, http.StatusMethodNotAllowed)
 }
 
+// s3ListingFilters are the container listing filters passed through bucket listings, along with any "meta." parameters.
+var s3ListingFilters = map[string]bool{
+	"content_type":    true,
+	"modified_since":  true,
+	"modified_before": true,
+	"size_gt":         true,
+	"size_lt":         true,
+}
+
 func (s *s3ApiHandler) handleContainerRequest(writer http.ResponseWriter, request *http.Request) {
 	ctx := GetProxyContext(request)
 	request.ParseForm()
 
 	writer.Header().Set("Location", "/"+s.container)
 
+	if _, ok := request.Form["inventory"]; ok {
+		s.handleInventoryRequest(writer, request)
+		return
+	}
+
+	if _, ok := request.Form["notification"]; ok {
+		s.handleNotificationRequest(writer, request)
+		return
+	}
+
 	if request.Method == "HEAD" {
 		newReq, err := ctx.newSubrequest("HEAD", s.path, http.NoBody, request, "s3api")
 		if err != nil {
@@ -970,6 +1102,12 @@ func (s *s3ApiHandler) handleContainerRequest(writer http.ResponseWriter, reques
 		if delimiter != "" {
 			nq.Set("delimiter", delimiter)
 		}
+		// Container listing filters aren't part of the S3 API, but are passed along for clients that know about them.
+		for k, v := range q {
+			if (s3ListingFilters[k] || strings.HasPrefix(k, "meta.")) && len(v) > 0 {
+				nq.Set(k, v[0])
+			}
+		}
 		cap := NewCaptureWriter()
 		newReq.URL.RawQuery = nq.Encode()
 		ctx.serveHTTPSubrequest(cap, newReq)
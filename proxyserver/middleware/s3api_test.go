@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"encoding/xml"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValidBucketName(t *testing.T) {
@@ -28,3 +30,57 @@ func TestS3DateString(t *testing.T) {
 	// Doesn't index out of range.
 	assert.Equal(t, "no", s3DateString("no"))
 }
+
+func TestS3InventoryHeaders(t *testing.T) {
+	body := `<InventoryConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Destination>
+    <S3BucketDestination>
+      <Format>CSV</Format>
+      <Bucket>arn:aws:s3:::reports</Bucket>
+      <Prefix>daily</Prefix>
+    </S3BucketDestination>
+  </Destination>
+  <IsEnabled>true</IsEnabled>
+  <Filter><Prefix>logs/</Prefix></Filter>
+  <Id>report1</Id>
+  <IncludedObjectVersions>Current</IncludedObjectVersions>
+  <OptionalFields><Field>Size</Field><Field>ETag</Field><Field>IsMultipartUploaded</Field></OptionalFields>
+  <Schedule><Frequency>Weekly</Frequency></Schedule>
+</InventoryConfiguration>`
+	config := s3InventoryConfiguration{}
+	require.Nil(t, xml.Unmarshal([]byte(body), &config))
+	headers, ok := s3InventoryToHeaders(&config)
+	require.True(t, ok)
+	assert.Equal(t, "reports/daily", headers.Get("X-Container-Inventory-Destination"))
+	assert.Equal(t, "csv", headers.Get("X-Container-Inventory-Format"))
+	assert.Equal(t, "weekly", headers.Get("X-Container-Inventory-Frequency"))
+	assert.Equal(t, "bytes,hash", headers.Get("X-Container-Inventory-Fields"))
+	assert.Equal(t, "logs/", headers.Get("X-Container-Inventory-Prefix"))
+	assert.Equal(t, "report1", headers.Get("X-Container-Sysmeta-Inventory-Id"))
+
+	back := s3InventoryFromHeaders(headers)
+	require.NotNil(t, back)
+	assert.Equal(t, "report1", back.Id)
+	assert.Equal(t, "arn:aws:s3:::reports", back.Destination.S3BucketDestination.Bucket)
+	assert.Equal(t, "daily", back.Destination.S3BucketDestination.Prefix)
+	assert.Equal(t, "Weekly", back.Schedule.Frequency)
+	assert.Equal(t, "logs/", back.Filter.Prefix)
+	assert.Equal(t, []string{"Size", "ETag"}, back.OptionalFields)
+
+	config.IsEnabled = false
+	headers, ok = s3InventoryToHeaders(&config)
+	require.True(t, ok)
+	assert.Nil(t, s3InventoryFromHeaders(headers))
+
+	config.IsEnabled = true
+	config.Destination.S3BucketDestination.Format = "ORC"
+	_, ok = s3InventoryToHeaders(&config)
+	assert.False(t, ok)
+}
+
+func TestS3NotificationEvents(t *testing.T) {
+	assert.Equal(t, "ObjectCreated", s3EventToNotification("s3:ObjectCreated:*"))
+	assert.Equal(t, "ObjectRemoved:Delete", s3EventToNotification("s3:ObjectRemoved:Delete"))
+	assert.Equal(t, "s3:ObjectCreated:*", notificationToS3Event("ObjectCreated"))
+	assert.Equal(t, "s3:ObjectRemoved:Delete", notificationToS3Event("ObjectRemoved:Delete"))
+}
@@ -0,0 +1,267 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/containerserver"
+)
+
+const (
+	s3InventoryBodyLimit = 65536
+	s3InventoryIdHeader  = "X-Container-Sysmeta-Inventory-Id"
+	s3BucketArnPrefix    = "arn:aws:s3:::"
+)
+
+// s3InventoryFields maps the S3 inventory optional fields to the container inventory fields.  S3 fields without a
+// container inventory equivalent are accepted and left out of reports.
+var s3InventoryFields = []struct{ s3Field, field string }{
+	{"Size", "bytes"},
+	{"ETag", "hash"},
+	{"LastModifiedDate", "last_modified"},
+	{"StorageClass", "storage_policy"},
+}
+
+// s3InventoryHeaders are the container headers an inventory configuration is stored in.
+var s3InventoryHeaders = []string{
+	containerserver.InventoryDestinationHeader,
+	containerserver.InventoryFormatHeader,
+	containerserver.InventoryFrequencyHeader,
+	containerserver.InventoryFieldsHeader,
+	containerserver.InventoryPrefixHeader,
+	s3InventoryIdHeader,
+}
+
+type s3InventoryConfiguration struct {
+	XMLName     xml.Name `xml:"InventoryConfiguration"`
+	Xmlns       string   `xml:"xmlns,attr,omitempty"`
+	Id          string   `xml:"Id"`
+	IsEnabled   bool     `xml:"IsEnabled"`
+	Destination struct {
+		S3BucketDestination struct {
+			Format string `xml:"Format"`
+			Bucket string `xml:"Bucket"`
+			Prefix string `xml:"Prefix,omitempty"`
+		} `xml:"S3BucketDestination"`
+	} `xml:"Destination"`
+	Filter *struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"Filter,omitempty"`
+	IncludedObjectVersions string   `xml:"IncludedObjectVersions"`
+	OptionalFields         []string `xml:"OptionalFields>Field"`
+	Schedule               struct {
+		Frequency string `xml:"Frequency"`
+	} `xml:"Schedule"`
+}
+
+// handleInventoryRequest handles the bucket ?inventory subresource, which keeps a bucket's single inventory
+// configuration in the container's inventory headers.
+func (s *s3ApiHandler) handleInventoryRequest(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		s.getInventory(writer, request)
+	case "PUT":
+		body, err := ioutil.ReadAll(io.LimitReader(request.Body, s3InventoryBodyLimit))
+		if err != nil {
+			srv.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		config := s3InventoryConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			MalformedXMLResponse(writer, request)
+			return
+		}
+		headers, ok := s3InventoryToHeaders(&config)
+		if !ok {
+			MalformedXMLResponse(writer, request)
+			return
+		}
+		if id := request.Form.Get("id"); id != "" && id != config.Id {
+			MalformedXMLResponse(writer, request)
+			return
+		}
+		s.postBucketHeaders(writer, request, headers, http.StatusOK)
+	case "DELETE":
+		headers := http.Header{}
+		for _, key := range s3InventoryHeaders {
+			headers.Set(key, "")
+		}
+		s.postBucketHeaders(writer, request, headers, http.StatusNoContent)
+	default:
+		srv.StandardResponse(writer, http.StatusMethodNotAllowed)
+	}
+}
+
+// s3InventoryToHeaders returns the container headers for an inventory configuration, or false if it isn't valid.
+// Disabled configurations are removed, since container inventory has no way to keep one without running it.
+func s3InventoryToHeaders(config *s3InventoryConfiguration) (http.Header, bool) {
+	headers := http.Header{}
+	if !config.IsEnabled {
+		for _, key := range s3InventoryHeaders {
+			headers.Set(key, "")
+		}
+		return headers, true
+	}
+	dest := config.Destination.S3BucketDestination
+	bucket := strings.TrimPrefix(dest.Bucket, s3BucketArnPrefix)
+	if config.Id == "" || !validBucketName(bucket) {
+		return nil, false
+	}
+	if prefix := strings.Trim(dest.Prefix, "/"); prefix != "" {
+		bucket += "/" + prefix
+	}
+	headers.Set(containerserver.InventoryDestinationHeader, bucket)
+	switch strings.ToUpper(dest.Format) {
+	case "CSV":
+		headers.Set(containerserver.InventoryFormatHeader, "csv")
+	case "NDJSON":
+		headers.Set(containerserver.InventoryFormatHeader, "ndjson")
+	default:
+		return nil, false
+	}
+	switch config.Schedule.Frequency {
+	case "Daily":
+		headers.Set(containerserver.InventoryFrequencyHeader, "daily")
+	case "Weekly":
+		headers.Set(containerserver.InventoryFrequencyHeader, "weekly")
+	default:
+		return nil, false
+	}
+	var fields []string
+	for _, s3Field := range config.OptionalFields {
+		for _, f := range s3InventoryFields {
+			if f.s3Field == s3Field {
+				fields = append(fields, f.field)
+			}
+		}
+	}
+	if len(fields) == 0 {
+		// Reports always have at least the object names; an empty field list would get the default fields.
+		fields = []string{"bytes"}
+	}
+	headers.Set(containerserver.InventoryFieldsHeader, strings.Join(fields, ","))
+	prefix := ""
+	if config.Filter != nil {
+		prefix = config.Filter.Prefix
+	}
+	headers.Set(containerserver.InventoryPrefixHeader, prefix)
+	headers.Set(s3InventoryIdHeader, config.Id)
+	return headers, true
+}
+
+// s3InventoryFromHeaders returns the inventory configuration stored in a container's headers, or nil if it has none.
+func s3InventoryFromHeaders(headers http.Header) *s3InventoryConfiguration {
+	destination := headers.Get(containerserver.InventoryDestinationHeader)
+	if destination == "" {
+		return nil
+	}
+	config := &s3InventoryConfiguration{Xmlns: s3Xmlns, Id: headers.Get(s3InventoryIdHeader), IsEnabled: true}
+	parts := strings.SplitN(destination, "/", 2)
+	config.Destination.S3BucketDestination.Bucket = s3BucketArnPrefix + parts[0]
+	if len(parts) == 2 {
+		config.Destination.S3BucketDestination.Prefix = parts[1]
+	}
+	config.Destination.S3BucketDestination.Format = "CSV"
+	if strings.ToLower(headers.Get(containerserver.InventoryFormatHeader)) == "ndjson" {
+		config.Destination.S3BucketDestination.Format = "NDJSON"
+	}
+	config.Schedule.Frequency = "Daily"
+	if strings.ToLower(headers.Get(containerserver.InventoryFrequencyHeader)) == "weekly" {
+		config.Schedule.Frequency = "Weekly"
+	}
+	if prefix := headers.Get(containerserver.InventoryPrefixHeader); prefix != "" {
+		config.Filter = &struct {
+			Prefix string `xml:"Prefix"`
+		}{Prefix: prefix}
+	}
+	config.IncludedObjectVersions = "Current"
+	fields := strings.Split(headers.Get(containerserver.InventoryFieldsHeader), ",")
+	if headers.Get(containerserver.InventoryFieldsHeader) == "" {
+		fields = []string{"bytes", "hash", "last_modified", "storage_policy"}
+	}
+	for _, field := range fields {
+		for _, f := range s3InventoryFields {
+			if f.field == strings.TrimSpace(field) {
+				config.OptionalFields = append(config.OptionalFields, f.s3Field)
+			}
+		}
+	}
+	return config
+}
+
+func (s *s3ApiHandler) getInventory(writer http.ResponseWriter, request *http.Request) {
+	ctx := GetProxyContext(request)
+	newReq, err := ctx.newSubrequest("HEAD", s.path, http.NoBody, request, "s3api")
+	if err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cap := NewCaptureWriter()
+	ctx.serveHTTPSubrequest(cap, newReq)
+	if cap.status == 404 {
+		NoSuchBucketResponse(writer, request)
+		return
+	} else if cap.status/100 != 2 {
+		srv.StandardResponse(writer, cap.status)
+		return
+	}
+	config := s3InventoryFromHeaders(cap.Header())
+	if config == nil || (request.Form.Get("id") != "" && request.Form.Get("id") != config.Id) {
+		NoSuchConfigurationResponse(writer, request)
+		return
+	}
+	output, err := xml.MarshalIndent(config, "", "  ")
+	if err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	writer.WriteHeader(200)
+	writer.Write([]byte(xml.Header))
+	writer.Write(output)
+}
+
+// postBucketHeaders stores headers on the bucket's container, responding with status if that worked.
+func (s *s3ApiHandler) postBucketHeaders(writer http.ResponseWriter, request *http.Request, headers http.Header, status int) {
+	ctx := GetProxyContext(request)
+	newReq, err := ctx.newSubrequest("POST", s.path, http.NoBody, request, "s3api")
+	if err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for key := range headers {
+		newReq.Header.Set(key, headers.Get(key))
+	}
+	cap := NewCaptureWriter()
+	ctx.serveHTTPSubrequest(cap, newReq)
+	if cap.status == 404 {
+		NoSuchBucketResponse(writer, request)
+		return
+	} else if cap.status == 400 {
+		InvalidArgumentResponse(writer, request)
+		return
+	} else if cap.status/100 != 2 {
+		srv.StandardResponse(writer, cap.status)
+		return
+	}
+	writer.WriteHeader(status)
+}
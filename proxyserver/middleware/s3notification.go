@@ -0,0 +1,127 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/srv"
+)
+
+const s3NotificationBodyLimit = 65536
+
+type s3TopicConfiguration struct {
+	Id     string   `xml:"Id,omitempty"`
+	Topic  string   `xml:"Topic"`
+	Events []string `xml:"Event"`
+}
+
+// s3NotificationConfiguration holds a bucket's notification endpoint as a topic.  Buckets have at most one, since
+// their container only has one endpoint.
+type s3NotificationConfiguration struct {
+	XMLName             xml.Name               `xml:"NotificationConfiguration"`
+	Xmlns               string                 `xml:"xmlns,attr,omitempty"`
+	TopicConfigurations []s3TopicConfiguration `xml:"TopicConfiguration"`
+}
+
+// s3EventToNotification turns an S3 event type, like s3:ObjectCreated:*, into a notification event, like ObjectCreated.
+func s3EventToNotification(event string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(event, "s3:"), ":*")
+}
+
+func notificationToS3Event(event string) string {
+	if !strings.Contains(event, ":") {
+		return "s3:" + event + ":*"
+	}
+	return "s3:" + event
+}
+
+// handleNotificationRequest handles the bucket ?notification subresource, using the container's notification headers.
+func (s *s3ApiHandler) handleNotificationRequest(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		ctx := GetProxyContext(request)
+		newReq, err := ctx.newSubrequest("HEAD", s.path, http.NoBody, request, "s3api")
+		if err != nil {
+			srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		cap := NewCaptureWriter()
+		ctx.serveHTTPSubrequest(cap, newReq)
+		if cap.status == 404 {
+			NoSuchBucketResponse(writer, request)
+			return
+		} else if cap.status/100 != 2 {
+			srv.StandardResponse(writer, cap.status)
+			return
+		}
+		config := &s3NotificationConfiguration{Xmlns: s3Xmlns}
+		if url := cap.Header().Get(NotificationUrlHeader); url != "" {
+			topic := s3TopicConfiguration{Topic: url}
+			for _, event := range common.SliceFromCSV(cap.Header().Get(NotificationEventsHeader)) {
+				topic.Events = append(topic.Events, notificationToS3Event(event))
+			}
+			if len(topic.Events) == 0 {
+				topic.Events = []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*", "s3:ObjectMetadataUpdated:*"}
+			}
+			config.TopicConfigurations = append(config.TopicConfigurations, topic)
+		}
+		output, err := xml.MarshalIndent(config, "", "  ")
+		if err != nil {
+			srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		writer.WriteHeader(200)
+		writer.Write([]byte(xml.Header))
+		writer.Write(output)
+	case "PUT":
+		body, err := ioutil.ReadAll(io.LimitReader(request.Body, s3NotificationBodyLimit))
+		if err != nil {
+			srv.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		config := s3NotificationConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil || len(config.TopicConfigurations) > 1 {
+			MalformedXMLResponse(writer, request)
+			return
+		}
+		headers := http.Header{}
+		headers.Set(NotificationUrlHeader, "")
+		headers.Set(NotificationEventsHeader, "")
+		if len(config.TopicConfigurations) == 1 {
+			topic := config.TopicConfigurations[0]
+			if topic.Topic == "" || len(topic.Events) == 0 {
+				MalformedXMLResponse(writer, request)
+				return
+			}
+			var events []string
+			for _, event := range topic.Events {
+				events = append(events, s3EventToNotification(event))
+			}
+			headers.Set(NotificationUrlHeader, topic.Topic)
+			headers.Set(NotificationEventsHeader, strings.Join(events, ","))
+		}
+		s.postBucketHeaders(writer, request, headers, http.StatusOK)
+	default:
+		srv.StandardResponse(writer, http.StatusMethodNotAllowed)
+	}
+}
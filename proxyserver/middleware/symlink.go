@@ -0,0 +1,302 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/conf"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/uber-go/tally"
+)
+
+const (
+	SymlinkTargetHeader        = "X-Symlink-Target"
+	SymlinkTargetAccountHeader = "X-Symlink-Target-Account"
+	SymlinkTargetEtagHeader    = "X-Symlink-Target-Etag"
+	SymlinkTargetBytesHeader   = "X-Symlink-Target-Bytes"
+	symlinkTargetSysmeta       = "X-Object-Sysmeta-Symlink-Target"
+	symlinkAccountSysmeta      = "X-Object-Sysmeta-Symlink-Target-Account"
+	symlinkEtagSysmeta         = "X-Object-Sysmeta-Symlink-Target-Etag"
+	symlinkBytesSysmeta        = "X-Object-Sysmeta-Symlink-Target-Bytes"
+	symlinkContentType         = "application/symlink"
+	containerUpdateContentType = "X-Object-Sysmeta-Container-Update-Override-Content-Type"
+)
+
+type symlinkMiddleware struct {
+	next           http.Handler
+	symloopMax     int
+	putsMetric     tally.Counter
+	resolvesMetric tally.Counter
+}
+
+// symlinkWriter passes a response through unless it's from a symlink, in which case the symlink middleware follows it.
+type symlinkWriter struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	// location is the path of the object being written, if it's a symlink's target.
+	location string
+	// etag is what a static symlink expects its target's etag to be.
+	etag     string
+	symlink  bool
+	mismatch bool
+}
+
+func newSymlinkWriter(w http.ResponseWriter, location, etag string) *symlinkWriter {
+	return &symlinkWriter{ResponseWriter: w, header: make(http.Header), status: 500, location: location, etag: etag}
+}
+
+func (sw *symlinkWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *symlinkWriter) WriteHeader(status int) {
+	sw.status = status
+	if sw.header.Get(symlinkTargetSysmeta) != "" {
+		sw.symlink = true
+		return
+	}
+	if sw.etag != "" && status/100 == 2 {
+		etag := strings.Trim(sw.header.Get("Etag"), "\"")
+		if sloEtag := sw.header.Get("X-Object-Sysmeta-Slo-Etag"); sloEtag != "" {
+			etag = sloEtag
+		}
+		if etag != sw.etag {
+			sw.mismatch = true
+			srv.SimpleErrorResponse(sw.ResponseWriter, http.StatusConflict,
+				fmt.Sprintf("Object Etag %q does not match X-Symlink-Target-Etag header %q", etag, sw.etag))
+			return
+		}
+	}
+	CopyItems(sw.ResponseWriter.Header(), sw.header)
+	if sw.location != "" {
+		sw.ResponseWriter.Header().Set("Content-Location", sw.location)
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *symlinkWriter) Write(b []byte) (int, error) {
+	if sw.symlink || sw.mismatch {
+		return len(b), nil
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// symlinkGetWriter shows the symlink's target on ?symlink=get requests.
+type symlinkGetWriter struct {
+	http.ResponseWriter
+}
+
+func (w *symlinkGetWriter) WriteHeader(status int) {
+	h := w.Header()
+	if target := h.Get(symlinkTargetSysmeta); target != "" {
+		h.Set(SymlinkTargetHeader, target)
+		if account := h.Get(symlinkAccountSysmeta); account != "" {
+			h.Set(SymlinkTargetAccountHeader, account)
+		}
+		if etag := h.Get(symlinkEtagSysmeta); etag != "" {
+			h.Set(SymlinkTargetEtagHeader, etag)
+			h.Set(SymlinkTargetBytesHeader, h.Get(symlinkBytesSysmeta))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// parseSymlinkTarget returns the container and object from an X-Symlink-Target header.
+func parseSymlinkTarget(target string) (string, string, error) {
+	target, err := url.PathUnescape(target)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(strings.TrimPrefix(target, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("X-Symlink-Target header must be of the form <container name>/<object name>")
+	}
+	return parts[0], parts[1], nil
+}
+
+// setSymlinkContainerUpdate lists the symlink in its container with its target's path.
+func setSymlinkContainerUpdate(request *http.Request, account, target string) {
+	contentType := request.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = symlinkContentType
+		request.Header.Set("Content-Type", contentType)
+	}
+	targetPath, err := url.PathUnescape(target)
+	if err != nil {
+		return
+	}
+	request.Header.Set(containerUpdateContentType, common.ContentTypeWithSymlink(contentType, fmt.Sprintf("/v1/%s/%s", account, targetPath)))
+}
+
+func (s *symlinkMiddleware) handlePut(writer http.ResponseWriter, request *http.Request, account, container, obj string) {
+	target := request.Header.Get(SymlinkTargetHeader)
+	if target == "" {
+		if request.Header.Get(SymlinkTargetAccountHeader) != "" || request.Header.Get(SymlinkTargetEtagHeader) != "" {
+			srv.SimpleErrorResponse(writer, http.StatusBadRequest, "X-Symlink-Target header required")
+			return
+		}
+		// Copies and versions of symlinks bring their targets along in sysmeta.
+		if target = request.Header.Get(symlinkTargetSysmeta); target != "" {
+			targetAccount := account
+			if a, err := url.PathUnescape(request.Header.Get(symlinkAccountSysmeta)); err == nil && a != "" {
+				targetAccount = a
+			}
+			setSymlinkContainerUpdate(request, targetAccount, target)
+		}
+		s.next.ServeHTTP(writer, request)
+		return
+	}
+	if request.ContentLength != 0 {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Symlink requests require a zero byte body")
+		return
+	}
+	targetContainer, targetObject, err := parseSymlinkTarget(target)
+	if err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	targetAccount := account
+	if a := request.Header.Get(SymlinkTargetAccountHeader); a != "" {
+		if targetAccount, err = url.PathUnescape(a); err != nil || targetAccount == "" || strings.Contains(targetAccount, "/") {
+			srv.SimpleErrorResponse(writer, http.StatusPreconditionFailed, "Invalid X-Symlink-Target-Account header")
+			return
+		}
+		request.Header.Set(symlinkAccountSysmeta, common.Urlencode(targetAccount))
+	}
+	if targetAccount == account && targetContainer == container && targetObject == obj {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, "Symlink cannot target itself")
+		return
+	}
+	target = common.Urlencode(targetContainer + "/" + targetObject)
+	if etag := strings.Trim(request.Header.Get(SymlinkTargetEtagHeader), "\""); etag != "" {
+		// Static symlinks record their target's etag, and only ever resolve to that version of it.
+		ctx := GetProxyContext(request)
+		headReq, err := ctx.newSubrequest("HEAD", fmt.Sprintf("/v1/%s/%s", common.Urlencode(targetAccount), target), http.NoBody, request, "symlink")
+		if err != nil {
+			srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sw := newSymlinkWriter(NewCaptureWriter(), "", etag)
+		s.next.ServeHTTP(sw, headReq)
+		if sw.symlink {
+			srv.SimpleErrorResponse(writer, http.StatusConflict, "X-Symlink-Target-Etag cannot be used with a target that is a symlink")
+			return
+		} else if sw.mismatch {
+			srv.SimpleErrorResponse(writer, http.StatusConflict, fmt.Sprintf("Object Etag does not match X-Symlink-Target-Etag header %q", etag))
+			return
+		} else if sw.status == http.StatusNotFound {
+			srv.SimpleErrorResponse(writer, http.StatusConflict, fmt.Sprintf("X-Symlink-Target does not exist: %s", target))
+			return
+		} else if sw.status/100 != 2 {
+			srv.StandardResponse(writer, sw.status)
+			return
+		}
+		size := sw.header.Get("Content-Length")
+		if sloSize := sw.header.Get("X-Object-Sysmeta-Slo-Size"); sloSize != "" {
+			size = sloSize
+		}
+		request.Header.Set(symlinkEtagSysmeta, etag)
+		request.Header.Set(symlinkBytesSysmeta, size)
+	}
+	request.Header.Set(symlinkTargetSysmeta, target)
+	request.Header.Del(SymlinkTargetHeader)
+	request.Header.Del(SymlinkTargetAccountHeader)
+	request.Header.Del(SymlinkTargetEtagHeader)
+	setSymlinkContainerUpdate(request, targetAccount, target)
+	s.putsMetric.Inc(1)
+	s.next.ServeHTTP(writer, request)
+}
+
+// handleGet serves GET and HEAD requests, following any symlinks to their targets up to symloopMax times.
+func (s *symlinkMiddleware) handleGet(writer http.ResponseWriter, request *http.Request, account string) {
+	ctx := GetProxyContext(request)
+	sw := newSymlinkWriter(writer, "", "")
+	s.next.ServeHTTP(sw, request)
+	for hops := 0; sw.symlink; hops++ {
+		if hops >= s.symloopMax {
+			srv.SimpleErrorResponse(writer, http.StatusConflict,
+				fmt.Sprintf("Too many levels of symbolic links, maximum allowed is %d", s.symloopMax))
+			return
+		}
+		if a := sw.header.Get(symlinkAccountSysmeta); a != "" {
+			if account, _ = url.PathUnescape(a); account == "" {
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+				return
+			}
+		}
+		location := fmt.Sprintf("/v1/%s/%s", common.Urlencode(account), sw.header.Get(symlinkTargetSysmeta))
+		subreq, err := ctx.newSubrequest(request.Method, location, http.NoBody, request, "symlink")
+		if err != nil {
+			srv.SimpleErrorResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		CopyItems(subreq.Header, request.Header)
+		subreq.URL.RawQuery = request.URL.RawQuery
+		s.resolvesMetric.Inc(1)
+		sw = newSymlinkWriter(writer, location, sw.header.Get(symlinkEtagSysmeta))
+		s.next.ServeHTTP(sw, subreq)
+	}
+}
+
+func (s *symlinkMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	apiReq, account, container, obj := getPathParts(request)
+	if !apiReq || obj == "" {
+		s.next.ServeHTTP(writer, request)
+		return
+	}
+	switch request.Method {
+	case "PUT":
+		s.handlePut(writer, request, account, container, obj)
+	case "POST":
+		if request.Header.Get(SymlinkTargetHeader) != "" {
+			srv.SimpleErrorResponse(writer, http.StatusBadRequest, "A PUT request is required to set a symlink target")
+			return
+		}
+		s.next.ServeHTTP(writer, request)
+	case "GET", "HEAD":
+		if request.URL.Query().Get("symlink") == "get" {
+			s.next.ServeHTTP(&symlinkGetWriter{ResponseWriter: writer}, request)
+			return
+		}
+		s.handleGet(writer, request, account)
+	default:
+		s.next.ServeHTTP(writer, request)
+	}
+}
+
+func NewSymlink(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	symloopMax := int(config.GetInt("symloop_max", 2))
+	if symloopMax < 1 {
+		return nil, fmt.Errorf("symloop_max must be at least 1")
+	}
+	RegisterInfo("symlink", map[string]interface{}{"symloop_max": symloopMax, "static_links": true})
+	putsMetric := metricsScope.Counter("symlink_puts")
+	resolvesMetric := metricsScope.Counter("symlink_resolves")
+	return func(next http.Handler) http.Handler {
+		return &symlinkMiddleware{
+			next:           next,
+			symloopMax:     symloopMax,
+			putsMetric:     putsMetric,
+			resolvesMetric: resolvesMetric,
+		}
+	}, nil
+}
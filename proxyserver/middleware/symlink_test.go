@@ -0,0 +1,189 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+type symlinkTestObject struct {
+	headers map[string]string
+	body    string
+}
+
+// symlinkTestApp serves GETs and HEADs of its objects, and records the headers of PUTs.
+func symlinkTestApp(objects map[string]symlinkTestObject, puts map[string]http.Header) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "PUT" {
+			puts[request.URL.Path] = request.Header
+			writer.WriteHeader(201)
+			return
+		}
+		obj, ok := objects[request.URL.Path]
+		if !ok {
+			writer.WriteHeader(404)
+			return
+		}
+		for k, v := range obj.headers {
+			writer.Header().Set(k, v)
+		}
+		writer.WriteHeader(200)
+		if request.Method == "GET" {
+			writer.Write([]byte(obj.body))
+		}
+	})
+}
+
+func newSymlinkTest(objects map[string]symlinkTestObject, puts map[string]http.Header) http.Handler {
+	next := symlinkTestApp(objects, puts)
+	return &symlinkMiddleware{next: next, symloopMax: 2, putsMetric: tally.NoopScope.Counter("p"), resolvesMetric: tally.NoopScope.Counter("r")}
+}
+
+func symlinkTestRequest(method, path string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+	ctx := &ProxyContext{
+		ProxyContextMiddleware: &ProxyContextMiddleware{},
+		Logger:                 zap.NewNop(),
+	}
+	return req.WithContext(context.WithValue(req.Context(), "proxycontext", ctx))
+}
+
+func TestSymlinkPut(t *testing.T) {
+	puts := map[string]http.Header{}
+	s := newSymlinkTest(map[string]symlinkTestObject{}, puts)
+	req := symlinkTestRequest("PUT", "/v1/a/c/link", nil)
+	req.Header.Set("X-Symlink-Target", "tc/some%20obj")
+	req.Header.Set("X-Symlink-Target-Account", "b")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 201, w.Code)
+	put := puts["/v1/a/c/link"]
+	require.Equal(t, "tc/some%20obj", put.Get("X-Object-Sysmeta-Symlink-Target"))
+	require.Equal(t, "b", put.Get("X-Object-Sysmeta-Symlink-Target-Account"))
+	require.Equal(t, "application/symlink", put.Get("Content-Type"))
+	require.Equal(t, "application/symlink;symlink_path=/v1/b/tc/some%20obj", put.Get("X-Object-Sysmeta-Container-Update-Override-Content-Type"))
+	require.Equal(t, "", put.Get("X-Symlink-Target"))
+
+	req = symlinkTestRequest("PUT", "/v1/a/c/link", []byte("data"))
+	req.Header.Set("X-Symlink-Target", "tc/obj")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 400, w.Code)
+
+	req = symlinkTestRequest("PUT", "/v1/a/c/link", nil)
+	req.Header.Set("X-Symlink-Target", "tc")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 412, w.Code)
+
+	req = symlinkTestRequest("PUT", "/v1/a/c/link", nil)
+	req.Header.Set("X-Symlink-Target", "c/link")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 400, w.Code)
+}
+
+func TestSymlinkPutStatic(t *testing.T) {
+	objects := map[string]symlinkTestObject{
+		"/v1/a/c/obj": {headers: map[string]string{"Etag": `"abc"`, "Content-Length": "5"}},
+	}
+	puts := map[string]http.Header{}
+	s := newSymlinkTest(objects, puts)
+	req := symlinkTestRequest("PUT", "/v1/a/c/link", nil)
+	req.Header.Set("X-Symlink-Target", "c/obj")
+	req.Header.Set("X-Symlink-Target-Etag", "abc")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 201, w.Code)
+	require.Equal(t, "abc", puts["/v1/a/c/link"].Get("X-Object-Sysmeta-Symlink-Target-Etag"))
+	require.Equal(t, "5", puts["/v1/a/c/link"].Get("X-Object-Sysmeta-Symlink-Target-Bytes"))
+
+	req = symlinkTestRequest("PUT", "/v1/a/c/link2", nil)
+	req.Header.Set("X-Symlink-Target", "c/obj")
+	req.Header.Set("X-Symlink-Target-Etag", "def")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 409, w.Code)
+	require.Nil(t, puts["/v1/a/c/link2"])
+
+	req = symlinkTestRequest("PUT", "/v1/a/c/link3", nil)
+	req.Header.Set("X-Symlink-Target", "c/missing")
+	req.Header.Set("X-Symlink-Target-Etag", "abc")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, 409, w.Code)
+}
+
+func TestSymlinkGet(t *testing.T) {
+	objects := map[string]symlinkTestObject{
+		"/v1/a/c/link":  {headers: map[string]string{"X-Object-Sysmeta-Symlink-Target": "c/link2"}},
+		"/v1/a/c/link2": {headers: map[string]string{"X-Object-Sysmeta-Symlink-Target": "c2/obj", "X-Object-Sysmeta-Symlink-Target-Account": "b"}},
+		"/v1/b/c2/obj":  {headers: map[string]string{"Etag": `"abc"`}, body: "hello"},
+	}
+	s := newSymlinkTest(objects, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, symlinkTestRequest("GET", "/v1/a/c/link", nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "hello", w.Body.String())
+	require.Equal(t, "/v1/b/c2/obj", w.Header().Get("Content-Location"))
+	require.Equal(t, "", w.Header().Get("X-Object-Sysmeta-Symlink-Target"))
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, symlinkTestRequest("GET", "/v1/a/c/link2?symlink=get", nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "c2/obj", w.Header().Get("X-Symlink-Target"))
+	require.Equal(t, "b", w.Header().Get("X-Symlink-Target-Account"))
+	body, _ := ioutil.ReadAll(w.Body)
+	require.Equal(t, 0, len(body))
+}
+
+func TestSymlinkGetLoop(t *testing.T) {
+	objects := map[string]symlinkTestObject{
+		"/v1/a/c/one": {headers: map[string]string{"X-Object-Sysmeta-Symlink-Target": "c/two"}},
+		"/v1/a/c/two": {headers: map[string]string{"X-Object-Sysmeta-Symlink-Target": "c/one"}},
+	}
+	s := newSymlinkTest(objects, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, symlinkTestRequest("HEAD", "/v1/a/c/one", nil))
+	require.Equal(t, 409, w.Code)
+}
+
+func TestSymlinkGetStaticMismatch(t *testing.T) {
+	objects := map[string]symlinkTestObject{
+		"/v1/a/c/link": {headers: map[string]string{"X-Object-Sysmeta-Symlink-Target": "c/obj", "X-Object-Sysmeta-Symlink-Target-Etag": "abc"}},
+		"/v1/a/c/obj":  {headers: map[string]string{"Etag": `"def"`}, body: "changed"},
+	}
+	s := newSymlinkTest(objects, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, symlinkTestRequest("GET", "/v1/a/c/link", nil))
+	require.Equal(t, 409, w.Code)
+	require.NotContains(t, w.Body.String(), "changed")
+
+	objects["/v1/a/c/obj"] = symlinkTestObject{headers: map[string]string{"Etag": `"x"`, "X-Object-Sysmeta-Slo-Etag": "abc"}, body: "manifest"}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, symlinkTestRequest("GET", "/v1/a/c/link", nil))
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "manifest", w.Body.String())
+}
@@ -68,6 +68,7 @@ func (ta *tempAuth) getUserPassword(account, user string) string {
 type cachedAuth struct {
 	Groups  []string
 	Expires int64
+	User    string
 }
 
 func (ta *tempAuth) getUserGroups(tu *testUser) []string {
@@ -118,7 +119,7 @@ func (ta *tempAuth) getToken(ctx context.Context, proxyCtx *ProxyContext, user,
 	if token == "" {
 		token = ta.reseller + common.UUID()
 		now := time.Now().Unix()
-		proxyCtx.Cache.Set(ctx, "auth:"+token, &cachedAuth{Expires: now + 86400, Groups: userGroups}, 86400)
+		proxyCtx.Cache.Set(ctx, "auth:"+token, &cachedAuth{Expires: now + 86400, Groups: userGroups, User: fmt.Sprintf("%s:%s", tUser.Account, tUser.Username)}, 86400)
 		if err := proxyCtx.Cache.Set(ctx, "authuser:"+user, &token, 86400); err != nil {
 			proxyCtx.Logger.Debug("Error setting tempauth token", zap.Error(err))
 			return tUser, ""
@@ -258,6 +259,8 @@ func (ta *tempAuth) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 							}
 						}
 						ctx.RemoteUsers = ca.Groups
+						ctx.RemoteUser = ca.User
+						ctx.AuthMethod = "tempauth"
 						ctx.Authorize = ta.authorize
 					}
 				} else if ok {
@@ -18,8 +18,14 @@ package middleware
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -74,24 +80,90 @@ func (w *tuWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
-func checkhmac(key, sig []byte, method, path string, expires time.Time) bool {
-	if method == "HEAD" {
-		for _, meth := range []string{"HEAD", "GET", "POST", "PUT"} {
-			mac := hmac.New(sha1.New, key)
-			fmt.Fprintf(mac, "%s\n%d\n%s", meth, expires.Unix(), path)
-			if hmac.Equal(sig, mac.Sum(nil)) {
-				return true
+// tempurlDigests are the digests temp url and form post signatures may be made with.
+var tempurlDigests = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// allowedDigests returns the named digests, from tempurlDigests.
+func allowedDigests(names []string) (map[string]func() hash.Hash, error) {
+	digests := map[string]func() hash.Hash{}
+	for _, name := range names {
+		digest, ok := tempurlDigests[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown digest %q", name)
+		}
+		digests[strings.ToLower(name)] = digest
+	}
+	return digests, nil
+}
+
+// parseSignature decodes a signature, either hex or of the form "sha512:<base64>", and returns the name of its digest.
+// Hex signatures are matched to their digest by length, and "" is returned if it doesn't match any.
+func parseSignature(sig string) (string, []byte, error) {
+	if i := strings.Index(sig, ":"); i >= 0 {
+		encoded := strings.TrimRight(sig[i+1:], "=")
+		sigb, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			if sigb, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+				return "", nil, err
 			}
 		}
+		return strings.ToLower(sig[:i]), sigb, nil
+	}
+	sigb, err := hex.DecodeString(sig)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(sigb) == 0 {
+		return "", nil, errors.New("Empty signature")
+	}
+	for name, digest := range tempurlDigests {
+		if digest().Size() == len(sigb) {
+			return name, sigb, nil
+		}
+	}
+	return "", sigb, nil
+}
+
+// inIPRange reports whether the request came from ipRange, which is an address or a CIDR block.
+func inIPRange(request *http.Request, ipRange string) bool {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
 		return false
-	} else {
-		mac := hmac.New(sha1.New, key)
-		fmt.Fprintf(mac, "%s\n%d\n%s", method, expires.Unix(), path)
-		return hmac.Equal(sig, mac.Sum(nil))
 	}
+	if strings.Contains(ipRange, "/") {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		return err == nil && ipNet.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(ipRange))
 }
 
-func tempurl(requestsMetric tally.Counter) func(http.Handler) http.Handler {
+func checkhmac(digest func() hash.Hash, key, sig []byte, method, path, ipRange string, expires time.Time) bool {
+	methods := []string{method}
+	if method == "HEAD" {
+		methods = []string{"HEAD", "GET", "POST", "PUT"}
+	}
+	for _, meth := range methods {
+		mac := hmac.New(digest, key)
+		if ipRange != "" {
+			fmt.Fprintf(mac, "ip=%s\n", ipRange)
+		}
+		fmt.Fprintf(mac, "%s\n%d\n%s", meth, expires.Unix(), path)
+		if hmac.Equal(sig, mac.Sum(nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+func tempurl(digests map[string]func() hash.Hash, requestsMetric tally.Counter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			if request.Method == "OPTIONS" {
@@ -124,7 +196,7 @@ func tempurl(requestsMetric tally.Counter) func(http.Handler) http.Handler {
 				return
 			}
 
-			sigb, err := hex.DecodeString(sig)
+			digestName, sigb, err := parseSignature(sig)
 			if err != nil {
 				srv.StandardResponse(writer, 401)
 				return
@@ -153,16 +225,31 @@ func tempurl(requestsMetric tally.Counter) func(http.Handler) http.Handler {
 				path = fmt.Sprintf("/v1/%s/%s/%s", account, container, obj)
 			}
 
+			digest, ok := digests[digestName]
+			if !ok {
+				srv.StandardResponse(writer, 401)
+				return
+			}
+
+			ipRange := q.Get("temp_url_ip_range")
+			if ipRange != "" && !inIPRange(request, ipRange) {
+				srv.StandardResponse(writer, 401)
+				return
+			}
+
+			validKey := func(key string) bool {
+				return checkhmac(digest, []byte(key), sigb, request.Method, path, ipRange, expires)
+			}
 			scope := SCOPE_INVALID
 			if ai, err := ctx.GetAccountInfo(request.Context(), account); err == nil {
-				if key, ok := ai.Metadata["Temp-Url-Key"]; ok && checkhmac([]byte(key), sigb, request.Method, path, expires) {
+				if key, ok := ai.Metadata["Temp-Url-Key"]; ok && validKey(key) {
 					scope = SCOPE_ACCOUNT
-				} else if key, ok := ai.Metadata["Temp-Url-Key-2"]; ok && checkhmac([]byte(key), sigb, request.Method, path, expires) {
+				} else if key, ok := ai.Metadata["Temp-Url-Key-2"]; ok && validKey(key) {
 					scope = SCOPE_ACCOUNT
 				} else if ci, err := ctx.C.GetContainerInfo(request.Context(), account, container); err == nil {
-					if key, ok := ci.Metadata["Temp-Url-Key"]; ok && checkhmac([]byte(key), sigb, request.Method, path, expires) {
+					if key, ok := ci.Metadata["Temp-Url-Key"]; ok && validKey(key) {
 						scope = SCOPE_CONTAINER
-					} else if key, ok := ci.Metadata["Temp-Url-Key-2"]; ok && checkhmac([]byte(key), sigb, request.Method, path, expires) {
+					} else if key, ok := ci.Metadata["Temp-Url-Key-2"]; ok && validKey(key) {
 						scope = SCOPE_CONTAINER
 					}
 				}
@@ -172,9 +259,11 @@ func tempurl(requestsMetric tally.Counter) func(http.Handler) http.Handler {
 				return
 			}
 			ctx.RemoteUsers = []string{".tempurl"}
+			ctx.AuthMethod = "tempurl"
 			ctx.Authorize = func(r *http.Request) (bool, int) {
 				ar, a, c, _ := getPathParts(r)
-				if ar && ((scope == SCOPE_ACCOUNT && a == account) || (scope == SCOPE_CONTAINER && c == container)) {
+				// Symlinks are followed with this too, so their targets have to be in the key's scope.
+				if ar && a == account && (scope == SCOPE_ACCOUNT || c == container) {
 					return true, http.StatusOK
 				}
 				return false, http.StatusUnauthorized
@@ -196,12 +285,18 @@ func tempurl(requestsMetric tally.Counter) func(http.Handler) http.Handler {
 }
 
 func NewTempURL(config conf.Section, metricsScope tally.Scope) (func(http.Handler) http.Handler, error) {
+	digestNames := common.SliceFromCSV(config.GetDefault("allowed_digests", "sha256,sha512"))
+	digests, err := allowedDigests(digestNames)
+	if err != nil {
+		return nil, err
+	}
 	RegisterInfo("tempurl", map[string]interface{}{
 		"methods":                 []string{"GET", "HEAD", "PUT", "POST", "DELETE"},
 		"incoming_remove_headers": []string{"x-timestamp"},
 		"incoming_allow_headers":  []string{},
 		"outgoing_remove_headers": []string{"x-object-meta-*"}, "outgoing_allow_headers": []string{"x-object-meta-public-*"},
+		"allowed_digests": digestNames,
 	})
 	requestsMetric := metricsScope.Counter("tempurl_requests")
-	return tempurl(requestsMetric), nil
+	return tempurl(digests, requestsMetric), nil
 }
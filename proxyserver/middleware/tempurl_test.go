@@ -17,6 +17,7 @@ package middleware
 
 import (
 	"context"
+	"crypto/sha1"
 	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
@@ -54,20 +55,20 @@ func TestCheckHmac(t *testing.T) {
 	// test cases generated by example python code
 	sig, err := hex.DecodeString("6deb0c7da21f396f1368681dc0bd57df0d1c4369")
 	require.Nil(t, err)
-	require.True(t, checkhmac([]byte("mykey"), sig, "GET",
-		"/v1/AUTH_account/container/object", time.Unix(1493709631, 0).In(time.UTC)))
+	require.True(t, checkhmac(sha1.New, []byte("mykey"), sig, "GET",
+		"/v1/AUTH_account/container/object", "", time.Unix(1493709631, 0).In(time.UTC)))
 
 	// sig is actually for a POST, but make sure we can HEAD with it.
 	sig, err = hex.DecodeString("1ad2301fcc4e525ee0167298c0fbb426e90fb3b1")
 	require.Nil(t, err)
-	require.True(t, checkhmac([]byte("mykey"), sig, "HEAD",
-		"/v1/AUTH_account/container/object", time.Unix(1493709631, 0).In(time.UTC)))
+	require.True(t, checkhmac(sha1.New, []byte("mykey"), sig, "HEAD",
+		"/v1/AUTH_account/container/object", "", time.Unix(1493709631, 0).In(time.UTC)))
 
 	// sig is actually for a POST, but make sure we can HEAD with it.
 	sig, err = hex.DecodeString("1111111111111111111111111111111111111111")
 	require.Nil(t, err)
-	require.False(t, checkhmac([]byte("mykey"), sig, "HEAD",
-		"/v1/AUTH_account/container/object", time.Unix(1493709631, 0).In(time.UTC)))
+	require.False(t, checkhmac(sha1.New, []byte("mykey"), sig, "HEAD",
+		"/v1/AUTH_account/container/object", "", time.Unix(1493709631, 0).In(time.UTC)))
 }
 
 func TestTuWriter(t *testing.T) {
@@ -105,7 +106,7 @@ func TestTempurlMiddlewarePassOptions(t *testing.T) {
 		require.Equal(t, r, request)
 		served = true
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.True(t, served)
 }
@@ -125,7 +126,7 @@ func TestTempurlMiddlewarePassAlreadyAuthorized(t *testing.T) {
 		require.Equal(t, r, request)
 		served = true
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.True(t, served)
 }
@@ -140,7 +141,7 @@ func TestTempurlMiddlewarePassNoQuery(t *testing.T) {
 		require.Equal(t, r, request)
 		served = true
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.True(t, served)
 }
@@ -150,7 +151,7 @@ func TestTempurlMiddleware401OnlySig(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", &ProxyContext{}))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -160,7 +161,7 @@ func TestTempurlMiddleware401Expired(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", &ProxyContext{}))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -170,7 +171,7 @@ func TestTempurlMiddleware401BadSig(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", &ProxyContext{}))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -180,7 +181,7 @@ func TestTempurlMiddleware401NoContainer(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", &ProxyContext{}))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -191,7 +192,7 @@ func TestTempurlMiddleware400PuttingManifest(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", &ProxyContext{}))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 400, w.Result().StatusCode)
 }
@@ -212,7 +213,7 @@ func TestTempurlMiddleware401NoKeys(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -233,7 +234,7 @@ func TestTempurlMiddleware401WrongKeys(t *testing.T) {
 	r = r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx))
 	w := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 401, w.Result().StatusCode)
 }
@@ -265,7 +266,7 @@ func TestTempurlMiddlewareContainerKey(t *testing.T) {
 		require.True(t, ok)
 		writer.WriteHeader(200)
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 200, w.Result().StatusCode)
 }
@@ -291,7 +292,7 @@ func TestTempurlMiddlewarePath(t *testing.T) {
 		require.True(t, ok)
 		writer.WriteHeader(200)
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 200, w.Result().StatusCode)
 }
@@ -324,7 +325,68 @@ func TestTempurlMiddlewareAccountKey(t *testing.T) {
 		require.False(t, ok)
 		writer.WriteHeader(200)
 	})
-	mid := tempurl(common.NewTestScope().Counter("test_tempurl"))(handler)
+	mid := tempurl(tempurlDigests, common.NewTestScope().Counter("test_tempurl"))(handler)
 	mid.ServeHTTP(w, r)
 	require.Equal(t, 200, w.Result().StatusCode)
 }
+
+func TestTempurlMiddlewareDigests(t *testing.T) {
+	f, err := client.NewProxyClient(staticPolicyList, srv.NewTestConfigLoader(&test.FakeRing{}),
+		nil, "", "", "", "", "", conf.Config{})
+	require.Nil(t, err)
+	digests, err := allowedDigests([]string{"sha256", "sha512"})
+	require.Nil(t, err)
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	})
+	mid := tempurl(digests, common.NewTestScope().Counter("test_tempurl"))(handler)
+	for _, tc := range []struct {
+		query      string
+		remoteAddr string
+		status     int
+	}{
+		{"temp_url_sig=f80dab871904cb255498a5605767554fd7e73285e0b1813619036fc32b2b99cf", "", 200},
+		{"temp_url_sig=sha512:pEyo1XMhcBf4o7XRL1qQqMR2qZB1Co1a8EluFro__02eJ_UOmqx-8plfJsaZAXNr6ykGrAcx5ErZiex5Hq9_GQ&temp_url_prefix=o", "", 200},
+		{"temp_url_sig=sha256:pEyo1XMhcBf4o7XRL1qQqMR2qZB1Co1a8EluFro__02eJ_UOmqx-8plfJsaZAXNr6ykGrAcx5ErZiex5Hq9_GQ&temp_url_prefix=o", "", 401},
+		// sha1 signatures are good, but not allowed.
+		{"temp_url_sig=f2d61be897a27c03ac9a0dac3a8c4f6ce3a3d623", "", 401},
+		{"temp_url_sig=sha1:8tYb6JeifAOsmg2sOoxPbOOj1iM", "", 401},
+		{"temp_url_sig=3349e8802dfc5820250617fbcb4bd7b175200c6ecf3a0a211d09ede5d814d968&temp_url_ip_range=192.0.2.0/24", "192.0.2.1:1234", 200},
+		{"temp_url_sig=3349e8802dfc5820250617fbcb4bd7b175200c6ecf3a0a211d09ede5d814d968&temp_url_ip_range=192.0.2.0/24", "198.51.100.1:1234", 401},
+		{"temp_url_sig=3349e8802dfc5820250617fbcb4bd7b175200c6ecf3a0a211d09ede5d814d968&temp_url_ip_range=192.0.2.1", "192.0.2.1:1234", 401},
+	} {
+		r := httptest.NewRequest("GET", "/v1/a/c/o?temp_url_expires=9999999999&"+tc.query, nil)
+		if tc.remoteAddr != "" {
+			r.RemoteAddr = tc.remoteAddr
+		}
+		ctx := &ProxyContext{
+			C: f.NewRequestClient(nil, map[string]*client.ContainerInfo{
+				"container/a/c": {Metadata: map[string]string{}},
+			}, zap.NewNop()),
+			accountInfoCache: map[string]*AccountInfo{
+				"account/a": {Metadata: map[string]string{"Temp-Url-Key": "mykey"}}},
+		}
+		r = r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx))
+		w := httptest.NewRecorder()
+		mid.ServeHTTP(w, r)
+		require.Equal(t, tc.status, w.Result().StatusCode, tc.query)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	name, sig, err := parseSignature("f2d61be897a27c03ac9a0dac3a8c4f6ce3a3d623")
+	require.Nil(t, err)
+	require.Equal(t, "sha1", name)
+	require.Equal(t, 20, len(sig))
+	name, sig, err = parseSignature("SHA512:XIelL6qQFCE6Gl9KHInuw6OiI46LFZpDHQjC4bVOzNsreA687eGoy6vEeFdLl0diFWp0Eo7Of4DEIeOsIpC42A==")
+	require.Nil(t, err)
+	require.Equal(t, "sha512", name)
+	require.Equal(t, 64, len(sig))
+	name, _, err = parseSignature("abcdef")
+	require.Nil(t, err)
+	require.Equal(t, "", name)
+	_, _, err = parseSignature("sha256:!!!")
+	require.NotNil(t, err)
+	_, err = allowedDigests([]string{"sha256", "md5"})
+	require.NotNil(t, err)
+}
@@ -224,7 +224,8 @@ func okAuthFunc(r *http.Request) (bool, int) { return true, http.StatusOK }
 
 func (v *versionedWrites) copyObject(writer http.ResponseWriter, request *http.Request, dest string, src string) bool {
 	ctx := GetProxyContext(request)
-	srcBody, srcHeader, srcStatus := PipedGet(common.Urlencode(src), request, "VW", okAuthFunc)
+	// Symlinks are versioned as symlinks, not as copies of their targets.
+	srcBody, srcHeader, srcStatus := PipedGet(common.Urlencode(src)+"?symlink=get", request, "VW", okAuthFunc)
 	if srcBody != nil {
 		defer srcBody.Close()
 	}
@@ -16,6 +16,7 @@
 package proxyserver
 
 import (
+	"io"
 	"mime"
 	"net/http"
 	"path/filepath"
@@ -29,6 +30,16 @@ import (
 	"github.com/troubling/hummingbird/proxyserver/middleware"
 )
 
+// setIndexedMetadataHeader tells the object servers which object metadata the container indexes, so they only send
+// those keys with container updates, and know to send one when a POST changes them.
+func setIndexedMetadataHeader(request *http.Request, containerInfo *client.ContainerInfo) {
+	if containerInfo.IndexedMetadata != "" {
+		request.Header.Set("X-Backend-Container-Indexed-Metadata", containerInfo.IndexedMetadata)
+	} else {
+		request.Header.Del("X-Backend-Container-Indexed-Metadata")
+	}
+}
+
 func (server *ProxyServer) ObjectGetHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := srv.GetVars(request)
 	ctx := middleware.GetProxyContext(request)
@@ -185,11 +196,30 @@ func (server *ProxyServer) ObjectPostHandler(writer http.ResponseWriter, request
 		writer.Write([]byte(str))
 		return
 	}
+	setIndexedMetadataHeader(request, containerInfo)
 	resp := ctx.C.PostObject(request.Context(), vars["account"], vars["container"], vars["obj"], request.Header)
 	resp.Body.Close()
 	srv.StandardResponse(writer, resp.StatusCode)
 }
 
+// checksumReader verifies an object's checksums as its body streams through to the object servers.  A mismatch is
+// returned in place of EOF, which aborts the uploads before the object servers can commit the object.
+type checksumReader struct {
+	io.Reader
+	checksummer *common.Checksummer
+	err         error
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		if r.err = r.checksummer.Verify(); r.err != nil {
+			return n, r.err
+		}
+	}
+	return n, err
+}
+
 func (server *ProxyServer) ObjectPutHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := srv.GetVars(request)
 	ctx := middleware.GetProxyContext(request)
@@ -241,8 +271,34 @@ func (server *ProxyServer) ObjectPutHandler(writer http.ResponseWriter, request
 		writer.Write([]byte(str))
 		return
 	}
-	resp := ctx.C.PutObject(request.Context(), vars["account"], vars["container"], vars["obj"], request.Header, request.Body)
+	setIndexedMetadataHeader(request, containerInfo)
+	checksummer, err := common.NewChecksummer(request.Header)
+	if err != nil {
+		srv.SimpleErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	body := &checksumReader{Reader: io.TeeReader(request.Body, checksummer.Writer()), checksummer: checksummer}
+	resp := ctx.C.PutObject(request.Context(), vars["account"], vars["container"], vars["obj"], request.Header, body)
 	resp.Body.Close()
+	if body.err != nil {
+		srv.SimpleErrorResponse(writer, 422, body.err.Error())
+		return
+	}
+	if resp.StatusCode/100 == 2 {
+		for key, sum := range checksummer.Sums() {
+			if backendSum := resp.Header.Get(key); backendSum != "" && backendSum != sum {
+				ctx.Logger.Error("object PUT: backend checksum mismatch", zap.String("checksum", key),
+					zap.String("expected", sum), zap.String("backend", backendSum))
+				srv.StandardResponse(writer, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	for key := range resp.Header {
+		if common.IsChecksumHeader(key) {
+			writer.Header().Set(key, resp.Header.Get(key))
+		}
+	}
 	writer.Header().Set("Etag", resp.Header.Get("Etag"))
 	if modified, err := common.ParseDate(request.Header.Get("X-Timestamp")); err == nil {
 		writer.Header().Set("Last-Modified", common.FormatLastModified(modified))
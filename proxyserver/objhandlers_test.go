@@ -0,0 +1,85 @@
+//  Copyright (c) 2018 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/stretchr/testify/require"
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/common"
+	"github.com/troubling/hummingbird/common/srv"
+	"github.com/troubling/hummingbird/proxyserver/middleware"
+)
+
+type checksumPutClient struct {
+	client.RequestClient
+	uploadErr error
+	header    http.Header
+}
+
+func (c *checksumPutClient) GetContainerInfo(ctx context.Context, account string, container string) (*client.ContainerInfo, error) {
+	return &client.ContainerInfo{}, nil
+}
+
+func (c *checksumPutClient) PutObject(ctx context.Context, account string, container string, obj string, headers http.Header, src io.Reader) *http.Response {
+	if _, c.uploadErr = ioutil.ReadAll(src); c.uploadErr != nil {
+		return &http.Response{StatusCode: 503, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+	}
+	return &http.Response{StatusCode: 201, Header: c.header, Body: ioutil.NopCloser(strings.NewReader(""))}
+}
+
+func TestObjectPutChecksums(t *testing.T) {
+	p := ProxyServer{}
+	sum := sha256.Sum256([]byte("hello"))
+	good := hex.EncodeToString(sum[:])
+	put := func(c *checksumPutClient, checksum string) int {
+		r := httptest.NewRequest("PUT", "/v1/a/c/o", strings.NewReader("hello"))
+		r.Header.Set("Content-Length", "5")
+		r.Header.Set(common.ChecksumSHA256, checksum)
+		ctx := &middleware.ProxyContext{C: c, Logger: zap.NewNop()}
+		r = r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx))
+		r = srv.SetVars(r, map[string]string{"account": "a", "container": "c", "obj": "o"})
+		w := httptest.NewRecorder()
+		p.ObjectPutHandler(w, r)
+		return w.Code
+	}
+
+	c := &checksumPutClient{header: http.Header{common.ChecksumSHA256: {good}}}
+	require.Equal(t, 201, put(c, good))
+	require.Nil(t, c.uploadErr)
+
+	// A mismatch fails the upload itself, so the object servers never commit it.
+	c = &checksumPutClient{header: http.Header{}}
+	require.Equal(t, 422, put(c, strings.Repeat("0", 64)))
+	require.NotNil(t, c.uploadErr)
+
+	// The object servers have to agree with what the client sent.
+	c = &checksumPutClient{header: http.Header{common.ChecksumSHA256: {strings.Repeat("0", 64)}}}
+	require.Equal(t, 500, put(c, good))
+
+	require.Equal(t, 400, put(c, "nothex"))
+}